@@ -29,6 +29,8 @@ import (
 const (
 	// CheckpointPath is the location of the file containing the log checkpoint.
 	CheckpointPath = "checkpoint"
+	// MetadataPath is the location of the file containing the log's metadata, if the log publishes it.
+	MetadataPath = "metadata.json"
 )
 
 // EntriesPathForLogIndex builds the local path at which the leaf with the given index lives in.
@@ -37,7 +39,7 @@ const (
 // The logSize is required so that a partial qualifier can be appended to tiles that
 // would contain fewer than 256 entries.
 func EntriesPathForLogIndex(seq, logSize uint64) string {
-	return EntriesPath(seq/EntryBundleWidth, PartialTileSize(0, seq, logSize))
+	return EntriesPath(seq/EntryBundleWidth, PartialTileSize(0, seq/EntryBundleWidth, logSize))
 }
 
 // NWithSuffix returns a tiles-spec "N" path, with a partial suffix if p > 0.
@@ -55,6 +57,12 @@ func EntriesPath(n uint64, p uint8) string {
 	return fmt.Sprintf("tile/entries/%s", NWithSuffix(0, n, p))
 }
 
+// SignaturePath returns the path of the detached signature which logs that sign their tiles and entry
+// bundles publish alongside the resource at the given path.
+func SignaturePath(path string) string {
+	return path + ".sig"
+}
+
 // TilePath builds the path to the subtree tile with the given level and index in tile space.
 // If p > 0 the path represents a partial tile.
 func TilePath(tileLevel, tileIndex uint64, p uint8) string {
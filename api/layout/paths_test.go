@@ -45,6 +45,18 @@ func TestEntriesPathForLogIndex(t *testing.T) {
 			seq:      256,
 			logSize:  257,
 			wantPath: "tile/entries/001.p/1",
+		}, {
+			seq:      300,
+			logSize:  600,
+			wantPath: "tile/entries/001",
+		}, {
+			seq:      511,
+			logSize:  513,
+			wantPath: "tile/entries/001",
+		}, {
+			seq:      512,
+			logSize:  513,
+			wantPath: "tile/entries/002.p/1",
 		}, {
 			seq:      123456789 * 256,
 			logSize:  123456790 * 256,
@@ -281,6 +293,11 @@ func TestParseTileLevelIndexPartial(t *testing.T) {
 			pathIndex: "x001/002.p/256",
 			wantErr:   true,
 		},
+		{
+			pathLevel: "8",
+			pathIndex: "x001/002.p/0",
+			wantErr:   true,
+		},
 		{
 			pathLevel: "63",
 			pathIndex: "x999/x999/x999/x999/x999/x999/999.p/255",
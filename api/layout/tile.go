@@ -47,3 +47,16 @@ func NodeCoordsToTileAddress(treeLevel, treeIndex uint64) (uint64, uint64, uint,
 
 	return tileLevel, tileIndex, nodeLevel, nodeIndex
 }
+
+// ResourceCount returns the number of tiles, across all levels, and the number of entry bundles which
+// make up a tree of the given size, counting both full and partial resources.
+//
+// Only the resources needed to serve the tree at this size are counted; storage implementations
+// may additionally retain partial tiles and bundles for earlier sizes which have since been superseded.
+func ResourceCount(size uint64) (tiles, bundles uint64) {
+	for s := size; s > 0; s >>= TileHeight {
+		tiles += (s + TileWidth - 1) / TileWidth
+	}
+	bundles = (size + EntryBundleWidth - 1) / EntryBundleWidth
+	return tiles, bundles
+}
@@ -82,3 +82,68 @@ func TestNodeCoordsToTileAddress(t *testing.T) {
 		})
 	}
 }
+
+func TestPartialTileSize(t *testing.T) {
+	for _, test := range []struct {
+		level, index, logSize uint64
+		want                  uint8
+	}{
+		// Level 0, around the first tile boundary.
+		{level: 0, index: 0, logSize: 1, want: 1},
+		{level: 0, index: 0, logSize: 255, want: 255},
+		{level: 0, index: 0, logSize: 256, want: 0},
+		{level: 0, index: 0, logSize: 257, want: 0},
+		{level: 0, index: 1, logSize: 257, want: 1},
+		{level: 0, index: 1, logSize: 511, want: 255},
+		{level: 0, index: 1, logSize: 512, want: 0},
+		// Level 0, far from the start of the log.
+		{level: 0, index: 1 << 30, logSize: 1<<38 + 7, want: 7},
+		{level: 0, index: 1<<30 - 1, logSize: 1<<38 + 7, want: 0},
+		{level: 0, index: 1<<30 - 1, logSize: 1 << 38, want: 0},
+		// Level 1 tiles appear once the log has at least one full level 0 tile.
+		{level: 1, index: 0, logSize: 256, want: 1},
+		{level: 1, index: 0, logSize: 511, want: 1},
+		{level: 1, index: 0, logSize: 512, want: 2},
+		{level: 1, index: 0, logSize: 256*256 - 1, want: 255},
+		{level: 1, index: 0, logSize: 256 * 256, want: 0},
+		{level: 1, index: 1, logSize: 256*256 + 256, want: 1},
+		// Level 2 tiles appear once the log has at least one full level 1 tile.
+		{level: 2, index: 0, logSize: 256 * 256, want: 1},
+		{level: 2, index: 0, logSize: 256*256*256 - 1, want: 255},
+		{level: 2, index: 0, logSize: 256 * 256 * 256, want: 0},
+	} {
+		t.Run(fmt.Sprintf("%d/%d@%d", test.level, test.index, test.logSize), func(t *testing.T) {
+			if got := PartialTileSize(test.level, test.index, test.logSize); got != test.want {
+				t.Errorf("PartialTileSize(%d, %d, %d) = %d, want %d", test.level, test.index, test.logSize, got, test.want)
+			}
+		})
+	}
+}
+
+func TestResourceCount(t *testing.T) {
+	for _, test := range []struct {
+		size                   uint64
+		wantTiles, wantBundles uint64
+	}{
+		{size: 0, wantTiles: 0, wantBundles: 0},
+		{size: 1, wantTiles: 1, wantBundles: 1},
+		{size: 255, wantTiles: 1, wantBundles: 1},
+		// The root of a full bottom-level tile is the first node in a level 1 tile.
+		{size: 256, wantTiles: 2, wantBundles: 1},
+		{size: 257, wantTiles: 3, wantBundles: 2},
+		{size: 512, wantTiles: 3, wantBundles: 2},
+		{size: 256 * 256, wantTiles: 256 + 1 + 1, wantBundles: 256},
+		{size: 256*256 + 1, wantTiles: 257 + 1 + 1, wantBundles: 257},
+		{size: 1 << 32, wantTiles: 1<<24 + 1<<16 + 1<<8 + 1 + 1, wantBundles: 1 << 24},
+	} {
+		t.Run(fmt.Sprintf("%d", test.size), func(t *testing.T) {
+			tiles, bundles := ResourceCount(test.size)
+			if tiles != test.wantTiles {
+				t.Errorf("got %d tiles, want %d", tiles, test.wantTiles)
+			}
+			if bundles != test.wantBundles {
+				t.Errorf("got %d bundles, want %d", bundles, test.wantBundles)
+			}
+		})
+	}
+}
@@ -0,0 +1,30 @@
+// Copyright 2024 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+// LogMetadata describes the parameters a client needs in order to use a log.
+//
+// It is published as JSON at layout.MetadataPath.
+type LogMetadata struct {
+	// Origin is the origin line of the log's checkpoints.
+	Origin string `json:"origin"`
+	// PublicKeys holds the verifier keys, in note.NewVerifier format, for the keys which may sign
+	// the log's checkpoints.
+	PublicKeys []string `json:"public_keys"`
+	// TileHeight is the number of Merkle tree levels represented by each tile.
+	TileHeight uint `json:"tile_height"`
+	// EntryBundleWidth is the maximum number of entries in an entry bundle.
+	EntryBundleWidth uint `json:"entry_bundle_width"`
+}
@@ -0,0 +1,92 @@
+// Copyright 2025 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ResourceStatementHeader is the first line of every encoded ResourceStatement.
+//
+// Resource statements are signed with the same key(s) as the log's checkpoints, so this fixed line
+// ensures that a signed statement can never be mistaken for a checkpoint, or any other signed note,
+// regardless of the log's origin or the resource's path.
+const ResourceStatementHeader = "tessera/resource-statement/v1"
+
+// ResourceStatement is the body of the signed note which a log may publish at layout.SignaturePath
+// alongside each tile or entry bundle it writes, attesting to the content of that resource.
+//
+// This allows the provenance of a resource, e.g. one served from a CDN cache, to be checked
+// without reconstructing the tree up to a checkpoint.
+type ResourceStatement struct {
+	// Origin is the origin line of the log's checkpoints.
+	Origin string
+	// Path is the path of the resource within the log.
+	Path string
+	// Hash is the SHA-256 hash of the resource's content.
+	Hash []byte
+}
+
+// NewResourceStatement returns a ResourceStatement for the given resource content.
+func NewResourceStatement(origin, path string, data []byte) ResourceStatement {
+	h := sha256.Sum256(data)
+	return ResourceStatement{Origin: origin, Path: path, Hash: h[:]}
+}
+
+// MarshalText implements encoding/TextMarshaler.
+//
+// The statement is encoded as four newline terminated lines: ResourceStatementHeader, the origin,
+// the path, and the base64 encoded hash.
+func (s ResourceStatement) MarshalText() ([]byte, error) {
+	if s.Origin == "" || s.Path == "" {
+		return nil, errors.New("origin and path must not be empty")
+	}
+	if strings.ContainsRune(s.Origin+s.Path, '\n') {
+		return nil, errors.New("origin and path must not contain newlines")
+	}
+	if len(s.Hash) != sha256.Size {
+		return nil, fmt.Errorf("hash is %d bytes, want %d", len(s.Hash), sha256.Size)
+	}
+	return []byte(fmt.Sprintf("%s\n%s\n%s\n%s\n", ResourceStatementHeader, s.Origin, s.Path, base64.StdEncoding.EncodeToString(s.Hash))), nil
+}
+
+// UnmarshalText implements encoding/TextUnmarshaler.
+func (s *ResourceStatement) UnmarshalText(raw []byte) error {
+	lines := bytes.Split(raw, []byte{'\n'})
+	if len(lines) != 5 || len(lines[4]) != 0 {
+		return errors.New("resource statement must contain exactly four newline terminated lines")
+	}
+	if string(lines[0]) != ResourceStatementHeader {
+		return fmt.Errorf("resource statement must start with %q", ResourceStatementHeader)
+	}
+	lines = lines[1:]
+	if len(lines[0]) == 0 || len(lines[1]) == 0 {
+		return errors.New("origin and path must not be empty")
+	}
+	h, err := base64.StdEncoding.DecodeString(string(lines[2]))
+	if err != nil {
+		return fmt.Errorf("invalid hash: %v", err)
+	}
+	if len(h) != sha256.Size {
+		return fmt.Errorf("hash is %d bytes, want %d", len(h), sha256.Size)
+	}
+	s.Origin, s.Path, s.Hash = string(lines[0]), string(lines[1]), h
+	return nil
+}
@@ -22,6 +22,7 @@ import (
 	"crypto/sha256"
 	"encoding/binary"
 	"fmt"
+	"math"
 
 	"github.com/transparency-dev/trillian-tessera/api/layout"
 )
@@ -73,21 +74,110 @@ type EntryBundle struct {
 // UnmarshalText implements encoding/TextUnmarshaler and reads EntryBundles
 // which are encoded using the tlog-tiles spec.
 func (t *EntryBundle) UnmarshalText(raw []byte) error {
+	return t.UnmarshalWithCodec(raw, LengthPrefixedCodec{})
+}
+
+// UnmarshalWithCodec reads EntryBundles which were encoded using the provided codec.
+//
+// The codec MUST be the same one which was used to serialise the entries when they were
+// added to the log.
+func (t *EntryBundle) UnmarshalWithCodec(raw []byte, c EntryBundleCodec) error {
+	entries, err := c.UnmarshalBundle(raw)
+	if err != nil {
+		return err
+	}
+	t.Entries = entries
+	return nil
+}
+
+// EntryBundleCodec describes how entries are serialised into, and parsed out of, an EntryBundle.
+//
+// Storage implementations grow partial bundles by appending newly serialised entries to the
+// existing bundle data, so implementations MUST produce encodings which can simply be concatenated.
+type EntryBundleCodec interface {
+	// ID identifies the encoding produced by this codec, including any parameters which affect it, e.g.
+	// "tlog-tiles". Two codecs with the same ID MUST produce identical encodings.
+	//
+	// Logs only accept entries which were marshalled by a codec with the same ID as their own.
+	ID() string
+	// MarshalEntry returns the serialised form of the provided entry data, ready to be appended to a bundle.
+	MarshalEntry(data []byte) ([]byte, error)
+	// UnmarshalBundle parses a serialised entry bundle into its constituent entries.
+	UnmarshalBundle(raw []byte) ([][]byte, error)
+}
+
+// LengthPrefixedCodec is the default EntryBundleCodec, and implements the entry bundle format
+// described by the tlog-tiles spec, where each entry is prefixed by its length as a big-endian uint16.
+type LengthPrefixedCodec struct{}
+
+// ID implements EntryBundleCodec.
+func (LengthPrefixedCodec) ID() string { return "tlog-tiles" }
+
+// MarshalEntry implements EntryBundleCodec.
+func (LengthPrefixedCodec) MarshalEntry(data []byte) ([]byte, error) {
+	if l := len(data); l > math.MaxUint16 {
+		return nil, fmt.Errorf("entry of %d bytes is larger than maximum permitted size %d", l, math.MaxUint16)
+	}
+	r := make([]byte, 0, 2+len(data))
+	r = binary.BigEndian.AppendUint16(r, uint16(len(data)))
+	r = append(r, data...)
+	return r, nil
+}
+
+// UnmarshalBundle implements EntryBundleCodec.
+func (LengthPrefixedCodec) UnmarshalBundle(raw []byte) ([][]byte, error) {
 	nodes := make([][]byte, 0, layout.EntryBundleWidth)
 	for index := 0; index < len(raw); {
 		dataIndex := index + 2
 		if dataIndex > len(raw) {
-			return fmt.Errorf("dangling bytes at byte index %d in data of %d bytes", index, len(raw))
+			return nil, fmt.Errorf("dangling bytes at byte index %d in data of %d bytes", index, len(raw))
 		}
 		size := int(binary.BigEndian.Uint16(raw[index:dataIndex]))
 		dataEnd := dataIndex + size
 		if dataEnd > len(raw) {
-			return fmt.Errorf("require %d bytes from byte index %d, but size is %d", size, dataIndex, len(raw))
+			return nil, fmt.Errorf("require %d bytes from byte index %d, but size is %d", size, dataIndex, len(raw))
 		}
 		data := raw[dataIndex:dataEnd]
 		nodes = append(nodes, data)
 		index = dataIndex + size
 	}
-	t.Entries = nodes
-	return nil
+	return nodes, nil
+}
+
+// HashCodec is an EntryBundleCodec for logs whose entries are all fixed-size hashes (e.g. the hash of a
+// binary stored elsewhere).
+//
+// Entries are stored in bundles without any framing, which reduces the storage required for such logs.
+type HashCodec struct {
+	// Size is the length in bytes which every entry must have, e.g. sha256.Size.
+	Size int
+}
+
+// ID implements EntryBundleCodec.
+func (c HashCodec) ID() string { return fmt.Sprintf("hash/%d", c.Size) }
+
+// MarshalEntry implements EntryBundleCodec.
+func (c HashCodec) MarshalEntry(data []byte) ([]byte, error) {
+	if c.Size <= 0 {
+		return nil, fmt.Errorf("invalid hash size %d", c.Size)
+	}
+	if l := len(data); l != c.Size {
+		return nil, fmt.Errorf("entry is %d bytes, want %d", l, c.Size)
+	}
+	return data, nil
+}
+
+// UnmarshalBundle implements EntryBundleCodec.
+func (c HashCodec) UnmarshalBundle(raw []byte) ([][]byte, error) {
+	if c.Size <= 0 {
+		return nil, fmt.Errorf("invalid hash size %d", c.Size)
+	}
+	if len(raw)%c.Size != 0 {
+		return nil, fmt.Errorf("%d is not a multiple of %d", len(raw), c.Size)
+	}
+	nodes := make([][]byte, 0, len(raw)/c.Size)
+	for index := 0; index < len(raw); index += c.Size {
+		nodes = append(nodes, raw[index:index+c.Size])
+	}
+	return nodes, nil
 }
@@ -109,6 +109,87 @@ func TestLeafBundle_MarshalTileRoundtrip(t *testing.T) {
 	}
 }
 
+// fixedSizeCodec is an EntryBundleCodec for entries which are always exactly 4 bytes long.
+type fixedSizeCodec struct{}
+
+func (fixedSizeCodec) ID() string { return "fixed-4" }
+
+func (fixedSizeCodec) MarshalEntry(data []byte) ([]byte, error) {
+	if len(data) != 4 {
+		return nil, fmt.Errorf("entry must be 4 bytes, got %d", len(data))
+	}
+	return data, nil
+}
+
+func (fixedSizeCodec) UnmarshalBundle(raw []byte) ([][]byte, error) {
+	if len(raw)%4 != 0 {
+		return nil, fmt.Errorf("%d is not a multiple of 4", len(raw))
+	}
+	r := make([][]byte, 0, len(raw)/4)
+	for i := 0; i < len(raw); i += 4 {
+		r = append(r, raw[i:i+4])
+	}
+	return r, nil
+}
+
+func TestLeafBundle_CodecRoundtrip(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		codec api.EntryBundleCodec
+	}{
+		{
+			name:  "length prefixed",
+			codec: api.LengthPrefixedCodec{},
+		}, {
+			name:  "fixed size",
+			codec: fixedSizeCodec{},
+		}, {
+			name:  "hash",
+			codec: api.HashCodec{Size: 4},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			bundleRaw := &bytes.Buffer{}
+			want := make([][]byte, 42)
+			for i := range want {
+				want[i] = []byte(fmt.Sprintf("%04d", i))
+				e, err := tessera.NewEntryWithCodec(want[i], test.codec)
+				if err != nil {
+					t.Fatalf("NewEntryWithCodec(): %v", err)
+				}
+				_, _ = bundleRaw.Write(e.MarshalBundleData(uint64(i)))
+			}
+
+			bundle := api.EntryBundle{}
+			if err := bundle.UnmarshalWithCodec(bundleRaw.Bytes(), test.codec); err != nil {
+				t.Fatalf("UnmarshalWithCodec() = %v", err)
+			}
+			if diff := cmp.Diff(want, bundle.Entries); diff != "" {
+				t.Fatalf("Got entries with diff: %s", diff)
+			}
+		})
+	}
+}
+
+func TestLengthPrefixedCodec_MarshalEntryTooLarge(t *testing.T) {
+	if _, err := (api.LengthPrefixedCodec{}).MarshalEntry(make([]byte, 1<<16)); err == nil {
+		t.Fatal("MarshalEntry() succeeded for oversized entry, want error")
+	}
+}
+
+func TestHashCodec(t *testing.T) {
+	c := api.HashCodec{Size: sha256.Size}
+	if _, err := c.MarshalEntry([]byte("too short")); err == nil {
+		t.Error("MarshalEntry() succeeded with wrong sized entry, want error")
+	}
+	if _, err := c.UnmarshalBundle(make([]byte, sha256.Size+1)); err == nil {
+		t.Error("UnmarshalBundle() succeeded with dangling bytes, want error")
+	}
+	if _, err := (api.HashCodec{}).MarshalEntry(nil); err == nil {
+		t.Error("MarshalEntry() succeeded with zero Size, want error")
+	}
+}
+
 func TestLeafBundle_UnmarshalText(t *testing.T) {
 	for _, test := range []struct {
 		desc    string
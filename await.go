@@ -89,6 +89,19 @@ func (a *IntegrationAwaiter) Await(ctx context.Context, future IndexFuture) (uin
 	return i, cp, err
 }
 
+// AwaitIndex blocks until the log has made a checkpoint available which commits to
+// the given index, i.e. one whose size is larger than index, and returns that checkpoint.
+//
+// This is useful for callers which already know the index assigned to an entry
+// (e.g. one persisted from an earlier Add), and no longer have its IndexFuture.
+//
+// This operation can be aborted early by cancelling the context. In this event,
+// or in the event that there is an error getting a valid checkpoint, an error
+// will be returned from this method.
+func (a *IntegrationAwaiter) AwaitIndex(ctx context.Context, index uint64) ([]byte, error) {
+	return a.await(ctx, index)
+}
+
 // pollLoop MUST be called in a goroutine when constructing an IntegrationAwaiter
 // and will run continually until its context is cancelled. It wakes up every
 // `pollPeriod` to check if there are clients blocking. If there are, it requests
@@ -226,3 +226,24 @@ func TestAwait_multiClient(t *testing.T) {
 	}
 	wg.Wait()
 }
+
+func TestAwaitIndex(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	size := uint64(0)
+	readCheckpoint := func(ctx context.Context) ([]byte, error) {
+		size++
+		return []byte(fmt.Sprintf("origin\n%d\nqINS1GRFhWHwdkUeqLEoP4yEMkTBBzxBkGwGQlVlVcs=\n", size)), nil
+	}
+	awaiter := tessera.NewIntegrationAwaiter(ctx, readCheckpoint, 5*time.Millisecond)
+
+	cp, err := awaiter.AwaitIndex(ctx, 4)
+	if err != nil {
+		t.Fatalf("AwaitIndex: %v", err)
+	}
+	if want := []byte("origin\n5\n"); !bytes.HasPrefix(cp, want) {
+		t.Errorf("got checkpoint %q, want one with prefix %q", cp, want)
+	}
+}
@@ -285,17 +285,41 @@ func (n *nodeCache) GetNode(ctx context.Context, id compact.NodeID) ([]byte, err
 
 // GetEntryBundle fetches the entry bundle at the given _tile index_.
 func GetEntryBundle(ctx context.Context, f EntryBundleFetcherFunc, i, logSize uint64) (api.EntryBundle, error) {
+	return GetEntryBundleWithCodec(ctx, f, i, logSize, api.LengthPrefixedCodec{})
+}
+
+// GetEntryBundleWithCodec fetches the entry bundle at the given _tile index_, and parses it using the provided codec.
+//
+// The codec MUST match the one used when the entries were added to the log.
+// An error is returned if the bundle contains fewer entries than expected for a log of size logSize, or if
+// it contains more than layout.EntryBundleWidth entries. Some logs serve a larger bundle than requested for a
+// partial index, e.g. when the log has grown since logSize, in which case the extra entries are discarded;
+// full bundles must contain exactly layout.EntryBundleWidth entries.
+func GetEntryBundleWithCodec(ctx context.Context, f EntryBundleFetcherFunc, i, logSize uint64, c api.EntryBundleCodec) (api.EntryBundle, error) {
 	bundle := api.EntryBundle{}
-	sRaw, err := f(ctx, i, layout.PartialTileSize(0, i, logSize))
+	p := layout.PartialTileSize(0, i, logSize)
+	sRaw, err := f(ctx, i, p)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
 			return bundle, fmt.Errorf("leaf bundle at index %d not found: %v", i, err)
 		}
 		return bundle, fmt.Errorf("failed to fetch leaf bundle at index %d: %v", i, err)
 	}
-	if err := bundle.UnmarshalText(sRaw); err != nil {
+	if err := bundle.UnmarshalWithCodec(sRaw, c); err != nil {
 		return bundle, fmt.Errorf("failed to parse EntryBundle at index %d: %v", i, err)
 	}
+	// The number of entries is determined by the bundle content rather than assumed, so check that there are
+	// as many as the log size says there should be. Too few, or too many in a full bundle, means that the bundle
+	// was written with a different bundle width or codec, and indexing into it would return the wrong entries.
+	want := uint64(p)
+	if p == 0 {
+		want = layout.EntryBundleWidth
+	}
+	got := uint64(len(bundle.Entries))
+	if got < want || got > layout.EntryBundleWidth || (p == 0 && got != want) {
+		return bundle, fmt.Errorf("EntryBundle at index %d has %d entries, want %d", i, got, want)
+	}
+	bundle.Entries = bundle.Entries[:want]
 	return bundle, nil
 }
 
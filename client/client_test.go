@@ -348,20 +348,55 @@ func TestGetEntryBundleAddressing(t *testing.T) {
 	for _, test := range []struct {
 		name                string
 		idx, logSize        uint64
+		bundleEntries       int
 		wantPartialTileSize uint8
+		wantErr             bool
 	}{
 		{
 			name:                "works - partial tile",
 			idx:                 0,
 			logSize:             34,
+			bundleEntries:       34,
 			wantPartialTileSize: 34,
 		},
 		{
 			name:                "works - full tile",
 			idx:                 1,
 			logSize:             layout.TileWidth*2 + 45,
+			bundleEntries:       layout.EntryBundleWidth,
 			wantPartialTileSize: 0,
 		},
+		{
+			name:                "too few entries",
+			idx:                 1,
+			logSize:             layout.TileWidth*2 + 45,
+			bundleEntries:       128,
+			wantPartialTileSize: 0,
+			wantErr:             true,
+		},
+		{
+			name:                "works - larger bundle than requested",
+			idx:                 0,
+			logSize:             34,
+			bundleEntries:       35,
+			wantPartialTileSize: 34,
+		},
+		{
+			name:                "too many entries - full tile",
+			idx:                 1,
+			logSize:             layout.TileWidth*2 + 45,
+			bundleEntries:       layout.EntryBundleWidth + 1,
+			wantPartialTileSize: 0,
+			wantErr:             true,
+		},
+		{
+			name:                "too many entries - partial tile",
+			idx:                 0,
+			logSize:             34,
+			bundleEntries:       layout.EntryBundleWidth + 1,
+			wantPartialTileSize: 34,
+			wantErr:             true,
+		},
 	} {
 		t.Run(test.name, func(t *testing.T) {
 			gotIdx := uint64(0)
@@ -369,11 +404,22 @@ func TestGetEntryBundleAddressing(t *testing.T) {
 			f := func(_ context.Context, i uint64, sz uint8) ([]byte, error) {
 				gotIdx = i
 				gotTileSize = sz
-				return []byte{}, nil
+				b := []byte{}
+				for j := 0; j < test.bundleEntries; j++ {
+					e, err := api.LengthPrefixedCodec{}.MarshalEntry([]byte{byte(j)})
+					if err != nil {
+						return nil, err
+					}
+					b = append(b, e...)
+				}
+				return b, nil
 			}
-			_, err := GetEntryBundle(context.Background(), f, test.idx, test.logSize)
-			if err != nil {
-				t.Fatalf("GetEntryBundle: %v", err)
+			bundle, err := GetEntryBundle(context.Background(), f, test.idx, test.logSize)
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Fatalf("GetEntryBundle: %v, wantErr %t", err, test.wantErr)
+			}
+			if wantEntries := layout.PartialTileSize(0, test.idx, test.logSize); err == nil && wantEntries > 0 && len(bundle.Entries) != int(wantEntries) {
+				t.Errorf("got %d entries, want %d", len(bundle.Entries), wantEntries)
 			}
 			if gotIdx != test.idx {
 				t.Errorf("f got idx %d, want %d", gotIdx, test.idx)
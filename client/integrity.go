@@ -0,0 +1,101 @@
+// Copyright 2024 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand/v2"
+
+	"github.com/transparency-dev/merkle/proof"
+	"github.com/transparency-dev/trillian-tessera/api/layout"
+	"golang.org/x/mod/sumdb/note"
+)
+
+// CheckIntegrity performs a bounded check of the integrity of a copy of a log, e.g. a mirror or replica
+// which is about to start serving.
+//
+// The checkpoint is verified, and its root hash is checked against the tiles. Then, up to samples entry bundles,
+// always including the right-most one, are checked to contain entries which hash to the corresponding leaves in
+// the tiles, and to be included in the tree committed to by the checkpoint.
+//
+// The amount of work done is proportional to samples rather than the size of the log, so this does not prove
+// that the whole log is intact.
+func CheckIntegrity(ctx context.Context, cpF CheckpointFetcherFunc, tF TileFetcherFunc, bF EntryBundleFetcherFunc, v note.Verifier, origin string, samples uint) error {
+	cp, _, _, err := FetchCheckpoint(ctx, cpF, v, origin)
+	if err != nil {
+		return fmt.Errorf("failed to fetch checkpoint: %v", err)
+	}
+	if cp.Size == 0 {
+		return nil
+	}
+	pb, err := NewProofBuilder(ctx, *cp, tF)
+	if err != nil {
+		return fmt.Errorf("checkpoint does not match tiles: %v", err)
+	}
+
+	numBundles := (cp.Size-1)/layout.EntryBundleWidth + 1
+	for _, b := range sampleBundles(numBundles, uint64(samples)) {
+		first := b * layout.EntryBundleWidth
+		bundle, err := GetEntryBundle(ctx, bF, b, cp.Size)
+		if err != nil {
+			return err
+		}
+		leaves, err := FetchLeafHashes(ctx, tF, first, uint64(len(bundle.Entries)), cp.Size)
+		if err != nil {
+			return fmt.Errorf("failed to fetch leaf hashes for entry bundle %d: %v", b, err)
+		}
+		for i, e := range bundle.Entries {
+			if h := hasher.HashLeaf(e); !bytes.Equal(h, leaves[i]) {
+				return fmt.Errorf("entry %d has leaf hash %x, but tile has %x", first+uint64(i), h, leaves[i])
+			}
+		}
+		p, err := pb.InclusionProof(ctx, first)
+		if err != nil {
+			return fmt.Errorf("failed to build inclusion proof for entry %d: %v", first, err)
+		}
+		if err := proof.VerifyInclusion(hasher, first, cp.Size, leaves[0], p, cp.Hash); err != nil {
+			return fmt.Errorf("entry %d is not included in checkpoint: %v", first, err)
+		}
+	}
+	return nil
+}
+
+// sampleBundles returns the indices of up to n distinct entry bundles selected at random from a log with
+// numBundles bundles. The right-most bundle is always included if n > 0.
+func sampleBundles(numBundles, n uint64) []uint64 {
+	if n >= numBundles {
+		r := make([]uint64, numBundles)
+		for i := range r {
+			r[i] = uint64(i)
+		}
+		return r
+	}
+	if n == 0 {
+		return nil
+	}
+	seen := map[uint64]bool{numBundles - 1: true}
+	r := []uint64{numBundles - 1}
+	for uint64(len(r)) < n {
+		b := rand.Uint64N(numBundles - 1)
+		if seen[b] {
+			continue
+		}
+		seen[b] = true
+		r = append(r, b)
+	}
+	return r
+}
@@ -0,0 +1,113 @@
+// Copyright 2024 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/transparency-dev/trillian-tessera/api/layout"
+)
+
+func TestCheckIntegrity(t *testing.T) {
+	ctx := context.Background()
+	cpF := func(ctx context.Context) ([]byte, error) { return testLogFetcher(ctx, layout.CheckpointPath) }
+	bF := func(ctx context.Context, i uint64, p uint8) ([]byte, error) {
+		return testLogFetcher(ctx, layout.EntriesPath(i, p))
+	}
+	// corrupt returns a copy of d with its last byte flipped.
+	corrupt := func(d []byte, err error) ([]byte, error) {
+		if err != nil {
+			return nil, err
+		}
+		d = slices.Clone(d)
+		d[len(d)-1] ^= 0xff
+		return d, nil
+	}
+
+	for _, test := range []struct {
+		name    string
+		cpF     CheckpointFetcherFunc
+		tF      TileFetcherFunc
+		bF      EntryBundleFetcherFunc
+		origin  string
+		wantErr bool
+	}{
+		{
+			name:   "ok",
+			cpF:    cpF,
+			tF:     testLogTileFetcher,
+			bF:     bF,
+			origin: testOrigin,
+		}, {
+			name:    "wrong origin",
+			cpF:     cpF,
+			tF:      testLogTileFetcher,
+			bF:      bF,
+			origin:  "example.com/another/log",
+			wantErr: true,
+		}, {
+			name: "corrupt tile",
+			cpF:  cpF,
+			tF: func(ctx context.Context, l, i uint64, p uint8) ([]byte, error) {
+				return corrupt(testLogTileFetcher(ctx, l, i, p))
+			},
+			bF:      bF,
+			origin:  testOrigin,
+			wantErr: true,
+		}, {
+			name: "corrupt entry bundle",
+			cpF:  cpF,
+			tF:   testLogTileFetcher,
+			bF: func(ctx context.Context, i uint64, p uint8) ([]byte, error) {
+				return corrupt(bF(ctx, i, p))
+			},
+			origin:  testOrigin,
+			wantErr: true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			err := CheckIntegrity(ctx, test.cpF, test.tF, test.bF, testLogVerifier, test.origin, 4)
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Fatalf("CheckIntegrity: %v, wantErr %t", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestSampleBundles(t *testing.T) {
+	for _, test := range []struct {
+		numBundles, n uint64
+		wantLen       int
+	}{
+		{numBundles: 1, n: 4, wantLen: 1},
+		{numBundles: 4, n: 4, wantLen: 4},
+		{numBundles: 100, n: 4, wantLen: 4},
+		{numBundles: 100, n: 0, wantLen: 0},
+	} {
+		got := sampleBundles(test.numBundles, test.n)
+		if len(got) != test.wantLen {
+			t.Errorf("sampleBundles(%d, %d): got %d bundles, want %d", test.numBundles, test.n, len(got), test.wantLen)
+		}
+		if test.wantLen > 0 && !slices.Contains(got, test.numBundles-1) {
+			t.Errorf("sampleBundles(%d, %d) = %v, want right-most bundle included", test.numBundles, test.n, got)
+		}
+		slices.Sort(got)
+		if len(slices.Compact(got)) != len(got) {
+			t.Errorf("sampleBundles(%d, %d) = %v, contains duplicates", test.numBundles, test.n, got)
+		}
+	}
+}
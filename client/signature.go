@@ -0,0 +1,50 @@
+// Copyright 2025 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/transparency-dev/trillian-tessera/api"
+	"golang.org/x/mod/sumdb/note"
+)
+
+// VerifyResourceSignature checks that sig, fetched from layout.SignaturePath(path), is a valid signature by
+// the log over data, the content of the tile or entry bundle fetched from path.
+//
+// This allows the provenance of an individual resource to be checked without reconstructing the tree up to
+// a checkpoint, for logs which sign their resources.
+func VerifyResourceSignature(path string, data, sig []byte, origin string, v note.Verifier) error {
+	n, err := note.Open(sig, note.VerifierList(v))
+	if err != nil {
+		return fmt.Errorf("failed to verify signature for %q: %v", path, err)
+	}
+	var s api.ResourceStatement
+	if err := s.UnmarshalText([]byte(n.Text)); err != nil {
+		return fmt.Errorf("failed to parse signed statement for %q: %v", path, err)
+	}
+	if s.Origin != origin {
+		return fmt.Errorf("signed statement for %q has origin %q, want %q", path, s.Origin, origin)
+	}
+	if s.Path != path {
+		return fmt.Errorf("signed statement for %q is for path %q", path, s.Path)
+	}
+	if h := sha256.Sum256(data); !bytes.Equal(h[:], s.Hash) {
+		return fmt.Errorf("content of %q does not match signed hash", path)
+	}
+	return nil
+}
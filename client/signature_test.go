@@ -0,0 +1,93 @@
+// Copyright 2025 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"testing"
+
+	"github.com/transparency-dev/trillian-tessera/api"
+	"golang.org/x/mod/sumdb/note"
+)
+
+func TestVerifyResourceSignature(t *testing.T) {
+	const path = "tile/entries/000"
+	data := []byte("bundle")
+
+	dataHash := sha256.Sum256(data)
+
+	signText := func(text string) []byte {
+		t.Helper()
+		sig, err := note.Sign(&note.Note{Text: text}, testLogSigner)
+		if err != nil {
+			t.Fatalf("Sign: %v", err)
+		}
+		return sig
+	}
+	sign := func(origin, path string, data []byte) []byte {
+		t.Helper()
+		body, err := api.NewResourceStatement(origin, path, data).MarshalText()
+		if err != nil {
+			t.Fatalf("MarshalText: %v", err)
+		}
+		return signText(string(body))
+	}
+
+	for _, test := range []struct {
+		name    string
+		data    []byte
+		sig     []byte
+		wantErr bool
+	}{
+		{
+			name: "ok",
+			data: data,
+			sig:  sign(testOrigin, path, data),
+		}, {
+			name:    "wrong content",
+			data:    []byte("other bundle"),
+			sig:     sign(testOrigin, path, data),
+			wantErr: true,
+		}, {
+			name:    "wrong path",
+			data:    data,
+			sig:     sign(testOrigin, "tile/entries/001", data),
+			wantErr: true,
+		}, {
+			name:    "wrong origin",
+			data:    data,
+			sig:     sign("example.com/other", path, data),
+			wantErr: true,
+		}, {
+			name:    "missing header",
+			data:    data,
+			sig:     signText(testOrigin + "\n" + path + "\n" + base64.StdEncoding.EncodeToString(dataHash[:]) + "\n"),
+			wantErr: true,
+		}, {
+			name:    "bad signature",
+			data:    data,
+			sig:     []byte("not a note"),
+			wantErr: true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			err := VerifyResourceSignature(path, test.data, test.sig, testOrigin, testLogVerifier)
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Fatalf("VerifyResourceSignature: %v, wantErr %t", err, test.wantErr)
+			}
+		})
+	}
+}
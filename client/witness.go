@@ -0,0 +1,137 @@
+// Copyright 2024 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/transparency-dev/formats/log"
+	"github.com/transparency-dev/merkle/proof"
+	"golang.org/x/mod/sumdb/note"
+)
+
+// Witness describes a source of checkpoints cosigned by a witness.
+type Witness struct {
+	// Name identifies the witness in results.
+	Name string
+	// Verifier verifies the witness's cosignatures.
+	Verifier note.Verifier
+	// Fetch returns the latest checkpoint for the log which has been cosigned by the witness.
+	Fetch CheckpointFetcherFunc
+}
+
+// WitnessComparison is the result of comparing a witness's view of a log with the log's own checkpoint.
+type WitnessComparison struct {
+	// Witness is the name of the witness.
+	Witness string
+	// CheckpointRaw is the cosigned checkpoint fetched from the witness, if any.
+	CheckpointRaw []byte
+	// Err is nil if the witness has cosigned a checkpoint which is consistent with the log's checkpoint.
+	//
+	// If the witness has cosigned an inconsistent checkpoint, i.e. the log has presented a split view, this
+	// wraps an ErrInconsistency holding the evidence. Otherwise, a non-nil error indicates that the comparison
+	// could not be performed, e.g. because the witness was unreachable.
+	Err error
+}
+
+// CompareWithWitnesses fetches the latest cosigned checkpoint for the log from each of the witnesses, and
+// checks that it is consistent with the provided log checkpoint, using f to fetch the tiles needed for
+// consistency proofs.
+//
+// This allows a monitor to detect a log presenting a split view, i.e. a checkpoint to the witnesses which
+// conflicts with the one it serves itself.
+//
+// Returns an error if logCPRaw cannot be verified, otherwise returns one WitnessComparison per witness,
+// in the same order.
+func CompareWithWitnesses(ctx context.Context, logCPRaw []byte, logV note.Verifier, origin string, f TileFetcherFunc, witnesses []Witness) ([]WitnessComparison, error) {
+	logCP, _, _, err := log.ParseCheckpoint(logCPRaw, origin, logV)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse log checkpoint: %v", err)
+	}
+	r := make([]WitnessComparison, 0, len(witnesses))
+	for _, w := range witnesses {
+		c := WitnessComparison{Witness: w.Name}
+		c.CheckpointRaw, c.Err = compareWithWitness(ctx, logCPRaw, *logCP, logV, origin, f, w)
+		r = append(r, c)
+	}
+	return r, nil
+}
+
+// compareWithWitness checks that the checkpoint cosigned by w is consistent with logCP.
+func compareWithWitness(ctx context.Context, logCPRaw []byte, logCP log.Checkpoint, logV note.Verifier, origin string, f TileFetcherFunc, w Witness) ([]byte, error) {
+	wRaw, err := w.Fetch(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch checkpoint from witness: %v", err)
+	}
+	n, err := note.Open(wRaw, note.VerifierList(logV, w.Verifier))
+	if err != nil {
+		return wRaw, fmt.Errorf("failed to open witness checkpoint: %v", err)
+	}
+	if !signedBy(n, logV) || !signedBy(n, w.Verifier) {
+		return wRaw, errors.New("witness checkpoint is not signed by both the log and the witness")
+	}
+	wCP, _, _, err := log.ParseCheckpoint(wRaw, origin, logV)
+	if err != nil {
+		return wRaw, fmt.Errorf("failed to parse witness checkpoint: %v", err)
+	}
+
+	smaller, larger, smallerRaw, largerRaw := logCP, *wCP, logCPRaw, wRaw
+	if smaller.Size > larger.Size {
+		smaller, larger, smallerRaw, largerRaw = larger, smaller, largerRaw, smallerRaw
+	}
+	if smaller.Size == larger.Size {
+		if !bytes.Equal(smaller.Hash, larger.Hash) {
+			return wRaw, ErrInconsistency{
+				SmallerRaw: smallerRaw,
+				LargerRaw:  largerRaw,
+				Wrapped:    fmt.Errorf("checkpoints with same size (%d) but different hashes (%x vs %x)", smaller.Size, smaller.Hash, larger.Hash),
+			}
+		}
+		return wRaw, nil
+	}
+	if smaller.Size == 0 {
+		return wRaw, nil
+	}
+	pb, err := NewProofBuilder(ctx, larger, f)
+	if err != nil {
+		return wRaw, fmt.Errorf("failed to create proof builder: %v", err)
+	}
+	p, err := pb.ConsistencyProof(ctx, smaller.Size, larger.Size)
+	if err != nil {
+		return wRaw, fmt.Errorf("failed to build consistency proof between sizes %d and %d: %v", smaller.Size, larger.Size, err)
+	}
+	if err := proof.VerifyConsistency(hasher, smaller.Size, larger.Size, p, smaller.Hash, larger.Hash); err != nil {
+		return wRaw, ErrInconsistency{
+			SmallerRaw: smallerRaw,
+			LargerRaw:  largerRaw,
+			Proof:      p,
+			Wrapped:    err,
+		}
+	}
+	return wRaw, nil
+}
+
+// signedBy returns true if n has a verified signature from v.
+func signedBy(n *note.Note, v note.Verifier) bool {
+	for _, s := range n.Sigs {
+		if s.Name == v.Name() && s.Hash == v.KeyHash() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,107 @@
+// Copyright 2024 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/transparency-dev/formats/log"
+	"golang.org/x/mod/sumdb/note"
+)
+
+// testLogSigner is the signer for the golden test log, from testdata/build_log.sh.
+var testLogSigner = mustMakeSigner("PRIVATE+KEY+example.com/log/testdata+33d7b496+AeymY/SZAX0jZcJ8enZ5FY1Dz+wTML2yWSkK+9DSF3eg")
+
+func mustMakeSigner(k string) note.Signer {
+	s, err := note.NewSigner(k)
+	if err != nil {
+		panic(fmt.Errorf("NewSigner: %v", err))
+	}
+	return s
+}
+
+func TestCompareWithWitnesses(t *testing.T) {
+	ctx := context.Background()
+	sk, vk, err := note.GenerateKey(rand.Reader, "witness")
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	wS, wV := mustMakeSigner(sk), mustMakeVerifier(vk)
+
+	// cosign returns the raw checkpoint with an additional signature from the witness.
+	cosign := func(cpRaw []byte) []byte {
+		t.Helper()
+		n, err := note.Open(cpRaw, note.VerifierList(testLogVerifier))
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		r, err := note.Sign(n, wS)
+		if err != nil {
+			t.Fatalf("Sign: %v", err)
+		}
+		return r
+	}
+	witness := func(name string, cpRaw []byte) Witness {
+		return Witness{
+			Name:     name,
+			Verifier: wV,
+			Fetch:    func(_ context.Context) ([]byte, error) { return cpRaw, nil },
+		}
+	}
+	// A checkpoint for the same size as testCheckpoints[5], but with a different root.
+	forkRaw, err := note.Sign(&note.Note{Text: string(log.Checkpoint{
+		Origin: testOrigin,
+		Size:   testCheckpoints[5].Size,
+		Hash:   testCheckpoints[4].Hash,
+	}.Marshal())}, testLogSigner)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+
+	got, err := CompareWithWitnesses(ctx, testRawCheckpoints[5], testLogVerifier, testOrigin, testLogTileFetcher, []Witness{
+		witness("same", cosign(testRawCheckpoints[5])),
+		witness("behind", cosign(testRawCheckpoints[2])),
+		witness("ahead", cosign(testRawCheckpoints[9])),
+		witness("fork", cosign(forkRaw)),
+		witness("not cosigned", testRawCheckpoints[5]),
+		witness("empty response", nil),
+	})
+	if err != nil {
+		t.Fatalf("CompareWithWitnesses: %v", err)
+	}
+	for i, test := range []struct {
+		wantErr          bool
+		wantInconsistent bool
+	}{
+		{},
+		{},
+		{},
+		{wantErr: true, wantInconsistent: true},
+		{wantErr: true},
+		{wantErr: true},
+	} {
+		c := got[i]
+		if gotErr := c.Err != nil; gotErr != test.wantErr {
+			t.Errorf("%s: got err %v, want err %t", c.Witness, c.Err, test.wantErr)
+		}
+		if gotInconsistent := errors.As(c.Err, &ErrInconsistency{}); gotInconsistent != test.wantInconsistent {
+			t.Errorf("%s: got inconsistent %t, want %t", c.Witness, gotInconsistent, test.wantInconsistent)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+// Copyright 2024 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package personality
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"time"
+
+	aaws "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	tessera "github.com/transparency-dev/trillian-tessera"
+	"github.com/transparency-dev/trillian-tessera/storage/aws"
+)
+
+var awsFlags struct {
+	bucket            string
+	dbName            string
+	dbHost            string
+	dbPort            int
+	dbUser            string
+	dbPassword        string
+	dbMaxConns        int
+	dbMaxIdle         int
+	s3Endpoint        string
+	s3AccessKeyID     string
+	s3SecretAccessKey string
+	s3SessionToken    string
+	s3RoleARN         string
+	s3WebIdentityFile string
+	s3STSEndpoint     string
+	signer            string
+	publishInterval   time.Duration
+	additionalSigners []string
+}
+
+// AWS runs the personality using the AWS storage implementation.
+var AWS = &Backend{
+	Name:          "aws",
+	DefaultListen: ":2024",
+	H2C:           true,
+	RegisterFlags: func(fs *flag.FlagSet) {
+		fs.StringVar(&awsFlags.bucket, "bucket", "", "Bucket to use for storing log")
+		fs.StringVar(&awsFlags.dbName, "db_name", "", "AuroraDB name")
+		fs.StringVar(&awsFlags.dbHost, "db_host", "", "AuroraDB host")
+		fs.IntVar(&awsFlags.dbPort, "db_port", 3306, "AuroraDB port")
+		fs.StringVar(&awsFlags.dbUser, "db_user", "", "AuroraDB user")
+		fs.StringVar(&awsFlags.dbPassword, "db_password", "", "AuroraDB user")
+		fs.IntVar(&awsFlags.dbMaxConns, "db_max_conns", 0, "Maximum connections to the database, defaults to 0, i.e unlimited")
+		fs.IntVar(&awsFlags.dbMaxIdle, "db_max_idle_conns", 2, "Maximum idle database connections in the connection pool, defaults to 2")
+		fs.StringVar(&awsFlags.s3Endpoint, "s3_endpoint", "", "Endpoint for custom non-AWS S3 service")
+		fs.StringVar(&awsFlags.s3AccessKeyID, "s3_access_key", "", "Access key ID for custom non-AWS S3 service")
+		fs.StringVar(&awsFlags.s3SecretAccessKey, "s3_secret", "", "Secret access key for custom non-AWS S3 service")
+		fs.StringVar(&awsFlags.s3SessionToken, "s3_session_token", "", "Session token to use with --s3_access_key and --s3_secret")
+		fs.StringVar(&awsFlags.s3RoleARN, "s3_role_arn", "", "ARN of a role to assume via STS for custom non-AWS S3 service")
+		fs.StringVar(&awsFlags.s3WebIdentityFile, "s3_web_identity_token_file", "", "File containing a web identity token with which to assume --s3_role_arn")
+		fs.StringVar(&awsFlags.s3STSEndpoint, "s3_sts_endpoint", "", "Endpoint for the STS service used to assume --s3_role_arn, defaults to --s3_endpoint")
+		fs.StringVar(&awsFlags.signer, "signer", "", "Note signer to use to sign checkpoints")
+		fs.DurationVar(&awsFlags.publishInterval, "publish_interval", 3*time.Second, "How frequently to publish updated checkpoints")
+		stringListFlag(fs, &awsFlags.additionalSigners, "additional_signer", "Additional note signer for checkpoints, may be specified multiple times")
+	},
+	New: newAWS,
+}
+
+func newAWS(ctx context.Context, _ *http.ServeMux) (AddFn, error) {
+	awsCfg, err := awsConfigFromFlags()
+	if err != nil {
+		return nil, err
+	}
+	s, a := signersOrDie(awsFlags.signer, awsFlags.additionalSigners)
+	if err := checkDistinctSigners(s, a); err != nil {
+		return nil, err
+	}
+
+	// Create our Tessera storage backend:
+	storage, err := aws.New(ctx, awsCfg,
+		tessera.WithCheckpointSigner(s, a...),
+		tessera.WithCheckpointInterval(awsFlags.publishInterval),
+		tessera.WithBatching(1024, time.Second),
+		tessera.WithPushback(10*4096),
+	)
+	if err != nil {
+		return nil, err
+	}
+	// The log is served directly from the S3 bucket, so there are no read handlers to register.
+	return storage.Add, nil
+}
+
+// awsConfigFromFlags returns an aws.Config struct populated with values
+// provided via flags.
+func awsConfigFromFlags() (aws.Config, error) {
+	if awsFlags.bucket == "" {
+		return aws.Config{}, errors.New("--bucket must be set")
+	}
+	if awsFlags.dbName == "" {
+		return aws.Config{}, errors.New("--db_name must be set")
+	}
+	if awsFlags.dbHost == "" {
+		return aws.Config{}, errors.New("--db_host must be set")
+	}
+	if awsFlags.dbPort == 0 {
+		return aws.Config{}, errors.New("--db_port must be set")
+	}
+	if awsFlags.dbUser == "" {
+		return aws.Config{}, errors.New("--db_user must be set")
+	}
+	// Empty passord isn't an option with AuroraDB MySQL.
+	if awsFlags.dbPassword == "" {
+		return aws.Config{}, errors.New("--db_password must be set")
+	}
+
+	dbEndpoint := fmt.Sprintf("%s:%d", awsFlags.dbHost, awsFlags.dbPort)
+	dsn := fmt.Sprintf("%s:%s@tcp(%s)/%s?allowCleartextPasswords=true",
+		awsFlags.dbUser, awsFlags.dbPassword, dbEndpoint, awsFlags.dbName,
+	)
+
+	// Configure to use MinIO Server
+	var awsConfig *aaws.Config
+	var s3Opts func(o *s3.Options)
+	var s3Creds *aws.S3Credentials
+	if awsFlags.s3Endpoint != "" {
+		const defaultRegion = "us-east-1"
+		s3Opts = func(o *s3.Options) {
+			o.BaseEndpoint = aaws.String(awsFlags.s3Endpoint)
+			o.Region = defaultRegion
+			o.UsePathStyle = true
+		}
+		s3Creds = &aws.S3Credentials{
+			AccessKeyID:          awsFlags.s3AccessKeyID,
+			SecretAccessKey:      awsFlags.s3SecretAccessKey,
+			SessionToken:         awsFlags.s3SessionToken,
+			RoleARN:              awsFlags.s3RoleARN,
+			WebIdentityTokenFile: awsFlags.s3WebIdentityFile,
+			STSEndpoint:          awsFlags.s3STSEndpoint,
+		}
+		if s3Creds.RoleARN != "" && s3Creds.STSEndpoint == "" {
+			// S3-compatible services such as MinIO typically serve their STS API from the same endpoint.
+			s3Creds.STSEndpoint = awsFlags.s3Endpoint
+		}
+
+		awsConfig = &aaws.Config{
+			Region: defaultRegion,
+		}
+	}
+
+	return aws.Config{
+		Bucket:        awsFlags.bucket,
+		SDKConfig:     awsConfig,
+		S3Options:     s3Opts,
+		S3Credentials: s3Creds,
+		DSN:           dsn,
+		MaxOpenConns:  awsFlags.dbMaxConns,
+		MaxIdleConns:  awsFlags.dbMaxIdle,
+	}, nil
+}
@@ -0,0 +1,100 @@
+// Copyright 2024 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package personality
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"time"
+
+	tessera "github.com/transparency-dev/trillian-tessera"
+	"github.com/transparency-dev/trillian-tessera/storage/gcp"
+)
+
+var gcpFlags struct {
+	bucket            string
+	spanner           string
+	signer            string
+	persistentDedup   bool
+	dedupFailOpen     bool
+	sharedLocks       bool
+	additionalSigners []string
+}
+
+// GCP runs the personality using the GCP storage implementation.
+var GCP = &Backend{
+	Name:          "gcp",
+	DefaultListen: ":2024",
+	H2C:           true,
+	RegisterFlags: func(fs *flag.FlagSet) {
+		fs.StringVar(&gcpFlags.bucket, "bucket", "", "Bucket to use for storing log")
+		fs.StringVar(&gcpFlags.spanner, "spanner", "", "Spanner resource URI ('projects/.../...')")
+		fs.StringVar(&gcpFlags.signer, "signer", "", "Note signer to use to sign checkpoints")
+		fs.BoolVar(&gcpFlags.persistentDedup, "gcp_dedup", false, "EXPERIMENTAL: Set to true to enable persistent dedupe storage")
+		fs.BoolVar(&gcpFlags.dedupFailOpen, "gcp_dedup_fail_open", false, "Set to true to accept entries, possibly as duplicates, when --gcp_dedup storage lookups fail, rather than rejecting them")
+		fs.BoolVar(&gcpFlags.sharedLocks, "spanner_shared_locks", false, "Set to true to use shared, rather than exclusive, locks when reading Spanner coordination rows")
+		stringListFlag(fs, &gcpFlags.additionalSigners, "additional_signer", "Additional note signer for checkpoints, may be specified multiple times")
+	},
+	New: newGCP,
+}
+
+func newGCP(ctx context.Context, _ *http.ServeMux) (AddFn, error) {
+	if gcpFlags.bucket == "" {
+		return nil, errors.New("--bucket must be set")
+	}
+	if gcpFlags.spanner == "" {
+		return nil, errors.New("--spanner must be set")
+	}
+	s, a := signersOrDie(gcpFlags.signer, gcpFlags.additionalSigners)
+	if err := checkDistinctSigners(s, a); err != nil {
+		return nil, err
+	}
+
+	// Create our Tessera storage backend:
+	gcpCfg := gcp.Config{
+		Bucket:                  gcpFlags.bucket,
+		Spanner:                 gcpFlags.spanner,
+		SharedCoordinationLocks: gcpFlags.sharedLocks,
+	}
+	storage, err := gcp.New(ctx, gcpCfg,
+		tessera.WithCheckpointSigner(s, a...),
+		tessera.WithCheckpointInterval(10*time.Second),
+		tessera.WithBatching(1024, time.Second),
+		tessera.WithPushback(10*4096),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// Handle dedup configuration
+	addDelegate := storage.Add
+
+	// PersistentDedup is currently experimental, so there's no terraform or documentation yet!
+	if gcpFlags.persistentDedup {
+		policy := gcp.DedupeFailClosed
+		if gcpFlags.dedupFailOpen {
+			policy = gcp.DedupeFailOpen
+		}
+		addDelegate, err = gcp.NewDedupe(ctx, fmt.Sprintf("%s_dedup", gcpFlags.spanner), addDelegate, gcp.WithDedupeFailurePolicy(policy))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create new GCP dedupe: %v", err)
+		}
+	}
+	// The log is served directly from the GCS bucket, so there are no read handlers to register.
+	return addDelegate, nil
+}
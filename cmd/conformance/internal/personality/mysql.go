@@ -0,0 +1,161 @@
+// Copyright 2024 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package personality
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	tessera "github.com/transparency-dev/trillian-tessera"
+	"github.com/transparency-dev/trillian-tessera/internal/options"
+	"github.com/transparency-dev/trillian-tessera/storage/mysql"
+	"golang.org/x/mod/sumdb/note"
+	"k8s.io/klog/v2"
+)
+
+var mysqlFlags struct {
+	mysqlURI                  string
+	dbConnMaxLifetime         time.Duration
+	dbMaxOpenConns            int
+	dbMaxIdleConns            int
+	initSchemaPath            string
+	privateKeyPath            string
+	publishInterval           time.Duration
+	additionalPrivateKeyPaths []string
+	entryBundlePrefetch       uint
+	checkpointHistory         bool
+	historyRetention          time.Duration
+}
+
+// MySQL runs the personality using the MySQL storage implementation.
+var MySQL = &Backend{
+	Name:          "mysql",
+	DefaultListen: ":2024",
+	RegisterFlags: func(fs *flag.FlagSet) {
+		fs.StringVar(&mysqlFlags.mysqlURI, "mysql_uri", "user:password@tcp(db:3306)/tessera", "Connection string for a MySQL database")
+		fs.DurationVar(&mysqlFlags.dbConnMaxLifetime, "db_conn_max_lifetime", 3*time.Minute, "")
+		fs.IntVar(&mysqlFlags.dbMaxOpenConns, "db_max_open_conns", 64, "")
+		fs.IntVar(&mysqlFlags.dbMaxIdleConns, "db_max_idle_conns", 64, "")
+		fs.StringVar(&mysqlFlags.initSchemaPath, "init_schema_path", "", "Location of the schema file if database initialization is needed")
+		fs.StringVar(&mysqlFlags.privateKeyPath, "private_key_path", "", "Location of private key file")
+		fs.DurationVar(&mysqlFlags.publishInterval, "publish_interval", 3*time.Second, "How frequently to publish updated checkpoints")
+		fs.UintVar(&mysqlFlags.entryBundlePrefetch, "entry_bundle_prefetch", 0, "Number of full entry bundles to prefetch when sequential reads are detected, or 0 to disable prefetching")
+		fs.BoolVar(&mysqlFlags.checkpointHistory, "checkpoint_history", false, "Set to retain published checkpoints so that they can be served with ?size= and ?at=, requires the CheckpointHistory table")
+		fs.DurationVar(&mysqlFlags.historyRetention, "checkpoint_history_retention", 0, "How long to retain published checkpoints for when --checkpoint_history is set, or 0 to keep them forever")
+		stringListFlag(fs, &mysqlFlags.additionalPrivateKeyPaths, "additional_private_key_path", "Location of additional private key file, may be specified multiple times")
+	},
+	New: newMySQL,
+}
+
+func newMySQL(ctx context.Context, mux *http.ServeMux) (AddFn, error) {
+	db, err := createDatabase(ctx)
+	if err != nil {
+		return nil, err
+	}
+	noteSigner := signerFromFileOrDie(mysqlFlags.privateKeyPath)
+	additionalSigners := []note.Signer{}
+	for _, p := range mysqlFlags.additionalPrivateKeyPaths {
+		additionalSigners = append(additionalSigners, signerFromFileOrDie(p))
+	}
+	if err := checkDistinctSigners(noteSigner, additionalSigners); err != nil {
+		return nil, err
+	}
+
+	// Initialise the Tessera MySQL storage
+	opts := []func(*options.StorageOptions){
+		tessera.WithCheckpointSigner(noteSigner, additionalSigners...),
+		tessera.WithCheckpointInterval(mysqlFlags.publishInterval),
+	}
+	if mysqlFlags.checkpointHistory {
+		opts = append(opts, tessera.WithCheckpointHistory(mysqlFlags.historyRetention))
+	}
+	storage, err := mysql.New(ctx, db, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	// Set up the handlers for the tlog-tiles GET methods.
+	ConfigureTilesReadAPI(mux, mysqlReader(storage))
+	return storage.Add, nil
+}
+
+// historyLogReader is a LogReader which serves historical checkpoints from a separate CheckpointHistoryReader.
+type historyLogReader struct {
+	tessera.LogReader
+	tessera.CheckpointHistoryReader
+}
+
+// mysqlReader returns the LogReader to serve the tlog-tiles GET methods from, prefetching entry bundles
+// if --entry_bundle_prefetch is set.
+func mysqlReader(storage tessera.LogReader) tessera.LogReader {
+	n := mysqlFlags.entryBundlePrefetch
+	if n == 0 {
+		return storage
+	}
+	var r tessera.LogReader = tessera.NewPrefetchingReader(storage, n, 4*n)
+	if h, ok := storage.(tessera.CheckpointHistoryReader); ok {
+		// The PrefetchingReader doesn't serve historical checkpoints, so read them directly from storage.
+		r = historyLogReader{LogReader: r, CheckpointHistoryReader: h}
+	}
+	return r
+}
+
+func createDatabase(ctx context.Context) (*sql.DB, error) {
+	db, err := sql.Open("mysql", mysqlFlags.mysqlURI)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to DB: %v", err)
+	}
+	db.SetConnMaxLifetime(mysqlFlags.dbConnMaxLifetime)
+	db.SetMaxOpenConns(mysqlFlags.dbMaxOpenConns)
+	db.SetMaxIdleConns(mysqlFlags.dbMaxIdleConns)
+
+	if err := initDatabaseSchema(ctx); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+func initDatabaseSchema(ctx context.Context) error {
+	if mysqlFlags.initSchemaPath == "" {
+		return nil
+	}
+	klog.Infof("Initializing database schema")
+
+	db, err := sql.Open("mysql", mysqlFlags.mysqlURI+"?multiStatements=true")
+	if err != nil {
+		return fmt.Errorf("failed to connect to DB: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			klog.Warningf("Failed to close db: %v", err)
+		}
+	}()
+
+	rawSchema, err := os.ReadFile(mysqlFlags.initSchemaPath)
+	if err != nil {
+		return fmt.Errorf("failed to read init schema file %q: %v", mysqlFlags.initSchemaPath, err)
+	}
+	if _, err := db.ExecContext(ctx, string(rawSchema)); err != nil {
+		return fmt.Errorf("failed to execute init database schema: %v", err)
+	}
+
+	klog.Infof("Database schema initialized")
+	return nil
+}
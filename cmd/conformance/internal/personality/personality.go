@@ -0,0 +1,350 @@
+// Copyright 2024 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package personality contains the parts of the conformance personalities which are common to all
+// storage implementations, along with a Backend describing how to set up each of those implementations.
+package personality
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	tessera "github.com/transparency-dev/trillian-tessera"
+	"github.com/transparency-dev/trillian-tessera/api/layout"
+	"github.com/transparency-dev/trillian-tessera/internal/httpserver"
+	"golang.org/x/mod/sumdb/note"
+	"k8s.io/klog/v2"
+)
+
+// AddFn adds an entry to a log.
+type AddFn func(ctx context.Context, e *tessera.Entry) tessera.IndexFuture
+
+// Backend describes how to run the conformance personality using a particular storage implementation.
+type Backend struct {
+	// Name is the name used to select this backend.
+	Name string
+	// DefaultListen is the default address:port on which the personality listens.
+	DefaultListen string
+	// H2C enables unencrypted HTTP/2 support.
+	H2C bool
+	// RegisterFlags registers the flags used to configure this backend.
+	RegisterFlags func(fs *flag.FlagSet)
+	// New creates the storage for the log, and registers on mux any handlers needed to serve it.
+	// Returns the function which should be used to add entries to the log.
+	New func(ctx context.Context, mux *http.ServeMux) (AddFn, error)
+}
+
+// Backends holds all known backends, keyed by name.
+var Backends = map[string]*Backend{
+	AWS.Name:   AWS,
+	GCP.Name:   GCP,
+	MySQL.Name: MySQL,
+	POSIX.Name: POSIX,
+}
+
+// BackendNames returns the sorted names of all known backends.
+func BackendNames() []string {
+	r := make([]string, 0, len(Backends))
+	for n := range Backends {
+		r = append(r, n)
+	}
+	sort.Strings(r)
+	return r
+}
+
+// Main registers the flags for b, parses the command line, and then serves the personality until the process
+// is terminated.
+func Main(b *Backend) {
+	listen := flag.String("listen", b.DefaultListen, "Address:port to listen on")
+	serverConfig := httpserver.DefaultConfig()
+	serverConfig.H2C = b.H2C
+	serverConfig.RegisterFlags(flag.CommandLine)
+	b.RegisterFlags(flag.CommandLine)
+
+	klog.InitFlags(nil)
+	flag.Parse()
+	ctx := context.Background()
+
+	// Use our own mux rather than http.DefaultServeMux, since some dependencies of the storage
+	// implementations register their own handlers on the latter which may conflict with ours.
+	mux := http.NewServeMux()
+	add, err := b.New(ctx, mux)
+	if err != nil {
+		klog.Exitf("Failed to create new %s storage: %v", b.Name, err)
+	}
+	dedupeAdd := tessera.InMemoryDedupe(add, 256)
+
+	// Expose a HTTP handler for the conformance test writes.
+	// This should accept arbitrary bytes POSTed to /add, and return an ascii
+	// decimal representation of the index assigned to the entry.
+	mux.HandleFunc("POST /add", AddHandler(dedupeAdd))
+
+	// TODO(mhutchinson): Change the listen flag to just a port, or fix up this address formatting
+	klog.Infof("Environment variables useful for accessing this log:\n"+
+		"export WRITE_URL=http://localhost%s/ \n"+
+		"export READ_URL=http://localhost%s/ \n", *listen, *listen)
+	// Serve HTTP requests until the process is terminated
+	if err := httpserver.New(*listen, mux, serverConfig).ListenAndServe(); err != nil {
+		klog.Exitf("ListenAndServe: %v", err)
+	}
+}
+
+// AddHandler returns a handler which adds the body of each request to the log using add, and writes
+// out the index assigned to it.
+func AddHandler(add AddFn) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		idx, err := add(r.Context(), tessera.NewEntry(b))()
+		if err != nil {
+			if errors.Is(err, tessera.ErrPushback) {
+				w.Header().Add("Retry-After", "1")
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(err.Error()))
+			return
+		}
+		// Write out the assigned index
+		if _, err = w.Write([]byte(fmt.Sprintf("%d", idx))); err != nil {
+			klog.Errorf("/add: %v", err)
+			return
+		}
+	}
+}
+
+// ConfigureTilesReadAPI adds the API methods from https://c2sp.org/tlog-tiles to the mux,
+// routing the requests to the provided storage.
+func ConfigureTilesReadAPI(mux *http.ServeMux, storage tessera.LogReader) {
+	mux.HandleFunc("GET /checkpoint", func(w http.ResponseWriter, r *http.Request) {
+		read := storage.ReadCheckpoint
+		// ?size=N selects the earliest checkpoint committing to at least N entries, ?at=N the checkpoint
+		// published at exactly N entries.
+		q := r.URL.Query()
+		if sizeParam, atParam := q.Get("size"), q.Get("at"); sizeParam != "" || atParam != "" {
+			// Historical checkpoints are only available from storage implementations which retain them.
+			h, ok := storage.(tessera.CheckpointHistoryReader)
+			if !ok {
+				w.WriteHeader(http.StatusNotImplemented)
+				return
+			}
+			readAt, param := h.ReadCheckpointAtSize, sizeParam
+			if atParam != "" {
+				if sizeParam != "" {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				readAt, param = h.ReadCheckpointAt, atParam
+			}
+			size, err := strconv.ParseUint(param, 10, 64)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			read = func(ctx context.Context) ([]byte, error) { return readAt(ctx, size) }
+		}
+		checkpoint, err := read(r.Context())
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			if errors.Is(err, errors.ErrUnsupported) {
+				w.WriteHeader(http.StatusNotImplemented)
+				return
+			}
+			klog.Errorf("/checkpoint: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		// Don't cache checkpoints as the endpoint refreshes regularly.
+		// A personality that wanted to _could_ set a small cache time here which was no higher
+		// than the checkpoint publish interval.
+		w.Header().Set("Cache-Control", "no-cache")
+		if _, err := w.Write(checkpoint); err != nil {
+			klog.Errorf("/checkpoint: %v", err)
+			return
+		}
+	})
+
+	mux.HandleFunc("GET /tile/{level}/{index...}", func(w http.ResponseWriter, r *http.Request) {
+		level, index, p, err := layout.ParseTileLevelIndexPartial(r.PathValue("level"), r.PathValue("index"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			if _, werr := w.Write([]byte(fmt.Sprintf("Malformed URL: %s", err.Error()))); werr != nil {
+				klog.Errorf("/tile/{level}/{index...}: %v", werr)
+			}
+			return
+		}
+		tile, err := storage.ReadTile(r.Context(), level, index, p)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			klog.Errorf("/tile/{level}/{index...}: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "max-age=31536000, immutable")
+
+		if err := writeMaybeGzipped(w, r, tile); err != nil {
+			klog.Errorf("/tile/{level}/{index...}: %v", err)
+			return
+		}
+	})
+
+	mux.HandleFunc("GET /tile/entries/{index...}", func(w http.ResponseWriter, r *http.Request) {
+		index, p, err := layout.ParseTileIndexPartial(r.PathValue("index"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			if _, werr := w.Write([]byte(fmt.Sprintf("Malformed URL: %s", err.Error()))); werr != nil {
+				klog.Errorf("/tile/entries/{index...}: %v", werr)
+			}
+			return
+		}
+
+		entryBundle, err := storage.ReadEntryBundle(r.Context(), index, p)
+		if err != nil {
+			klog.Errorf("/tile/entries/{index...}: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if entryBundle == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+
+		if err := writeMaybeGzipped(w, r, entryBundle); err != nil {
+			klog.Errorf("/tile/entries/{index...}: %v", err)
+			return
+		}
+	})
+}
+
+// writeMaybeGzipped writes body to w, gzip-compressing it if the request indicates that the client accepts it.
+//
+// Tiles and entry bundles compress well, so this can significantly reduce egress for clients fetching lots
+// of them. The Vary header is always set so that caches store the compressed and uncompressed representations
+// of each immutable resource separately, rather than serving one to clients which asked for the other.
+func writeMaybeGzipped(w http.ResponseWriter, r *http.Request, body []byte) error {
+	w.Header().Add("Vary", "Accept-Encoding")
+	if !acceptsGzip(r) {
+		_, err := w.Write(body)
+		return err
+	}
+	w.Header().Set("Content-Encoding", "gzip")
+	gw := gzip.NewWriter(w)
+	if _, err := gw.Write(body); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// acceptsGzip returns true if the Accept-Encoding header of r permits a gzip-encoded response.
+func acceptsGzip(r *http.Request) bool {
+	for _, v := range r.Header.Values("Accept-Encoding") {
+		for _, e := range strings.Split(v, ",") {
+			coding, params, _ := strings.Cut(strings.TrimSpace(e), ";")
+			if !strings.EqualFold(strings.TrimSpace(coding), "gzip") {
+				continue
+			}
+			// An explicit weight of 0 means that gzip is not acceptable.
+			if k, v, ok := strings.Cut(params, "="); ok && strings.TrimSpace(k) == "q" {
+				if q, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil && q == 0 {
+					return false
+				}
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// stringListFlag registers a flag which may be specified multiple times, appending each value to l.
+func stringListFlag(fs *flag.FlagSet, l *[]string, name, usage string) {
+	fs.Func(name, usage, func(s string) error {
+		*l = append(*l, s)
+		return nil
+	})
+}
+
+// signersOrDie creates note signers from the provided keys.
+func signersOrDie(key string, additional []string) (note.Signer, []note.Signer) {
+	s, err := note.NewSigner(key)
+	if err != nil {
+		klog.Exitf("Failed to create new signer: %v", err)
+	}
+
+	var a []note.Signer
+	for _, as := range additional {
+		s, err := note.NewSigner(as)
+		if err != nil {
+			klog.Exitf("Failed to create additional signer: %v", err)
+		}
+		a = append(a, s)
+	}
+
+	return s, a
+}
+
+// checkDistinctSigners returns an error if any of the provided signers share the same key, since this
+// would result in redundant signatures being added to checkpoints.
+func checkDistinctSigners(s note.Signer, additional []note.Signer) error {
+	type keyID struct {
+		name string
+		hash uint32
+	}
+	seen := make(map[keyID]bool)
+	for _, s := range append([]note.Signer{s}, additional...) {
+		id := keyID{name: s.Name(), hash: s.KeyHash()}
+		if seen[id] {
+			return fmt.Errorf("signer key %s+%08x specified more than once", id.name, id.hash)
+		}
+		seen[id] = true
+	}
+	return nil
+}
+
+// signerFromFileOrDie creates a note signer from the key stored in the file at path.
+func signerFromFileOrDie(path string) note.Signer {
+	k, err := os.ReadFile(path)
+	if err != nil {
+		klog.Exitf("Failed to read private key file %q: %v", path, err)
+	}
+	s, err := note.NewSigner(string(k))
+	if err != nil {
+		klog.Exitf("Failed to create new signer from %q: %v", path, err)
+	}
+	return s
+}
@@ -0,0 +1,165 @@
+// Copyright 2024 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package personality
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/mod/sumdb/note"
+)
+
+func TestCheckDistinctSigners(t *testing.T) {
+	newSigner := func(name string) note.Signer {
+		t.Helper()
+		k, _, err := note.GenerateKey(rand.Reader, name)
+		if err != nil {
+			t.Fatalf("GenerateKey: %v", err)
+		}
+		s, err := note.NewSigner(k)
+		if err != nil {
+			t.Fatalf("NewSigner: %v", err)
+		}
+		return s
+	}
+	a, b, c := newSigner("example.com/log"), newSigner("witness"), newSigner("example.com/log")
+
+	for _, test := range []struct {
+		name       string
+		s          note.Signer
+		additional []note.Signer
+		wantErr    bool
+	}{
+		{name: "no additional", s: a},
+		{name: "distinct", s: a, additional: []note.Signer{b, c}},
+		{name: "additional duplicates primary", s: a, additional: []note.Signer{b, a}, wantErr: true},
+		{name: "duplicate additional", s: a, additional: []note.Signer{b, b}, wantErr: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if err := checkDistinctSigners(test.s, test.additional); (err != nil) != test.wantErr {
+				t.Errorf("checkDistinctSigners: got err %v, want err %t", err, test.wantErr)
+			}
+		})
+	}
+}
+
+// fixedLogReader serves the same content for every tile and entry bundle.
+type fixedLogReader []byte
+
+func (f fixedLogReader) ReadCheckpoint(_ context.Context) ([]byte, error) { return f, nil }
+func (f fixedLogReader) ReadTile(_ context.Context, _, _ uint64, _ uint8) ([]byte, error) {
+	return f, nil
+}
+func (f fixedLogReader) ReadEntryBundle(_ context.Context, _ uint64, _ uint8) ([]byte, error) {
+	return f, nil
+}
+
+func TestTilesReadAPIGzip(t *testing.T) {
+	want := bytes.Repeat([]byte("compressible "), 100)
+	mux := http.NewServeMux()
+	ConfigureTilesReadAPI(mux, fixedLogReader(want))
+
+	for _, path := range []string{"/tile/0/000", "/tile/entries/000.p/3"} {
+		for _, test := range []struct {
+			acceptEncoding string
+			wantGzip       bool
+		}{
+			{acceptEncoding: "", wantGzip: false},
+			{acceptEncoding: "br", wantGzip: false},
+			{acceptEncoding: "gzip", wantGzip: true},
+			{acceptEncoding: "deflate, GZIP;q=0.5", wantGzip: true},
+			{acceptEncoding: "gzip;q=0", wantGzip: false},
+		} {
+			t.Run(path+"/"+test.acceptEncoding, func(t *testing.T) {
+				req := httptest.NewRequest(http.MethodGet, path, nil)
+				if test.acceptEncoding != "" {
+					req.Header.Set("Accept-Encoding", test.acceptEncoding)
+				}
+				rec := httptest.NewRecorder()
+				mux.ServeHTTP(rec, req)
+
+				resp := rec.Result()
+				if got, want := resp.Header.Get("Vary"), "Accept-Encoding"; got != want {
+					t.Errorf("Vary: got %q, want %q", got, want)
+				}
+				body := io.Reader(resp.Body)
+				if gotGzip := resp.Header.Get("Content-Encoding") == "gzip"; gotGzip != test.wantGzip {
+					t.Fatalf("got gzip %t, want %t", gotGzip, test.wantGzip)
+				} else if gotGzip {
+					gr, err := gzip.NewReader(resp.Body)
+					if err != nil {
+						t.Fatalf("gzip.NewReader: %v", err)
+					}
+					body = gr
+				}
+				got, err := io.ReadAll(body)
+				if err != nil {
+					t.Fatalf("ReadAll: %v", err)
+				}
+				if !bytes.Equal(got, want) {
+					t.Errorf("got body %q, want %q", got, want)
+				}
+			})
+		}
+	}
+}
+
+// fixedHistoryReader additionally serves the same content for every historical checkpoint.
+type fixedHistoryReader struct {
+	fixedLogReader
+}
+
+func (f fixedHistoryReader) ReadCheckpointAtSize(_ context.Context, _ uint64) ([]byte, error) {
+	return f.fixedLogReader, nil
+}
+func (f fixedHistoryReader) ReadCheckpointAt(_ context.Context, _ uint64) ([]byte, error) {
+	return f.fixedLogReader, nil
+}
+
+func TestMySQLReaderCheckpointHistory(t *testing.T) {
+	defer func(prefetch uint, history bool) {
+		mysqlFlags.entryBundlePrefetch, mysqlFlags.checkpointHistory = prefetch, history
+	}(mysqlFlags.entryBundlePrefetch, mysqlFlags.checkpointHistory)
+	mysqlFlags.entryBundlePrefetch, mysqlFlags.checkpointHistory = 2, true
+
+	want := []byte("checkpoint")
+	mux := http.NewServeMux()
+	ConfigureTilesReadAPI(mux, mysqlReader(fixedHistoryReader{fixedLogReader(want)}))
+
+	for _, path := range []string{"/checkpoint?size=1", "/checkpoint?at=1", "/tile/entries/000"} {
+		t.Run(path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+
+			resp := rec.Result()
+			if got, want := resp.StatusCode, http.StatusOK; got != want {
+				t.Fatalf("got status %d, want %d", got, want)
+			}
+			got, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("ReadAll: %v", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("got body %q, want %q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright 2024 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package personality
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	tessera "github.com/transparency-dev/trillian-tessera"
+	"github.com/transparency-dev/trillian-tessera/client"
+	"github.com/transparency-dev/trillian-tessera/storage/posix"
+	"golang.org/x/mod/sumdb/note"
+	"k8s.io/klog/v2"
+)
+
+var posixFlags struct {
+	storageDir                string
+	initialise                bool
+	privKeyFile               string
+	additionalPrivateKeyFiles []string
+	verifyOnStart             bool
+	verifyOnStartSamples      uint
+	verifyOnStartTimeout      time.Duration
+	publicKey                 string
+}
+
+// POSIX runs the personality using the POSIX storage implementation.
+var POSIX = &Backend{
+	Name:          "posix",
+	DefaultListen: ":2025",
+	RegisterFlags: func(fs *flag.FlagSet) {
+		fs.StringVar(&posixFlags.storageDir, "storage_dir", "", "Root directory to store log data.")
+		fs.BoolVar(&posixFlags.initialise, "initialise", false, "Set when creating a new log to initialise the structure.")
+		fs.StringVar(&posixFlags.privKeyFile, "private_key", "", "Location of private key file. If unset, uses the contents of the LOG_PRIVATE_KEY environment variable.")
+		stringListFlag(fs, &posixFlags.additionalPrivateKeyFiles, "additional_private_key", "Location of addition private key, may be specified multiple times")
+		fs.BoolVar(&posixFlags.verifyOnStart, "verify_on_start", false, "Set to check the integrity of the log in --storage_dir before serving it, refusing to start if this fails.")
+		fs.UintVar(&posixFlags.verifyOnStartSamples, "verify_on_start_samples", 16, "Maximum number of entry bundles to check with --verify_on_start.")
+		fs.DurationVar(&posixFlags.verifyOnStartTimeout, "verify_on_start_timeout", 30*time.Second, "Maximum time to spend checking the log with --verify_on_start.")
+		fs.StringVar(&posixFlags.publicKey, "public_key", os.Getenv("LOG_PUBLIC_KEY"), "Public key of the log, used by --verify_on_start. Defaults to the contents of the LOG_PUBLIC_KEY environment variable.")
+	},
+	New: newPOSIX,
+}
+
+func newPOSIX(ctx context.Context, mux *http.ServeMux) (AddFn, error) {
+	if posixFlags.storageDir == "" {
+		return nil, errors.New("--storage_dir must be set")
+	}
+	// Gather the info needed for reading/writing checkpoints
+	s := posixSignerOrDie()
+	a := []note.Signer{}
+	for _, p := range posixFlags.additionalPrivateKeyFiles {
+		a = append(a, signerFromFileOrDie(p))
+	}
+	if err := checkDistinctSigners(s, a); err != nil {
+		return nil, err
+	}
+
+	// Create the Tessera POSIX storage, using the directory from the --storage_dir flag
+	storage, err := posix.New(ctx, posixFlags.storageDir, posixFlags.initialise, tessera.WithCheckpointSigner(s, a...), tessera.WithBatching(256, time.Second))
+	if err != nil {
+		return nil, err
+	}
+
+	if posixFlags.verifyOnStart {
+		if err := verifyPOSIXLog(ctx); err != nil {
+			return nil, fmt.Errorf("refusing to serve log which failed verification: %v", err)
+		}
+	}
+
+	// Proxy all GET requests to the filesystem as a lightweight file server.
+	// This makes it easier to test this implementation from another machine.
+	fs := http.FileServer(http.Dir(posixFlags.storageDir))
+	mux.Handle("GET /checkpoint", addCacheHeaders("no-cache", fs))
+	mux.Handle("GET /tile/", addCacheHeaders("max-age=31536000, immutable", fs))
+	mux.Handle("GET /", fs)
+
+	return storage.Add, nil
+}
+
+// verifyPOSIXLog performs a bounded check of the integrity of the log stored in --storage_dir.
+func verifyPOSIXLog(ctx context.Context) error {
+	if posixFlags.publicKey == "" {
+		return errors.New("--public_key must be set when using --verify_on_start")
+	}
+	v, err := note.NewVerifier(posixFlags.publicKey)
+	if err != nil {
+		return fmt.Errorf("invalid --public_key: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, posixFlags.verifyOnStartTimeout)
+	defer cancel()
+
+	f := client.FileFetcher{Root: posixFlags.storageDir}
+	start := time.Now()
+	if err := client.CheckIntegrity(ctx, f.ReadCheckpoint, f.ReadTile, f.ReadEntryBundle, v, v.Name(), posixFlags.verifyOnStartSamples); err != nil {
+		return err
+	}
+	klog.Infof("Verified log in %s in %s", posixFlags.storageDir, time.Since(start))
+	return nil
+}
+
+func addCacheHeaders(value string, fs http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Cache-Control", value)
+		fs.ServeHTTP(w, r)
+	}
+}
+
+// posixSignerOrDie reads the log private key from file or environment variable.
+func posixSignerOrDie() note.Signer {
+	if len(posixFlags.privKeyFile) > 0 {
+		return signerFromFileOrDie(posixFlags.privKeyFile)
+	}
+	privKey := os.Getenv("LOG_PRIVATE_KEY")
+	if len(privKey) == 0 {
+		klog.Exit("Supply private key file path using --private_key or set LOG_PRIVATE_KEY environment variable")
+	}
+	s, err := note.NewSigner(privKey)
+	if err != nil {
+		klog.Exitf("Failed to instantiate signer: %q", err)
+	}
+	return s
+}
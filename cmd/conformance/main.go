@@ -0,0 +1,61 @@
+// Copyright 2024 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// conformance runs the conformance personality using the storage implementation selected
+// by the --storage flag, allowing a single binary to be deployed for any backend.
+//
+// The remaining flags are those of the corresponding binary in the subdirectory of the same name.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/transparency-dev/trillian-tessera/cmd/conformance/internal/personality"
+)
+
+func main() {
+	// The flags accepted depend on the chosen backend, so we need to know which it is
+	// before the command line can be parsed.
+	name := storageFlag(os.Args[1:])
+	b, ok := personality.Backends[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "--storage must be one of: %s\n", strings.Join(personality.BackendNames(), ", "))
+		os.Exit(2)
+	}
+	flag.String("storage", "", fmt.Sprintf("Storage implementation to use, one of: %s", strings.Join(personality.BackendNames(), ", ")))
+	personality.Main(b)
+}
+
+// storageFlag returns the value of the --storage flag in args, or the empty string if it's not present.
+func storageFlag(args []string) string {
+	for i, a := range args {
+		if a == "--" {
+			break
+		}
+		if !strings.HasPrefix(a, "-") {
+			continue
+		}
+		a = strings.TrimPrefix(strings.TrimPrefix(a, "-"), "-")
+		if v, ok := strings.CutPrefix(a, "storage="); ok {
+			return v
+		}
+		if a == "storage" && i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,35 @@
+// Copyright 2024 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import "testing"
+
+func TestStorageFlag(t *testing.T) {
+	for _, test := range []struct {
+		args []string
+		want string
+	}{
+		{args: []string{"--storage=posix", "--storage_dir=/tmp/log"}, want: "posix"},
+		{args: []string{"--listen=:8080", "-storage", "gcp"}, want: "gcp"},
+		{args: []string{"-storage=mysql"}, want: "mysql"},
+		{args: []string{"--storage_dir=/tmp/log"}, want: ""},
+		{args: []string{"--", "--storage=aws"}, want: ""},
+		{args: []string{"--storage"}, want: ""},
+	} {
+		if got := storageFlag(test.args); got != test.want {
+			t.Errorf("storageFlag(%q) = %q, want %q", test.args, got, test.want)
+		}
+	}
+}
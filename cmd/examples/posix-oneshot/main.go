@@ -16,6 +16,8 @@
 // tlog-tiles log stored on a posix filesystem.
 // The command takes a list of new entries to add to the log, and exits
 // when they are successfully integrated.
+// Alternatively, with --watch it will continue to add new entries matching
+// the glob as they appear.
 // See the README in this package for more detailed usage instructions.
 package main
 
@@ -39,6 +41,9 @@ var (
 	initialise  = flag.Bool("initialise", false, "Set when creating a new log to initialise the structure.")
 	entries     = flag.String("entries", "", "File path glob of entries to add to the log.")
 	privKeyFile = flag.String("private_key", "", "Location of private key file. If unset, uses the contents of the LOG_PRIVATE_KEY environment variable.")
+	watch       = flag.Bool("watch", false, "Set to keep running and add new files matching the --entries glob as they appear.")
+	watchPeriod = flag.Duration("watch_interval", 5*time.Second, "How frequently to look for new entries when --watch is set.")
+	fsync       = flag.Bool("fsync", false, "Set to fsync files and directories as they're written, trading write throughput for durability across power loss.")
 )
 
 const (
@@ -68,6 +73,9 @@ func main() {
 
 	// Handle the case where no entries are to be added.
 	if len(*entries) == 0 {
+		if *watch {
+			klog.Exit("--entries must be set when using --watch")
+		}
 		if *initialise {
 			_, err := posix.New(ctx, *storageDir, *initialise, tessera.WithCheckpointSigner(s))
 			if err != nil {
@@ -85,13 +93,19 @@ func main() {
 	// The options provide the checkpoint signer & verifier, and batch options.
 	// In this case, we want to create a single batch containing all of the leaves being added in order to
 	// add all of these leaves without creating any intermediate checkpoints.
+	// When watching, the number of leaves isn't known up-front, so we use the default batch size instead.
+	batchSize := uint(len(filesToAdd))
+	if *watch {
+		batchSize = tessera.DefaultBatchMaxSize
+	}
 	st, err := posix.New(
 		ctx,
 		*storageDir,
 		*initialise,
 		tessera.WithCheckpointSigner(s),
 		tessera.WithCheckpointInterval(checkpointInterval),
-		tessera.WithBatching(uint(len(filesToAdd)), time.Second))
+		tessera.WithBatching(batchSize, time.Second),
+		posix.WithFsync(*fsync))
 	if err != nil {
 		klog.Exitf("Failed to construct storage: %v", err)
 	}
@@ -100,6 +114,41 @@ func main() {
 	// IntegrationAwaiter to help with that.
 	await := tessera.NewIntegrationAwaiter(ctx, st.ReadCheckpoint, time.Second)
 
+	addEntriesOrDie(ctx, st, await, filesToAdd)
+	if !*watch {
+		// All futures have been resolved, which means the log is built and we can allow the process to terminate. Goodbye!
+		return
+	}
+
+	// Keep track of which files we've already added, so that we only add new ones.
+	seen := make(map[string]bool)
+	for _, fp := range filesToAdd {
+		seen[fp] = true
+	}
+	klog.Infof("Watching %q for new entries", *entries)
+	t := time.NewTicker(*watchPeriod)
+	defer t.Stop()
+	for range t.C {
+		toAdd, err := filepath.Glob(*entries)
+		if err != nil {
+			klog.Exitf("Failed to glob entries %q: %q", *entries, err)
+		}
+		newFiles := make([]string, 0)
+		for _, fp := range toAdd {
+			if !seen[fp] {
+				newFiles = append(newFiles, fp)
+				seen[fp] = true
+			}
+		}
+		if len(newFiles) > 0 {
+			addEntriesOrDie(ctx, st, await, newFiles)
+		}
+	}
+}
+
+// addEntriesOrDie adds the contents of each of the provided files to the log, in order, and waits for them
+// all to be integrated.
+func addEntriesOrDie(ctx context.Context, st *posix.Storage, await *tessera.IntegrationAwaiter, filesToAdd []string) {
 	// Add each of the leaves in order, and store the futures in a slice
 	// that we will check once all leaves are sent to storage.
 	indexFutures := make([]entryInfo, 0, len(filesToAdd))
@@ -121,8 +170,6 @@ func main() {
 		}
 		klog.Infof("%d: %v", seq, entry.name)
 	}
-
-	// All futures have been resolved, which means the log is built and we can allow the process to terminate. Goodbye!
 }
 
 // Read log private key from file or environment variable
@@ -161,7 +208,7 @@ func readEntriesOrDie() []string {
 		klog.Exitf("Failed to glob entries %q: %q", *entries, err)
 	}
 	klog.V(1).Infof("toAdd: %v", toAdd)
-	if len(toAdd) == 0 {
+	if len(toAdd) == 0 && !*watch {
 		klog.Exit("Sequence must be run with at least one valid entry")
 	}
 	return toAdd
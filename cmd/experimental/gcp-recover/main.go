@@ -0,0 +1,69 @@
+// Copyright 2024 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// gcp-recover is a disaster recovery tool which rebuilds the Spanner coordination state for a
+// GCP log from the tiles, entry bundles, and checkpoint stored in its GCS bucket.
+//
+// The log must not be running while this tool is used.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/transparency-dev/merkle/rfc6962"
+	"github.com/transparency-dev/trillian-tessera/storage/gcp"
+	"golang.org/x/mod/sumdb/note"
+	"k8s.io/klog/v2"
+)
+
+var (
+	bucket   = flag.String("bucket", "", "Bucket containing the log")
+	spanner  = flag.String("spanner", "", "Spanner resource URI ('projects/.../...') to recover into")
+	verifier = flag.String("verifier", "", "Note verifier key for the log's checkpoints")
+	origin   = flag.String("origin", "", "Origin of the log's checkpoints, defaults to the verifier name")
+	extend   = flag.Bool("extend", false, "Set to integrate entry bundles found beyond the checkpoint. Only valid for logs whose entries were all created with tessera.NewEntry, and MUST NOT be used for CT logs")
+)
+
+func main() {
+	klog.InitFlags(nil)
+	flag.Parse()
+	ctx := context.Background()
+
+	if *bucket == "" {
+		klog.Exit("--bucket must be set")
+	}
+	if *spanner == "" {
+		klog.Exit("--spanner must be set")
+	}
+	v, err := note.NewVerifier(*verifier)
+	if err != nil {
+		klog.Exitf("Failed to create verifier: %v", err)
+	}
+	o := *origin
+	if o == "" {
+		o = v.Name()
+	}
+
+	var leafHashes gcp.LeafHashesFunc
+	if *extend {
+		leafHashes = gcp.NewEntryLeafHashes(rfc6962.DefaultHasher)
+	}
+	size, root, err := gcp.Recover(ctx, gcp.Config{Bucket: *bucket, Spanner: *spanner}, o, v, leafHashes)
+	if err != nil {
+		klog.Exitf("Recovery failed: %v", err)
+	}
+	fmt.Printf("Recovered tree: size %d, root %x\n", size, root)
+}
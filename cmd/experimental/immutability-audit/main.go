@@ -0,0 +1,67 @@
+// Copyright 2024 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// immutability-audit checks that none of the full tiles or entry bundles of a log stored in a GCS or S3
+// bucket have been overwritten with different content, which would violate the log's append-only property.
+//
+// The bucket must have object versioning enabled for overwrites to be detectable.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/transparency-dev/trillian-tessera/storage/aws"
+	"github.com/transparency-dev/trillian-tessera/storage/gcp"
+	"k8s.io/klog/v2"
+)
+
+var (
+	storage = flag.String("storage", "gcp", "Object store holding the log, one of: gcp, aws")
+	bucket  = flag.String("bucket", "", "Bucket containing the log")
+)
+
+func main() {
+	klog.InitFlags(nil)
+	flag.Parse()
+	ctx := context.Background()
+
+	if *bucket == "" {
+		klog.Exit("--bucket must be set")
+	}
+
+	var rewritten []string
+	var err error
+	switch *storage {
+	case "gcp":
+		rewritten, err = gcp.AuditImmutability(ctx, *bucket)
+	case "aws":
+		rewritten, err = aws.AuditImmutability(ctx, aws.Config{Bucket: *bucket})
+	default:
+		klog.Exitf("Unknown storage %q", *storage)
+	}
+	if err != nil {
+		klog.Exitf("Audit failed: %v", err)
+	}
+
+	for _, o := range rewritten {
+		fmt.Printf("REWRITTEN: %s\n", o)
+	}
+	if len(rewritten) > 0 {
+		os.Exit(1)
+	}
+	fmt.Println("No rewritten tiles or entry bundles found")
+}
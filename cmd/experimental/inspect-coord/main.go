@@ -0,0 +1,74 @@
+// Copyright 2024 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// inspect-coord prints the contents of the coordination tables used by the GCP (Spanner) and
+// AWS (MySQL) storage implementations, to help diagnose integration backlogs and stuck sequencers.
+//
+// The tables are only read, and no locks are taken, so it's safe to run against a live log.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/transparency-dev/trillian-tessera/storage/aws"
+	"github.com/transparency-dev/trillian-tessera/storage/gcp"
+	"k8s.io/klog/v2"
+)
+
+var (
+	storage = flag.String("storage", "gcp", "Storage implementation whose coordination tables should be inspected, one of: gcp, aws")
+	spanner = flag.String("spanner", "", "Spanner resource URI ('projects/.../...'), for --storage=gcp")
+	dbDSN   = flag.String("db_dsn", "", "DSN of the MySQL coordination database, for --storage=aws")
+)
+
+func main() {
+	klog.InitFlags(nil)
+	flag.Parse()
+	ctx := context.Background()
+
+	var next, integrated, pending, stale uint64
+	var root []byte
+	switch *storage {
+	case "gcp":
+		if *spanner == "" {
+			klog.Exit("--spanner must be set")
+		}
+		s, err := gcp.InspectCoordination(ctx, *spanner)
+		if err != nil {
+			klog.Exitf("Failed to inspect coordination tables: %v", err)
+		}
+		next, integrated, root, pending, stale = s.Next, s.Integrated, s.RootHash, s.PendingBatches, s.StaleBatches
+	case "aws":
+		if *dbDSN == "" {
+			klog.Exit("--db_dsn must be set")
+		}
+		s, err := aws.InspectCoordination(ctx, *dbDSN)
+		if err != nil {
+			klog.Exitf("Failed to inspect coordination tables: %v", err)
+		}
+		next, integrated, root, pending, stale = s.Next, s.Integrated, s.RootHash, s.PendingBatches, s.StaleBatches
+	default:
+		klog.Exitf("Unknown storage %q", *storage)
+	}
+
+	fmt.Printf("SeqCoord.next:     %d\n", next)
+	fmt.Printf("IntCoord.seq:      %d\n", integrated)
+	fmt.Printf("IntCoord.rootHash: %x\n", root)
+	fmt.Printf("Unintegrated:      %d entries in %d Seq rows\n", next-integrated, pending)
+	if stale > 0 {
+		fmt.Printf("WARNING: %d Seq rows precede IntCoord.seq and should have been removed\n", stale)
+	}
+}
@@ -0,0 +1,262 @@
+// Copyright 2024 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// loadgen populates a log with synthetic entries by calling the storage implementation's AddBatch
+// directly, and reports the achieved throughput and latency percentiles.
+//
+// Entries are generated from a seeded PRNG, so repeated runs with the same flags add identical
+// entries, making results comparable across the posix, mysql, gcp, and aws storage implementations.
+package main
+
+import (
+	"context"
+	crand "crypto/rand"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	aaws "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	_ "github.com/go-sql-driver/mysql"
+	tessera "github.com/transparency-dev/trillian-tessera"
+	"github.com/transparency-dev/trillian-tessera/internal/options"
+	"github.com/transparency-dev/trillian-tessera/storage/aws"
+	"github.com/transparency-dev/trillian-tessera/storage/gcp"
+	"github.com/transparency-dev/trillian-tessera/storage/mysql"
+	"github.com/transparency-dev/trillian-tessera/storage/posix"
+	"golang.org/x/mod/sumdb/note"
+	"k8s.io/klog/v2"
+)
+
+var (
+	storage       = flag.String("storage", "posix", "Storage implementation to populate, one of: posix, mysql, gcp, aws")
+	storageDir    = flag.String("storage_dir", "", "Root directory of the log, for --storage=posix. The log will be created if necessary.")
+	mysqlURI      = flag.String("mysql_uri", "", "Connection string for the log database, for --storage=mysql, or the coordination database, for --storage=aws. For mysql, the schema must already exist.")
+	bucket        = flag.String("bucket", "", "Bucket in which to store the log, for --storage=gcp and --storage=aws")
+	spanner       = flag.String("spanner", "", "Spanner resource URI ('projects/.../...') of the coordination database, for --storage=gcp")
+	s3Endpoint    = flag.String("s3_endpoint", "", "Endpoint for a custom non-AWS S3 service, for --storage=aws")
+	s3AccessKeyID = flag.String("s3_access_key", "", "Access key ID for --s3_endpoint")
+	s3Secret      = flag.String("s3_secret", "", "Secret access key for --s3_endpoint")
+	privKeyFile   = flag.String("private_key", "", "Location of private key file. If unset, an ephemeral key is generated.")
+
+	numEntries  = flag.Uint64("num_entries", 10000, "Number of entries to add")
+	minSize     = flag.Int("min_entry_size", 1024, "Minimum size in bytes of generated entries")
+	maxSize     = flag.Int("max_entry_size", 1024, "Maximum size in bytes of generated entries, sizes are uniformly distributed between the minimum and this")
+	batchSize   = flag.Int("add_batch_size", 16, "Number of entries passed to each AddBatch call")
+	concurrency = flag.Int("concurrency", 16, "Number of AddBatch calls to have outstanding at once")
+	seed        = flag.Int64("seed", 1, "Seed used to generate entry contents")
+
+	batchMaxSize = flag.Uint("batch_max_size", tessera.DefaultBatchMaxSize, "Maximum number of entries in a batch")
+	batchMaxAge  = flag.Duration("batch_max_age", tessera.DefaultBatchMaxAge, "Maximum age of a batch before it's flushed")
+)
+
+// addBatchFn adds entries to a log.
+type addBatchFn func(ctx context.Context, entries []*tessera.Entry) []tessera.IndexFuture
+
+func main() {
+	klog.InitFlags(nil)
+	flag.Parse()
+	ctx := context.Background()
+
+	if *minSize < 0 || *maxSize < *minSize {
+		klog.Exit("--min_entry_size must be non-negative and no larger than --max_entry_size")
+	}
+	if *batchSize < 1 || uint(*batchSize) > *batchMaxSize {
+		klog.Exit("--add_batch_size must be at least 1 and no larger than --batch_max_size")
+	}
+	if *concurrency < 1 {
+		klog.Exit("--concurrency must be at least 1")
+	}
+
+	addBatch, err := newStorage(ctx, signerOrDie())
+	if err != nil {
+		klog.Exitf("Failed to create %s storage: %v", *storage, err)
+	}
+
+	entries := generateEntries(*numEntries, *minSize, *maxSize, *seed)
+	latencies, elapsed := run(ctx, addBatch, entries, *batchSize, *concurrency)
+	report(latencies, elapsed)
+}
+
+func newStorage(ctx context.Context, s note.Signer) (addBatchFn, error) {
+	opts := []func(*options.StorageOptions){
+		tessera.WithCheckpointSigner(s),
+		tessera.WithBatching(*batchMaxSize, *batchMaxAge),
+	}
+	switch *storage {
+	case "posix":
+		if *storageDir == "" {
+			return nil, errors.New("--storage_dir must be set")
+		}
+		_, err := os.Stat(*storageDir)
+		st, err := posix.New(ctx, *storageDir, errors.Is(err, os.ErrNotExist), opts...)
+		if err != nil {
+			return nil, err
+		}
+		return st.AddBatch, nil
+	case "mysql":
+		if *mysqlURI == "" {
+			return nil, errors.New("--mysql_uri must be set")
+		}
+		db, err := sql.Open("mysql", *mysqlURI)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to DB: %v", err)
+		}
+		st, err := mysql.New(ctx, db, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return st.AddBatch, nil
+	case "gcp":
+		if *bucket == "" || *spanner == "" {
+			return nil, errors.New("--bucket and --spanner must be set")
+		}
+		st, err := gcp.New(ctx, gcp.Config{Bucket: *bucket, Spanner: *spanner}, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return st.AddBatch, nil
+	case "aws":
+		if *bucket == "" || *mysqlURI == "" {
+			return nil, errors.New("--bucket and --mysql_uri must be set")
+		}
+		cfg := aws.Config{Bucket: *bucket, DSN: *mysqlURI}
+		if *s3Endpoint != "" {
+			const defaultRegion = "us-east-1"
+			cfg.SDKConfig = &aaws.Config{Region: defaultRegion}
+			cfg.S3Options = func(o *s3.Options) {
+				o.BaseEndpoint = aaws.String(*s3Endpoint)
+				o.Region = defaultRegion
+				o.UsePathStyle = true
+			}
+			cfg.S3Credentials = &aws.S3Credentials{AccessKeyID: *s3AccessKeyID, SecretAccessKey: *s3Secret}
+		}
+		st, err := aws.New(ctx, cfg, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return st.AddBatch, nil
+	default:
+		return nil, fmt.Errorf("unknown storage %q", *storage)
+	}
+}
+
+// generateEntries deterministically creates n entries, with sizes uniformly distributed in [minSize, maxSize].
+//
+// Each entry is prefixed with its ordinal so that all entries are distinct.
+func generateEntries(n uint64, minSize, maxSize int, seed int64) [][]byte {
+	r := rand.New(rand.NewSource(seed))
+	entries := make([][]byte, n)
+	for i := range entries {
+		e := make([]byte, minSize+r.Intn(maxSize-minSize+1))
+		_, _ = r.Read(e)
+		entries[i] = append([]byte(fmt.Sprintf("%d:", i)), e...)
+	}
+	return entries
+}
+
+// run adds all entries to the log in batches of up to batchSize, with up to concurrency batches
+// outstanding at once.
+//
+// Returns the time taken for each entry to be assigned an index, measured from the start of the
+// AddBatch call which added it, and the total time taken.
+func run(ctx context.Context, addBatch addBatchFn, entries [][]byte, batchSize, concurrency int) ([]time.Duration, time.Duration) {
+	latencies := make([]time.Duration, len(entries))
+	work := make(chan []int)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range work {
+				for len(batch) > 0 {
+					es := make([]*tessera.Entry, len(batch))
+					for j, i := range batch {
+						es[j] = tessera.NewEntry(entries[i])
+					}
+					s := time.Now()
+					futures := addBatch(ctx, es)
+					// Entries which were pushed back are retried in a smaller batch.
+					var retry []int
+					for j, f := range futures {
+						_, err := f()
+						if errors.Is(err, tessera.ErrPushback) {
+							retry = append(retry, batch[j])
+							continue
+						}
+						if err != nil {
+							klog.Exitf("Failed to add entry %d: %v", batch[j], err)
+						}
+						latencies[batch[j]] = time.Since(s)
+					}
+					if len(retry) > 0 {
+						time.Sleep(100 * time.Millisecond)
+					}
+					batch = retry
+				}
+			}
+		}()
+	}
+	for i := 0; i < len(entries); i += batchSize {
+		batch := make([]int, 0, batchSize)
+		for j := i; j < len(entries) && j < i+batchSize; j++ {
+			batch = append(batch, j)
+		}
+		work <- batch
+	}
+	close(work)
+	wg.Wait()
+	return latencies, time.Since(start)
+}
+
+func report(latencies []time.Duration, elapsed time.Duration) {
+	fmt.Printf("Added %d entries in %v (%.1f entries/s)\n", len(latencies), elapsed.Round(time.Millisecond), float64(len(latencies))/elapsed.Seconds())
+	if len(latencies) == 0 {
+		return
+	}
+	slices.Sort(latencies)
+	for _, p := range []int{50, 90, 99} {
+		fmt.Printf("p%d latency: %v\n", p, latencies[(len(latencies)-1)*p/100])
+	}
+	fmt.Printf("max latency: %v\n", latencies[len(latencies)-1])
+}
+
+// signerOrDie returns a signer using the key from --private_key, or an ephemeral one if that's unset.
+func signerOrDie() note.Signer {
+	var k string
+	if *privKeyFile != "" {
+		b, err := os.ReadFile(*privKeyFile)
+		if err != nil {
+			klog.Exitf("Failed to read private key file %q: %v", *privKeyFile, err)
+		}
+		k = string(b)
+	} else {
+		var err error
+		if k, _, err = note.GenerateKey(crand.Reader, "loadgen"); err != nil {
+			klog.Exitf("Failed to generate key: %v", err)
+		}
+	}
+	s, err := note.NewSigner(k)
+	if err != nil {
+		klog.Exitf("Failed to create signer: %v", err)
+	}
+	return s
+}
@@ -0,0 +1,55 @@
+// Copyright 2024 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// posix-locks is a diagnostic tool which reports whether the advisory locks used by a
+// POSIX log are currently held, and by which process.
+//
+// This can help to diagnose a single-writer deployment which appears to be stuck.
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/transparency-dev/trillian-tessera/storage/posix"
+	"k8s.io/klog/v2"
+)
+
+var (
+	storageDir = flag.String("storage_dir", "", "Root directory of the log.")
+)
+
+func main() {
+	klog.InitFlags(nil)
+	flag.Parse()
+
+	if *storageDir == "" {
+		klog.Exit("--storage_dir must be set")
+	}
+
+	locks, err := posix.InspectLocks(*storageDir)
+	if err != nil {
+		klog.Exitf("Failed to inspect locks: %v", err)
+	}
+	for _, l := range locks {
+		switch {
+		case !l.Exists:
+			fmt.Printf("%s: not present\n", l.Path)
+		case l.Held:
+			fmt.Printf("%s: HELD by PID %d\n", l.Path, l.PID)
+		default:
+			fmt.Printf("%s: not held\n", l.Path)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2024 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// verify-inclusion checks that a given entry is included in a log at a given index, by verifying an
+// inclusion proof for the entry's leaf hash against the log's latest signed checkpoint.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+
+	"github.com/transparency-dev/merkle/proof"
+	"github.com/transparency-dev/merkle/rfc6962"
+	tessera "github.com/transparency-dev/trillian-tessera"
+	"github.com/transparency-dev/trillian-tessera/client"
+	"golang.org/x/mod/sumdb/note"
+	"k8s.io/klog/v2"
+)
+
+var (
+	logURL    = flag.String("log_url", "", "Log storage root URL, e.g. https://log.server/and/path/ or file:///path/to/log/")
+	logPubKey = flag.String("log_public_key", os.Getenv("TILES_LOG_PUBLIC_KEY"), "Public key for the log. This is defaulted to the environment variable TILES_LOG_PUBLIC_KEY")
+	origin    = flag.String("origin", "", "Origin of the log's checkpoints, defaults to the name of the log's public key")
+	entryFile = flag.String("entry_file", "", "File containing the bytes of the entry to check")
+	index     = flag.Int64("index", -1, "Index in the log at which the entry is expected to be found")
+)
+
+// fetcher knows how to fetch checkpoints and tiles from a log.
+type fetcher interface {
+	ReadCheckpoint(ctx context.Context) ([]byte, error)
+	ReadTile(ctx context.Context, l, i uint64, p uint8) ([]byte, error)
+}
+
+func main() {
+	klog.InitFlags(nil)
+	flag.Parse()
+	ctx := context.Background()
+
+	if *entryFile == "" {
+		klog.Exit("--entry_file must be set")
+	}
+	if *index < 0 {
+		klog.Exit("--index must be set")
+	}
+	v, err := note.NewVerifier(*logPubKey)
+	if err != nil {
+		klog.Exitf("Failed to create verifier from --log_public_key: %v", err)
+	}
+	o := *origin
+	if o == "" {
+		o = v.Name()
+	}
+	u, err := url.Parse(*logURL)
+	if err != nil {
+		klog.Exitf("Invalid --log_url %q: %v", *logURL, err)
+	}
+	f := newFetcher(u)
+
+	e, err := os.ReadFile(*entryFile)
+	if err != nil {
+		klog.Exitf("Failed to read entry: %v", err)
+	}
+	leafHash := tessera.NewEntry(e).LeafHash()
+
+	cp, _, _, err := client.FetchCheckpoint(ctx, f.ReadCheckpoint, v, o)
+	if err != nil {
+		klog.Exitf("Failed to fetch checkpoint: %v", err)
+	}
+	idx := uint64(*index)
+	if idx >= cp.Size {
+		fmt.Printf("FAIL: index %d is not within the log's latest checkpoint of size %d\n", idx, cp.Size)
+		os.Exit(1)
+	}
+	pb, err := client.NewProofBuilder(ctx, *cp, f.ReadTile)
+	if err != nil {
+		klog.Exitf("Failed to create proof builder: %v", err)
+	}
+	p, err := pb.InclusionProof(ctx, idx)
+	if err != nil {
+		klog.Exitf("Failed to build inclusion proof for index %d: %v", idx, err)
+	}
+	if err := proof.VerifyInclusion(rfc6962.DefaultHasher, idx, cp.Size, leafHash, p, cp.Hash); err != nil {
+		fmt.Printf("FAIL: entry is not included at index %d in tree of size %d: %v\n", idx, cp.Size, err)
+		os.Exit(1)
+	}
+	fmt.Printf("PASS: entry is included at index %d in tree of size %d\n", idx, cp.Size)
+}
+
+// newFetcher creates a fetcher for the log at the given root location.
+func newFetcher(root *url.URL) fetcher {
+	switch root.Scheme {
+	case "http", "https":
+		c, err := client.NewHTTPFetcher(root, nil)
+		if err != nil {
+			klog.Exitf("NewHTTPFetcher: %v", err)
+		}
+		return c
+	case "file":
+		return client.FileFetcher{Root: root.Path}
+	}
+	klog.Exitf("Unknown scheme on log URL: %q", root.Scheme)
+	return nil
+}
@@ -62,10 +62,12 @@ func convertCTEntry(e *ctonly.Entry) *Entry {
 	return r
 }
 
-// WithCTLayout instructs the underlying storage to use a Static CT API compatible scheme for layout.
+// WithCTLayout instructs the underlying storage to use a Static CT API compatible scheme for layout, and
+// for the format of its entry bundles.
 func WithCTLayout() func(*options.StorageOptions) {
 	return func(opts *options.StorageOptions) {
 		opts.EntriesPath = ctEntriesPath
+		opts.EntryBundleCodec = ctonly.Codec{}
 	}
 }
 
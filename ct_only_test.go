@@ -15,8 +15,11 @@
 package tessera
 
 import (
+	"bytes"
 	"fmt"
 	"testing"
+
+	"github.com/transparency-dev/trillian-tessera/ctonly"
 )
 
 func TestCTEntriesPath(t *testing.T) {
@@ -57,3 +60,49 @@ func TestCTEntriesPath(t *testing.T) {
 		})
 	}
 }
+
+func TestCTCodec(t *testing.T) {
+	entries := []*ctonly.Entry{
+		{
+			Timestamp:         1234,
+			Certificate:       []byte("certificate"),
+			FingerprintsChain: [][32]byte{{1}, {2}},
+		}, {
+			Timestamp:      5678,
+			IsPrecert:      true,
+			Certificate:    []byte("tbs"),
+			Precertificate: []byte("precertificate"),
+			IssuerKeyHash:  bytes.Repeat([]byte{3}, 32),
+		},
+	}
+	var bundle []byte
+	var want [][]byte
+	for i, e := range entries {
+		d := convertCTEntry(e).MarshalBundleData(uint64(i))
+		if _, err := (ctonly.Codec{}).MarshalEntry(d); err != nil {
+			t.Fatalf("MarshalEntry(%d): %v", i, err)
+		}
+		want = append(want, d)
+		bundle = append(bundle, d...)
+	}
+
+	got, err := ctonly.Codec{}.UnmarshalBundle(bundle)
+	if err != nil {
+		t.Fatalf("UnmarshalBundle: %v", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("UnmarshalBundle returned %d entries, want %d", len(got), len(want))
+	}
+	for i := range got {
+		if !bytes.Equal(got[i], want[i]) {
+			t.Errorf("entry %d: got %x, want %x", i, got[i], want[i])
+		}
+	}
+
+	if _, err := (ctonly.Codec{}).UnmarshalBundle(bundle[:len(bundle)-1]); err == nil {
+		t.Error("UnmarshalBundle of truncated bundle succeeded, want error")
+	}
+	if _, err := (ctonly.Codec{}).MarshalEntry(bundle); err == nil {
+		t.Error("MarshalEntry of two entries succeeded, want error")
+	}
+}
@@ -38,8 +38,10 @@ package ctonly
 import (
 	"crypto/sha256"
 	"errors"
+	"fmt"
 
 	"github.com/transparency-dev/merkle/rfc6962"
+	"github.com/transparency-dev/trillian-tessera/api/layout"
 	"golang.org/x/crypto/cryptobyte"
 )
 
@@ -91,6 +93,75 @@ func (c Entry) LeafData(idx uint64) []byte {
 	return b.BytesOrPanic()
 }
 
+// Codec is an api.EntryBundleCodec for the entry bundles of CT logs, which are sequences of the TileLeaf
+// structures returned by Entry.LeafData.
+//
+// CT entries are marshalled using their assigned index rather than by this codec, so MarshalEntry only checks
+// that the data is a single well-formed TileLeaf.
+type Codec struct{}
+
+// ID implements api.EntryBundleCodec.
+func (Codec) ID() string { return "static-ct" }
+
+// MarshalEntry implements api.EntryBundleCodec.
+func (Codec) MarshalEntry(data []byte) ([]byte, error) {
+	s := cryptobyte.String(data)
+	if _, err := readTileLeaf(&s); err != nil {
+		return nil, err
+	}
+	if !s.Empty() {
+		return nil, fmt.Errorf("%d trailing bytes after TileLeaf", len(s))
+	}
+	return data, nil
+}
+
+// UnmarshalBundle implements api.EntryBundleCodec.
+func (Codec) UnmarshalBundle(raw []byte) ([][]byte, error) {
+	s := cryptobyte.String(raw)
+	r := make([][]byte, 0, layout.EntryBundleWidth)
+	for !s.Empty() {
+		l, err := readTileLeaf(&s)
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: %v", len(r), err)
+		}
+		r = append(r, l)
+	}
+	return r, nil
+}
+
+// readTileLeaf reads a TileLeaf from s, and returns its serialised form.
+func readTileLeaf(s *cryptobyte.String) ([]byte, error) {
+	start := *s
+	var timestamp uint64
+	var entryType uint16
+	var skip cryptobyte.String
+	if !s.ReadUint64(&timestamp) || !s.ReadUint16(&entryType) {
+		return nil, errors.New("invalid TileLeaf header")
+	}
+	switch entryType {
+	case 0: // x509_entry
+		if !s.ReadUint24LengthPrefixed(&skip) {
+			return nil, errors.New("invalid certificate")
+		}
+	case 1: // precert_entry
+		if !s.Skip(sha256.Size) || !s.ReadUint24LengthPrefixed(&skip) {
+			return nil, errors.New("invalid precertificate entry")
+		}
+	default:
+		return nil, fmt.Errorf("unknown entry_type %d", entryType)
+	}
+	if !s.ReadUint16LengthPrefixed(&skip) {
+		return nil, errors.New("invalid extensions")
+	}
+	if entryType == 1 && !s.ReadUint24LengthPrefixed(&skip) {
+		return nil, errors.New("invalid pre_certificate")
+	}
+	if !s.ReadUint16LengthPrefixed(&skip) {
+		return nil, errors.New("invalid certificate_chain")
+	}
+	return start[:len(start)-len(*s)], nil
+}
+
 // MerkleTreeLeaf returns a RFC 6962 MerkleTreeLeaf.
 //
 // Note that we embed an SCT extension which captures the index of the entry in the log according to
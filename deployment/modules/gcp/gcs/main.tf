@@ -67,6 +67,7 @@ resource "google_spanner_database" "log_db" {
     "CREATE TABLE SeqCoord (id INT64 NOT NULL, next INT64 NOT NULL,) PRIMARY KEY (id)",
     "CREATE TABLE Seq (id INT64 NOT NULL, seq INT64 NOT NULL, v BYTES(MAX),) PRIMARY KEY (id, seq)",
     "CREATE TABLE IntCoord (id INT64 NOT NULL, seq INT64 NOT NULL, rootHash BYTES(32)) PRIMARY KEY (id)",
+    "CREATE TABLE PubCoord (id INT64 NOT NULL, holder STRING(MAX) NOT NULL, expiresAt TIMESTAMP NOT NULL) PRIMARY KEY (id)",
   ]
 
   deletion_protection = !var.ephemeral
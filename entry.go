@@ -16,10 +16,14 @@
 package tessera
 
 import (
+	"bytes"
 	"crypto/sha256"
-	"encoding/binary"
+	"errors"
+	"fmt"
 
+	"github.com/transparency-dev/merkle"
 	"github.com/transparency-dev/merkle/rfc6962"
+	"github.com/transparency-dev/trillian-tessera/api"
 )
 
 // Entry represents an entry in a log.
@@ -37,6 +41,10 @@ type Entry struct {
 
 	// marshalForBundle knows how to convert this entry's Data into a marshalled bundle entry.
 	marshalForBundle func(index uint64) []byte
+	// codec is the codec used by marshalForBundle, or nil if the entry is marshalled by other means, e.g. CT entries.
+	codec api.EntryBundleCodec
+	// err, if non-nil, is the reason this entry couldn't be marshalled using codec.
+	err error
 }
 
 // Data returns the raw entry bytes which will form the entry in the log.
@@ -62,20 +70,91 @@ func (e *Entry) MarshalBundleData(index uint64) []byte {
 	return e.marshalForBundle(index)
 }
 
+// Validate returns an error if this entry can't be added to a log whose entry bundles use the codec c, and
+// whose Merkle tree is built using the hasher h, e.g. because it's too large to be length prefixed, or it was
+// created with a different codec or hasher.
+//
+// Storage implementations call this as entries are added, with the codec and hasher configured via
+// WithEntryBundleCodec and WithMerkleHasher. If c or h is nil, the entry isn't checked against it.
+func (e *Entry) Validate(c api.EntryBundleCodec, h merkle.LogHasher) error {
+	if e.err != nil {
+		return e.err
+	}
+	if e.codec == nil {
+		// The entry is marshalled by other means, e.g. CT entries, whose leaf hash isn't known until
+		// they're assigned an index.
+		return nil
+	}
+	if c != nil && e.codec.ID() != c.ID() {
+		return fmt.Errorf("entry was created with codec %q, but the log uses %q", e.codec.ID(), c.ID())
+	}
+	// The leaf hash is calculated when the entry is created, so check that it's the one the log's hasher
+	// would produce rather than trusting it.
+	if h != nil && !bytes.Equal(h.HashLeaf(e.internal.Data), e.internal.LeafHash) {
+		return errors.New("entry's leaf hash wasn't calculated with the log's Merkle hasher")
+	}
+	return nil
+}
+
 // NewEntry creates a new Entry object with leaf data.
+//
+// The entry will be marshalled into bundles using the mechanism described by https://c2sp.org/tlog-tiles,
+// i.e. api.LengthPrefixedCodec.
 func NewEntry(data []byte) *Entry {
+	return NewEntryWithHasher(data, rfc6962.DefaultHasher)
+}
+
+// NewEntryWithHasher creates a new Entry object with leaf data, whose leaf hash is calculated using
+// the provided hasher.
+//
+// This should only be used with logs configured to use the same hasher via WithMerkleHasher; entries
+// created with a different hasher are rejected when they're added to the log.
+//
+// Entries which are too large to be length prefixed will be rejected when they're added to the log.
+func NewEntryWithHasher(data []byte, h merkle.LogHasher) *Entry {
+	e := newEntry(data, h)
+	// The error is kept, and returned by Validate, so that the entry is rejected by the storage.
+	_ = e.setCodec(api.LengthPrefixedCodec{})
+	return e
+}
+
+// NewEntryWithCodec creates a new Entry object with leaf data, which will be marshalled into
+// bundles using the provided codec.
+//
+// The log must be configured to use the same codec via WithEntryBundleCodec, and readers of the log must use
+// it to parse the entry bundles, e.g. via api.EntryBundle.UnmarshalWithCodec.
+// An error is returned if the codec is unable to marshal the provided data.
+func NewEntryWithCodec(data []byte, c api.EntryBundleCodec) (*Entry, error) {
+	return NewEntryWithCodecAndHasher(data, c, rfc6962.DefaultHasher)
+}
+
+// NewEntryWithCodecAndHasher creates a new Entry object with leaf data, which will be marshalled into
+// bundles using the provided codec, and whose leaf hash is calculated using the provided hasher.
+//
+// This is for logs configured with both WithEntryBundleCodec and WithMerkleHasher, which must be given
+// the same codec and hasher.
+// An error is returned if the codec is unable to marshal the provided data.
+func NewEntryWithCodecAndHasher(data []byte, c api.EntryBundleCodec, h merkle.LogHasher) (*Entry, error) {
+	e := newEntry(data, h)
+	if err := e.setCodec(c); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// setCodec marshals the entry's data using c, and arranges for it to be used when the entry is added to a bundle.
+func (e *Entry) setCodec(c api.EntryBundleCodec) error {
+	b, err := c.MarshalEntry(e.internal.Data)
+	e.codec, e.err = c, err
+	e.marshalForBundle = func(_ uint64) []byte { return b }
+	return err
+}
+
+func newEntry(data []byte, hasher merkle.LogHasher) *Entry {
 	e := &Entry{}
 	e.internal.Data = data
 	h := sha256.Sum256(e.internal.Data)
 	e.internal.Identity = h[:]
-	e.internal.LeafHash = rfc6962.DefaultHasher.HashLeaf(e.internal.Data)
-	// By default we will marshal ourselves into a bundle using the mechanism described
-	// by https://c2sp.org/tlog-tiles:
-	e.marshalForBundle = func(_ uint64) []byte {
-		r := make([]byte, 0, 2+len(e.internal.Data))
-		r = binary.BigEndian.AppendUint16(r, uint16(len(e.internal.Data)))
-		r = append(r, e.internal.Data...)
-		return r
-	}
+	e.internal.LeafHash = hasher.HashLeaf(e.internal.Data)
 	return e
 }
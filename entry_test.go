@@ -16,8 +16,17 @@ package tessera
 
 import (
 	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"errors"
 	"fmt"
+	"math"
 	"testing"
+
+	"github.com/transparency-dev/merkle"
+	"github.com/transparency-dev/merkle/rfc6962"
+	"github.com/transparency-dev/trillian-tessera/api"
+	"github.com/transparency-dev/trillian-tessera/ctonly"
 )
 
 func TestEntryMarshalBundleDelegates(t *testing.T) {
@@ -36,3 +45,128 @@ func TestEntryMarshalBundleDelegates(t *testing.T) {
 		t.Fatalf("Got %q, want %q", got, want)
 	}
 }
+
+type errCodec struct{}
+
+func (errCodec) ID() string                               { return "err" }
+func (errCodec) MarshalEntry([]byte) ([]byte, error)      { return nil, errors.New("bang") }
+func (errCodec) UnmarshalBundle([]byte) ([][]byte, error) { return nil, errors.New("bang") }
+
+// funcCodec is a LengthPrefixedCodec which holds a func, so can't be compared with ==.
+type funcCodec struct {
+	api.LengthPrefixedCodec
+	onMarshal func()
+}
+
+func TestNewEntryWithCodecError(t *testing.T) {
+	if _, err := NewEntryWithCodec([]byte("this is data"), errCodec{}); err == nil {
+		t.Fatal("NewEntryWithCodec() succeeded, want error")
+	}
+}
+
+// prefixHasher is a LogHasher whose leaf hashes are domain separated from RFC6962 ones by an extra prefix.
+type prefixHasher struct {
+	merkle.LogHasher
+}
+
+func (h prefixHasher) HashLeaf(leaf []byte) []byte {
+	return h.LogHasher.HashLeaf(append([]byte("prefix"), leaf...))
+}
+
+func TestEntryValidate(t *testing.T) {
+	customHasher := prefixHasher{rfc6962.DefaultHasher}
+	customEntry, err := NewEntryWithCodecAndHasher(make([]byte, sha256.Size), api.HashCodec{Size: sha256.Size}, customHasher)
+	if err != nil {
+		t.Fatalf("NewEntryWithCodecAndHasher: %v", err)
+	}
+	funcEntry, err := NewEntryWithCodec([]byte("this is data"), funcCodec{onMarshal: func() {}})
+	if err != nil {
+		t.Fatalf("NewEntryWithCodec: %v", err)
+	}
+	hashEntry, err := NewEntryWithCodec(make([]byte, sha256.Size), api.HashCodec{Size: sha256.Size})
+	if err != nil {
+		t.Fatalf("NewEntryWithCodec: %v", err)
+	}
+	for _, test := range []struct {
+		name    string
+		e       *Entry
+		codec   api.EntryBundleCodec
+		hasher  merkle.LogHasher
+		wantErr bool
+	}{
+		{
+			name:  "default codec",
+			e:     NewEntry([]byte("this is data")),
+			codec: api.LengthPrefixedCodec{},
+		}, {
+			name:  "largest length prefixed entry",
+			e:     NewEntry(make([]byte, math.MaxUint16)),
+			codec: api.LengthPrefixedCodec{},
+		}, {
+			name:    "too large to length prefix",
+			e:       NewEntry(make([]byte, math.MaxUint16+1)),
+			codec:   api.LengthPrefixedCodec{},
+			wantErr: true,
+		}, {
+			name:    "too large without log codec",
+			e:       NewEntry(make([]byte, math.MaxUint16+1)),
+			wantErr: true,
+		}, {
+			name:  "matching codec",
+			e:     hashEntry,
+			codec: api.HashCodec{Size: sha256.Size},
+		}, {
+			name:    "different codec",
+			e:       hashEntry,
+			codec:   api.LengthPrefixedCodec{},
+			wantErr: true,
+		}, {
+			name:  "codec with the same ID",
+			e:     funcEntry,
+			codec: funcCodec{onMarshal: func() {}},
+		}, {
+			name:    "different codec parameters",
+			e:       hashEntry,
+			codec:   api.HashCodec{Size: 20},
+			wantErr: true,
+		}, {
+			name:   "default hasher",
+			e:      NewEntry([]byte("this is data")),
+			hasher: rfc6962.DefaultHasher,
+		}, {
+			name:   "equivalent hasher",
+			e:      NewEntry([]byte("this is data")),
+			hasher: rfc6962.New(crypto.SHA256),
+		}, {
+			name:   "matching custom hasher",
+			e:      NewEntryWithHasher([]byte("this is data"), customHasher),
+			hasher: customHasher,
+		}, {
+			name:   "matching codec and custom hasher",
+			e:      customEntry,
+			codec:  api.HashCodec{Size: sha256.Size},
+			hasher: customHasher,
+		}, {
+			name:    "custom hasher log with default entry",
+			e:       NewEntry([]byte("this is data")),
+			hasher:  customHasher,
+			wantErr: true,
+		}, {
+			name:    "default hasher log with custom entry",
+			e:       NewEntryWithHasher([]byte("this is data"), customHasher),
+			hasher:  rfc6962.DefaultHasher,
+			wantErr: true,
+		}, {
+			name:   "CT entry",
+			e:      convertCTEntry(&ctonly.Entry{Certificate: []byte("cert")}),
+			codec:  ctonly.Codec{},
+			hasher: rfc6962.DefaultHasher,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.e.Validate(test.codec, test.hasher); (err != nil) != test.wantErr {
+				t.Errorf("Validate: got %v, want error %t", err, test.wantErr)
+			}
+		})
+	}
+}
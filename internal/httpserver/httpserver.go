@@ -0,0 +1,85 @@
+// Copyright 2024 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package httpserver provides a helper for constructing HTTP servers with safe defaults.
+package httpserver
+
+import (
+	"flag"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// Config holds the settings used to construct an HTTP server.
+type Config struct {
+	// ReadHeaderTimeout is the maximum duration for reading request headers.
+	ReadHeaderTimeout time.Duration
+	// ReadTimeout is the maximum duration for reading an entire request, including the body.
+	ReadTimeout time.Duration
+	// WriteTimeout is the maximum duration before timing out writes of the response.
+	WriteTimeout time.Duration
+	// IdleTimeout is the maximum amount of time to wait for the next request on an idle connection.
+	IdleTimeout time.Duration
+
+	// H2C enables unencrypted HTTP/2 support.
+	H2C bool
+	// MaxConcurrentStreams is the maximum number of concurrent HTTP/2 streams per client connection.
+	// Only used when H2C is true.
+	MaxConcurrentStreams uint
+}
+
+// DefaultConfig returns a Config with conservative timeouts set, which protect against slow clients
+// holding on to server resources.
+func DefaultConfig() Config {
+	return Config{
+		ReadHeaderTimeout:    10 * time.Second,
+		ReadTimeout:          30 * time.Second,
+		WriteTimeout:         30 * time.Second,
+		IdleTimeout:          2 * time.Minute,
+		MaxConcurrentStreams: 1000,
+	}
+}
+
+// RegisterFlags registers flags on the provided FlagSet which allow the values in c to be overridden.
+func (c *Config) RegisterFlags(fs *flag.FlagSet) {
+	fs.DurationVar(&c.ReadHeaderTimeout, "http_read_header_timeout", c.ReadHeaderTimeout, "Maximum duration for reading request headers")
+	fs.DurationVar(&c.ReadTimeout, "http_read_timeout", c.ReadTimeout, "Maximum duration for reading an entire request, including the body")
+	fs.DurationVar(&c.WriteTimeout, "http_write_timeout", c.WriteTimeout, "Maximum duration before timing out writes of the response")
+	fs.DurationVar(&c.IdleTimeout, "http_idle_timeout", c.IdleTimeout, "Maximum time to wait for the next request on an idle connection")
+	if c.H2C {
+		fs.UintVar(&c.MaxConcurrentStreams, "http2_max_concurrent_streams", c.MaxConcurrentStreams, "Maximum number of concurrent HTTP/2 streams per client connection")
+	}
+}
+
+// New returns an http.Server which will listen on addr and serve requests using h, configured using c.
+func New(addr string, h http.Handler, c Config) *http.Server {
+	if c.H2C {
+		h2s := &http2.Server{
+			MaxConcurrentStreams: uint32(c.MaxConcurrentStreams),
+			IdleTimeout:          c.IdleTimeout,
+		}
+		h = h2c.NewHandler(h, h2s)
+	}
+	return &http.Server{
+		Addr:              addr,
+		Handler:           h,
+		ReadHeaderTimeout: c.ReadHeaderTimeout,
+		ReadTimeout:       c.ReadTimeout,
+		WriteTimeout:      c.WriteTimeout,
+		IdleTimeout:       c.IdleTimeout,
+	}
+}
@@ -0,0 +1,45 @@
+// Copyright 2024 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpserver
+
+import (
+	"flag"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestNewSetsTimeouts(t *testing.T) {
+	c := DefaultConfig()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	c.RegisterFlags(fs)
+	if err := fs.Parse([]string{"--http_write_timeout=5s"}); err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	s := New(":0", http.NotFoundHandler(), c)
+	if got, want := s.WriteTimeout, 5*time.Second; got != want {
+		t.Errorf("WriteTimeout = %v, want %v", got, want)
+	}
+	for name, got := range map[string]time.Duration{
+		"ReadHeaderTimeout": s.ReadHeaderTimeout,
+		"ReadTimeout":       s.ReadTimeout,
+		"IdleTimeout":       s.IdleTimeout,
+	} {
+		if got <= 0 {
+			t.Errorf("%s = %v, want > 0", name, got)
+		}
+	}
+}
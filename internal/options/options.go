@@ -15,9 +15,13 @@
 package options
 
 import (
+	"os"
 	"time"
 
 	f_log "github.com/transparency-dev/formats/log"
+	"github.com/transparency-dev/merkle"
+	"github.com/transparency-dev/trillian-tessera/api"
+	"go.opentelemetry.io/otel/metric"
 )
 
 // NewCPFunc is the signature of a function which knows how to format and sign checkpoints.
@@ -26,19 +30,73 @@ type NewCPFunc func(size uint64, hash []byte) ([]byte, error)
 // ParseCPFunc is the signature of a function which knows how to verify and parse checkpoints.
 type ParseCPFunc func(raw []byte) (*f_log.Checkpoint, error)
 
+// NewResourceSigFunc is the signature of a function which knows how to sign a tile or entry bundle
+// written at the given path.
+type NewResourceSigFunc func(path string, data []byte) ([]byte, error)
+
 // EntriesPathFunc is the signature of a function which knows how to format entry bundle paths.
 type EntriesPathFunc func(n uint64, p uint8) string
 
 // StorageOptions holds optional settings for all storage implementations.
 type StorageOptions struct {
 	NewCP NewCPFunc
+	// NewResourceSig is set alongside NewCP, but is only used if SignResources is true.
+	NewResourceSig NewResourceSigFunc
+	SignResources  bool
 
 	BatchMaxAge  time.Duration
 	BatchMaxSize uint
 
 	PushbackMaxOutstanding uint
+	MaxConcurrentAdds      uint
 
 	EntriesPath EntriesPathFunc
+	// EntryBundleCodec is the codec which entries must be marshalled with to be added to the log.
+	EntryBundleCodec api.EntryBundleCodec
+
+	CheckpointInterval          time.Duration
+	CheckpointIntervalJitter    float64
+	UnsafeCheckpointInterval    bool
+	PublishOnlyOnChange         bool
+	CheckpointRepublishInterval time.Duration
+
+	IntegrationMaxIdleInterval time.Duration
+	// IntegrationSizeLimit is the limit passed to WithIntegrationSizeLimit. It's nil if the option wasn't provided.
+	IntegrationSizeLimit        *uint64
+	IntegrationCatchUpSizeLimit uint
+	IntegrationWorkers          uint
+
+	ObjectChecksums bool
+
+	ObjectWriteRetryAttempts  int
+	ObjectWriteRetryBaseDelay time.Duration
+
+	ReadCacheMaxBytes int
+	ReadCacheTTL      time.Duration
+
+	Hasher merkle.LogHasher
+
+	VerifyRootOnInit bool
+	// VerifyRootFull causes the root verification on init to recalculate the root from every leaf hash.
+	VerifyRootFull bool
+
+	SkipSchemaInit bool
+
+	// CheckpointHistory enables retention of published checkpoints, for CheckpointHistoryRetention if non-zero.
+	CheckpointHistory          bool
+	CheckpointHistoryRetention time.Duration
+
+	// DirPerm and FilePerm are the modes used by the POSIX storage when creating directories and files, if
+	// Permissions is set by WithPermissions.
+	Permissions bool
+	DirPerm     os.FileMode
+	FilePerm    os.FileMode
+	// Fsync, if set, causes the POSIX storage to fsync files and their directories as they're written.
+	Fsync bool
+
+	// MetadataVerifierKeys are the keys passed to WithLogMetadata. It's nil if the option wasn't provided.
+	MetadataVerifierKeys []string
 
-	CheckpointInterval time.Duration
+	// MeterProvider, if non-nil, is used to create the storage's metrics in place of the global provider.
+	MeterProvider metric.MeterProvider
 }
@@ -21,7 +21,10 @@ import (
 	"time"
 
 	f_log "github.com/transparency-dev/formats/log"
+	"github.com/transparency-dev/merkle"
+	"github.com/transparency-dev/trillian-tessera/api"
 	"github.com/transparency-dev/trillian-tessera/internal/options"
+	"go.opentelemetry.io/otel/metric"
 	"golang.org/x/mod/sumdb/note"
 	"k8s.io/klog/v2"
 )
@@ -33,15 +36,46 @@ const (
 	DefaultBatchMaxAge = 250 * time.Millisecond
 	// DefaultCheckpointInterval is used by storage implementations if no WithCheckpointInterval option is provided when instantiating it.
 	DefaultCheckpointInterval = 10 * time.Second
+
+	// UnsafeCheckpointIntervalEnv is the name of the environment variable which must be set to "true" in
+	// order for the WithUnsafeCheckpointInterval option to be used.
+	UnsafeCheckpointIntervalEnv = "TESSERA_UNSAFE_CHECKPOINT_INTERVAL"
+	// DefaultIntegrationMaxIdleInterval is used by storage implementations if no WithIntegrationIdleBackoff option is provided when instantiating it.
+	DefaultIntegrationMaxIdleInterval = 10 * time.Second
+	// DefaultObjectWriteRetryAttempts is used by storage implementations if no WithObjectWriteRetry option is provided when instantiating it.
+	// A single attempt leaves retries to the object storage client libraries.
+	DefaultObjectWriteRetryAttempts = 1
+	// DefaultObjectWriteRetryBaseDelay is used by storage implementations if no WithObjectWriteRetry option is provided when instantiating it.
+	DefaultObjectWriteRetryBaseDelay = 100 * time.Millisecond
+	// DefaultPushbackMaxOutstanding is used by storage implementations which integrate asynchronously if no
+	// WithPushback option is provided when instantiating them.
+	DefaultPushbackMaxOutstanding = 4096
 )
 
 // ErrPushback is returned by underlying storage implementations when there are too many
 // entries with indices assigned but which have not yet been integrated into the tree.
 //
+// This is the primary protection against overload: once integration falls behind by more than the
+// threshold configured with WithPushback, further entries are refused rather than growing the backlog.
+// It's also returned, wrapped, when the limit set by WithMaxConcurrentAdds is reached.
+//
 // Personalities encountering this error should apply back-pressure to the source of new entries
 // in an appropriate manner (e.g. for HTTP services, return a 503 with a Retry-After header).
 var ErrPushback = errors.New("too many unintegrated entries")
 
+// ErrBatchTooLarge is returned by underlying storage implementations when AddBatch is called with more
+// entries than can be queued together, i.e. more than the batch size configured with WithBatching, or the
+// limit set by WithMaxConcurrentAdds if that's smaller.
+//
+// Unlike ErrPushback, retrying the same batch will never succeed; it must be split into smaller batches.
+var ErrBatchTooLarge = errors.New("batch too large")
+
+// ErrChecksumMismatch is returned by underlying storage implementations when the data read from
+// an object does not match the checksum stored alongside it, indicating that the stored object is corrupt.
+//
+// This is only detected when the WithObjectChecksums option is used.
+var ErrChecksumMismatch = errors.New("object checksum mismatch")
+
 // IndexFuture is the signature of a function which can return an assigned index or error.
 //
 // Implementations of this func are likely to be "futures", or a promise to return this data at
@@ -73,10 +107,15 @@ func WithCheckpointSigner(s note.Signer, additionalSigners ...note.Signer) func(
 	return func(o *options.StorageOptions) {
 		o.NewCP = func(size uint64, hash []byte) ([]byte, error) {
 			// If we're signing a zero-sized tree, the tlog-checkpoint spec says (via RFC6962) that
-			// the root must be SHA256 of the empty string, so we'll enforce that here:
+			// the root must be SHA256 of the empty string, so we'll enforce that here (or the
+			// equivalent value if a custom hasher has been configured):
 			if size == 0 {
-				emptyRoot := sha256.Sum256([]byte{})
-				hash = emptyRoot[:]
+				if o.Hasher != nil {
+					hash = o.Hasher.EmptyRoot()
+				} else {
+					emptyRoot := sha256.Sum256([]byte{})
+					hash = emptyRoot[:]
+				}
 			}
 			cpRaw := f_log.Checkpoint{
 				Origin: origin,
@@ -90,6 +129,17 @@ func WithCheckpointSigner(s note.Signer, additionalSigners ...note.Signer) func(
 			}
 			return n, nil
 		}
+		o.NewResourceSig = func(path string, data []byte) ([]byte, error) {
+			body, err := api.NewResourceStatement(origin, path, data).MarshalText()
+			if err != nil {
+				return nil, err
+			}
+			n, err := note.Sign(&note.Note{Text: string(body)}, append([]note.Signer{s}, additionalSigners...)...)
+			if err != nil {
+				return nil, fmt.Errorf("note.Sign: %w", err)
+			}
+			return n, nil
+		}
 	}
 }
 
@@ -116,13 +166,275 @@ func WithBatching(maxSize uint, maxAge time.Duration) func(*options.StorageOptio
 // WithPushback allows configuration of when the storage should start pushing back on add requests.
 //
 // maxOutstanding is the number of "in-flight" add requests - i.e. the number of entries with sequence numbers
-// assigned, but which are not yet integrated into the log.
+// assigned, but which are not yet integrated into the log. The check is made as each batch is sequenced: if the
+// number outstanding already exceeds maxOutstanding, every entry in the batch fails with ErrPushback and none
+// is assigned an index. Since a batch may be sequenced while just under the threshold, the number outstanding
+// can exceed maxOutstanding by up to one batch per frontend.
+//
+// This applies only to storage implementations which integrate asynchronously (GCP, AWS, and Azure); the others
+// integrate each batch before sequencing the next, and so ignore it.
+// If this option isn't provided, or maxOutstanding is zero, DefaultPushbackMaxOutstanding is used.
 func WithPushback(maxOutstanding uint) func(*options.StorageOptions) {
 	return func(o *options.StorageOptions) {
 		o.PushbackMaxOutstanding = maxOutstanding
 	}
 }
 
+// WithMaxConcurrentAdds allows configuration of the maximum number of add requests which may be
+// concurrently waiting in the storage's in-memory queue for an index to be assigned.
+//
+// Add requests made while this limit is reached will fail with ErrPushback. This provides admission
+// control which bounds memory use independently of the outstanding entries limit set by WithPushback.
+//
+// If this option isn't provided, or maxConcurrent is zero, no limit is applied.
+func WithMaxConcurrentAdds(maxConcurrent uint) func(*options.StorageOptions) {
+	return func(o *options.StorageOptions) {
+		o.MaxConcurrentAdds = maxConcurrent
+	}
+}
+
+// WithPublishOnlyOnChange instructs the storage to skip republishing a checkpoint when the size of the
+// tree has not changed since it last published one.
+//
+// By default, a fresh checkpoint is published every checkpoint interval even if the log hasn't grown,
+// which keeps the published checkpoint's timestamp (and any witness cosignatures) fresh.
+// Logs which are not witnessed can use this option to avoid needless writes to storage, and witnessed
+// logs can combine it with WithCheckpointRepublishInterval to bound the age of their checkpoints.
+func WithPublishOnlyOnChange() func(*options.StorageOptions) {
+	return func(o *options.StorageOptions) {
+		o.PublishOnlyOnChange = true
+	}
+}
+
+// WithCheckpointRepublishInterval sets the maximum age of the published checkpoint for logs using
+// WithPublishOnlyOnChange: once it is at least maxAge old, a fresh checkpoint is published even though the
+// size of the tree hasn't changed.
+//
+// This allows witnessed logs to avoid needless writes while idle, while still periodically refreshing the
+// checkpoint and its cosignatures so that clients can distinguish an idle log from a dead one.
+// It has no effect without WithPublishOnlyOnChange, since a fresh checkpoint is then published every
+// checkpoint interval anyway.
+func WithCheckpointRepublishInterval(maxAge time.Duration) func(*options.StorageOptions) {
+	return func(o *options.StorageOptions) {
+		o.CheckpointRepublishInterval = maxAge
+	}
+}
+
+// WithIntegrationIdleBackoff configures how far storage implementations which integrate sequenced entries
+// asynchronously (e.g. GCP and AWS) may back off polling for new entries while the log is idle.
+//
+// When several consecutive polls find nothing to integrate, the polling interval is progressively
+// lengthened up to maxInterval, reducing load on the coordination database. Adding a new entry resets
+// the interval to its minimum. Setting maxInterval to zero disables the backoff.
+//
+// If this option isn't provided, storage implementations will use the DefaultIntegrationMaxIdleInterval const above.
+func WithIntegrationIdleBackoff(maxInterval time.Duration) func(*options.StorageOptions) {
+	return func(o *options.StorageOptions) {
+		o.IntegrationMaxIdleInterval = maxInterval
+	}
+}
+
+// WithIntegrationSizeLimit sets the maximum number of sequenced entries which storage implementations
+// that integrate entries asynchronously (e.g. GCP and AWS) will integrate in a single pass.
+//
+// Larger limits reduce the number of times partial tiles and entry bundles are rewritten when the log is
+// busy, but increase the memory used by, and the duration of, each integration transaction, since all of
+// the entries being integrated are held in memory at once. Smaller limits bound that memory and latency.
+//
+// If this option isn't provided, storage implementations will use their own DefaultIntegrationSizeLimit.
+// The storage implementation's New returns an error if n is zero.
+func WithIntegrationSizeLimit(n uint64) func(*options.StorageOptions) {
+	return func(o *options.StorageOptions) {
+		o.IntegrationSizeLimit = &n
+	}
+}
+
+// WithIntegrationCatchUp enables a catch-up mode for storage implementations which integrate entries
+// asynchronously, to bound the time taken to integrate a large backlog of sequenced entries, e.g. following
+// an outage.
+//
+// While catching up, i.e. whenever the previous pass integrated as many entries as it was allowed to, the
+// next pass is started immediately rather than waiting for the integration interval, and integrates up to
+// sizeLimit entries. Once a pass integrates fewer entries than its limit, the usual cadence resumes, so steady
+// traffic which is keeping up doesn't trigger catch-up. Checkpoints continue to be published no more frequently
+// than the configured checkpoint interval.
+//
+// Catch-up is disabled by default.
+func WithIntegrationCatchUp(sizeLimit uint) func(*options.StorageOptions) {
+	return func(o *options.StorageOptions) {
+		o.IntegrationCatchUpSizeLimit = sizeLimit
+	}
+}
+
+// WithIntegrationWorkers sets the number of goroutines which storage implementations may use to hash
+// new entries into the tree during a single integration pass.
+//
+// Hashing is CPU bound, so on multi-core machines using more workers can increase integration throughput
+// for large batches of entries. Batches which fit within a single tile are always hashed by one goroutine.
+//
+// By default, hashing is done by a single goroutine.
+func WithIntegrationWorkers(n uint) func(*options.StorageOptions) {
+	return func(o *options.StorageOptions) {
+		o.IntegrationWorkers = n
+	}
+}
+
+// WithObjectChecksums configures object storage based implementations (e.g. GCP and AWS) to store a
+// CRC32C checksum alongside each object they write, and to verify it when the object is read back.
+//
+// The checksum is also sent with each write, allowing the object store to reject uploads which were
+// corrupted in transit. Reads of objects whose content doesn't match the stored checksum will fail
+// with an error wrapping ErrChecksumMismatch.
+func WithObjectChecksums() func(*options.StorageOptions) {
+	return func(o *options.StorageOptions) {
+		o.ObjectChecksums = true
+	}
+}
+
+// WithObjectWriteRetry configures how object storage based implementations (GCP, AWS, and Azure) retry writes
+// which fail with transient errors, such as server errors or rate limiting, before failing the operation.
+//
+// Each write is attempted at most maxAttempts times. The delay before the first retry is around baseDelay,
+// and doubles with each subsequent retry, with random jitter applied. Writes which fail because a
+// precondition wasn't met are never retried, as these are handled by checking whether the existing
+// object is identical to the one being written.
+//
+// Setting maxAttempts to 1 disables retries.
+//
+// Note that the GCS, S3, and Azure Blob Storage client libraries already retry some failed requests
+// themselves, according to their own policies. Retries configured here are applied on top of those, so
+// the worst case number of requests and latency for a write are multiplied by maxAttempts. This is
+// mostly useful where the client library's retries have been disabled or limited, or don't cover the
+// failures seen in practice.
+//
+// If this option isn't provided, storage implementations will use the DefaultObjectWriteRetryAttempts and
+// DefaultObjectWriteRetryBaseDelay consts above, i.e. a single attempt.
+// The storage implementation's New returns an error if maxAttempts is less than 1, or baseDelay is negative.
+func WithObjectWriteRetry(maxAttempts int, baseDelay time.Duration) func(*options.StorageOptions) {
+	return func(o *options.StorageOptions) {
+		o.ObjectWriteRetryAttempts = maxAttempts
+		o.ObjectWriteRetryBaseDelay = baseDelay
+	}
+}
+
+// WithResourceSignatures configures object storage based implementations (e.g. GCP and AWS) to publish a
+// detached signature alongside each tile and entry bundle they write, at the path given by
+// layout.SignaturePath.
+//
+// Each signature is a signed note, using the signer(s) provided via WithCheckpointSigner, whose body is an
+// api.ResourceStatement binding the log's origin and the resource's path to the hash of its content.
+// This allows clients to check the provenance of a resource, e.g. one served from a CDN cache, without
+// reconstructing the tree up to a checkpoint; see client.VerifyResourceSignature.
+//
+// Signing adds one extra object write per tile and entry bundle written.
+func WithResourceSignatures() func(*options.StorageOptions) {
+	return func(o *options.StorageOptions) {
+		o.SignResources = true
+	}
+}
+
+// WithReadCache configures object storage based implementations (e.g. GCP and AWS) to keep an in-memory
+// LRU cache of the tiles and entry bundles they read, which are immutable, to reduce the load on the object
+// store from read-heavy personalities. The checkpoint is never cached.
+//
+// The cache holds at most maxBytes of data. If ttl is non-zero, cached objects are discarded once they're
+// older than this, otherwise they're kept until evicted to make space for others.
+func WithReadCache(maxBytes int, ttl time.Duration) func(*options.StorageOptions) {
+	return func(o *options.StorageOptions) {
+		o.ReadCacheMaxBytes = maxBytes
+		o.ReadCacheTTL = ttl
+	}
+}
+
+// WithMerkleHasher configures the hasher used to construct the log's Merkle tree.
+//
+// Note that the https://c2sp.org/tlog-tiles spec requires RFC6962 hashing, so this option should only be
+// used by applications which knowingly depart from it (e.g. to use domain-separated hashes). Leaf hashes
+// are calculated when entries are created, so such applications must also use NewEntryWithHasher with the
+// same hasher when adding entries to the log (or NewEntryWithCodecAndHasher, if WithEntryBundleCodec is also
+// used); entries whose leaf hash wasn't calculated with this hasher, e.g. those created with NewEntry, are
+// rejected when they're added.
+//
+// If this option isn't provided, storage implementations will use the RFC6962 hasher.
+func WithMerkleHasher(h merkle.LogHasher) func(*options.StorageOptions) {
+	return func(o *options.StorageOptions) {
+		o.Hasher = h
+	}
+}
+
+// WithEntryBundleCodec configures the codec which entries must be marshalled with to be added to the log.
+//
+// Entries are marshalled when they're created, so applications using this option must create entries with
+// NewEntryWithCodec using the same codec (or NewEntryWithCodecAndHasher, if WithMerkleHasher is also used);
+// entries created with a codec with a different ID are rejected when they're added. Readers of the log must
+// also use this codec to parse its entry bundles.
+//
+// If this option isn't provided, storage implementations expect entries to use api.LengthPrefixedCodec, as
+// described by https://c2sp.org/tlog-tiles.
+func WithEntryBundleCodec(c api.EntryBundleCodec) func(*options.StorageOptions) {
+	return func(o *options.StorageOptions) {
+		o.EntryBundleCodec = c
+	}
+}
+
+// WithRootVerificationOnInit instructs the storage to verify, when it is first created, that the root hash
+// committed to by its published checkpoint matches the root recalculated from the stored tiles.
+//
+// By default only a sample of the tree is checked: the tiles holding the nodes needed to recalculate the root,
+// which is cheap even for large logs. If full is true, the root is additionally recalculated from every leaf
+// hash in the tree, which requires reading all of the level 0 tiles and so may take a long time.
+//
+// If the roots do not match then the log has forked or been corrupted, and the storage will refuse to start
+// rather than appending further entries to it.
+func WithRootVerificationOnInit(full bool) func(*options.StorageOptions) {
+	return func(o *options.StorageOptions) {
+		o.VerifyRootOnInit = true
+		o.VerifyRootFull = full
+	}
+}
+
+// WithSkipSchemaInit instructs the storage not to create its database schema on startup, and instead only
+// check that the expected tables are present.
+//
+// This is intended for environments where the schema is provisioned out-of-band, and the storage's
+// database user is not granted DDL privileges.
+//
+// Currently only the AWS and Azure storage implementations create their own schema; the others always require
+// it to be provisioned externally, and so ignore this option.
+func WithSkipSchemaInit() func(*options.StorageOptions) {
+	return func(o *options.StorageOptions) {
+		o.SkipSchemaInit = true
+	}
+}
+
+// WithCheckpointHistory instructs the storage to retain the checkpoints it publishes, so that they can be
+// served by the CheckpointHistoryReader methods. Without this option, those methods fail with an error wrapping
+// errors.ErrUnsupported.
+//
+// Checkpoints are kept for at least the given retention period after being published, after which they're
+// deleted when later checkpoints are published. A zero retention keeps every checkpoint, which grows the
+// history by one row per published tree size for the lifetime of the log.
+//
+// Currently only the MySQL and PostgreSQL storage implementations support this option, and require the
+// CheckpointHistory table from their schema.sql to have been created.
+func WithCheckpointHistory(retention time.Duration) func(*options.StorageOptions) {
+	return func(o *options.StorageOptions) {
+		o.CheckpointHistory = true
+		o.CheckpointHistoryRetention = retention
+	}
+}
+
+// WithMetricFactory configures the storage to create its metrics with the provided MeterProvider, rather than
+// the global one, e.g. so that they're exported alongside the personality's own metrics.
+//
+// Currently only the GCP, AWS, and Azure storage implementations support this option; the others always use the global
+// MeterProvider.
+func WithMetricFactory(mp metric.MeterProvider) func(*options.StorageOptions) {
+	return func(o *options.StorageOptions) {
+		o.MeterProvider = mp
+	}
+}
+
 // WithCheckpointInterval configures the frequency at which Tessera will attempt to create & publish
 // a new checkpoint.
 //
@@ -142,3 +454,51 @@ func WithCheckpointInterval(interval time.Duration) func(*options.StorageOptions
 		o.CheckpointInterval = interval
 	}
 }
+
+// WithUnsafeCheckpointInterval configures the frequency at which Tessera will attempt to create & publish
+// a new checkpoint, bypassing the minimum interval enforced by storage implementations.
+//
+// This option is intended ONLY for use in tests which need to drive checkpoint publication rapidly;
+// it must not be used in production. To guard against accidental use, the storage implementation's New returns
+// an error unless the environment variable named by UnsafeCheckpointIntervalEnv is set to "true". It also returns
+// an error if interval isn't positive.
+func WithUnsafeCheckpointInterval(interval time.Duration) func(*options.StorageOptions) {
+	return func(o *options.StorageOptions) {
+		o.CheckpointInterval = interval
+		o.UnsafeCheckpointInterval = true
+	}
+}
+
+// WithCheckpointIntervalJitter randomises each interval between checkpoint publishing attempts by up to
+// ±fraction of the configured checkpoint interval.
+//
+// This is useful where many logs, or many instances of the same log, are configured with the same
+// checkpoint interval and would otherwise all attempt to publish at the same moment.
+// Jittered intervals will never be shorter than the minimum checkpoint interval permitted by the storage
+// implementation.
+//
+// fraction must be in the range [0, 1), otherwise the storage implementation's New returns an error.
+// The default is 0 (no jitter).
+func WithCheckpointIntervalJitter(fraction float64) func(*options.StorageOptions) {
+	return func(o *options.StorageOptions) {
+		o.CheckpointIntervalJitter = fraction
+	}
+}
+
+// WithLogMetadata instructs the storage to publish a metadata object describing the log at
+// layout.MetadataPath, so that clients can discover the parameters needed to use the log.
+//
+// The verifierKeys, in note.NewVerifier format, are the public keys for the log's checkpoint signers.
+// All keys must have the same name, which is published as the log's origin.
+//
+// The metadata is written each time the storage is created, so it will be updated to reflect rotated keys
+// on restart. Not all storage implementations support publishing metadata, those which do provide a
+// ReadMetadata method which may be used with NewMetadataHandler to serve it.
+//
+// The storage implementation's New returns an error if no keys are provided, if any of them are invalid or have
+// different names, or if it doesn't support publishing metadata.
+func WithLogMetadata(verifierKeys ...string) func(*options.StorageOptions) {
+	return func(o *options.StorageOptions) {
+		o.MetadataVerifierKeys = append([]string{}, verifierKeys...)
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright 2024 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tessera
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+
+	"github.com/transparency-dev/trillian-tessera/api/layout"
+	"k8s.io/klog/v2"
+)
+
+// NewMetadataHandler returns an http.Handler which serves the log metadata returned by read, typically
+// the ReadMetadata method of a storage implementation configured using WithLogMetadata.
+//
+// Requests are answered with a 404 if read returns an error wrapping os.ErrNotExist.
+func NewMetadataHandler(read func(ctx context.Context) ([]byte, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m, err := read(r.Context())
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			klog.Errorf("/%s: %v", layout.MetadataPath, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-cache")
+		if _, err := w.Write(m); err != nil {
+			klog.Errorf("/%s: %v", layout.MetadataPath, err)
+		}
+	})
+}
@@ -0,0 +1,67 @@
+// Copyright 2024 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tessera
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/transparency-dev/trillian-tessera/api/layout"
+)
+
+func TestMetadataHandler(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		read     func(context.Context) ([]byte, error)
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "ok",
+			read:     func(context.Context) ([]byte, error) { return []byte(`{"origin":"example.com/log"}`), nil },
+			wantCode: http.StatusOK,
+			wantBody: `{"origin":"example.com/log"}`,
+		}, {
+			name:     "not found",
+			read:     func(context.Context) ([]byte, error) { return nil, fmt.Errorf("metadata: %w", os.ErrNotExist) },
+			wantCode: http.StatusNotFound,
+		}, {
+			name:     "error",
+			read:     func(context.Context) ([]byte, error) { return nil, errors.New("boom") },
+			wantCode: http.StatusInternalServerError,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			NewMetadataHandler(test.read).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/"+layout.MetadataPath, nil))
+			if w.Code != test.wantCode {
+				t.Errorf("got status %d, want %d", w.Code, test.wantCode)
+			}
+			if got := w.Body.String(); got != test.wantBody {
+				t.Errorf("got body %q, want %q", got, test.wantBody)
+			}
+			if test.wantCode == http.StatusOK {
+				if got, want := w.Header().Get("Content-Type"), "application/json"; got != want {
+					t.Errorf("got Content-Type %q, want %q", got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright 2024 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tessera
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"k8s.io/klog/v2"
+)
+
+const name = "github.com/transparency-dev/trillian-tessera"
+
+// Tessera and its storage implementations record spans using the global OpenTelemetry TracerProvider.
+// Metrics are recorded using the global MeterProvider, unless storage implementations which support it
+// are given another with WithMetricFactory. The global providers are no-ops unless the binary has
+// registered its own.
+var meter = otel.Meter(name)
+
+var resourceKindKey = attribute.Key("tessera.resource_kind")
+
+// counter describes an Int64Counter to be created by mustCreateCounters.
+type counter struct {
+	p    *metric.Int64Counter
+	name string
+	desc string
+}
+
+// mustCreateCounters creates each of the described counters, exiting if this isn't possible.
+func mustCreateCounters(cs ...counter) {
+	for _, c := range cs {
+		var err error
+		*c.p, err = meter.Int64Counter(c.name, metric.WithDescription(c.desc), metric.WithUnit("{resource}"))
+		if err != nil {
+			klog.Exitf("Failed to create %s metric: %v", c.name, err)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright 2024 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tessera
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+	"go.opentelemetry.io/otel/metric"
+	"k8s.io/klog/v2"
+)
+
+// prefetchTimeout bounds how long a single background prefetch may take.
+const prefetchTimeout = 30 * time.Second
+
+// PrefetchingReader is a LogReader which detects sequential reads of full entry bundles, and warms an
+// in-memory cache with the bundles which follow so that they can be served with lower latency.
+//
+// This benefits clients such as monitors which scan through the whole log in order.
+// Partial entry bundles, tiles, and checkpoints are always passed straight through to the delegate.
+type PrefetchingReader struct {
+	delegate LogReader
+	ahead    uint64
+	cache    *lru.Cache[uint64, []byte]
+
+	mu sync.Mutex
+	// next is the index of the full entry bundle which would follow on sequentially from the last one requested.
+	next uint64
+	// inFlight holds the bundles which are currently being prefetched, keyed by index.
+	// The channels are closed once the corresponding prefetch has completed.
+	inFlight map[uint64]chan struct{}
+}
+
+// NewPrefetchingReader returns a PrefetchingReader which reads from delegate, prefetching up to ahead full entry
+// bundles following a sequential read, and holding at most cacheSize of them in memory.
+func NewPrefetchingReader(delegate LogReader, ahead, cacheSize uint) *PrefetchingReader {
+	c, err := lru.New[uint64, []byte](int(max(cacheSize, ahead, 1)))
+	if err != nil {
+		panic(fmt.Errorf("lru.New(%d): %v", cacheSize, err))
+	}
+	return &PrefetchingReader{
+		delegate: delegate,
+		ahead:    uint64(ahead),
+		cache:    c,
+		inFlight: make(map[uint64]chan struct{}),
+	}
+}
+
+// ReadCheckpoint returns the latest checkpoint from the delegate.
+func (r *PrefetchingReader) ReadCheckpoint(ctx context.Context) ([]byte, error) {
+	return r.delegate.ReadCheckpoint(ctx)
+}
+
+// ReadTile returns the requested tile from the delegate.
+func (r *PrefetchingReader) ReadTile(ctx context.Context, level, index uint64, p uint8) ([]byte, error) {
+	return r.delegate.ReadTile(ctx, level, index, p)
+}
+
+// ReadEntryBundle returns the requested entry bundle, serving it from the prefetch cache if possible.
+//
+// If the request follows on sequentially from the previous one, the next bundles will be prefetched.
+func (r *PrefetchingReader) ReadEntryBundle(ctx context.Context, index uint64, p uint8) ([]byte, error) {
+	if p != 0 {
+		// Partial bundles will be superseded, so aren't worth prefetching or caching.
+		return r.delegate.ReadEntryBundle(ctx, index, p)
+	}
+
+	r.mu.Lock()
+	sequential := index == r.next
+	r.next = index + 1
+	done := r.inFlight[index]
+	r.mu.Unlock()
+
+	if done != nil {
+		// Wait for the prefetch rather than fetching the same bundle again.
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	d, ok := r.cache.Get(index)
+	if ok {
+		prefetchHits.Add(ctx, 1)
+	} else {
+		prefetchMisses.Add(ctx, 1)
+		var err error
+		if d, err = r.delegate.ReadEntryBundle(ctx, index, 0); err != nil {
+			return nil, err
+		}
+	}
+	if sequential || ok {
+		r.prefetch(index+1, index+r.ahead)
+	}
+	return d, nil
+}
+
+// prefetch fetches the full entry bundles in the closed range [from, to] in the background, skipping those which
+// are already cached or being fetched.
+func (r *PrefetchingReader) prefetch(from, to uint64) {
+	for i := from; i <= to; i++ {
+		if r.cache.Contains(i) {
+			continue
+		}
+		r.mu.Lock()
+		if r.inFlight[i] != nil {
+			r.mu.Unlock()
+			continue
+		}
+		done := make(chan struct{})
+		r.inFlight[i] = done
+		r.mu.Unlock()
+
+		go func(i uint64) {
+			defer func() {
+				r.mu.Lock()
+				delete(r.inFlight, i)
+				r.mu.Unlock()
+				close(done)
+			}()
+			// Use a fresh context, since this must outlive the request which triggered it.
+			ctx, cancel := context.WithTimeout(context.Background(), prefetchTimeout)
+			defer cancel()
+			d, err := r.delegate.ReadEntryBundle(ctx, i, 0)
+			if err != nil {
+				// This is expected when we reach the end of the log.
+				klog.V(2).Infof("Failed to prefetch entry bundle %d: %v", i, err)
+				return
+			}
+			if d != nil {
+				r.cache.Add(i, d)
+			}
+		}(i)
+	}
+}
+
+var (
+	prefetchHits   metric.Int64Counter
+	prefetchMisses metric.Int64Counter
+)
+
+func init() {
+	mustCreateCounters(
+		counter{&prefetchHits, "tessera.prefetching_reader.hits", "Number of full entry bundles served from the prefetch cache"},
+		counter{&prefetchMisses, "tessera.prefetching_reader.misses", "Number of full entry bundles which were not in the prefetch cache"},
+	)
+}
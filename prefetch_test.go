@@ -0,0 +1,110 @@
+// Copyright 2024 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tessera_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	tessera "github.com/transparency-dev/trillian-tessera"
+	"github.com/transparency-dev/trillian-tessera/api/layout"
+)
+
+// syncMemLog wraps memLog so that it can be safely read by concurrent prefetches, and counts
+// reads of each full entry bundle.
+type syncMemLog struct {
+	mu          sync.Mutex
+	l           *memLog
+	bundleReads map[uint64]int
+}
+
+func (s *syncMemLog) ReadCheckpoint(ctx context.Context) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.l.ReadCheckpoint(ctx)
+}
+
+func (s *syncMemLog) ReadTile(ctx context.Context, level, index uint64, p uint8) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.l.ReadTile(ctx, level, index, p)
+}
+
+func (s *syncMemLog) ReadEntryBundle(ctx context.Context, index uint64, p uint8) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if p == 0 {
+		s.bundleReads[index]++
+	}
+	return s.l.ReadEntryBundle(ctx, index, p)
+}
+
+func (s *syncMemLog) reads(index uint64) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.bundleReads[index]
+}
+
+func TestPrefetchingReader(t *testing.T) {
+	ctx := context.Background()
+	const numBundles = 10
+	l := &syncMemLog{l: newMemLog(), bundleReads: make(map[uint64]int)}
+	for i := uint64(0); i < numBundles; i++ {
+		l.l.m[layout.EntriesPath(i, 0)] = []byte(fmt.Sprintf("bundle %d", i))
+	}
+
+	r := tessera.NewPrefetchingReader(l, 3, 8)
+
+	// Reading sequentially should result in each bundle being fetched from the delegate exactly once.
+	for i := uint64(0); i < numBundles; i++ {
+		got, err := r.ReadEntryBundle(ctx, i, 0)
+		if err != nil {
+			t.Fatalf("ReadEntryBundle(%d): %v", i, err)
+		}
+		if want := []byte(fmt.Sprintf("bundle %d", i)); !bytes.Equal(got, want) {
+			t.Errorf("ReadEntryBundle(%d): got %q, want %q", i, got, want)
+		}
+		// Wait for the prefetches to be issued so that the read counts are deterministic.
+		for j := i + 1; j <= min(i+3, numBundles-1); j++ {
+			waitFor(t, func() bool { return l.reads(j) > 0 })
+		}
+	}
+	for i := uint64(0); i < numBundles; i++ {
+		if got := l.reads(i); got != 1 {
+			t.Errorf("got %d reads of bundle %d from delegate, want 1", got, i)
+		}
+	}
+
+	// Partial bundles are passed straight through.
+	l.mu.Lock()
+	l.l.m[layout.EntriesPath(numBundles, 5)] = []byte("partial")
+	l.mu.Unlock()
+	if got, err := r.ReadEntryBundle(ctx, numBundles, 5); err != nil || !bytes.Equal(got, []byte("partial")) {
+		t.Errorf("ReadEntryBundle(partial): got (%q, %v), want %q", got, err, "partial")
+	}
+}
+
+func waitFor(t *testing.T, f func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !f(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+	}
+}
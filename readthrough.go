@@ -0,0 +1,156 @@
+// Copyright 2024 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tessera
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel/metric"
+	"k8s.io/klog/v2"
+)
+
+// LogReader describes a type which can read the resources of a tlog-tiles log.
+//
+// Implementations should return an error wrapping os.ErrNotExist when the requested resource
+// is not present.
+type LogReader interface {
+	ReadCheckpoint(ctx context.Context) ([]byte, error)
+	ReadTile(ctx context.Context, level, index uint64, p uint8) ([]byte, error)
+	ReadEntryBundle(ctx context.Context, index uint64, p uint8) ([]byte, error)
+}
+
+// CheckpointHistoryReader is an optional capability of a LogReader which retains the checkpoints it
+// has previously published.
+//
+// Personalities can check for it with a type assertion in order to serve historical checkpoints, e.g.
+// to monitors which need to verify consistency against a pinned tree size.
+type CheckpointHistoryReader interface {
+	// ReadCheckpointAtSize returns the earliest retained checkpoint which commits to a tree of at
+	// least the given size, or an error wrapping os.ErrNotExist if there is no such checkpoint.
+	// If older checkpoints have been pruned, this may not be the first checkpoint published at that size.
+	ReadCheckpointAtSize(ctx context.Context, size uint64) ([]byte, error)
+	// ReadCheckpointAt returns the checkpoint which was published for a tree of exactly the given size,
+	// or an error wrapping os.ErrNotExist if no checkpoint was published at that size.
+	ReadCheckpointAt(ctx context.Context, size uint64) ([]byte, error)
+}
+
+// ResourceExistenceChecker is an optional capability of a LogReader which can cheaply check whether a
+// tile or entry bundle is present without fetching its content.
+//
+// Tools which only need to know whether resources are present, e.g. when checking a log for missing
+// resources, can check for it with a type assertion in order to avoid downloading them.
+type ResourceExistenceChecker interface {
+	// TileExists returns true if the specified tile is present in the log's storage.
+	TileExists(ctx context.Context, level, index uint64, p uint8) (bool, error)
+	// EntryBundleExists returns true if the specified entry bundle is present in the log's storage.
+	EntryBundleExists(ctx context.Context, index uint64, p uint8) (bool, error)
+}
+
+// ReadThroughCacheStore describes the local storage used by a ReadThroughCache.
+//
+// The POSIX storage implementation satisfies this interface.
+type ReadThroughCacheStore interface {
+	LogReader
+	WriteTile(ctx context.Context, level, index uint64, p uint8, data []byte) error
+	WriteEntryBundle(ctx context.Context, index uint64, p uint8, data []byte) error
+}
+
+// ReadThroughCache is a LogReader which serves tiles and entry bundles from local storage where possible,
+// falling back to fetching them from an upstream log and storing them locally when they're not present.
+//
+// This allows a mirror of a log to start serving immediately, and be populated lazily as resources are
+// requested, rather than needing to be fully populated up-front.
+//
+// Tiles and entry bundles are immutable, so they can safely be cached indefinitely. Checkpoints are not,
+// and so are always read from upstream.
+//
+// Note that no verification is performed on the resources fetched from upstream, so it must be trusted.
+type ReadThroughCache struct {
+	local    ReadThroughCacheStore
+	upstream LogReader
+}
+
+// NewReadThroughCache returns a ReadThroughCache which serves resources from local, populating it from
+// upstream on demand.
+func NewReadThroughCache(local ReadThroughCacheStore, upstream LogReader) *ReadThroughCache {
+	return &ReadThroughCache{
+		local:    local,
+		upstream: upstream,
+	}
+}
+
+// ReadCheckpoint returns the latest checkpoint from upstream.
+func (c *ReadThroughCache) ReadCheckpoint(ctx context.Context) ([]byte, error) {
+	return c.upstream.ReadCheckpoint(ctx)
+}
+
+// ReadTile returns the requested tile, fetching it from upstream and storing it locally if necessary.
+func (c *ReadThroughCache) ReadTile(ctx context.Context, level, index uint64, p uint8) ([]byte, error) {
+	return readThrough(ctx, "tile",
+		func(ctx context.Context) ([]byte, error) { return c.local.ReadTile(ctx, level, index, p) },
+		func(ctx context.Context) ([]byte, error) { return c.upstream.ReadTile(ctx, level, index, p) },
+		func(ctx context.Context, d []byte) error { return c.local.WriteTile(ctx, level, index, p, d) })
+}
+
+// ReadEntryBundle returns the requested entry bundle, fetching it from upstream and storing it locally if necessary.
+func (c *ReadThroughCache) ReadEntryBundle(ctx context.Context, index uint64, p uint8) ([]byte, error) {
+	return readThrough(ctx, "entry_bundle",
+		func(ctx context.Context) ([]byte, error) { return c.local.ReadEntryBundle(ctx, index, p) },
+		func(ctx context.Context) ([]byte, error) { return c.upstream.ReadEntryBundle(ctx, index, p) },
+		func(ctx context.Context, d []byte) error { return c.local.WriteEntryBundle(ctx, index, p, d) })
+}
+
+// readThrough returns the resource from local, if present, or otherwise fetches it from upstream and stores it.
+//
+// Failure to store the resource locally is not fatal, since it can still be served.
+func readThrough(ctx context.Context, kind string, local, upstream func(context.Context) ([]byte, error), store func(context.Context, []byte) error) ([]byte, error) {
+	attrs := metric.WithAttributes(resourceKindKey.String(kind))
+	d, err := local(ctx)
+	if err == nil && d != nil {
+		cacheHits.Add(ctx, 1, attrs)
+		return d, nil
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read %s from local storage: %w", kind, err)
+	}
+	cacheMisses.Add(ctx, 1, attrs)
+
+	d, err = upstream(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := store(ctx, d); err != nil {
+		cacheFillErrors.Add(ctx, 1, attrs)
+		klog.Warningf("Failed to store %s locally: %v", kind, err)
+	}
+	return d, nil
+}
+
+var (
+	cacheHits       metric.Int64Counter
+	cacheMisses     metric.Int64Counter
+	cacheFillErrors metric.Int64Counter
+)
+
+func init() {
+	mustCreateCounters(
+		counter{&cacheHits, "tessera.read_through_cache.hits", "Number of resources served from local storage by a ReadThroughCache"},
+		counter{&cacheMisses, "tessera.read_through_cache.misses", "Number of resources fetched from upstream by a ReadThroughCache"},
+		counter{&cacheFillErrors, "tessera.read_through_cache.fill_errors", "Number of resources fetched from upstream which a ReadThroughCache failed to store locally"},
+	)
+}
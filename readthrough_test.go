@@ -0,0 +1,165 @@
+// Copyright 2024 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tessera_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	tessera "github.com/transparency-dev/trillian-tessera"
+	"github.com/transparency-dev/trillian-tessera/api/layout"
+	"github.com/transparency-dev/trillian-tessera/storage/posix"
+	"golang.org/x/mod/sumdb/note"
+)
+
+// memLog is a simple in-memory ReadThroughCacheStore which counts reads.
+type memLog struct {
+	m     map[string][]byte
+	reads int
+}
+
+func newMemLog() *memLog {
+	return &memLog{m: make(map[string][]byte)}
+}
+
+func (l *memLog) read(p string) ([]byte, error) {
+	l.reads++
+	d, ok := l.m[p]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", p, os.ErrNotExist)
+	}
+	return d, nil
+}
+
+func (l *memLog) ReadCheckpoint(_ context.Context) ([]byte, error) {
+	return l.read(layout.CheckpointPath)
+}
+
+func (l *memLog) ReadTile(_ context.Context, level, index uint64, p uint8) ([]byte, error) {
+	return l.read(layout.TilePath(level, index, p))
+}
+
+func (l *memLog) ReadEntryBundle(_ context.Context, index uint64, p uint8) ([]byte, error) {
+	return l.read(layout.EntriesPath(index, p))
+}
+
+func (l *memLog) WriteTile(_ context.Context, level, index uint64, p uint8, data []byte) error {
+	l.m[layout.TilePath(level, index, p)] = data
+	return nil
+}
+
+func (l *memLog) WriteEntryBundle(_ context.Context, index uint64, p uint8, data []byte) error {
+	l.m[layout.EntriesPath(index, p)] = data
+	return nil
+}
+
+func TestReadThroughCache(t *testing.T) {
+	ctx := context.Background()
+	upstream := newMemLog()
+	upstream.m[layout.CheckpointPath] = []byte("checkpoint")
+	upstream.m[layout.TilePath(0, 1, 0)] = []byte("tile")
+	upstream.m[layout.EntriesPath(2, 5)] = []byte("bundle")
+	local := newMemLog()
+
+	c := tessera.NewReadThroughCache(local, upstream)
+
+	for i := 0; i < 2; i++ {
+		if got, err := c.ReadTile(ctx, 0, 1, 0); err != nil || !bytes.Equal(got, []byte("tile")) {
+			t.Errorf("ReadTile: got (%q, %v), want %q", got, err, "tile")
+		}
+		if got, err := c.ReadEntryBundle(ctx, 2, 5); err != nil || !bytes.Equal(got, []byte("bundle")) {
+			t.Errorf("ReadEntryBundle: got (%q, %v), want %q", got, err, "bundle")
+		}
+	}
+	// Only the first read of each resource should have gone upstream.
+	if got, want := upstream.reads, 2; got != want {
+		t.Errorf("got %d upstream reads, want %d", got, want)
+	}
+	if _, ok := local.m[layout.TilePath(0, 1, 0)]; !ok {
+		t.Error("tile was not stored locally")
+	}
+	if _, ok := local.m[layout.EntriesPath(2, 5)]; !ok {
+		t.Error("entry bundle was not stored locally")
+	}
+
+	// Checkpoints must not be cached.
+	if got, err := c.ReadCheckpoint(ctx); err != nil || !bytes.Equal(got, []byte("checkpoint")) {
+		t.Errorf("ReadCheckpoint: got (%q, %v), want %q", got, err, "checkpoint")
+	}
+	if _, ok := local.m[layout.CheckpointPath]; ok {
+		t.Error("checkpoint was stored locally")
+	}
+
+	// Resources missing upstream should be reported as such.
+	if _, err := c.ReadTile(ctx, 1, 0, 0); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("ReadTile of missing tile: got %v, want %v", err, os.ErrNotExist)
+	}
+}
+
+func TestReadThroughCachePOSIX(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signer, err := note.NewSigner("PRIVATE+KEY+example.com/log/testdata+33d7b496+AeymY/SZAX0jZcJ8enZ5FY1Dz+wTML2yWSkK+9DSF3eg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstream, err := posix.New(ctx, t.TempDir(), true, tessera.WithCheckpointSigner(signer))
+	if err != nil {
+		t.Fatalf("posix.New(upstream): %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := upstream.Add(ctx, tessera.NewEntry([]byte(fmt.Sprintf("entry %d", i))))(); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+	local, err := posix.New(ctx, t.TempDir(), true, tessera.WithCheckpointSigner(signer))
+	if err != nil {
+		t.Fatalf("posix.New(local): %v", err)
+	}
+
+	c := tessera.NewReadThroughCache(local, upstream)
+	for _, test := range []struct {
+		name string
+		read func(tessera.LogReader) ([]byte, error)
+	}{
+		{
+			name: "tile",
+			read: func(r tessera.LogReader) ([]byte, error) { return r.ReadTile(ctx, 0, 0, 3) },
+		}, {
+			name: "entry bundle",
+			read: func(r tessera.LogReader) ([]byte, error) { return r.ReadEntryBundle(ctx, 0, 3) },
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			want, err := test.read(upstream)
+			if err != nil {
+				t.Fatalf("read from upstream: %v", err)
+			}
+			if _, err := test.read(local); !errors.Is(err, os.ErrNotExist) {
+				t.Fatalf("read from local before caching: got %v, want %v", err, os.ErrNotExist)
+			}
+			if got, err := test.read(c); err != nil || !bytes.Equal(got, want) {
+				t.Fatalf("read through cache: got (%q, %v), want %q", got, err, want)
+			}
+			if got, err := test.read(local); err != nil || !bytes.Equal(got, want) {
+				t.Errorf("read from local after caching: got (%q, %v), want %q", got, err, want)
+			}
+		})
+	}
+}
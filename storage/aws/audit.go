@@ -0,0 +1,132 @@
+// Copyright 2024 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aws
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	storage "github.com/transparency-dev/trillian-tessera/storage/internal"
+)
+
+// AuditImmutability inspects every stored version of the full tiles and entry bundles in the bucket
+// configured in cfg, and returns the names of any which have been overwritten with different content.
+//
+// Any such object indicates a serious violation of the log's append-only property.
+//
+// Previous versions are only retained if versioning is enabled on the bucket, so without it overwrites
+// cannot be detected. Entry bundles stored outside of the standard tile/ layout are not checked.
+// Only the Bucket, SDKConfig, and S3Options fields of cfg are used.
+//
+// Versions are compared by their CRC32C checksum. ETags can't be used for this since they're not a digest
+// of the content for multipart or SSE-KMS encrypted uploads, so the checksum stored by S3 or by the
+// WithObjectChecksums option is used where available, and the content is fetched and hashed otherwise.
+func AuditImmutability(ctx context.Context, cfg Config) ([]string, error) {
+	if cfg.SDKConfig == nil {
+		sdkConfig, err := config.LoadDefaultConfig(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load default AWS configuration: %v", err)
+		}
+		cfg.SDKConfig = &sdkConfig
+		cfg.S3Options = func(_ *s3.Options) {}
+	}
+	c := s3.NewFromConfig(*cfg.SDKConfig, cfg.S3Options)
+
+	// Only objects with more than one version can have been rewritten, so we avoid fetching checksums for
+	// the rest.
+	versions := make(map[string][]string)
+	in := &s3.ListObjectVersionsInput{
+		Bucket: aws.String(cfg.Bucket),
+		Prefix: aws.String("tile/"),
+	}
+	for {
+		out, err := c.ListObjectVersions(ctx, in)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list object versions in bucket %q: %v", cfg.Bucket, err)
+		}
+		for _, v := range out.Versions {
+			if k := aws.ToString(v.Key); storage.IsImmutable(k) {
+				versions[k] = append(versions[k], aws.ToString(v.VersionId))
+			}
+		}
+		if !aws.ToBool(out.IsTruncated) {
+			break
+		}
+		in.KeyMarker, in.VersionIdMarker = out.NextKeyMarker, out.NextVersionIdMarker
+	}
+
+	var vs []storage.ObjectVersion
+	for k, ids := range versions {
+		if len(ids) < 2 {
+			continue
+		}
+		for _, id := range ids {
+			sum, err := versionChecksum(ctx, c, cfg.Bucket, k, id)
+			if err != nil {
+				return nil, err
+			}
+			vs = append(vs, storage.ObjectVersion{Name: k, Checksum: sum})
+		}
+	}
+	return storage.FindRewrites(vs), nil
+}
+
+// objectVersionReader is the subset of the S3 client used to read specific versions of objects.
+type objectVersionReader interface {
+	HeadObject(ctx context.Context, in *s3.HeadObjectInput, opts ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+	GetObject(ctx context.Context, in *s3.GetObjectInput, opts ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+}
+
+// versionChecksum returns the CRC32C checksum of the content of the given version of an object.
+func versionChecksum(ctx context.Context, c objectVersionReader, bucket, key, versionID string) (string, error) {
+	h, err := c.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket:       aws.String(bucket),
+		Key:          aws.String(key),
+		VersionId:    aws.String(versionID),
+		ChecksumMode: types.ChecksumModeEnabled,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to get attributes of %q version %q: %v", key, versionID, err)
+	}
+	if sum, ok := h.Metadata[crc32cMetadataKey]; ok {
+		return sum, nil
+	}
+	// The checksum of an object uploaded in multiple parts is a checksum of the part checksums, suffixed
+	// with the number of parts, so can't be compared with that of an object uploaded in a single part.
+	if sum := aws.ToString(h.ChecksumCRC32C); sum != "" && !strings.Contains(sum, "-") {
+		return sum, nil
+	}
+
+	r, err := c.GetObject(ctx, &s3.GetObjectInput{
+		Bucket:    aws.String(bucket),
+		Key:       aws.String(key),
+		VersionId: aws.String(versionID),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to get %q version %q: %v", key, versionID, err)
+	}
+	defer func() { _ = r.Body.Close() }()
+	d, err := io.ReadAll(r.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %q version %q: %v", key, versionID, err)
+	}
+	return crc32c(d), nil
+}
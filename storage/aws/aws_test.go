@@ -12,475 +12,88 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-// This the tests for a MySQL+S3 AWS Tessera implementation.  It requires a
-// MySQL database to successfully run the MySQL tests, otherwise they are
-// skipped.  Run tests with `-parallel=1` to avoid concurent tests on the same
-// database, and specifically runs of `mustDropTables`.
-//
-// Sample command to start a local MySQL database using Docker:
-// $ docker run --name test-mysql -p 3306:3306 -e MYSQL_ROOT_PASSWORD=root -e MYSQL_DATABASE=test_tessera -d mysql
 package aws
 
 import (
 	"bytes"
 	"context"
-	"crypto/sha256"
-	"database/sql"
 	"errors"
-	"flag"
-	"fmt"
-	"os"
-	"reflect"
-	"sync"
+	"io"
 	"testing"
-	"time"
 
-	"github.com/aws/aws-sdk-go-v2/service/s3/types"
-	"github.com/aws/smithy-go"
-	"github.com/google/go-cmp/cmp"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
 	tessera "github.com/transparency-dev/trillian-tessera"
-	"github.com/transparency-dev/trillian-tessera/api"
-	"github.com/transparency-dev/trillian-tessera/api/layout"
-	storage "github.com/transparency-dev/trillian-tessera/storage/internal"
-	"k8s.io/klog/v2"
-)
-
-var (
-	mySQLURI            = flag.String("mysql_uri", "root:root@tcp(localhost:3306)/test_tessera", "Connection string for a MySQL database")
-	isMySQLTestOptional = flag.Bool("is_mysql_test_optional", true, "Boolean value to control whether the MySQL test is optional")
 )
 
-// TestMain inits flags and runs tests.
-func TestMain(m *testing.M) {
-	klog.InitFlags(nil)
-	// m.Run() will parse flags
-	os.Exit(m.Run())
-}
-
-// canSkipMySQLTest checks if the test MySQL db is available and, if not, if the test can be skipped.
-//
-// Use this method before every MySQL test, and if it returns true, skip the test.
-//
-// If is_mysql_test_optional is set to true and MySQL database cannot be opened or pinged,
-// the test will fail immediately. Otherwise, the test will be skipped if the test is optional
-// and the database is not available.
-func canSkipMySQLTest(t *testing.T, ctx context.Context) bool {
-	t.Helper()
+func TestCheckCRC32C(t *testing.T) {
+	data := []byte("hello")
+	s := &s3Storage{checksums: true}
+	put := &s3.PutObjectInput{}
+	s.withChecksum(put, data)
 
-	db, err := sql.Open("mysql", *mySQLURI)
-	if err != nil {
-		if *isMySQLTestOptional {
-			return true
-		}
-		t.Fatalf("failed to open MySQL test db: %v", err)
-	}
-	defer func() {
-		if err := db.Close(); err != nil {
-			t.Fatalf("failed to close MySQL database: %v", err)
-		}
-	}()
-	if err := db.PingContext(ctx); err != nil {
-		if *isMySQLTestOptional {
-			return true
-		}
-		t.Fatalf("failed to ping MySQL test db: %v", err)
+	if err := checkCRC32C("obj", data, put.Metadata); err != nil {
+		t.Errorf("checkCRC32C with matching checksum: %v", err)
 	}
-	return false
-}
-
-// mustDropTables drops the `Seq`, `SeqCoord` and `IntCoord` tables.
-// Call this function before every MySQL test.
-func mustDropTables(t *testing.T, ctx context.Context) {
-	t.Helper()
-
-	db, err := sql.Open("mysql", *mySQLURI)
-	if err != nil {
-		t.Fatalf("failed to connect to db: %v", *mySQLURI)
+	if err := checkCRC32C("obj", []byte("hellp"), put.Metadata); !errors.Is(err, tessera.ErrChecksumMismatch) {
+		t.Errorf("checkCRC32C with corrupt data: got %v, want %v", err, tessera.ErrChecksumMismatch)
 	}
-	defer func() {
-		if err := db.Close(); err != nil {
-			t.Fatalf("failed to close db: %v", err)
-		}
-	}()
-
-	if _, err := db.ExecContext(ctx, "DROP TABLE IF EXISTS `Seq`, `SeqCoord`, `IntCoord`"); err != nil {
-		t.Fatalf("failed to drop all tables: %v", err)
+	if err := checkCRC32C("obj", []byte("hellp"), nil); err != nil {
+		t.Errorf("checkCRC32C without stored checksum: %v", err)
 	}
 }
 
-func TestMySQLSequencerAssignEntries(t *testing.T) {
-	ctx := context.Background()
-	if canSkipMySQLTest(t, ctx) {
-		klog.Warningf("MySQL not available, skipping %s", t.Name())
-		t.Skip("MySQL not available, skipping test")
-	}
-	// Clean tables in case there's already something in there.
-	mustDropTables(t, ctx)
-
-	seq, err := newMySQLSequencer(ctx, *mySQLURI, 1000, 0, 0)
-	if err != nil {
-		t.Fatalf("newMySQLSequencer: %v", err)
-	}
-
-	want := uint64(0)
-	for chunks := 0; chunks < 10; chunks++ {
-		entries := []*tessera.Entry{}
-		for i := 0; i < 10+chunks; i++ {
-			entries = append(entries, tessera.NewEntry([]byte(fmt.Sprintf("item %d/%d", chunks, i))))
-		}
-		if err := seq.assignEntries(ctx, entries); err != nil {
-			t.Fatalf("assignEntries: %v", err)
-		}
-		for i, e := range entries {
-			if got := *e.Index(); got != want {
-				t.Errorf("Chunk %d entry %d got seq %d, want %d", chunks, i, got, want)
-			}
-			want++
-		}
-	}
-}
-
-func TestMySQLSequencerPushback(t *testing.T) {
-	ctx := context.Background()
-	if canSkipMySQLTest(t, ctx) {
-		klog.Warningf("MySQL not available, skipping %s", t.Name())
-		t.Skip("MySQL not available, skipping test")
-	}
-	// Clean tables in case there's already something in there.
-	mustDropTables(t, ctx)
-
-	for _, test := range []struct {
-		name           string
-		threshold      uint64
-		initialEntries int
-		wantPushback   bool
-	}{
-		{
-			name:           "no pushback: num < threshold",
-			threshold:      10,
-			initialEntries: 5,
-		},
-		{
-			name:           "no pushback: num = threshold",
-			threshold:      10,
-			initialEntries: 10,
-		},
-		{
-			name:           "pushback: initial > threshold",
-			threshold:      10,
-			initialEntries: 15,
-			wantPushback:   true,
-		},
-	} {
-		t.Run(test.name, func(t *testing.T) {
-			mustDropTables(t, ctx)
-
-			seq, err := newMySQLSequencer(ctx, *mySQLURI, test.threshold, 0, 0)
-			if err != nil {
-				t.Fatalf("newMySQLSequencer: %v", err)
-			}
-			// Set up the test scenario with the configured number of initial outstanding entries
-			entries := []*tessera.Entry{}
-			for i := 0; i < test.initialEntries; i++ {
-				entries = append(entries, tessera.NewEntry([]byte(fmt.Sprintf("initial item %d", i))))
-			}
-			if err := seq.assignEntries(ctx, entries); err != nil {
-				t.Fatalf("initial assignEntries: %v", err)
-			}
-
-			// Now perform the test with a single additional entry to check for pushback
-			entries = []*tessera.Entry{tessera.NewEntry([]byte("additional"))}
-			err = seq.assignEntries(ctx, entries)
-			if gotPushback := errors.Is(err, tessera.ErrPushback); gotPushback != test.wantPushback {
-				t.Fatalf("assignEntries: got pushback %t (%v), want pushback: %t", gotPushback, err, test.wantPushback)
-			} else if !gotPushback && err != nil {
-				t.Fatalf("assignEntries: %v", err)
-			}
-		})
-	}
+// fakeVersionReader serves a single object version with the given attributes.
+type fakeVersionReader struct {
+	head *s3.HeadObjectOutput
+	data []byte
+	gets int
 }
 
-func TestMySQLSequencerRoundTrip(t *testing.T) {
-	ctx := context.Background()
-	if canSkipMySQLTest(t, ctx) {
-		klog.Warningf("MySQL not available, skipping %s", t.Name())
-		t.Skip("MySQL not available, skipping test")
-	}
-	// Clean tables in case there's already something in there.
-	mustDropTables(t, ctx)
-
-	s, err := newMySQLSequencer(ctx, *mySQLURI, 1000, 0, 0)
-	if err != nil {
-		t.Fatalf("newMySQLSequencer: %v", err)
-	}
-
-	seq := 0
-	wantEntries := []storage.SequencedEntry{}
-	for chunks := 0; chunks < 10; chunks++ {
-		entries := []*tessera.Entry{}
-		for i := 0; i < 10+chunks; i++ {
-			e := tessera.NewEntry([]byte(fmt.Sprintf("item %d", seq)))
-			entries = append(entries, e)
-			wantEntries = append(wantEntries, storage.SequencedEntry{
-				BundleData: e.MarshalBundleData(uint64(seq)),
-				LeafHash:   e.LeafHash(),
-			})
-			seq++
-		}
-		if err := s.assignEntries(ctx, entries); err != nil {
-			t.Fatalf("assignEntries: %v", err)
-		}
-	}
-
-	seenIdx := uint64(0)
-	f := func(_ context.Context, fromSeq uint64, entries []storage.SequencedEntry) ([]byte, error) {
-		if fromSeq != seenIdx {
-			return nil, fmt.Errorf("f called with fromSeq %d, want %d", fromSeq, seenIdx)
-		}
-		for i, e := range entries {
-
-			if got, want := e, wantEntries[i]; !reflect.DeepEqual(got, want) {
-				return nil, fmt.Errorf("entry %d+%d != %d", fromSeq, i, seenIdx)
-			}
-			seenIdx++
-		}
-		return []byte("newroot"), nil
-	}
-
-	more, err := s.consumeEntries(ctx, 7, f, false)
-	if err != nil {
-		t.Errorf("consumeEntries: %v", err)
-	}
-	if !more {
-		t.Errorf("more: false, expected true")
-	}
+func (f *fakeVersionReader) HeadObject(_ context.Context, _ *s3.HeadObjectInput, _ ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	return f.head, nil
 }
 
-func makeTile(t *testing.T, size uint64) *api.HashTile {
-	t.Helper()
-	r := &api.HashTile{Nodes: make([][]byte, size)}
-	for i := uint64(0); i < size; i++ {
-		h := sha256.Sum256([]byte(fmt.Sprintf("%d", i)))
-		r.Nodes[i] = h[:]
-	}
-	return r
+func (f *fakeVersionReader) GetObject(_ context.Context, _ *s3.GetObjectInput, _ ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	f.gets++
+	return &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(f.data))}, nil
 }
 
-func TestTileRoundtrip(t *testing.T) {
-	ctx := context.Background()
-	m := newMemObjStore()
-	s := &Storage{
-		objStore: m,
-	}
-
+func TestVersionChecksum(t *testing.T) {
+	data := []byte("hello")
 	for _, test := range []struct {
 		name     string
-		level    uint64
-		index    uint64
-		logSize  uint64
-		tileSize uint64
-	}{
-		{
-			name:     "ok",
-			level:    0,
-			index:    3 * layout.TileWidth,
-			logSize:  3*layout.TileWidth + 20,
-			tileSize: 20,
-		},
-	} {
-		t.Run(test.name, func(t *testing.T) {
-			wantTile := makeTile(t, test.tileSize)
-			if err := s.setTile(ctx, test.level, test.index, test.logSize, wantTile); err != nil {
-				t.Fatalf("setTile: %v", err)
-			}
-
-			expPath := layout.TilePath(test.level, test.index, layout.PartialTileSize(test.level, test.index, test.logSize))
-			_, ok := m.mem[expPath]
-			if !ok {
-				t.Fatalf("want tile at %v but found none", expPath)
-			}
-
-			got, err := s.getTiles(ctx, []storage.TileID{{Level: test.level, Index: test.index}}, test.logSize)
-			if err != nil {
-				t.Fatalf("getTile: %v", err)
-			}
-			if !cmp.Equal(got[0], wantTile) {
-				t.Fatal("roundtrip returned different data")
-			}
-		})
-	}
-}
-
-func makeBundle(t *testing.T, size uint64) []byte {
-	t.Helper()
-	r := &bytes.Buffer{}
-	for i := uint64(0); i < size; i++ {
-		e := tessera.NewEntry([]byte(fmt.Sprintf("%d", i)))
-		if _, err := r.Write(e.MarshalBundleData(i)); err != nil {
-			t.Fatalf("MarshalBundleEntry: %v", err)
-		}
-	}
-	return r.Bytes()
-}
-
-func TestBundleRoundtrip(t *testing.T) {
-	ctx := context.Background()
-	m := newMemObjStore()
-	s := &Storage{
-		objStore:    m,
-		entriesPath: layout.EntriesPath,
-	}
-
-	for _, test := range []struct {
-		name       string
-		index      uint64
-		p          uint8
-		bundleSize uint64
-	}{
-		{
-			name:       "ok",
-			index:      3 * layout.EntryBundleWidth,
-			p:          20,
-			bundleSize: 20,
-		},
-	} {
-		t.Run(test.name, func(t *testing.T) {
-			wantBundle := makeBundle(t, test.bundleSize)
-			if err := s.setEntryBundle(ctx, test.index, test.p, wantBundle); err != nil {
-				t.Fatalf("setEntryBundle: %v", err)
-			}
-
-			expPath := layout.EntriesPath(test.index, test.p)
-			_, ok := m.mem[expPath]
-			if !ok {
-				t.Fatalf("want bundle at %v but found none", expPath)
-			}
-
-			got, err := s.getEntryBundle(ctx, test.index, test.p)
-			if err != nil {
-				t.Fatalf("getEntryBundle: %v", err)
-			}
-			if !cmp.Equal(got, wantBundle) {
-				t.Fatal("roundtrip returned different data")
-			}
-		})
-	}
-}
-
-func TestPublishCheckpoint(t *testing.T) {
-	ctx := context.Background()
-	if canSkipMySQLTest(t, ctx) {
-		klog.Warningf("MySQL not available, skipping %s", t.Name())
-		t.Skip("MySQL not available, skipping test")
-	}
-	// Clean tables in case there's already something in there.
-	mustDropTables(t, ctx)
-
-	s, err := newMySQLSequencer(ctx, *mySQLURI, 1000, 0, 0)
-	if err != nil {
-		t.Fatalf("newMySQLSequencer: %v", err)
-	}
-
-	for _, test := range []struct {
-		name            string
-		cpModifiedAt    time.Time
-		publishInterval time.Duration
-		wantUpdate      bool
+		head     *s3.HeadObjectOutput
+		wantGets int
 	}{
 		{
-			name:            "works ok",
-			cpModifiedAt:    time.Now().Add(-15 * time.Second),
-			publishInterval: 10 * time.Second,
-			wantUpdate:      true,
+			name: "metadata",
+			head: &s3.HeadObjectOutput{Metadata: map[string]string{crc32cMetadataKey: crc32c(data)}},
+		}, {
+			name: "S3 checksum",
+			head: &s3.HeadObjectOutput{ChecksumCRC32C: aws.String(crc32c(data))},
+		}, {
+			name:     "multipart checksum",
+			head:     &s3.HeadObjectOutput{ChecksumCRC32C: aws.String("AAAAAA==-2")},
+			wantGets: 1,
 		}, {
-			name:            "too soon, skip update",
-			cpModifiedAt:    time.Now().Add(-5 * time.Second),
-			publishInterval: 10 * time.Second,
-			wantUpdate:      false,
+			name:     "no checksum",
+			head:     &s3.HeadObjectOutput{},
+			wantGets: 1,
 		},
 	} {
 		t.Run(test.name, func(t *testing.T) {
-			m := newMemObjStore()
-			storage := &Storage{
-				objStore:    m,
-				sequencer:   s,
-				entriesPath: layout.EntriesPath,
-				newCP:       func(size uint64, hash []byte) ([]byte, error) { return []byte(fmt.Sprintf("%d/%x,", size, hash)), nil },
-			}
-			// Call init so we've got a zero-sized checkpoint to work with.
-			if err := storage.init(ctx); err != nil {
-				t.Fatalf("storage.init: %v", err)
-			}
-			cpOld := []byte("bananas")
-			if err := m.setObject(ctx, layout.CheckpointPath, cpOld, ""); err != nil {
-				t.Fatalf("setObject(bananas): %v", err)
-			}
-			m.lMod = test.cpModifiedAt
-			if err := storage.publishCheckpoint(ctx, test.publishInterval); err != nil {
-				t.Fatalf("publishCheckpoint: %v", err)
-			}
-			cpNew, err := m.getObject(ctx, layout.CheckpointPath)
-			cpUpdated := !bytes.Equal(cpOld, cpNew)
+			f := &fakeVersionReader{head: test.head, data: data}
+			got, err := versionChecksum(context.Background(), f, "bucket", "tile/0/000", "v1")
 			if err != nil {
-				// Do not use errors.Is. Keep errors.As to compare by type and not by value.
-				var nske *types.NoSuchKey
-				if !errors.As(err, &nske) {
-					t.Fatalf("getObject: %v", err)
-				}
-				cpUpdated = false
+				t.Fatalf("versionChecksum: %v", err)
 			}
-			if test.wantUpdate != cpUpdated {
-				t.Fatalf("got cpUpdated=%t, want %t", cpUpdated, test.wantUpdate)
+			if want := crc32c(data); got != want {
+				t.Errorf("versionChecksum = %q, want %q", got, want)
+			}
+			if f.gets != test.wantGets {
+				t.Errorf("got %d GetObject calls, want %d", f.gets, test.wantGets)
 			}
 		})
 	}
-
-}
-
-type memObjStore struct {
-	sync.RWMutex
-	mem  map[string][]byte
-	lMod time.Time
-}
-
-func newMemObjStore() *memObjStore {
-	return &memObjStore{
-		mem: make(map[string][]byte),
-	}
-}
-
-func (m *memObjStore) getObject(_ context.Context, obj string) ([]byte, error) {
-	m.RLock()
-	defer m.RUnlock()
-
-	d, ok := m.mem[obj]
-	if !ok {
-		return nil, fmt.Errorf("obj %q not found: %w", obj, &types.NoSuchKey{})
-	}
-	return d, nil
-}
-
-// TODO(phboneff): add content type tests
-func (m *memObjStore) setObject(_ context.Context, obj string, data []byte, _ string) error {
-	m.Lock()
-	defer m.Unlock()
-	m.mem[obj] = data
-	return nil
-}
-
-// TODO(phboneff): add content type tests
-func (m *memObjStore) setObjectIfNoneMatch(_ context.Context, obj string, data []byte, _ string) error {
-	m.Lock()
-	defer m.Unlock()
-
-	d, ok := m.mem[obj]
-	if ok && !bytes.Equal(d, data) {
-		return &smithy.GenericAPIError{Code: "PreconditionFailed"}
-	}
-	m.mem[obj] = data
-	return nil
-}
-
-func (m *memObjStore) lastModified(_ context.Context, obj string) (time.Time, error) {
-	return m.lMod, nil
 }
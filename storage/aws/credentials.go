@@ -0,0 +1,95 @@
+// Copyright 2024 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aws
+
+import (
+	"errors"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// S3Credentials describes how to obtain credentials for the S3 service, for the common cases where
+// static keys alone are not sufficient, e.g. MinIO deployments using its STS API.
+//
+// Credentials are obtained in one of the following ways:
+//   - If RoleARN and WebIdentityTokenFile are set, the role is assumed using the web identity token
+//     read from the file, e.g. a Kubernetes service account token.
+//   - If only RoleARN is set, the role is assumed using the static keys if provided, or the credentials
+//     from the SDK config otherwise.
+//   - Otherwise, the static keys are used directly.
+type S3Credentials struct {
+	// AccessKeyID, SecretAccessKey, and SessionToken are static credentials.
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	// RoleARN is the ARN of the role to assume via STS.
+	RoleARN string
+	// WebIdentityTokenFile is the path of a file containing an OIDC token with which to assume RoleARN.
+	WebIdentityTokenFile string
+	// STSEndpoint optionally overrides the endpoint of the STS service, e.g. to use the one provided by MinIO.
+	STSEndpoint string
+}
+
+// provider returns a credentials provider as described by c, which uses sdkConfig to talk to STS if necessary.
+func (c S3Credentials) provider(sdkConfig aws.Config) (aws.CredentialsProvider, error) {
+	var static aws.CredentialsProvider
+	if c.AccessKeyID != "" || c.SecretAccessKey != "" {
+		if c.AccessKeyID == "" || c.SecretAccessKey == "" {
+			return nil, errors.New("both AccessKeyID and SecretAccessKey must be set")
+		}
+		static = credentials.NewStaticCredentialsProvider(c.AccessKeyID, c.SecretAccessKey, c.SessionToken)
+	}
+	if c.RoleARN == "" {
+		if c.WebIdentityTokenFile != "" {
+			return nil, errors.New("RoleARN must be set when using WebIdentityTokenFile")
+		}
+		if static == nil {
+			return nil, errors.New("one of static keys or RoleARN must be set")
+		}
+		return static, nil
+	}
+
+	if static != nil {
+		sdkConfig.Credentials = static
+	}
+	stsClient := sts.NewFromConfig(sdkConfig, func(o *sts.Options) {
+		if c.STSEndpoint != "" {
+			o.BaseEndpoint = aws.String(c.STSEndpoint)
+		}
+	})
+	var p aws.CredentialsProvider
+	if c.WebIdentityTokenFile != "" {
+		p = stscreds.NewWebIdentityRoleProvider(stsClient, c.RoleARN, stscreds.IdentityTokenFile(c.WebIdentityTokenFile))
+	} else {
+		p = stscreds.NewAssumeRoleProvider(stsClient, c.RoleARN)
+	}
+	// Cache the assumed role credentials so that STS is only called when they're about to expire.
+	return aws.NewCredentialsCache(p), nil
+}
+
+// withS3Credentials returns an S3 options func which applies opts, if non-nil, and then configures the
+// client to use the provided credentials.
+func withS3Credentials(opts func(*s3.Options), p aws.CredentialsProvider) func(*s3.Options) {
+	return func(o *s3.Options) {
+		if opts != nil {
+			opts(o)
+		}
+		o.Credentials = p
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2024 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aws
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// fakeSTS serves the AssumeRole and AssumeRoleWithWebIdentity STS actions, returning credentials whose
+// access key ID is the name of the action.
+func fakeSTS(t *testing.T) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("ParseForm: %v", err)
+		}
+		action := r.Form.Get("Action")
+		w.Header().Set("Content-Type", "text/xml")
+		fmt.Fprintf(w, `<%[1]sResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <%[1]sResult>
+    <Credentials>
+      <AccessKeyId>%[1]s</AccessKeyId>
+      <SecretAccessKey>secret</SecretAccessKey>
+      <SessionToken>token</SessionToken>
+      <Expiration>2099-01-01T00:00:00Z</Expiration>
+    </Credentials>
+  </%[1]sResult>
+  <ResponseMetadata><RequestId>1</RequestId></ResponseMetadata>
+</%[1]sResponse>`, action)
+	}))
+}
+
+func TestS3CredentialsProvider(t *testing.T) {
+	ctx := context.Background()
+	sts := fakeSTS(t)
+	defer sts.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("oidc-token"), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	sdkConfig := aws.Config{
+		Region:      "us-east-1",
+		Credentials: aws.AnonymousCredentials{},
+	}
+
+	for _, test := range []struct {
+		name        string
+		creds       S3Credentials
+		wantErr     bool
+		wantKeyID   string
+		wantSession string
+	}{
+		{
+			name:      "static",
+			creds:     S3Credentials{AccessKeyID: "id", SecretAccessKey: "secret"},
+			wantKeyID: "id",
+		}, {
+			name:        "static with session token",
+			creds:       S3Credentials{AccessKeyID: "id", SecretAccessKey: "secret", SessionToken: "session"},
+			wantKeyID:   "id",
+			wantSession: "session",
+		}, {
+			name:      "assume role",
+			creds:     S3Credentials{AccessKeyID: "id", SecretAccessKey: "secret", RoleARN: "arn:role", STSEndpoint: sts.URL},
+			wantKeyID: "AssumeRole",
+		}, {
+			name:      "web identity",
+			creds:     S3Credentials{RoleARN: "arn:role", WebIdentityTokenFile: tokenFile, STSEndpoint: sts.URL},
+			wantKeyID: "AssumeRoleWithWebIdentity",
+		}, {
+			name:    "nothing",
+			creds:   S3Credentials{},
+			wantErr: true,
+		}, {
+			name:    "missing secret",
+			creds:   S3Credentials{AccessKeyID: "id"},
+			wantErr: true,
+		}, {
+			name:    "web identity without role",
+			creds:   S3Credentials{WebIdentityTokenFile: tokenFile},
+			wantErr: true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			p, err := test.creds.provider(sdkConfig)
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Fatalf("provider: %v, wantErr %t", err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			got, err := p.Retrieve(ctx)
+			if err != nil {
+				t.Fatalf("Retrieve: %v", err)
+			}
+			if got.AccessKeyID != test.wantKeyID {
+				t.Errorf("got AccessKeyID %q, want %q", got.AccessKeyID, test.wantKeyID)
+			}
+			if test.wantSession != "" && got.SessionToken != test.wantSession {
+				t.Errorf("got SessionToken %q, want %q", got.SessionToken, test.wantSession)
+			}
+		})
+	}
+}
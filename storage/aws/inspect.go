@@ -0,0 +1,32 @@
+// Copyright 2024 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aws
+
+import (
+	"context"
+
+	"github.com/transparency-dev/trillian-tessera/storage/internal/objectstore"
+)
+
+// CoordinationState is a snapshot of the contents of the MySQL coordination tables.
+type CoordinationState = objectstore.CoordinationState
+
+// InspectCoordination returns the current state of the coordination tables in the MySQL database at dsn.
+//
+// The tables are read in a single read-only transaction without locking reads, so a live log is not
+// affected. This is intended to help diagnose integration backlogs and stuck sequencers.
+func InspectCoordination(ctx context.Context, dsn string) (CoordinationState, error) {
+	return objectstore.InspectCoordination(ctx, dsn)
+}
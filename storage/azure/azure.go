@@ -0,0 +1,286 @@
+// Copyright 2024 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package azure contains an Azure-based storage implementation for Tessera.
+//
+// This storage implementation uses Azure Blob Storage for long-term storage and serving of
+// entry bundles and log tiles, and MySQL (e.g. Azure Database for MySQL) for coordinating
+// updates to Blob Storage when multiple instances of a personality binary are running.
+//
+// A single Blob Storage container is used to hold entry bundles and log internal tiles.
+// The blob names within the container are selected so as to conform to the
+// expected layout of a tile-based log.
+//
+// A MySQL database provides a transactional mechanism to allow multiple
+// frontends to safely update the contents of the log.
+package azure
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/streaming"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	tessera "github.com/transparency-dev/trillian-tessera"
+	"github.com/transparency-dev/trillian-tessera/internal/options"
+	storage "github.com/transparency-dev/trillian-tessera/storage/internal"
+	"github.com/transparency-dev/trillian-tessera/storage/internal/objectstore"
+)
+
+const (
+	DefaultPushbackMaxOutstanding = objectstore.DefaultPushbackMaxOutstanding
+	DefaultIntegrationSizeLimit   = objectstore.DefaultIntegrationSizeLimit
+)
+
+// Storage is an Azure based storage implementation for Tessera.
+type Storage struct {
+	*objectstore.Storage
+}
+
+// Config holds Azure resource configuration for a storage instance.
+type Config struct {
+	// ServiceURL is the URL of the Blob Storage service endpoint of the storage account to use,
+	// e.g. https://<account>.blob.core.windows.net/.
+	ServiceURL string
+	// Credential is an optional credential to use when accessing Blob Storage.
+	//
+	// If nil, the credential returned by azidentity.NewDefaultAzureCredential will be used.
+	Credential azcore.TokenCredential
+	// ClientOptions is an optional set of options used to configure the Blob Storage client.
+	ClientOptions *azblob.ClientOptions
+	// Container is the name of the Blob Storage container to use for storing log state.
+	Container string
+	// ReadContainer, if set, is the name of the Blob Storage container from which ReadCheckpoint, ReadTile
+	// and ReadEntryBundle serve the log, e.g. a replica of Container which is isolated from the write path.
+	//
+	// Integration always reads from and writes to Container, so this does not affect the log itself. However,
+	// reads from ReadContainer will lag behind writes to Container by the replication delay, and since
+	// checkpoints, tiles, and entry bundles may be replicated independently, a checkpoint read from it may
+	// refer to tiles which have not yet been replicated.
+	ReadContainer string
+	// DSN is the DSN of the MySQL instance to use.
+	DSN string
+	// Maximum connections to the MySQL database
+	MaxOpenConns int
+	// Maximum idle database connections in the connection pool
+	MaxIdleConns int
+	// ElectCheckpointPublisher causes the frontends sharing the log to elect, via a lease in the PubCoord
+	// table in MySQL, a single instance to publish checkpoints, rather than each of them attempting to
+	// update the checkpoint object every interval.
+	//
+	// If the lease can't be read or written, the instance falls back to publishing checkpoints itself.
+	ElectCheckpointPublisher bool
+}
+
+// New creates a new instance of the Azure based Storage.
+//
+// Storage instances created via this c'tor will participate in integrating newly sequenced entries into the log
+// and periodically publishing a new checkpoint which commits to the state of the tree.
+func New(ctx context.Context, cfg Config, opts ...func(*options.StorageOptions)) (*Storage, error) {
+	opt, err := storage.ResolveStorageOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	cred := cfg.Credential
+	if cred == nil {
+		c, err := azidentity.NewDefaultAzureCredential(nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create default Azure credential: %v", err)
+		}
+		cred = c
+	}
+	c, err := azblob.NewClient(cfg.ServiceURL, cred, cfg.ClientOptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Blob Storage client: %v", err)
+	}
+
+	oCfg := objectstore.Config{
+		ObjStore: &blobStorage{
+			client:    c,
+			container: cfg.Container,
+			checksums: opt.ObjectChecksums,
+			retry:     storage.WriteRetry{MaxAttempts: opt.ObjectWriteRetryAttempts, BaseDelay: opt.ObjectWriteRetryBaseDelay},
+		},
+		DSN:                      cfg.DSN,
+		MaxOpenConns:             cfg.MaxOpenConns,
+		MaxIdleConns:             cfg.MaxIdleConns,
+		ElectCheckpointPublisher: cfg.ElectCheckpointPublisher,
+	}
+	if cfg.ReadContainer != "" {
+		oCfg.ReadStore = &blobStorage{
+			client:    c,
+			container: cfg.ReadContainer,
+			checksums: opt.ObjectChecksums,
+		}
+	}
+	s, err := objectstore.New(ctx, oCfg, opt)
+	if err != nil {
+		return nil, err
+	}
+	return &Storage{Storage: s}, nil
+}
+
+// blobStorage knows how to store and retrieve objects from Azure Blob Storage.
+type blobStorage struct {
+	container string
+	client    *azblob.Client
+	// checksums, if set, causes MD5 checksums to be sent with writes and verified on reads.
+	checksums bool
+	// retry is the policy for retrying writes which fail with transient errors.
+	retry storage.WriteRetry
+}
+
+// blockBlobClient returns a client for the named blob in the container.
+func (s *blobStorage) blockBlobClient(obj string) *blockblob.Client {
+	return s.client.ServiceClient().NewContainerClient(s.container).NewBlockBlobClient(obj)
+}
+
+// GetObject returns the data of the specified object, or an error.
+//
+// Returns an error wrapping os.ErrNotExist if the object does not exist.
+func (s *blobStorage) GetObject(ctx context.Context, obj string) ([]byte, error) {
+	r, err := s.client.DownloadStream(ctx, s.container, obj, nil)
+	if err != nil {
+		if bloberror.HasCode(err, bloberror.BlobNotFound) {
+			return nil, fmt.Errorf("getObject: object %q not found in container %q: %w", obj, s.container, os.ErrNotExist)
+		}
+		return nil, fmt.Errorf("getObject: failed to create reader for object %q in container %q: %w", obj, s.container, err)
+	}
+
+	d, err := io.ReadAll(r.Body)
+	if err != nil {
+		_ = r.Body.Close()
+		return nil, fmt.Errorf("getObject: failed to read %q: %v", obj, err)
+	}
+	if s.checksums {
+		if err := checkMD5(obj, d, r.ContentMD5); err != nil {
+			_ = r.Body.Close()
+			return nil, err
+		}
+	}
+	return d, r.Body.Close()
+}
+
+// checkMD5 returns an error wrapping tessera.ErrChecksumMismatch if data does not match the MD5 checksum
+// stored in the blob properties.
+//
+// Blobs without a stored checksum are not checked.
+func checkMD5(obj string, data []byte, want []byte) error {
+	if len(want) == 0 {
+		return nil
+	}
+	if got := md5.Sum(data); !bytes.Equal(got[:], want) {
+		return fmt.Errorf("%q has MD5 %x, want %x: %w", obj, got, want, tessera.ErrChecksumMismatch)
+	}
+	return nil
+}
+
+// uploadOptions returns the options for uploading data to a blob with the given content type.
+func (s *blobStorage) uploadOptions(data []byte, contType string) *blockblob.UploadOptions {
+	o := &blockblob.UploadOptions{
+		HTTPHeaders: &blob.HTTPHeaders{BlobContentType: to.Ptr(contType)},
+	}
+	if s.checksums {
+		// Blob Storage rejects the upload if the content it receives doesn't match this checksum, and
+		// stores it in the blob properties, allowing us to verify it when reading.
+		c := md5.Sum(data)
+		o.TransactionalValidation = blob.TransferValidationTypeMD5(c[:])
+		o.HTTPHeaders.BlobContentMD5 = c[:]
+	}
+	return o
+}
+
+// SetObject stores the provided data in the specified object.
+func (s *blobStorage) SetObject(ctx context.Context, objName string, data []byte, contType string) error {
+	err := s.retry.Do(ctx, isRetryableWriteError, func() error {
+		_, err := s.blockBlobClient(objName).Upload(ctx, streaming.NopCloser(bytes.NewReader(data)), s.uploadOptions(data, contType))
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write object %q to container %q: %w", objName, s.container, err)
+	}
+	return nil
+}
+
+// SetObjectIfNoneMatch stores data in the specified object gated by a IfNoneMatch condition, i.e. write
+// iff no object exists under this key already.
+//
+// Returns an error wrapping objectstore.ErrObjectExists if an object already exists under the same key.
+func (s *blobStorage) SetObjectIfNoneMatch(ctx context.Context, objName string, data []byte, contType string) error {
+	o := s.uploadOptions(data, contType)
+	o.AccessConditions = &blob.AccessConditions{
+		ModifiedAccessConditions: &blob.ModifiedAccessConditions{IfNoneMatch: to.Ptr(azcore.ETagAny)},
+	}
+	err := s.retry.Do(ctx, isRetryableWriteError, func() error {
+		_, err := s.blockBlobClient(objName).Upload(ctx, streaming.NopCloser(bytes.NewReader(data)), o)
+		return err
+	})
+	if err != nil {
+		if bloberror.HasCode(err, bloberror.BlobAlreadyExists, bloberror.ConditionNotMet) {
+			return fmt.Errorf("object %q in container %q: %w", objName, s.container, objectstore.ErrObjectExists)
+		}
+		return fmt.Errorf("failed to write object %q to container %q: %w", objName, s.container, err)
+	}
+	return nil
+}
+
+// isRetryableWriteError returns true if err is a transient failure, i.e. a server error or rate limiting,
+// from which a write may succeed if retried.
+// Precondition failures are not retryable.
+func isRetryableWriteError(err error) bool {
+	var respErr *azcore.ResponseError
+	if !errors.As(err, &respErr) {
+		return false
+	}
+	return respErr.StatusCode == http.StatusTooManyRequests || respErr.StatusCode >= http.StatusInternalServerError
+}
+
+// LastModified returns the time the specified object was last modified, or an error.
+//
+// Returns an error wrapping os.ErrNotExist if the object does not exist.
+func (s *blobStorage) LastModified(ctx context.Context, obj string) (time.Time, error) {
+	r, err := s.blockBlobClient(obj).GetProperties(ctx, nil)
+	if err != nil {
+		if bloberror.HasCode(err, bloberror.BlobNotFound) {
+			return time.Time{}, fmt.Errorf("lastModified: object %q not found in container %q: %w", obj, s.container, os.ErrNotExist)
+		}
+		return time.Time{}, fmt.Errorf("lastModified: failed to get properties of object %q in container %q: %w", obj, s.container, err)
+	}
+	return *r.LastModified, nil
+}
+
+// Exists returns true if the specified object exists, using the blob properties rather than fetching its content.
+func (s *blobStorage) Exists(ctx context.Context, obj string) (bool, error) {
+	if _, err := s.blockBlobClient(obj).GetProperties(ctx, nil); err != nil {
+		if bloberror.HasCode(err, bloberror.BlobNotFound) {
+			return false, nil
+		}
+		return false, fmt.Errorf("exists: failed to get properties of object %q in container %q: %w", obj, s.container, err)
+	}
+	return true, nil
+}
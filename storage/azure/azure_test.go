@@ -0,0 +1,152 @@
+// Copyright 2024 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azure
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	tessera "github.com/transparency-dev/trillian-tessera"
+	"github.com/transparency-dev/trillian-tessera/api/layout"
+	"github.com/transparency-dev/trillian-tessera/storage/internal/objectstore"
+)
+
+// fakeBlobService implements just enough of the Blob Storage REST API to exercise blobStorage.
+type fakeBlobService struct {
+	sync.Mutex
+	blobs map[string][]byte
+	// corrupt, if set, causes the stored MD5 of all blobs to be wrong.
+	corrupt bool
+}
+
+func (f *fakeBlobService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+
+	notFound := func() {
+		w.Header().Set("x-ms-error-code", "BlobNotFound")
+		w.WriteHeader(http.StatusNotFound)
+	}
+	d, ok := f.blobs[r.URL.Path]
+	switch r.Method {
+	case http.MethodPut:
+		if ok && r.Header.Get("If-None-Match") == "*" {
+			w.Header().Set("x-ms-error-code", "BlobAlreadyExists")
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.blobs[r.URL.Path] = b
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodGet, http.MethodHead:
+		if !ok {
+			notFound()
+			return
+		}
+		c := md5.Sum(d)
+		if f.corrupt {
+			c[0] ^= 1
+		}
+		w.Header().Set("Content-MD5", base64.StdEncoding.EncodeToString(c[:]))
+		w.Header().Set("Last-Modified", time.Unix(1234567890, 0).UTC().Format(http.TimeFormat))
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", len(d)))
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(d)
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func TestBlobStorage(t *testing.T) {
+	ctx := context.Background()
+	f := &fakeBlobService{blobs: make(map[string][]byte)}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+	c, err := azblob.NewClientWithNoCredential(srv.URL, nil)
+	if err != nil {
+		t.Fatalf("NewClientWithNoCredential: %v", err)
+	}
+	s := &blobStorage{client: c, container: "log", checksums: true}
+
+	if _, err := s.GetObject(ctx, "tile/0/000"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("GetObject of missing object: got %v, want %v", err, os.ErrNotExist)
+	}
+	if _, err := s.LastModified(ctx, "tile/0/000"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("LastModified of missing object: got %v, want %v", err, os.ErrNotExist)
+	}
+
+	if ok, err := s.Exists(ctx, "tile/0/000"); err != nil || ok {
+		t.Errorf("Exists of missing object: got (%t, %v), want false", ok, err)
+	}
+
+	if err := s.SetObjectIfNoneMatch(ctx, "tile/0/000", []byte("tile"), "application/octet-stream"); err != nil {
+		t.Fatalf("SetObjectIfNoneMatch: %v", err)
+	}
+	if err := s.SetObjectIfNoneMatch(ctx, "tile/0/000", []byte("different"), "application/octet-stream"); !errors.Is(err, objectstore.ErrObjectExists) {
+		t.Errorf("SetObjectIfNoneMatch of existing object: got %v, want %v", err, objectstore.ErrObjectExists)
+	}
+	if ok, err := s.Exists(ctx, "tile/0/000"); err != nil || !ok {
+		t.Errorf("Exists: got (%t, %v), want true", ok, err)
+	}
+	if got, err := s.GetObject(ctx, "tile/0/000"); err != nil || string(got) != "tile" {
+		t.Errorf("GetObject: got (%q, %v), want %q", got, err, "tile")
+	}
+
+	for _, cp := range []string{"one", "two"} {
+		if err := s.SetObject(ctx, layout.CheckpointPath, []byte(cp), "text/plain; charset=utf-8"); err != nil {
+			t.Fatalf("SetObject: %v", err)
+		}
+		if got, err := s.GetObject(ctx, layout.CheckpointPath); err != nil || string(got) != cp {
+			t.Errorf("GetObject: got (%q, %v), want %q", got, err, cp)
+		}
+	}
+	if got, err := s.LastModified(ctx, layout.CheckpointPath); err != nil || !got.Equal(time.Unix(1234567890, 0)) {
+		t.Errorf("LastModified: got (%v, %v), want %v", got, err, time.Unix(1234567890, 0))
+	}
+
+	f.corrupt = true
+	if _, err := s.GetObject(ctx, layout.CheckpointPath); !errors.Is(err, tessera.ErrChecksumMismatch) {
+		t.Errorf("GetObject of corrupt object: got %v, want %v", err, tessera.ErrChecksumMismatch)
+	}
+	if _, ok := f.blobs["/log/tile/0/000"]; !ok {
+		t.Errorf("blob not stored at expected path, got paths %q", strings.Join(keys(f.blobs), ", "))
+	}
+}
+
+func keys(m map[string][]byte) []string {
+	r := make([]string, 0, len(m))
+	for k := range m {
+		r = append(r, k)
+	}
+	return r
+}
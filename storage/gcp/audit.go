@@ -0,0 +1,56 @@
+// Copyright 2024 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcp
+
+import (
+	"context"
+	"fmt"
+
+	gcs "cloud.google.com/go/storage"
+	storage "github.com/transparency-dev/trillian-tessera/storage/internal"
+	"google.golang.org/api/iterator"
+)
+
+// AuditImmutability inspects every stored generation of the full tiles and entry bundles in the given bucket,
+// and returns the names of any which have been overwritten with different content.
+//
+// Any such object indicates a serious violation of the log's append-only property.
+//
+// Noncurrent generations are only retained if Object Versioning is enabled on the bucket, so without it
+// overwrites cannot be detected. Entry bundles stored outside of the standard tile/ layout are not checked.
+func AuditImmutability(ctx context.Context, bucket string) ([]string, error) {
+	c, err := gcs.NewClient(ctx, gcs.WithJSONReads())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCS client: %v", err)
+	}
+	defer c.Close()
+
+	var vs []storage.ObjectVersion
+	it := c.Bucket(bucket).Objects(ctx, &gcs.Query{Prefix: "tile/", Versions: true})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list object generations in bucket %q: %v", bucket, err)
+		}
+		vs = append(vs, storage.ObjectVersion{
+			Name:     attrs.Name,
+			Checksum: fmt.Sprintf("%d/%08x", attrs.Size, attrs.CRC32C),
+		})
+	}
+	return storage.FindRewrites(vs), nil
+}
@@ -31,9 +31,12 @@ package gcp
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
 	"encoding/gob"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"net/http"
 	"os"
@@ -45,12 +48,13 @@ import (
 	gcs "cloud.google.com/go/storage"
 	"github.com/globocom/go-buffer"
 	"github.com/google/go-cmp/cmp"
-	"github.com/transparency-dev/merkle/rfc6962"
+	"github.com/transparency-dev/merkle"
 	tessera "github.com/transparency-dev/trillian-tessera"
 	"github.com/transparency-dev/trillian-tessera/api"
 	"github.com/transparency-dev/trillian-tessera/api/layout"
 	"github.com/transparency-dev/trillian-tessera/internal/options"
 	storage "github.com/transparency-dev/trillian-tessera/storage/internal"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/sync/errgroup"
 	"google.golang.org/api/googleapi"
 	"google.golang.org/api/iterator"
@@ -67,22 +71,58 @@ const (
 
 	logContType      = "application/octet-stream"
 	ckptContType     = "text/plain; charset=utf-8"
+	metaContType     = "application/json"
 	logCacheControl  = "max-age=604800,immutable"
 	ckptCacheControl = "no-cache"
 
-	DefaultPushbackMaxOutstanding = 4096
+	DefaultPushbackMaxOutstanding = tessera.DefaultPushbackMaxOutstanding
 	DefaultIntegrationSizeLimit   = 5 * 4096
+
+	// integrationInterval is how frequently we poll for sequenced entries to integrate while the log is busy.
+	integrationInterval = time.Second
+	// integrationIdleThreshold is the number of consecutive idle polls after which we start backing off.
+	integrationIdleThreshold = 3
+	// publisherLeaseIntervals is the number of checkpoint intervals for which the publisher lease is held
+	// without being renewed, i.e. how long it takes for another instance to take over publishing checkpoints
+	// if the current publisher stops.
+	publisherLeaseIntervals = 3
 )
 
 // Storage is a GCP based storage implementation for Tessera.
 type Storage struct {
+	// metrics holds the instruments used to record this instance's activity.
+	metrics     *metrics
 	newCP       options.NewCPFunc
 	entriesPath options.EntriesPathFunc
+	hasher      merkle.LogHasher
+	// integrationWorkers is the number of goroutines used to hash entries during integration.
+	integrationWorkers uint
+	// newResourceSig, if non-nil, is used to sign tiles and entry bundles as they're written.
+	newResourceSig options.NewResourceSigFunc
 
 	sequencer sequencer
 	objStore  objStore
+	// readStore, if set, is used instead of objStore to serve ReadCheckpoint, ReadTile, and ReadEntryBundle.
+	readStore objStore
+	// readCache, if non-nil, caches the immutable resources returned by ReadTile and ReadEntryBundle.
+	readCache *storage.ReadCache
+
+	// publishPolicy decides whether a checkpoint should be published for the current tree.
+	publishPolicy storage.PublishPolicy
+	// publishingPaused, if set, prevents new checkpoints from being published.
+	publishingPaused atomic.Bool
+	// electPublisher, if set, causes checkpoints to be published only while this instance holds the
+	// publisher lease, identified by publisherID.
+	electPublisher bool
+	publisherID    string
+	// publisherLease is how long the publisher lease is held for without being renewed.
+	publisherLease time.Duration
 
 	queue *storage.Queue
+	// integrationBackoff controls how frequently we poll for sequenced entries to integrate.
+	integrationBackoff *storage.IdleBackoff
+	// integrationSizeLimit is the maximum number of entries integrated in a single pass.
+	integrationSizeLimit uint64
 
 	cpUpdated chan struct{}
 }
@@ -92,6 +132,8 @@ type objStore interface {
 	getObject(ctx context.Context, obj string) ([]byte, int64, error)
 	setObject(ctx context.Context, obj string, data []byte, cond *gcs.Conditions, contType string, cacheCtl string) error
 	lastModified(ctx context.Context, obj string) (time.Time, error)
+	exists(ctx context.Context, obj string) (bool, error)
+	listObjects(ctx context.Context, prefix string) ([]string, error)
 }
 
 // sequencer describes a type which knows how to sequence entries.
@@ -107,6 +149,9 @@ type sequencer interface {
 	consumeEntries(ctx context.Context, limit uint64, f consumeFunc, forceUpdate bool) (bool, error)
 	// currentTree returns the sequencer's view of the current tree state.
 	currentTree(ctx context.Context) (uint64, []byte, error)
+	// publisherLease attempts to acquire or renew, for holder, the lease which elects a single instance
+	// to publish checkpoints. Returns true if holder holds the lease for the next ttl.
+	publisherLease(ctx context.Context, holder string, ttl time.Duration) (bool, error)
 }
 
 // consumeFunc is the signature of a function which can consume entries from the sequencer and integrate
@@ -118,18 +163,55 @@ type consumeFunc func(ctx context.Context, from uint64, entries []storage.Sequen
 type Config struct {
 	// Bucket is the name of the GCS bucket to use for storing log state.
 	Bucket string
+	// ReadBucket, if set, is the name of the GCS bucket from which ReadCheckpoint, ReadTile and ReadEntryBundle
+	// serve the log, e.g. a replica of Bucket which is isolated from the write path.
+	//
+	// Integration always reads from and writes to Bucket, so this does not affect the log itself. However, reads
+	// from ReadBucket will lag behind writes to Bucket by the replication delay, and since checkpoints, tiles,
+	// and entry bundles may be replicated independently, a checkpoint read from it may refer to tiles which
+	// have not yet been replicated.
+	ReadBucket string
 	// Spanner is the GCP resource URI of the spanner database instance to use.
 	Spanner string
+	// SharedCoordinationLocks causes the sequencer to request shared, rather than exclusive, locks when
+	// reading the coordination rows in Spanner.
+	//
+	// This is always safe from a correctness perspective, since Spanner read-write transactions are
+	// serialisable regardless of lock hint, and aborted transactions are retried automatically.
+	// Under low contention, shared locks can reduce latency; under high contention, e.g. with many
+	// frontends, they will likely cause more transactions to be aborted and retried, so the default
+	// of exclusive locks should be preferred unless profiling shows otherwise.
+	SharedCoordinationLocks bool
+	// SeqCleanupInterval, if non-zero, is the interval at which the Seq table is checked for rows which have
+	// already been integrated but not removed, e.g. due to a crash. Any such rows are deleted, and the number
+	// of remaining rows, i.e. the sequencing backlog, is recorded in the tessera.storage.seq_rows metric.
+	SeqCleanupInterval time.Duration
+	// ElectCheckpointPublisher causes the frontends sharing the log to elect, via a lease in the PubCoord
+	// table in Spanner, a single instance to publish checkpoints, rather than each of them attempting to
+	// update the checkpoint object every interval. This reduces the rate of GCS refusals for updates to
+	// the checkpoint object.
+	//
+	// If the lease can't be read or written, e.g. because the PubCoord table doesn't exist, the instance
+	// falls back to publishing checkpoints itself.
+	ElectCheckpointPublisher bool
 }
 
 // New creates a new instance of the GCP based Storage.
 func New(ctx context.Context, cfg Config, opts ...func(*options.StorageOptions)) (*Storage, error) {
-	opt := storage.ResolveStorageOptions(opts...)
+	opt, err := storage.ResolveStorageOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
 	if opt.PushbackMaxOutstanding == 0 {
 		opt.PushbackMaxOutstanding = DefaultPushbackMaxOutstanding
 	}
-	if opt.CheckpointInterval < minCheckpointInterval {
-		return nil, fmt.Errorf("requested CheckpointInterval (%v) is less than minimum permitted %v", opt.CheckpointInterval, minCheckpointInterval)
+	minInterval := storage.MinCheckpointInterval(opt, minCheckpointInterval)
+	if opt.CheckpointInterval < minInterval {
+		return nil, fmt.Errorf("requested CheckpointInterval (%v) is less than minimum permitted %v", opt.CheckpointInterval, minInterval)
+	}
+	metadata, err := storage.MarshalLogMetadata(opt)
+	if err != nil {
+		return nil, err
 	}
 
 	c, err := gcs.NewClient(ctx, gcs.WithJSONReads())
@@ -137,56 +219,84 @@ func New(ctx context.Context, cfg Config, opts ...func(*options.StorageOptions))
 		return nil, fmt.Errorf("failed to create GCS client: %v", err)
 	}
 
-	seq, err := newSpannerSequencer(ctx, cfg.Spanner, uint64(opt.PushbackMaxOutstanding))
+	seq, err := newSpannerSequencer(ctx, cfg.Spanner, uint64(opt.PushbackMaxOutstanding), opt.Hasher.EmptyRoot())
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Spanner sequencer: %v", err)
 	}
+	m, err := metricsFor(opt)
+	if err != nil {
+		return nil, err
+	}
+	seq.metrics = m
+	if cfg.SharedCoordinationLocks {
+		seq.lockHint = spannerpb.ReadRequest_LOCK_HINT_SHARED
+	}
 
 	r := &Storage{
+		metrics: m,
 		objStore: &gcsStorage{
+			metrics:   m,
 			gcsClient: c,
 			bucket:    cfg.Bucket,
+			checksums: opt.ObjectChecksums,
+			retry:     storage.WriteRetry{MaxAttempts: opt.ObjectWriteRetryAttempts, BaseDelay: opt.ObjectWriteRetryBaseDelay},
 		},
-		sequencer:   seq,
-		newCP:       opt.NewCP,
-		entriesPath: opt.EntriesPath,
-		cpUpdated:   make(chan struct{}),
+		sequencer:          seq,
+		newCP:              opt.NewCP,
+		newResourceSig:     storage.ResourceSigner(opt),
+		entriesPath:        opt.EntriesPath,
+		hasher:             opt.Hasher,
+		integrationWorkers: opt.IntegrationWorkers,
+		cpUpdated:          make(chan struct{}),
+		publishPolicy:      storage.PublishPolicy{OnlyOnChange: opt.PublishOnlyOnChange, RepublishInterval: opt.CheckpointRepublishInterval},
+		readCache:          storage.NewReadCache(opt.ReadCacheMaxBytes, opt.ReadCacheTTL),
+		electPublisher:     cfg.ElectCheckpointPublisher,
+		publisherID:        newPublisherID(),
+		publisherLease:     publisherLeaseIntervals * opt.CheckpointInterval,
+	}
+	if cfg.ReadBucket != "" {
+		r.readStore = &gcsStorage{
+			gcsClient: c,
+			bucket:    cfg.ReadBucket,
+			checksums: opt.ObjectChecksums,
+		}
+	}
+	r.queue = storage.NewQueue(ctx, opt.BatchMaxAge, opt.BatchMaxSize, opt.MaxConcurrentAdds, opt.EntryBundleCodec, opt.Hasher, r.sequencer.assignEntries)
+	r.integrationBackoff = storage.NewIdleBackoff(integrationInterval, opt.IntegrationMaxIdleInterval, integrationIdleThreshold)
+	r.integrationSizeLimit = DefaultIntegrationSizeLimit
+	if opt.IntegrationSizeLimit != nil {
+		r.integrationSizeLimit = *opt.IntegrationSizeLimit
 	}
-	r.queue = storage.NewQueue(ctx, opt.BatchMaxAge, opt.BatchMaxSize, r.sequencer.assignEntries)
 
 	if err := r.init(ctx); err != nil {
 		return nil, fmt.Errorf("failed to initialise log storage: %v", err)
 	}
+	if err := storage.PublishLogMetadata(ctx, metadata, func(ctx context.Context, path string, data []byte) error {
+		return r.objStore.setObject(ctx, path, data, nil, metaContType, ckptCacheControl)
+	}); err != nil {
+		return nil, err
+	}
+	if opt.VerifyRootOnInit {
+		if err := r.verifyRoot(ctx, opt.VerifyRootFull); err != nil {
+			return nil, fmt.Errorf("failed to verify log integrity: %v", err)
+		}
+	}
 
-	go func() {
-		t := time.NewTicker(1 * time.Second)
-		defer t.Stop()
-		for {
+	go storage.IntegrationLoop{
+		SizeLimit:        r.integrationSizeLimit,
+		CatchUpSizeLimit: uint64(opt.IntegrationCatchUpSizeLimit),
+		Backoff:          r.integrationBackoff,
+		Integrate:        r.integrateSequenced,
+		Integrated: func() {
 			select {
-			case <-ctx.Done():
-				return
-			case <-t.C:
+			case r.cpUpdated <- struct{}{}:
+			default:
 			}
+		},
+	}.Run(ctx)
 
-			func() {
-				// Don't quickloop for now, it causes issues updating checkpoint too frequently.
-				cctx, cancel := context.WithTimeout(ctx, 10*time.Second)
-				defer cancel()
-
-				if _, err := r.sequencer.consumeEntries(cctx, DefaultIntegrationSizeLimit, r.integrate, false); err != nil {
-					klog.Errorf("integrate: %v", err)
-					return
-				}
-				select {
-				case r.cpUpdated <- struct{}{}:
-				default:
-				}
-			}()
-		}
-	}()
-
-	go func(ctx context.Context, i time.Duration) {
-		t := time.NewTicker(i)
+	go func(ctx context.Context, j storage.Jitter) {
+		t := time.NewTimer(j.Next())
 		defer t.Stop()
 		for {
 			select {
@@ -194,36 +304,126 @@ func New(ctx context.Context, cfg Config, opts ...func(*options.StorageOptions))
 				return
 			case <-r.cpUpdated:
 			case <-t.C:
+				t.Reset(j.Next())
 			}
-			if err := r.publishCheckpoint(ctx, i); err != nil {
+			if err := r.publishCheckpoint(ctx, j.Min()); err != nil {
 				klog.Warningf("publishCheckpoint: %v", err)
 			}
 		}
-	}(ctx, opt.CheckpointInterval)
+	}(ctx, storage.Jitter{Interval: opt.CheckpointInterval, Fraction: opt.CheckpointIntervalJitter, Floor: minInterval})
+
+	if i := cfg.SeqCleanupInterval; i > 0 {
+		go func() {
+			t := time.NewTicker(i)
+			defer t.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-t.C:
+				}
+				n, err := seq.cleanupSeq(ctx)
+				if err != nil {
+					klog.Warningf("cleanupSeq: %v", err)
+					continue
+				}
+				r.metrics.seqRows.Record(ctx, n)
+			}
+		}()
+	}
 
 	return r, nil
 }
 
 // Add is the entrypoint for adding entries to a sequencing log.
 func (s *Storage) Add(ctx context.Context, e *tessera.Entry) tessera.IndexFuture {
+	ctx, span := tracer.Start(ctx, "tessera.storage.gcp.Add")
+	defer span.End()
+
+	if s.integrationBackoff != nil {
+		s.integrationBackoff.Wake()
+	}
 	return s.queue.Add(ctx, e)
 }
 
+// AddBatch adds all of the provided entries to the log, returning one future per entry in the same order.
+//
+// The entries are guaranteed to be assigned a contiguous range of indices, in the order provided.
+// Batches with more entries than can be queued together are rejected with tessera.ErrBatchTooLarge.
+func (s *Storage) AddBatch(ctx context.Context, entries []*tessera.Entry) []tessera.IndexFuture {
+	ctx, span := tracer.Start(ctx, "tessera.storage.gcp.AddBatch")
+	defer span.End()
+
+	if s.integrationBackoff != nil {
+		s.integrationBackoff.Wake()
+	}
+	return s.queue.AddBatch(ctx, entries)
+}
+
+// ReadCheckpoint returns the latest published checkpoint.
+//
+// This, along with ReadTile and ReadEntryBundle, reads directly from GCS without consulting Spanner,
+// so reads remain available even if Spanner is not.
 func (s *Storage) ReadCheckpoint(ctx context.Context) ([]byte, error) {
-	return s.get(ctx, layout.CheckpointPath)
+	return s.read(ctx, layout.CheckpointPath)
 }
 
+// ReadMetadata returns the log metadata published when the storage was created with the WithLogMetadata option.
+func (s *Storage) ReadMetadata(ctx context.Context) ([]byte, error) {
+	return s.read(ctx, layout.MetadataPath)
+}
+
+// ReadTile returns the requested tile.
 func (s *Storage) ReadTile(ctx context.Context, l, i uint64, p uint8) ([]byte, error) {
-	return s.get(ctx, layout.TilePath(l, i, p))
+	return s.readCache.Get(ctx, layout.TilePath(l, i, p), s.read)
 }
 
+// ReadEntryBundle returns the requested entry bundle.
 func (s *Storage) ReadEntryBundle(ctx context.Context, i uint64, p uint8) ([]byte, error) {
-	return s.get(ctx, s.entriesPath(i, p))
+	return s.readCache.Get(ctx, s.entriesPath(i, p), s.read)
+}
+
+// TileExists returns true if the requested tile is present, without fetching its content.
+func (s *Storage) TileExists(ctx context.Context, l, i uint64, p uint8) (bool, error) {
+	return s.exists(ctx, layout.TilePath(l, i, p))
+}
+
+// EntryBundleExists returns true if the requested entry bundle is present, without fetching its content.
+func (s *Storage) EntryBundleExists(ctx context.Context, i uint64, p uint8) (bool, error) {
+	return s.exists(ctx, s.entriesPath(i, p))
+}
+
+// IntegratedSize returns the size of the tree into which the sequencer has integrated entries.
+//
+// This may be larger than the size of the most recently published checkpoint.
+func (s *Storage) IntegratedSize(ctx context.Context) (uint64, error) {
+	size, _, err := s.sequencer.currentTree(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("currentTree: %v", err)
+	}
+	return size, nil
 }
 
-// get returns the requested object.
+// read returns the requested object from readStore if set, or objStore otherwise.
 //
 // This is indended to be used to proxy read requests through the personality for debug/testing purposes.
+func (s *Storage) read(ctx context.Context, path string) ([]byte, error) {
+	if s.readStore == nil {
+		return s.get(ctx, path)
+	}
+	d, _, err := s.readStore.getObject(ctx, path)
+	return d, err
+}
+
+// exists checks for the presence of the requested object in readStore if set, or objStore otherwise.
+func (s *Storage) exists(ctx context.Context, path string) (bool, error) {
+	if s.readStore == nil {
+		return s.objStore.exists(ctx, path)
+	}
+	return s.readStore.exists(ctx, path)
+}
+
+// get returns the requested object from objStore.
 func (s *Storage) get(ctx context.Context, path string) ([]byte, error) {
 	d, _, err := s.objStore.getObject(ctx, path)
 	return d, err
@@ -239,12 +439,13 @@ func (s *Storage) init(ctx context.Context) error {
 			// framework which prevents the tree from rolling backwards or otherwise forking).
 			cctx, c := context.WithTimeout(ctx, 10*time.Second)
 			defer c()
-			if _, err := s.sequencer.consumeEntries(cctx, DefaultIntegrationSizeLimit, s.integrate, true); err != nil {
+			if _, err := s.sequencer.consumeEntries(cctx, s.integrationSizeLimit, s.integrate, true); err != nil {
 				return fmt.Errorf("forced integrate: %v", err)
 			}
-			select {
-			case s.cpUpdated <- struct{}{}:
-			default:
+			// Publish the checkpoint for the new tree now, rather than waiting for the first asynchronous
+			// publish, so that readers see a valid checkpoint as soon as the storage is available.
+			if err := s.publishCheckpoint(ctx, 0); err != nil {
+				return fmt.Errorf("failed to publish initial checkpoint: %v", err)
 			}
 			return nil
 		}
@@ -254,19 +455,95 @@ func (s *Storage) init(ctx context.Context) error {
 	return nil
 }
 
+// verifyRoot checks that the root hash committed to by the published checkpoint is consistent with the
+// stored tiles.
+func (s *Storage) verifyRoot(ctx context.Context, full bool) error {
+	cpRaw, err := s.get(ctx, layout.CheckpointPath)
+	if err != nil {
+		if errors.Is(err, gcs.ErrObjectNotExist) {
+			// Nothing has been published yet, so there's nothing to verify.
+			return nil
+		}
+		return fmt.Errorf("failed to read checkpoint: %v", err)
+	}
+	return storage.VerifyCheckpoint(ctx, s.getTiles, cpRaw, s.hasher, full)
+}
+
+// holdsPublisherLease returns true if this instance should publish checkpoints, i.e. if publisher election
+// is disabled, this instance holds the publisher lease, or the lease is unavailable.
+func (s *Storage) holdsPublisherLease(ctx context.Context) bool {
+	if !s.electPublisher {
+		return true
+	}
+	held, err := s.sequencer.publisherLease(ctx, s.publisherID, s.publisherLease)
+	if err != nil {
+		klog.Warningf("publisherLease: %v; publishing checkpoints from this instance", err)
+		return true
+	}
+	return held
+}
+
+// newPublisherID returns a random identifier for this instance for use with the publisher lease.
+func newPublisherID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Errorf("rand.Read: %v", err))
+	}
+	return hex.EncodeToString(b)
+}
+
+// PausePublishing stops new checkpoints from being published, while entries continue to be sequenced
+// and integrated as normal.
+//
+// This is intended for use during an outage of the log's witnesses, to avoid publishing checkpoints which
+// can't be cosigned. Publishing is paused only for this instance, so it should be called on all instances
+// serving the log.
+func (s *Storage) PausePublishing() {
+	s.publishingPaused.Store(true)
+	s.metrics.publishingPaused.Record(context.Background(), 1)
+	klog.Info("Checkpoint publishing paused")
+}
+
+// ResumePublishing undoes a previous call to PausePublishing.
+//
+// A checkpoint covering all entries integrated in the meantime will be published at the next checkpoint interval.
+func (s *Storage) ResumePublishing() {
+	s.publishingPaused.Store(false)
+	s.metrics.publishingPaused.Record(context.Background(), 0)
+	klog.Info("Checkpoint publishing resumed")
+}
+
 func (s *Storage) publishCheckpoint(ctx context.Context, minStaleness time.Duration) error {
+	ctx, span := tracer.Start(ctx, "tessera.storage.gcp.publishCheckpoint")
+	defer span.End()
+
 	m, err := s.objStore.lastModified(ctx, layout.CheckpointPath)
 	if err != nil && !errors.Is(err, gcs.ErrObjectNotExist) {
 		return fmt.Errorf("lastModified(%q): %v", layout.CheckpointPath, err)
 	}
+	if !m.IsZero() {
+		s.metrics.checkpointAge.Record(ctx, time.Since(m).Seconds())
+	}
+	if s.publishingPaused.Load() {
+		klog.V(1).Info("publishCheckpoint: skipping publish because publishing is paused")
+		return nil
+	}
 	if time.Since(m) < minStaleness {
 		return nil
 	}
+	// The lease is only taken or renewed once the checkpoint is due to be replaced, so that instances don't
+	// contend for it every time they integrate entries.
+	if !s.holdsPublisherLease(ctx) {
+		return nil
+	}
 
 	size, root, err := s.sequencer.currentTree(ctx)
 	if err != nil {
 		return fmt.Errorf("currentTree: %v", err)
 	}
+	if !s.publishPolicy.ShouldPublish(size, time.Since(m)) {
+		return nil
+	}
 	cpRaw, err := s.newCP(size, root)
 	if err != nil {
 		return fmt.Errorf("newCP: %v", err)
@@ -275,6 +552,8 @@ func (s *Storage) publishCheckpoint(ctx context.Context, minStaleness time.Durat
 	if err := s.objStore.setObject(ctx, layout.CheckpointPath, cpRaw, nil, ckptContType, ckptCacheControl); err != nil {
 		return fmt.Errorf("writeCheckpoint: %v", err)
 	}
+	s.publishPolicy.Published(size)
+	s.metrics.lastPublished.Record(ctx, time.Now().Unix())
 	return nil
 
 }
@@ -290,7 +569,29 @@ func (s *Storage) setTile(ctx context.Context, level, index, logSize uint64, til
 	tPath := layout.TilePath(level, index, layout.PartialTileSize(level, index, logSize))
 	klog.V(2).Infof("StoreTile: %s (%d entries)", tPath, len(tile.Nodes))
 
-	return s.objStore.setObject(ctx, tPath, data, &gcs.Conditions{DoesNotExist: true}, logContType, logCacheControl)
+	if err := s.objStore.setObject(ctx, tPath, data, &gcs.Conditions{DoesNotExist: true}, logContType, logCacheControl); err != nil {
+		return err
+	}
+	return s.setResourceSignature(ctx, tPath, data)
+}
+
+// setResourceSignature stores a signature over the tile or entry bundle data written to objName at
+// the corresponding signature path, if resource signing is enabled.
+func (s *Storage) setResourceSignature(ctx context.Context, objName string, data []byte) error {
+	if s.newResourceSig == nil {
+		return nil
+	}
+	sig, err := s.newResourceSig(objName, data)
+	if err != nil {
+		return fmt.Errorf("failed to sign %q: %v", objName, err)
+	}
+	// Signatures need not be deterministic, so this write isn't conditional: any signature which
+	// may already be present covers the same content.
+	sigPath := layout.SignaturePath(objName)
+	if err := s.objStore.setObject(ctx, sigPath, sig, nil, ckptContType, logCacheControl); err != nil {
+		return fmt.Errorf("failed to write signature %q: %v", sigPath, err)
+	}
+	return nil
 }
 
 // getTiles returns the tiles with the given tile-coords for the specified log size.
@@ -348,6 +649,9 @@ func (s *Storage) getEntryBundle(ctx context.Context, bundleIndex uint64, p uint
 }
 
 // setEntryBundle idempotently stores the serialised entry bundle at the location implied by the bundleIndex and treeSize.
+//
+// Each size of a partial bundle is stored at its own path, so growing a partial bundle writes a new object
+// rather than overwriting the smaller one.
 func (s *Storage) setEntryBundle(ctx context.Context, bundleIndex uint64, p uint8, bundleRaw []byte) error {
 	objName := s.entriesPath(bundleIndex, p)
 	// Note that setObject does an idempotent interpretation of DoesNotExist - it only
@@ -357,11 +661,30 @@ func (s *Storage) setEntryBundle(ctx context.Context, bundleIndex uint64, p uint
 		return fmt.Errorf("setObject(%q): %v", objName, err)
 
 	}
-	return nil
+	return s.setResourceSignature(ctx, objName, bundleRaw)
+}
+
+// integrateSequenced integrates up to limit previously sequenced entries, and returns the number integrated.
+func (s *Storage) integrateSequenced(ctx context.Context, limit uint64) (uint64, error) {
+	var n uint64
+	_, err := s.sequencer.consumeEntries(ctx, limit, func(ctx context.Context, fromSeq uint64, entries []storage.SequencedEntry) ([]byte, error) {
+		n = uint64(len(entries))
+		return s.integrate(ctx, fromSeq, entries)
+	}, false)
+	return n, err
 }
 
 // integrate incorporates the provided entries into the log starting at fromSeq.
 func (s *Storage) integrate(ctx context.Context, fromSeq uint64, entries []storage.SequencedEntry) ([]byte, error) {
+	var opts []trace.SpanStartOption
+	if s.queue != nil {
+		// Link to the spans in which these entries were sequenced, if that happened in this instance.
+		opts = append(opts, trace.WithLinks(s.queue.SequencedLinks(fromSeq, fromSeq+uint64(len(entries)))...))
+	}
+	ctx, span := tracer.Start(ctx, "tessera.storage.gcp.integrate", opts...)
+	defer span.End()
+	span.SetAttributes(fromSeqKey.Int64(int64(fromSeq)), batchSizeKey.Int(len(entries)))
+
 	var newRoot []byte
 
 	errG := errgroup.Group{}
@@ -382,7 +705,7 @@ func (s *Storage) integrate(ctx context.Context, fromSeq uint64, entries []stora
 			return n, nil
 		}
 
-		newSize, root, tiles, err := storage.Integrate(ctx, getTiles, fromSeq, entries)
+		newSize, root, tiles, err := storage.Integrate(ctx, getTiles, fromSeq, entries, s.hasher, s.integrationWorkers)
 		if err != nil {
 			return fmt.Errorf("Integrate: %v", err)
 		}
@@ -399,7 +722,12 @@ func (s *Storage) integrate(ctx context.Context, fromSeq uint64, entries []stora
 		return nil
 	})
 
-	return newRoot, errG.Wait()
+	if err := errG.Wait(); err != nil {
+		return nil, err
+	}
+	s.metrics.entriesIntegrated.Add(ctx, int64(len(entries)))
+	s.metrics.integratedSize.Record(ctx, int64(fromSeq)+int64(len(entries)))
+	return newRoot, nil
 }
 
 // updateEntryBundles adds the entries being integrated into the entry bundles.
@@ -470,20 +798,28 @@ func (s *Storage) updateEntryBundles(ctx context.Context, fromSeq uint64, entrie
 // spannerSequencer uses Cloud Spanner to provide
 // a durable and thread/multi-process safe sequencer.
 type spannerSequencer struct {
+	metrics        *metrics
 	dbPool         *spanner.Client
 	maxOutstanding uint64
+	// emptyRoot is the root hash of the empty tree, used when initialising the IntCoord table.
+	emptyRoot []byte
+	// lockHint is the lock hint used when reading coordination rows in read-write transactions.
+	lockHint spannerpb.ReadRequest_LockHint
 }
 
 // new SpannerSequencer returns a new spannerSequencer struct which uses the provided
 // spanner resource name for its spanner connection.
-func newSpannerSequencer(ctx context.Context, spannerDB string, maxOutstanding uint64) (*spannerSequencer, error) {
+func newSpannerSequencer(ctx context.Context, spannerDB string, maxOutstanding uint64, emptyRoot []byte) (*spannerSequencer, error) {
 	dbPool, err := spanner.NewClient(ctx, spannerDB)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to Spanner: %v", err)
 	}
 	r := &spannerSequencer{
 		dbPool:         dbPool,
+		metrics:        defaultMetrics,
 		maxOutstanding: maxOutstanding,
+		emptyRoot:      emptyRoot,
+		lockHint:       spannerpb.ReadRequest_LOCK_HINT_EXCLUSIVE,
 	}
 	if err := r.initDB(ctx); err != nil {
 		return nil, fmt.Errorf("failed to initDB: %v", err)
@@ -505,6 +841,8 @@ func newSpannerSequencer(ctx context.Context, spannerDB string, maxOutstanding u
 //     This table coordinates integration of the batches of entries stored in
 //     Seq into the committed tree state.
 //
+// An optional 4th table, PubCoord, holds the lease used when Config.ElectCheckpointPublisher is set.
+//
 // The database and schema should be created externally, e.g. by terraform.
 func (s *spannerSequencer) initDB(ctx context.Context) error {
 
@@ -525,6 +863,12 @@ func (s *spannerSequencer) initDB(ctx context.Context) error {
 		seq INT64 NOT NULL,
 		rootHash BYTES(32) NOT NULL,
 	) PRIMARY KEY (id);
+
+	CREATE TABLE PubCoord (
+		id INT64 NOT NULL,
+		holder STRING(MAX) NOT NULL,
+		expiresAt TIMESTAMP NOT NULL,
+	) PRIMARY KEY (id);
 	*/
 
 	// Set default values for a newly initialised schema - these rows being present are a precondition for
@@ -534,7 +878,7 @@ func (s *spannerSequencer) initDB(ctx context.Context) error {
 	if _, err := s.dbPool.Apply(ctx, []*spanner.Mutation{spanner.Insert("SeqCoord", []string{"id", "next"}, []interface{}{0, 0})}); err != nil && spanner.ErrCode(err) != codes.AlreadyExists {
 		return err
 	}
-	if _, err := s.dbPool.Apply(ctx, []*spanner.Mutation{spanner.Insert("IntCoord", []string{"id", "seq", "rootHash"}, []interface{}{0, 0, rfc6962.DefaultHasher.EmptyRoot()})}); err != nil && spanner.ErrCode(err) != codes.AlreadyExists {
+	if _, err := s.dbPool.Apply(ctx, []*spanner.Mutation{spanner.Insert("IntCoord", []string{"id", "seq", "rootHash"}, []interface{}{0, 0, s.emptyRoot})}); err != nil && spanner.ErrCode(err) != codes.AlreadyExists {
 		return err
 	}
 	return nil
@@ -546,6 +890,10 @@ func (s *spannerSequencer) initDB(ctx context.Context) error {
 // This is achieved by storing the passed-in entries in the Seq table in Spanner, keyed by the
 // index assigned to the first entry in the batch.
 func (s *spannerSequencer) assignEntries(ctx context.Context, entries []*tessera.Entry) error {
+	ctx, span := tracer.Start(ctx, "tessera.storage.gcp.assignEntries")
+	defer span.End()
+	span.SetAttributes(batchSizeKey.Int(len(entries)))
+
 	// First grab the treeSize in a non-locking read-only fashion (we don't want to block/collide with integration).
 	// We'll use this value to determine whether we need to apply back-pressure.
 	var treeSize int64
@@ -561,7 +909,7 @@ func (s *spannerSequencer) assignEntries(ctx context.Context, entries []*tessera
 
 	_, err := s.dbPool.ReadWriteTransaction(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
 		// First we need to grab the next available sequence number from the SeqCoord table.
-		row, err := txn.ReadRowWithOptions(ctx, "SeqCoord", spanner.Key{0}, []string{"id", "next"}, &spanner.ReadOptions{LockHint: spannerpb.ReadRequest_LOCK_HINT_EXCLUSIVE})
+		row, err := txn.ReadRowWithOptions(ctx, "SeqCoord", spanner.Key{0}, []string{"id", "next"}, &spanner.ReadOptions{LockHint: s.lockHint})
 		if err != nil {
 			return fmt.Errorf("failed to read SeqCoord: %v", err)
 		}
@@ -611,8 +959,12 @@ func (s *spannerSequencer) assignEntries(ctx context.Context, entries []*tessera
 	})
 
 	if err != nil {
+		if errors.Is(err, tessera.ErrPushback) {
+			s.metrics.pushbacks.Add(ctx, 1)
+		}
 		return fmt.Errorf("failed to flush batch: %w", err)
 	}
+	s.metrics.entriesSequenced.Add(ctx, int64(len(entries)))
 
 	return nil
 }
@@ -624,10 +976,13 @@ func (s *spannerSequencer) assignEntries(ctx context.Context, entries []*tessera
 //
 // Returns true if some entries were consumed as a weak signal that there may be further entries waiting to be consumed.
 func (s *spannerSequencer) consumeEntries(ctx context.Context, limit uint64, f consumeFunc, forceUpdate bool) (bool, error) {
+	ctx, span := tracer.Start(ctx, "tessera.storage.gcp.consumeEntries")
+	defer span.End()
+
 	didWork := false
 	_, err := s.dbPool.ReadWriteTransaction(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
 		// Figure out which is the starting index of sequenced entries to start consuming from.
-		row, err := txn.ReadRowWithOptions(ctx, "IntCoord", spanner.Key{0}, []string{"seq", "rootHash"}, &spanner.ReadOptions{LockHint: spannerpb.ReadRequest_LOCK_HINT_EXCLUSIVE})
+		row, err := txn.ReadRowWithOptions(ctx, "IntCoord", spanner.Key{0}, []string{"seq", "rootHash"}, &spanner.ReadOptions{LockHint: s.lockHint})
 		if err != nil {
 			return err
 		}
@@ -642,7 +997,7 @@ func (s *spannerSequencer) consumeEntries(ctx context.Context, limit uint64, f c
 		rows := txn.ReadWithOptions(ctx, "Seq",
 			spanner.KeyRange{Start: spanner.Key{0, fromSeq}, End: spanner.Key{0, fromSeq + int64(limit)}},
 			[]string{"seq", "v"},
-			&spanner.ReadOptions{LockHint: spannerpb.ReadRequest_LOCK_HINT_EXCLUSIVE})
+			&spanner.ReadOptions{LockHint: s.lockHint})
 		defer rows.Stop()
 
 		seqsConsumed := []int64{}
@@ -722,10 +1077,99 @@ func (s *spannerSequencer) currentTree(ctx context.Context) (uint64, []byte, err
 	return uint64(fromSeq), rootHash, nil
 }
 
+// publisherLease acquires or renews the publisher lease for holder if it's unheld, expired, or already
+// held by holder.
+func (s *spannerSequencer) publisherLease(ctx context.Context, holder string, ttl time.Duration) (bool, error) {
+	held := false
+	_, err := s.dbPool.ReadWriteTransaction(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		held = false
+		now := time.Now()
+		row, err := txn.ReadRow(ctx, "PubCoord", spanner.Key{0}, []string{"holder", "expiresAt"})
+		if err != nil && spanner.ErrCode(err) != codes.NotFound {
+			return fmt.Errorf("failed to read PubCoord: %v", err)
+		}
+		if err == nil {
+			var curHolder string
+			var expiresAt time.Time
+			if err := row.Columns(&curHolder, &expiresAt); err != nil {
+				return fmt.Errorf("failed to read publisher lease: %v", err)
+			}
+			if curHolder != holder && now.Before(expiresAt) {
+				return nil
+			}
+		}
+		held = true
+		return txn.BufferWrite([]*spanner.Mutation{spanner.InsertOrUpdate("PubCoord", []string{"id", "holder", "expiresAt"}, []interface{}{0, holder, now.Add(ttl)})})
+	})
+	if err != nil {
+		return false, err
+	}
+	return held, nil
+}
+
+// cleanupSeq deletes any rows from the Seq table which precede the integration watermark in IntCoord.
+//
+// Integration consumes whole batches and deletes them in the same transaction as it advances the watermark,
+// so finding any such rows indicates that something has gone wrong.
+//
+// Returns the number of rows remaining in the Seq table.
+func (s *spannerSequencer) cleanupSeq(ctx context.Context) (int64, error) {
+	_, err := s.dbPool.ReadWriteTransaction(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		row, err := txn.ReadRow(ctx, "IntCoord", spanner.Key{0}, []string{"seq"})
+		if err != nil {
+			return fmt.Errorf("failed to read IntCoord: %v", err)
+		}
+		var seq int64
+		if err := row.Columns(&seq); err != nil {
+			return fmt.Errorf("failed to read integration coordination info: %v", err)
+		}
+		stale := spanner.KeyRange{Start: spanner.Key{0}, End: spanner.Key{0, seq}, Kind: spanner.ClosedOpen}
+		n := 0
+		if err := txn.Read(ctx, "Seq", stale, []string{"seq"}).Do(func(_ *spanner.Row) error {
+			n++
+			return nil
+		}); err != nil {
+			return fmt.Errorf("failed to read stale Seq rows: %v", err)
+		}
+		if n == 0 {
+			return nil
+		}
+		klog.Warningf("Deleting %d stale Seq rows below integrated size %d", n, seq)
+		return txn.BufferWrite([]*spanner.Mutation{spanner.Delete("Seq", stale)})
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var n int64
+	if err := s.dbPool.Single().Query(ctx, spanner.Statement{SQL: "SELECT COUNT(*) FROM Seq"}).Do(func(r *spanner.Row) error {
+		return r.Column(0, &n)
+	}); err != nil {
+		return 0, fmt.Errorf("failed to count Seq rows: %v", err)
+	}
+	return n, nil
+}
+
 // gcsStorage knows how to store and retrieve objects from GCS.
 type gcsStorage struct {
+	metrics   *metrics
 	bucket    string
 	gcsClient *gcs.Client
+	// checksums, if set, causes CRC32C checksums to be sent with writes and verified on reads.
+	checksums bool
+	// retry is the policy for retrying writes which fail with transient errors.
+	retry storage.WriteRetry
+}
+
+// crc32cTable is used to calculate CRC32C checksums, as supported by GCS.
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// checkCRC32C returns an error wrapping tessera.ErrChecksumMismatch if data does not have the expected checksum.
+func checkCRC32C(obj string, data []byte, want uint32) error {
+	if got := crc32.Checksum(data, crc32cTable); got != want {
+		return fmt.Errorf("%q has CRC32C %08x, want %08x: %w", obj, got, want, tessera.ErrChecksumMismatch)
+	}
+	return nil
 }
 
 // getObject returns the data and generation of the specified object, or an error.
@@ -739,6 +1183,14 @@ func (s *gcsStorage) getObject(ctx context.Context, obj string) ([]byte, int64,
 	if err != nil {
 		return nil, -1, fmt.Errorf("failed to read %q: %v", obj, err)
 	}
+	// Note that the GCS client may also detect a mismatch itself while reading the object above.
+	// GCS computes CRC32C checksums for all objects, so we don't need to have written them to check them.
+	if s.checksums {
+		if err := checkCRC32C(obj, d, r.Attrs.CRC32C); err != nil {
+			_ = r.Close()
+			return nil, -1, err
+		}
+	}
 	return d, r.Attrs.Generation, r.Close()
 }
 
@@ -751,23 +1203,12 @@ func (s *gcsStorage) getObject(ctx context.Context, obj string) ([]byte, int64,
 // the currently stored data is bit-for-bit identical to the data to-be-written.
 // This is intended to provide idempotentency for writes.
 func (s *gcsStorage) setObject(ctx context.Context, objName string, data []byte, cond *gcs.Conditions, contType string, cacheCtl string) error {
-	bkt := s.gcsClient.Bucket(s.bucket)
-	obj := bkt.Object(objName)
-
-	var w *gcs.Writer
-	if cond == nil {
-		w = obj.NewWriter(ctx)
-
-	} else {
-		w = obj.If(*cond).NewWriter(ctx)
-	}
-	w.ObjectAttrs.ContentType = contType
-	w.ObjectAttrs.CacheControl = cacheCtl
-	if _, err := w.Write(data); err != nil {
-		return fmt.Errorf("failed to write object %q to bucket %q: %w", objName, s.bucket, err)
-	}
-
-	if err := w.Close(); err != nil {
+	// Note that if a retried write had in fact succeeded, the retry will fail the precondition and be
+	// handled by the idempotency check below.
+	err := s.retry.Do(ctx, isRetryableWriteError, func() error {
+		return s.writeObject(ctx, objName, data, cond, contType, cacheCtl)
+	})
+	if err != nil {
 		// If we run into a precondition failure error, check that the object
 		// which exists contains the same content that we want to write.
 		// If so, we can consider this write to be idempotently successful.
@@ -781,15 +1222,53 @@ func (s *gcsStorage) setObject(ctx context.Context, objName string, data []byte,
 				return fmt.Errorf("precondition failed: resource content for %q differs from data to-be-written", objName)
 			}
 
+			s.metrics.idempotentWrites.Add(ctx, 1)
 			klog.V(2).Infof("setObject: identical resource already exists for %q, continuing", objName)
 			return nil
 		}
 
-		return fmt.Errorf("failed to close write on %q: %v", objName, err)
+		return fmt.Errorf("failed to write object %q to bucket %q: %w", objName, s.bucket, err)
 	}
 	return nil
 }
 
+// writeObject makes a single attempt to store the provided data in the specified object.
+// Errors from the GCS client are returned unwrapped so that callers can inspect them.
+func (s *gcsStorage) writeObject(ctx context.Context, objName string, data []byte, cond *gcs.Conditions, contType string, cacheCtl string) error {
+	bkt := s.gcsClient.Bucket(s.bucket)
+	obj := bkt.Object(objName)
+
+	var w *gcs.Writer
+	if cond == nil {
+		w = obj.NewWriter(ctx)
+
+	} else {
+		w = obj.If(*cond).NewWriter(ctx)
+	}
+	w.ObjectAttrs.ContentType = contType
+	w.ObjectAttrs.CacheControl = cacheCtl
+	if s.checksums {
+		w.CRC32C = crc32.Checksum(data, crc32cTable)
+		w.SendCRC32C = true
+	}
+	if _, err := w.Write(data); err != nil {
+		_ = w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// isRetryableWriteError returns true if err is a transient failure, i.e. a server error or rate limiting,
+// from which a write may succeed if retried.
+// Precondition failures are not retryable.
+func isRetryableWriteError(err error) bool {
+	var ee *googleapi.Error
+	if !errors.As(err, &ee) {
+		return false
+	}
+	return ee.Code == http.StatusTooManyRequests || ee.Code >= http.StatusInternalServerError
+}
+
 func (s *gcsStorage) lastModified(ctx context.Context, obj string) (time.Time, error) {
 	r, err := s.gcsClient.Bucket(s.bucket).Object(obj).NewReader(ctx)
 	if err != nil {
@@ -798,6 +1277,34 @@ func (s *gcsStorage) lastModified(ctx context.Context, obj string) (time.Time, e
 	return r.Attrs.LastModified, r.Close()
 }
 
+// exists returns true if the specified object exists, using a metadata request rather than fetching its content.
+func (s *gcsStorage) exists(ctx context.Context, obj string) (bool, error) {
+	if _, err := s.gcsClient.Bucket(s.bucket).Object(obj).Attrs(ctx); err != nil {
+		if errors.Is(err, gcs.ErrObjectNotExist) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to get attributes for object %q in bucket %q: %w", obj, s.bucket, err)
+	}
+	return true, nil
+}
+
+// listObjects returns the names of all objects whose name begins with prefix.
+func (s *gcsStorage) listObjects(ctx context.Context, prefix string) ([]string, error) {
+	var r []string
+	it := s.gcsClient.Bucket(s.bucket).Objects(ctx, &gcs.Query{Prefix: prefix})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list objects with prefix %q in bucket %q: %w", prefix, s.bucket, err)
+		}
+		r = append(r, attrs.Name)
+	}
+	return r, nil
+}
+
 // NewDedupe returns wrapped Add func which will use Spanner to maintain a mapping of
 // previously seen entries and their assigned indices. Future calls with the same entry
 // will return the previously assigned index, as yet unseen entries will be passed to the provided
@@ -810,8 +1317,10 @@ func (s *gcsStorage) lastModified(ctx context.Context, obj string) (time.Time, e
 // Note that the storage for this mapping is entirely separate and unconnected to the storage used for
 // maintaining the Merkle tree.
 //
+// By default, entries are rejected if their mapping can't be looked up, see WithDedupeFailurePolicy.
+//
 // This functionality is experimental!
-func NewDedupe(ctx context.Context, spannerDB string, delegate func(ctx context.Context, e *tessera.Entry) tessera.IndexFuture) (func(ctx context.Context, e *tessera.Entry) tessera.IndexFuture, error) {
+func NewDedupe(ctx context.Context, spannerDB string, delegate func(ctx context.Context, e *tessera.Entry) tessera.IndexFuture, opts ...func(*DedupeOptions)) (func(ctx context.Context, e *tessera.Entry) tessera.IndexFuture, error) {
 	/*
 	   Schema for reference:
 
@@ -825,11 +1334,50 @@ func NewDedupe(ctx context.Context, spannerDB string, delegate func(ctx context.
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to Spanner: %v", err)
 	}
+	return newDedupStorage(ctx, dedupDB, delegate, defaultMetrics, opts...).add, nil
+}
+
+// DedupeFailurePolicy determines what happens to an entry when its dedupe mapping can't be looked up.
+type DedupeFailurePolicy int
+
+const (
+	// DedupeFailClosed rejects the entry with the lookup error.
+	//
+	// This is the default, and prevents duplicate entries at the expense of availability.
+	DedupeFailClosed DedupeFailurePolicy = iota
+	// DedupeFailOpen passes the entry on to the delegate as though it had not been seen before.
+	//
+	// This keeps the log available while the dedupe storage is not, but may result in duplicate entries.
+	DedupeFailOpen
+)
+
+// DedupeOptions holds the optional configuration for NewDedupe.
+type DedupeOptions struct {
+	// FailurePolicy determines what happens to an entry when its dedupe mapping can't be looked up.
+	FailurePolicy DedupeFailurePolicy
+}
+
+// WithDedupeFailurePolicy configures what NewDedupe does with an entry when its dedupe mapping can't be looked
+// up in Spanner.
+func WithDedupeFailurePolicy(p DedupeFailurePolicy) func(*DedupeOptions) {
+	return func(o *DedupeOptions) {
+		o.FailurePolicy = p
+	}
+}
 
+// newDedupStorage returns a dedupStorage which uses the provided Spanner client, and starts flushing
+// the mappings it buffers in the background.
+func newDedupStorage(ctx context.Context, dedupDB *spanner.Client, delegate func(ctx context.Context, e *tessera.Entry) tessera.IndexFuture, m *metrics, opts ...func(*DedupeOptions)) *dedupStorage {
+	o := &DedupeOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
 	r := &dedupStorage{
 		ctx:      ctx,
 		dbPool:   dedupDB,
 		delegate: delegate,
+		failOpen: o.FailurePolicy == DedupeFailOpen,
+		metrics:  m,
 	}
 
 	// TODO(al): Make these configurable
@@ -850,13 +1398,16 @@ func NewDedupe(ctx context.Context, spannerDB string, delegate func(ctx context.
 			}
 		}
 	}(ctx)
-	return r.add, nil
+	return r
 }
 
 type dedupStorage struct {
 	ctx      context.Context
 	dbPool   *spanner.Client
 	delegate func(ctx context.Context, e *tessera.Entry) tessera.IndexFuture
+	// failOpen is true if entries should be passed to the delegate when their mapping can't be looked up.
+	failOpen bool
+	metrics  *metrics
 
 	numLookups  atomic.Uint64
 	numWrites   atomic.Uint64
@@ -917,11 +1468,18 @@ type dedupeMapping struct {
 // an IndexFuture will be returned that the client can use to get the sequence number of this entry.
 func (d *dedupStorage) add(ctx context.Context, e *tessera.Entry) tessera.IndexFuture {
 	idx, err := d.index(ctx, e.Identity())
-	if err != nil {
-		return func() (uint64, error) { return 0, err }
-	}
-	if idx != nil {
+	switch {
+	case err != nil:
+		d.metrics.dedupErrors.Add(ctx, 1)
+		if !d.failOpen {
+			return func() (uint64, error) { return 0, err }
+		}
+		klog.Warningf("Failed to look up dedup index, adding entry anyway: %v", err)
+	case idx != nil:
+		d.metrics.dedupHits.Add(ctx, 1)
 		return func() (uint64, error) { return *idx, nil }
+	default:
+		d.metrics.dedupMisses.Add(ctx, 1)
 	}
 
 	i, err := d.delegate(ctx, e)()
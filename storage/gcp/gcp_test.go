@@ -19,21 +19,34 @@ import (
 	"context"
 	"crypto/sha256"
 	"errors"
+	"flag"
 	"fmt"
+	"hash/crc32"
+	"math"
 	"os"
 	"reflect"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"cloud.google.com/go/spanner"
+	"cloud.google.com/go/spanner/apiv1/spannerpb"
 	"cloud.google.com/go/spanner/spannertest"
 	"cloud.google.com/go/spanner/spansql"
 	gcs "cloud.google.com/go/storage"
 	"github.com/google/go-cmp/cmp"
+	"github.com/transparency-dev/formats/log"
+	"github.com/transparency-dev/merkle/compact"
+	"github.com/transparency-dev/merkle/rfc6962"
 	tessera "github.com/transparency-dev/trillian-tessera"
 	"github.com/transparency-dev/trillian-tessera/api"
 	"github.com/transparency-dev/trillian-tessera/api/layout"
+	"github.com/transparency-dev/trillian-tessera/client"
 	storage "github.com/transparency-dev/trillian-tessera/storage/internal"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"golang.org/x/mod/sumdb/note"
 )
 
 func newSpannerDB(t *testing.T) func() {
@@ -47,6 +60,7 @@ func newSpannerDB(t *testing.T) func() {
 			CREATE TABLE SeqCoord (id INT64 NOT NULL, next INT64 NOT NULL,) PRIMARY KEY (id); 
 			CREATE TABLE Seq (id INT64 NOT NULL, seq INT64 NOT NULL, v BYTES(MAX),) PRIMARY KEY (id, seq); 
 			CREATE TABLE IntCoord (id INT64 NOT NULL, seq INT64 NOT NULL, rootHash BYTES(32) NOT NULL,) PRIMARY KEY (id); 
+			CREATE TABLE PubCoord (id INT64 NOT NULL, holder STRING(MAX) NOT NULL, expiresAt TIMESTAMP NOT NULL,) PRIMARY KEY (id); 
 	`)
 	if err != nil {
 		t.Fatalf("Invalid DDL: %v", err)
@@ -64,7 +78,7 @@ func TestSpannerSequencerAssignEntries(t *testing.T) {
 	close := newSpannerDB(t)
 	defer close()
 
-	seq, err := newSpannerSequencer(ctx, "projects/p/instances/i/databases/d", 1000)
+	seq, err := newSpannerSequencer(ctx, "projects/p/instances/i/databases/d", 1000, rfc6962.DefaultHasher.EmptyRoot())
 	if err != nil {
 		t.Fatalf("newSpannerSequencer: %v", err)
 	}
@@ -87,6 +101,46 @@ func TestSpannerSequencerAssignEntries(t *testing.T) {
 	}
 }
 
+var benchmarkSpanner = flag.String("benchmark_spanner", "", "Spanner database resource URI to use for benchmarks, if unset these are skipped")
+
+// BenchmarkSpannerSequencerAssignEntries measures assignEntries with concurrent callers, standing in for
+// multiple frontends, using each of the supported lock hints.
+//
+// The in-memory spannertest server does not model locking, so this needs a real Spanner database whose
+// schema has already been created, e.g.:
+//
+//	go test ./storage/gcp -run XXX -bench . --benchmark_spanner=projects/p/instances/i/databases/d
+func BenchmarkSpannerSequencerAssignEntries(b *testing.B) {
+	if *benchmarkSpanner == "" {
+		b.Skip("--benchmark_spanner not set, skipping benchmark")
+	}
+	ctx := context.Background()
+	for _, test := range []struct {
+		name     string
+		lockHint spannerpb.ReadRequest_LockHint
+	}{
+		{name: "exclusive", lockHint: spannerpb.ReadRequest_LOCK_HINT_EXCLUSIVE},
+		{name: "shared", lockHint: spannerpb.ReadRequest_LOCK_HINT_SHARED},
+	} {
+		b.Run(test.name, func(b *testing.B) {
+			seq, err := newSpannerSequencer(ctx, *benchmarkSpanner, math.MaxInt64, rfc6962.DefaultHasher.EmptyRoot())
+			if err != nil {
+				b.Fatalf("newSpannerSequencer: %v", err)
+			}
+			seq.lockHint = test.lockHint
+
+			b.SetParallelism(4)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if err := seq.assignEntries(ctx, []*tessera.Entry{tessera.NewEntry([]byte("entry"))}); err != nil {
+						b.Errorf("assignEntries: %v", err)
+					}
+				}
+			})
+		})
+	}
+}
 func TestSpannerSequencerPushback(t *testing.T) {
 	ctx := context.Background()
 
@@ -117,7 +171,7 @@ func TestSpannerSequencerPushback(t *testing.T) {
 			close := newSpannerDB(t)
 			defer close()
 
-			seq, err := newSpannerSequencer(ctx, "projects/p/instances/i/databases/d", test.threshold)
+			seq, err := newSpannerSequencer(ctx, "projects/p/instances/i/databases/d", test.threshold, rfc6962.DefaultHasher.EmptyRoot())
 			if err != nil {
 				t.Fatalf("newSpannerSequencer: %v", err)
 			}
@@ -142,12 +196,121 @@ func TestSpannerSequencerPushback(t *testing.T) {
 	}
 }
 
+func TestSpannerSequencerCleanupSeq(t *testing.T) {
+	ctx := context.Background()
+	close := newSpannerDB(t)
+	defer close()
+
+	seq, err := newSpannerSequencer(ctx, "projects/p/instances/i/databases/d", 1000, rfc6962.DefaultHasher.EmptyRoot())
+	if err != nil {
+		t.Fatalf("newSpannerSequencer: %v", err)
+	}
+	// Simulate batches at 0 and 10 having been integrated without being removed from Seq, with
+	// a further batch at 20 still awaiting integration.
+	if _, err := seq.dbPool.Apply(ctx, []*spanner.Mutation{
+		spanner.Insert("Seq", []string{"id", "seq", "v"}, []interface{}{0, 0, []byte{}}),
+		spanner.Insert("Seq", []string{"id", "seq", "v"}, []interface{}{0, 10, []byte{}}),
+		spanner.Insert("Seq", []string{"id", "seq", "v"}, []interface{}{0, 20, []byte{}}),
+		spanner.Update("IntCoord", []string{"id", "seq"}, []interface{}{0, 20}),
+	}); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		n, err := seq.cleanupSeq(ctx)
+		if err != nil {
+			t.Fatalf("cleanupSeq: %v", err)
+		}
+		if n != 1 {
+			t.Errorf("cleanupSeq: got %d remaining rows, want 1", n)
+		}
+	}
+	if _, err := seq.dbPool.Single().ReadRow(ctx, "Seq", spanner.Key{0, 20}, []string{"seq"}); err != nil {
+		t.Errorf("Unintegrated batch was removed: %v", err)
+	}
+}
+
+func TestInspectCoordination(t *testing.T) {
+	ctx := context.Background()
+	close := newSpannerDB(t)
+	defer close()
+
+	const db = "projects/p/instances/i/databases/d"
+	seq, err := newSpannerSequencer(ctx, db, 1000, rfc6962.DefaultHasher.EmptyRoot())
+	if err != nil {
+		t.Fatalf("newSpannerSequencer: %v", err)
+	}
+	root := sha256.Sum256([]byte("root"))
+	// Simulate a stale batch at 0 left behind after integrating to 10, with batches at 10 and 15
+	// awaiting integration.
+	if _, err := seq.dbPool.Apply(ctx, []*spanner.Mutation{
+		spanner.Insert("Seq", []string{"id", "seq", "v"}, []interface{}{0, 0, []byte{}}),
+		spanner.Insert("Seq", []string{"id", "seq", "v"}, []interface{}{0, 10, []byte{}}),
+		spanner.Insert("Seq", []string{"id", "seq", "v"}, []interface{}{0, 15, []byte{}}),
+		spanner.Update("SeqCoord", []string{"id", "next"}, []interface{}{0, 20}),
+		spanner.Update("IntCoord", []string{"id", "seq", "rootHash"}, []interface{}{0, 10, root[:]}),
+	}); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+
+	got, err := InspectCoordination(ctx, db)
+	if err != nil {
+		t.Fatalf("InspectCoordination: %v", err)
+	}
+	want := CoordinationState{
+		Next:           20,
+		Integrated:     10,
+		RootHash:       root[:],
+		PendingBatches: 2,
+		StaleBatches:   1,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("InspectCoordination diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestSpannerSequencerPublisherLease(t *testing.T) {
+	ctx := context.Background()
+	close := newSpannerDB(t)
+	defer close()
+
+	seq, err := newSpannerSequencer(ctx, "projects/p/instances/i/databases/d", 1000, rfc6962.DefaultHasher.EmptyRoot())
+	if err != nil {
+		t.Fatalf("newSpannerSequencer: %v", err)
+	}
+
+	const ttl = 500 * time.Millisecond
+	for _, step := range []struct {
+		holder string
+		sleep  time.Duration
+		want   bool
+	}{
+		{holder: "a", want: true},
+		{holder: "b", want: false},
+		// The holder can renew its lease.
+		{holder: "a", want: true},
+		{holder: "b", want: false},
+		// Once the lease has expired, another instance can take over.
+		{holder: "b", sleep: ttl, want: true},
+		{holder: "a", want: false},
+	} {
+		time.Sleep(step.sleep)
+		got, err := seq.publisherLease(ctx, step.holder, ttl)
+		if err != nil {
+			t.Fatalf("publisherLease(%q): %v", step.holder, err)
+		}
+		if got != step.want {
+			t.Errorf("publisherLease(%q) = %t, want %t", step.holder, got, step.want)
+		}
+	}
+}
+
 func TestSpannerSequencerRoundTrip(t *testing.T) {
 	ctx := context.Background()
 	close := newSpannerDB(t)
 	defer close()
 
-	s, err := newSpannerSequencer(ctx, "projects/p/instances/i/databases/d", 1000)
+	s, err := newSpannerSequencer(ctx, "projects/p/instances/i/databases/d", 1000, rfc6962.DefaultHasher.EmptyRoot())
 	if err != nil {
 		t.Fatalf("newSpannerSequencer: %v", err)
 	}
@@ -208,6 +371,7 @@ func TestTileRoundtrip(t *testing.T) {
 	ctx := context.Background()
 	m := newMemObjStore()
 	s := &Storage{
+		metrics:  defaultMetrics,
 		objStore: m,
 	}
 
@@ -249,6 +413,50 @@ func TestTileRoundtrip(t *testing.T) {
 	}
 }
 
+func TestResourceExists(t *testing.T) {
+	ctx := context.Background()
+	m := newMemObjStore()
+	var s tessera.ResourceExistenceChecker = &Storage{
+		metrics:     defaultMetrics,
+		objStore:    m,
+		entriesPath: layout.EntriesPath,
+	}
+	m.mem[layout.TilePath(1, 2, 0)] = []byte("tile")
+	m.mem[layout.EntriesPath(3, 4)] = []byte("bundle")
+
+	for _, test := range []struct {
+		name   string
+		exists func() (bool, error)
+		want   bool
+	}{
+		{
+			name:   "tile present",
+			exists: func() (bool, error) { return s.TileExists(ctx, 1, 2, 0) },
+			want:   true,
+		}, {
+			name:   "tile missing",
+			exists: func() (bool, error) { return s.TileExists(ctx, 1, 2, 5) },
+		}, {
+			name:   "bundle present",
+			exists: func() (bool, error) { return s.EntryBundleExists(ctx, 3, 4) },
+			want:   true,
+		}, {
+			name:   "bundle missing",
+			exists: func() (bool, error) { return s.EntryBundleExists(ctx, 3, 0) },
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := test.exists()
+			if err != nil {
+				t.Fatalf("exists: %v", err)
+			}
+			if got != test.want {
+				t.Errorf("exists = %t, want %t", got, test.want)
+			}
+		})
+	}
+}
+
 func makeBundle(t *testing.T, size uint64) []byte {
 	t.Helper()
 	r := &bytes.Buffer{}
@@ -265,6 +473,7 @@ func TestBundleRoundtrip(t *testing.T) {
 	ctx := context.Background()
 	m := newMemObjStore()
 	s := &Storage{
+		metrics:     defaultMetrics,
 		objStore:    m,
 		entriesPath: layout.EntriesPath,
 	}
@@ -305,22 +514,565 @@ func TestBundleRoundtrip(t *testing.T) {
 	}
 }
 
+func TestResourceSignatures(t *testing.T) {
+	ctx := context.Background()
+	const origin = "example.com/log/testdata"
+	skey, vkey, err := note.GenerateKey(nil, origin)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	signer, err := note.NewSigner(skey)
+	if err != nil {
+		t.Fatalf("NewSigner: %v", err)
+	}
+	verifier, err := note.NewVerifier(vkey)
+	if err != nil {
+		t.Fatalf("NewVerifier: %v", err)
+	}
+
+	m := newMemObjStore()
+	opt, err := storage.ResolveStorageOptions(tessera.WithCheckpointSigner(signer), tessera.WithResourceSignatures())
+	if err != nil {
+		t.Fatalf("ResolveStorageOptions: %v", err)
+	}
+	s := &Storage{
+		metrics:        defaultMetrics,
+		objStore:       m,
+		entriesPath:    layout.EntriesPath,
+		newResourceSig: storage.ResourceSigner(opt),
+	}
+
+	if err := s.setTile(ctx, 0, 0, 20, makeTile(t, 20)); err != nil {
+		t.Fatalf("setTile: %v", err)
+	}
+	if err := s.setEntryBundle(ctx, 0, 20, makeBundle(t, 20)); err != nil {
+		t.Fatalf("setEntryBundle: %v", err)
+	}
+
+	for _, path := range []string{layout.TilePath(0, 0, 20), layout.EntriesPath(0, 20)} {
+		data, ok := m.mem[path]
+		if !ok {
+			t.Fatalf("want resource at %v but found none", path)
+		}
+		sig, ok := m.mem[layout.SignaturePath(path)]
+		if !ok {
+			t.Fatalf("want signature for %v but found none", path)
+		}
+		if err := client.VerifyResourceSignature(path, data, sig, origin, verifier); err != nil {
+			t.Errorf("VerifyResourceSignature(%q): %v", path, err)
+		}
+	}
+}
+
+func TestGrowPartialBundle(t *testing.T) {
+	ctx := context.Background()
+	m := newMemObjStore()
+	s := &Storage{
+		metrics:     defaultMetrics,
+		objStore:    m,
+		entriesPath: layout.EntriesPath,
+		hasher:      rfc6962.DefaultHasher,
+	}
+
+	var want bytes.Buffer
+	seq := uint64(0)
+	// Grow the first bundle from 10 to 100 entries, then fill it and start the next.
+	for _, n := range []uint64{10, 90, layout.EntryBundleWidth - 100 + 5} {
+		entries := []storage.SequencedEntry{}
+		for i := uint64(0); i < n; i++ {
+			e := tessera.NewEntry([]byte(fmt.Sprintf("entry %d", seq+i)))
+			entries = append(entries, storage.SequencedEntry{BundleData: e.MarshalBundleData(seq + i), LeafHash: e.LeafHash()})
+			want.Write(e.MarshalBundleData(seq + i))
+		}
+		if _, err := s.integrate(ctx, seq, entries); err != nil {
+			t.Fatalf("integrate(%d, %d entries): %v", seq, n, err)
+		}
+		seq += n
+	}
+
+	// Each size of the partial bundle is stored separately, and each is a prefix of the next.
+	var prev []byte
+	for _, p := range []uint8{10, 100, 0} {
+		got, err := s.getEntryBundle(ctx, 0, p)
+		if err != nil {
+			t.Fatalf("getEntryBundle(0, %d): %v", p, err)
+		}
+		if !bytes.HasPrefix(got, prev) {
+			t.Errorf("bundle 0.p/%d is not an extension of the previous partial bundle", p)
+		}
+		prev = got
+	}
+	if want := want.Bytes()[:len(prev)]; !bytes.Equal(prev, want) {
+		t.Error("full bundle 0 has unexpected content")
+	}
+	if _, err := s.getEntryBundle(ctx, 1, 5); err != nil {
+		t.Errorf("getEntryBundle(1, 5): %v", err)
+	}
+
+	// Rewriting a partial bundle with divergent content must fail.
+	if err := s.setEntryBundle(ctx, 0, 10, []byte("divergent")); err == nil {
+		t.Error("setEntryBundle with divergent content succeeded, want error")
+	}
+}
+
+// unavailableSequencer is a sequencer whose database is unreachable.
+//
+// Only publisherLease is implemented, so any other use of the sequencer panics; this lets tests assert
+// that code paths such as reads never touch the coordination database.
+type unavailableSequencer struct{ sequencer }
+
+func (unavailableSequencer) publisherLease(_ context.Context, _ string, _ time.Duration) (bool, error) {
+	return false, errors.New("Spanner unavailable")
+}
+
+var concurrencySpanner = flag.String("concurrency_spanner", "", "Spanner database resource URI to use for TestConcurrentFrontends, if unset it's skipped. ALL DATA IN THIS DATABASE WILL BE DELETED.")
+
+// TestConcurrentFrontends runs several Storage instances, standing in for frontends, against a single
+// Spanner database and bucket, and checks that the entries they add concurrently are sequenced and
+// integrated into a single coherent log, with a single consistent progression of published checkpoints.
+//
+// The in-memory spannertest server does not model locking, so this needs a Spanner database whose
+// schema has already been created, e.g. one provided by the Cloud Spanner emulator:
+//
+//	SPANNER_EMULATOR_HOST=localhost:9010 go test ./storage/gcp -run TestConcurrentFrontends --concurrency_spanner=projects/p/instances/i/databases/d
+func TestConcurrentFrontends(t *testing.T) {
+	if *concurrencySpanner == "" {
+		t.Skip("--concurrency_spanner not set, skipping test")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Start from an empty log.
+	db, err := spanner.NewClient(ctx, *concurrencySpanner)
+	if err != nil {
+		t.Fatalf("spanner.NewClient: %v", err)
+	}
+	ms := []*spanner.Mutation{}
+	for _, table := range []string{"SeqCoord", "Seq", "IntCoord", "PubCoord"} {
+		ms = append(ms, spanner.Delete(table, spanner.AllKeys()))
+	}
+	if _, err := db.Apply(ctx, ms); err != nil {
+		t.Fatalf("failed to clear tables: %v", err)
+	}
+	db.Close()
+
+	const (
+		origin       = "example.com/log/concurrent"
+		numFrontends = 3
+		numBatches   = 20
+		batchSize    = 17
+		total        = numFrontends * numBatches * batchSize
+	)
+	skey, vkey, err := note.GenerateKey(nil, origin)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	signer, err := note.NewSigner(skey)
+	if err != nil {
+		t.Fatalf("NewSigner: %v", err)
+	}
+	verifier, err := note.NewVerifier(vkey)
+	if err != nil {
+		t.Fatalf("NewVerifier: %v", err)
+	}
+	opt, err := storage.ResolveStorageOptions(tessera.WithCheckpointSigner(signer))
+	if err != nil {
+		t.Fatalf("ResolveStorageOptions: %v", err)
+	}
+
+	m := newMemObjStore()
+	frontends := make([]*Storage, numFrontends)
+	for i := range frontends {
+		seq, err := newSpannerSequencer(ctx, *concurrencySpanner, math.MaxInt64, opt.Hasher.EmptyRoot())
+		if err != nil {
+			t.Fatalf("newSpannerSequencer: %v", err)
+		}
+		frontends[i] = &Storage{
+			metrics:        defaultMetrics,
+			objStore:       m,
+			sequencer:      seq,
+			newCP:          opt.NewCP,
+			entriesPath:    opt.EntriesPath,
+			hasher:         opt.Hasher,
+			electPublisher: true,
+			publisherID:    newPublisherID(),
+			publisherLease: time.Second,
+		}
+	}
+
+	// Each frontend integrates and, if it holds the publisher lease, publishes checkpoints in the background.
+	bg := sync.WaitGroup{}
+	for _, s := range frontends {
+		bg.Add(1)
+		go func() {
+			defer bg.Done()
+			for ctx.Err() == nil {
+				if _, err := s.sequencer.consumeEntries(ctx, 64, s.integrate, false); err != nil && ctx.Err() == nil {
+					t.Errorf("consumeEntries: %v", err)
+				}
+				if err := s.publishCheckpoint(ctx, 0); err != nil && ctx.Err() == nil {
+					t.Errorf("publishCheckpoint: %v", err)
+				}
+				time.Sleep(time.Millisecond)
+			}
+		}()
+	}
+
+	// Record each distinct checkpoint seen in the bucket.
+	var (
+		cpMu sync.Mutex
+		cps  []log.Checkpoint
+	)
+	bg.Add(1)
+	go func() {
+		defer bg.Done()
+		var last []byte
+		for ctx.Err() == nil {
+			raw, _, err := m.getObject(ctx, layout.CheckpointPath)
+			if err == nil && !bytes.Equal(raw, last) {
+				cp, _, _, err := log.ParseCheckpoint(raw, origin, verifier)
+				if err != nil {
+					t.Errorf("ParseCheckpoint: %v", err)
+					return
+				}
+				cpMu.Lock()
+				cps = append(cps, *cp)
+				cpMu.Unlock()
+				last = raw
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	// Add entries concurrently via all of the frontends.
+	var (
+		addMu   sync.Mutex
+		byIndex = make(map[uint64][]byte)
+	)
+	adders := sync.WaitGroup{}
+	for f, s := range frontends {
+		adders.Add(1)
+		go func() {
+			defer adders.Done()
+			for b := 0; b < numBatches; b++ {
+				entries := make([]*tessera.Entry, batchSize)
+				for i := range entries {
+					entries[i] = tessera.NewEntry([]byte(fmt.Sprintf("frontend %d batch %d entry %d", f, b, i)))
+				}
+				if err := s.sequencer.assignEntries(ctx, entries); err != nil {
+					t.Errorf("assignEntries: %v", err)
+					return
+				}
+				addMu.Lock()
+				for _, e := range entries {
+					idx := *e.Index()
+					if _, ok := byIndex[idx]; ok {
+						t.Errorf("index %d assigned more than once", idx)
+					}
+					byIndex[idx] = e.Data()
+				}
+				addMu.Unlock()
+			}
+		}()
+	}
+	adders.Wait()
+	if t.Failed() {
+		cancel()
+		bg.Wait()
+		t.FailNow()
+	}
+
+	// Wait for a checkpoint covering all of the entries to be published.
+	for deadline := time.Now().Add(30 * time.Second); ; {
+		cpMu.Lock()
+		done := len(cps) > 0 && cps[len(cps)-1].Size == total
+		cpMu.Unlock()
+		if done {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for checkpoint of size %d", total)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	bg.Wait()
+
+	// There must be no gaps in the assigned indices, and each entry must be stored at its index.
+	leafHashes := make([][]byte, total)
+	for i := uint64(0); i < total; i++ {
+		data, ok := byIndex[i]
+		if !ok {
+			t.Fatalf("index %d was not assigned", i)
+		}
+		leafHashes[i] = opt.Hasher.HashLeaf(data)
+		if i%layout.EntryBundleWidth == 0 {
+			raw, err := frontends[0].getEntryBundle(context.Background(), i/layout.EntryBundleWidth, layout.PartialTileSize(0, i/layout.EntryBundleWidth, total))
+			if err != nil {
+				t.Fatalf("getEntryBundle(%d): %v", i/layout.EntryBundleWidth, err)
+			}
+			eb := api.EntryBundle{}
+			if err := eb.UnmarshalText(raw); err != nil {
+				t.Fatalf("UnmarshalText: %v", err)
+			}
+			for j, got := range eb.Entries {
+				if want := byIndex[i+uint64(j)]; !bytes.Equal(got, want) {
+					t.Errorf("entry %d = %q, want %q", i+uint64(j), got, want)
+				}
+			}
+		}
+	}
+
+	// The published checkpoints must never go backwards, and each must commit to the expected tree.
+	rf := &compact.RangeFactory{Hash: opt.Hasher.HashChildren}
+	cr := rf.NewEmptyRange(0)
+	for i, cp := range cps {
+		if i > 0 && cp.Size < cps[i-1].Size {
+			t.Errorf("checkpoint size went backwards from %d to %d", cps[i-1].Size, cp.Size)
+			continue
+		}
+		for cr.End() < cp.Size {
+			if err := cr.Append(leafHashes[cr.End()], nil); err != nil {
+				t.Fatalf("Append: %v", err)
+			}
+		}
+		want := opt.Hasher.EmptyRoot()
+		if cp.Size > 0 {
+			if want, err = cr.GetRootHash(nil); err != nil {
+				t.Fatalf("GetRootHash: %v", err)
+			}
+		}
+		if !bytes.Equal(cp.Hash, want) {
+			t.Errorf("checkpoint for size %d has root %x, want %x", cp.Size, cp.Hash, want)
+		}
+	}
+}
+
+func TestWithMetricFactory(t *testing.T) {
+	ctx := context.Background()
+	reader := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+
+	opt, err := storage.ResolveStorageOptions(tessera.WithMetricFactory(mp))
+	if err != nil {
+		t.Fatalf("ResolveStorageOptions: %v", err)
+	}
+	m, err := metricsFor(opt)
+	if err != nil {
+		t.Fatalf("metricsFor: %v", err)
+	}
+	s := &Storage{metrics: m}
+	s.PausePublishing()
+
+	rm := metricdata.ResourceMetrics{}
+	if err := reader.Collect(ctx, &rm); err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name == "tessera.storage.publishing_paused" {
+				return
+			}
+		}
+	}
+	t.Error("tessera.storage.publishing_paused not recorded with the provided MeterProvider")
+}
+
+func TestHoldsPublisherLeaseFallback(t *testing.T) {
+	s := &Storage{
+		metrics:        defaultMetrics,
+		sequencer:      unavailableSequencer{},
+		electPublisher: true,
+		publisherID:    newPublisherID(),
+	}
+	if !s.holdsPublisherLease(context.Background()) {
+		t.Error("holdsPublisherLease: got false with unavailable lease, want true")
+	}
+}
+
+func TestReadsWithoutSequencer(t *testing.T) {
+	ctx := context.Background()
+	for _, test := range []struct {
+		name          string
+		withReadStore bool
+	}{
+		{
+			name: "object store",
+		}, {
+			name:          "separate read store",
+			withReadStore: true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			w := newMemObjStore()
+			s := &Storage{
+				metrics:     defaultMetrics,
+				objStore:    w,
+				sequencer:   unavailableSequencer{},
+				entriesPath: layout.EntriesPath,
+			}
+			want := "write"
+			if test.withReadStore {
+				r := newMemObjStore()
+				s.readStore = r
+				want = "read"
+				for _, path := range []string{layout.CheckpointPath, layout.TilePath(0, 0, 0), layout.EntriesPath(0, 0)} {
+					if err := r.setObject(ctx, path, []byte("read"), nil, "", ""); err != nil {
+						t.Fatalf("setObject(%q): %v", path, err)
+					}
+				}
+			}
+			for _, path := range []string{layout.CheckpointPath, layout.TilePath(0, 0, 0), layout.EntriesPath(0, 0)} {
+				if err := w.setObject(ctx, path, []byte("write"), nil, "", ""); err != nil {
+					t.Fatalf("setObject(%q): %v", path, err)
+				}
+			}
+
+			if got, err := s.ReadCheckpoint(ctx); err != nil || string(got) != want {
+				t.Errorf("ReadCheckpoint: got (%q, %v), want %q", got, err, want)
+			}
+			if got, err := s.ReadTile(ctx, 0, 0, 0); err != nil || string(got) != want {
+				t.Errorf("ReadTile: got (%q, %v), want %q", got, err, want)
+			}
+			if got, err := s.ReadEntryBundle(ctx, 0, 0); err != nil || string(got) != want {
+				t.Errorf("ReadEntryBundle: got (%q, %v), want %q", got, err, want)
+			}
+			// Integration must always use the write store.
+			if got, err := s.getEntryBundle(ctx, 0, 0); err != nil || string(got) != "write" {
+				t.Errorf("getEntryBundle: got (%q, %v), want %q", got, err, "write")
+			}
+		})
+	}
+}
+
+func TestReadCache(t *testing.T) {
+	ctx := context.Background()
+	m := newMemObjStore()
+	s := &Storage{
+		metrics:     defaultMetrics,
+		objStore:    m,
+		readCache:   storage.NewReadCache(1<<20, 0),
+		sequencer:   unavailableSequencer{},
+		entriesPath: layout.EntriesPath,
+	}
+	for _, data := range []string{"one", "two"} {
+		for _, path := range []string{layout.CheckpointPath, layout.TilePath(0, 0, 0), layout.EntriesPath(0, 0)} {
+			if err := m.setObject(ctx, path, []byte(data), nil, "", ""); err != nil {
+				t.Fatalf("setObject(%q): %v", path, err)
+			}
+		}
+
+		// The checkpoint must always be read from the object store.
+		if got, err := s.ReadCheckpoint(ctx); err != nil || string(got) != data {
+			t.Errorf("ReadCheckpoint: got (%q, %v), want %q", got, err, data)
+		}
+		// Tiles and bundles are immutable, so the first version read should continue to be served.
+		if got, err := s.ReadTile(ctx, 0, 0, 0); err != nil || string(got) != "one" {
+			t.Errorf("ReadTile: got (%q, %v), want %q", got, err, "one")
+		}
+		if got, err := s.ReadEntryBundle(ctx, 0, 0); err != nil || string(got) != "one" {
+			t.Errorf("ReadEntryBundle: got (%q, %v), want %q", got, err, "one")
+		}
+	}
+}
+
+func TestInitPublishesCheckpoint(t *testing.T) {
+	ctx := context.Background()
+
+	close := newSpannerDB(t)
+	defer close()
+
+	seq, err := newSpannerSequencer(ctx, "projects/p/instances/i/databases/d", 1000, rfc6962.DefaultHasher.EmptyRoot())
+	if err != nil {
+		t.Fatalf("newSpannerSequencer: %v", err)
+	}
+	s := &Storage{
+		metrics:     defaultMetrics,
+		objStore:    newMemObjStore(),
+		sequencer:   seq,
+		entriesPath: layout.EntriesPath,
+		hasher:      rfc6962.DefaultHasher,
+		newCP:       func(size uint64, hash []byte) ([]byte, error) { return []byte(fmt.Sprintf("%d/%x", size, hash)), nil },
+	}
+	if err := s.init(ctx); err != nil {
+		t.Fatalf("init: %v", err)
+	}
+
+	// The checkpoint for the empty tree should be available as soon as init returns.
+	got, err := s.ReadCheckpoint(ctx)
+	if err != nil {
+		t.Fatalf("ReadCheckpoint: %v", err)
+	}
+	if want := fmt.Sprintf("0/%x", rfc6962.DefaultHasher.EmptyRoot()); string(got) != want {
+		t.Errorf("ReadCheckpoint: got %q, want %q", got, want)
+	}
+}
+
+func TestIntegratedSize(t *testing.T) {
+	ctx := context.Background()
+
+	close := newSpannerDB(t)
+	defer close()
+
+	seq, err := newSpannerSequencer(ctx, "projects/p/instances/i/databases/d", 1000, rfc6962.DefaultHasher.EmptyRoot())
+	if err != nil {
+		t.Fatalf("newSpannerSequencer: %v", err)
+	}
+	s := &Storage{
+		metrics:     defaultMetrics,
+		objStore:    newMemObjStore(),
+		sequencer:   seq,
+		entriesPath: layout.EntriesPath,
+		hasher:      rfc6962.DefaultHasher,
+		newCP:       func(size uint64, hash []byte) ([]byte, error) { return []byte(fmt.Sprintf("%d/%x", size, hash)), nil },
+	}
+	if err := s.init(ctx); err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	if got, err := s.IntegratedSize(ctx); err != nil || got != 0 {
+		t.Errorf("IntegratedSize: got (%d, %v), want 0", got, err)
+	}
+
+	entries := []*tessera.Entry{}
+	for i := 0; i < 10; i++ {
+		entries = append(entries, tessera.NewEntry([]byte(fmt.Sprintf("item %d", i))))
+	}
+	if err := seq.assignEntries(ctx, entries); err != nil {
+		t.Fatalf("assignEntries: %v", err)
+	}
+	if _, err := seq.consumeEntries(ctx, DefaultIntegrationSizeLimit, s.integrate, false); err != nil {
+		t.Fatalf("consumeEntries: %v", err)
+	}
+
+	// The integrated size should be visible even though no new checkpoint has been published.
+	if got, err := s.IntegratedSize(ctx); err != nil || got != 10 {
+		t.Errorf("IntegratedSize: got (%d, %v), want 10", got, err)
+	}
+	if got, err := s.ReadCheckpoint(ctx); err != nil || !strings.HasPrefix(string(got), "0/") {
+		t.Errorf("ReadCheckpoint: got (%q, %v), want checkpoint for size 0", got, err)
+	}
+}
+
 func TestPublishCheckpoint(t *testing.T) {
 	ctx := context.Background()
 
 	close := newSpannerDB(t)
 	defer close()
 
-	s, err := newSpannerSequencer(ctx, "projects/p/instances/i/databases/d", 1000)
+	s, err := newSpannerSequencer(ctx, "projects/p/instances/i/databases/d", 1000, rfc6962.DefaultHasher.EmptyRoot())
 	if err != nil {
 		t.Fatalf("newSpannerSequencer: %v", err)
 	}
 
 	for _, test := range []struct {
-		name            string
-		cpModifiedAt    time.Time
-		publishInterval time.Duration
-		wantUpdate      bool
+		name                string
+		cpModifiedAt        time.Time
+		publishInterval     time.Duration
+		publishOnlyOnChange bool
+		republishInterval   time.Duration
+		pausePublishing     bool
+		resumePublishing    bool
+		wantUpdate          bool
 	}{
 		{
 			name:            "works ok",
@@ -332,25 +1084,70 @@ func TestPublishCheckpoint(t *testing.T) {
 			cpModifiedAt:    time.Now().Add(-5 * time.Second),
 			publishInterval: 10 * time.Second,
 			wantUpdate:      false,
+		}, {
+			name:                "publish only on change, size unchanged",
+			cpModifiedAt:        time.Now().Add(-15 * time.Second),
+			publishInterval:     10 * time.Second,
+			publishOnlyOnChange: true,
+			wantUpdate:          false,
+		}, {
+			name:                "publish only on change, size unchanged, republish interval not reached",
+			cpModifiedAt:        time.Now().Add(-15 * time.Second),
+			publishInterval:     10 * time.Second,
+			publishOnlyOnChange: true,
+			republishInterval:   time.Minute,
+			wantUpdate:          false,
+		}, {
+			name:                "publish only on change, size unchanged, republish interval reached",
+			cpModifiedAt:        time.Now().Add(-15 * time.Second),
+			publishInterval:     10 * time.Second,
+			publishOnlyOnChange: true,
+			republishInterval:   12 * time.Second,
+			wantUpdate:          true,
+		}, {
+			name:            "publishing paused, skip update",
+			cpModifiedAt:    time.Now().Add(-15 * time.Second),
+			publishInterval: 10 * time.Second,
+			pausePublishing: true,
+			wantUpdate:      false,
+		}, {
+			name:             "publishing resumed",
+			cpModifiedAt:     time.Now().Add(-15 * time.Second),
+			publishInterval:  10 * time.Second,
+			pausePublishing:  true,
+			resumePublishing: true,
+			wantUpdate:       true,
 		},
 	} {
 		t.Run(test.name, func(t *testing.T) {
 			m := newMemObjStore()
 			storage := &Storage{
+				metrics:     defaultMetrics,
 				objStore:    m,
 				sequencer:   s,
 				entriesPath: layout.EntriesPath,
+				hasher:      rfc6962.DefaultHasher,
 				newCP:       func(size uint64, hash []byte) ([]byte, error) { return []byte(fmt.Sprintf("%d/%x,", size, hash)), nil },
 			}
+			storage.publishPolicy.OnlyOnChange = test.publishOnlyOnChange
+			storage.publishPolicy.RepublishInterval = test.republishInterval
 			// Call init so we've got a zero-sized checkpoint to work with.
 			if err := storage.init(ctx); err != nil {
 				t.Fatalf("storage.init: %v", err)
 			}
+			// Pretend we've already published a checkpoint for the current tree.
+			storage.publishPolicy.Published(0)
 			cpOld := []byte("bananas")
 			if err := m.setObject(ctx, layout.CheckpointPath, cpOld, nil, "", ""); err != nil {
 				t.Fatalf("setObject(bananas): %v", err)
 			}
 			m.lMod = test.cpModifiedAt
+			if test.pausePublishing {
+				storage.PausePublishing()
+			}
+			if test.resumePublishing {
+				storage.ResumePublishing()
+			}
 			if err := storage.publishCheckpoint(ctx, test.publishInterval); err != nil {
 				t.Fatalf("publishCheckpoint: %v", err)
 			}
@@ -414,3 +1211,285 @@ func (m *memObjStore) setObject(_ context.Context, obj string, data []byte, cond
 func (m *memObjStore) lastModified(_ context.Context, obj string) (time.Time, error) {
 	return m.lMod, nil
 }
+
+func (m *memObjStore) exists(_ context.Context, obj string) (bool, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	_, ok := m.mem[obj]
+	return ok, nil
+}
+
+func (m *memObjStore) listObjects(_ context.Context, prefix string) ([]string, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	r := []string{}
+	for k := range m.mem {
+		if strings.HasPrefix(k, prefix) {
+			r = append(r, k)
+		}
+	}
+	return r, nil
+}
+
+func TestRecover(t *testing.T) {
+	ctx := context.Background()
+	const origin = "example.com/test/log"
+
+	close := newSpannerDB(t)
+	defer close()
+
+	seq, err := newSpannerSequencer(ctx, "projects/p/instances/i/databases/d", 1000, rfc6962.DefaultHasher.EmptyRoot())
+	if err != nil {
+		t.Fatalf("newSpannerSequencer: %v", err)
+	}
+	m := newMemObjStore()
+	s := &Storage{
+		metrics:     defaultMetrics,
+		objStore:    m,
+		sequencer:   seq,
+		entriesPath: layout.EntriesPath,
+		hasher:      rfc6962.DefaultHasher,
+	}
+	integrate := func(from, num uint64) []byte {
+		t.Helper()
+		entries := []storage.SequencedEntry{}
+		for i := from; i < from+num; i++ {
+			e := tessera.NewEntry([]byte(fmt.Sprintf("entry %d", i)))
+			entries = append(entries, storage.SequencedEntry{BundleData: e.MarshalBundleData(i), LeafHash: e.LeafHash()})
+		}
+		root, err := s.integrate(ctx, from, entries)
+		if err != nil {
+			t.Fatalf("integrate: %v", err)
+		}
+		return root
+	}
+
+	skey, vkey, err := note.GenerateKey(nil, origin)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	signer, err := note.NewSigner(skey)
+	if err != nil {
+		t.Fatalf("NewSigner: %v", err)
+	}
+	verifier, err := note.NewVerifier(vkey)
+	if err != nil {
+		t.Fatalf("NewVerifier: %v", err)
+	}
+
+	// Publish a checkpoint part way through the log, and then integrate some more entries
+	// whose tree state was never committed to.
+	cpRoot := integrate(0, 300)
+	cpRaw, err := note.Sign(&note.Note{Text: string(log.Checkpoint{Origin: origin, Size: 300, Hash: cpRoot}.Marshal())}, signer)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	if err := m.setObject(ctx, layout.CheckpointPath, cpRaw, nil, "", ""); err != nil {
+		t.Fatalf("setObject: %v", err)
+	}
+	wantRoot := integrate(300, 150)
+
+	// Leave some stale sequenced entries behind which should be discarded.
+	if err := seq.assignEntries(ctx, []*tessera.Entry{tessera.NewEntry([]byte("stale"))}); err != nil {
+		t.Fatalf("assignEntries: %v", err)
+	}
+
+	// Without a way to compute leaf hashes, the entries beyond the checkpoint can't be integrated.
+	if _, _, err := s.recoverTree(ctx, origin, verifier, nil); err == nil {
+		t.Error("recoverTree succeeded with entries beyond checkpoint and no LeafHashesFunc, want error")
+	}
+
+	size, root, err := s.recoverTree(ctx, origin, verifier, NewEntryLeafHashes(rfc6962.DefaultHasher))
+	if err != nil {
+		t.Fatalf("recoverTree: %v", err)
+	}
+	if got, want := size, uint64(450); got != want {
+		t.Errorf("got size %d, want %d", got, want)
+	}
+	if !bytes.Equal(root, wantRoot) {
+		t.Errorf("got root %x, want %x", root, wantRoot)
+	}
+
+	if err := seq.resetCoordination(ctx, size, root); err != nil {
+		t.Fatalf("resetCoordination: %v", err)
+	}
+	gotSize, gotRoot, err := seq.currentTree(ctx)
+	if err != nil {
+		t.Fatalf("currentTree: %v", err)
+	}
+	if gotSize != size || !bytes.Equal(gotRoot, root) {
+		t.Errorf("currentTree = (%d, %x), want (%d, %x)", gotSize, gotRoot, size, root)
+	}
+	f := func(_ context.Context, _ uint64, entries []storage.SequencedEntry) ([]byte, error) {
+		return nil, fmt.Errorf("unexpected call with %d entries", len(entries))
+	}
+	if _, err := seq.consumeEntries(ctx, 10, f, false); err != nil {
+		t.Errorf("consumeEntries: %v", err)
+	}
+
+	// A checkpoint which doesn't match the stored tiles must be rejected.
+	badRaw, err := note.Sign(&note.Note{Text: string(log.Checkpoint{Origin: origin, Size: 300, Hash: wantRoot}.Marshal())}, signer)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	if err := m.setObject(ctx, layout.CheckpointPath, badRaw, nil, "", ""); err != nil {
+		t.Fatalf("setObject: %v", err)
+	}
+	if _, _, err := s.recoverTree(ctx, origin, verifier, NewEntryLeafHashes(rfc6962.DefaultHasher)); err == nil {
+		t.Error("recoverTree succeeded with bad checkpoint, want error")
+	}
+}
+
+func TestCheckCRC32C(t *testing.T) {
+	data := []byte("hello")
+	want := crc32.Checksum(data, crc32cTable)
+	if err := checkCRC32C("obj", data, want); err != nil {
+		t.Errorf("checkCRC32C with matching checksum: %v", err)
+	}
+	if err := checkCRC32C("obj", []byte("hellp"), want); !errors.Is(err, tessera.ErrChecksumMismatch) {
+		t.Errorf("checkCRC32C with corrupt data: got %v, want %v", err, tessera.ErrChecksumMismatch)
+	}
+}
+
+// newDedupeSpannerDB returns a client for a test Spanner database containing an IDSeq table whose idx
+// column has the given type, along with a func to close it.
+func newDedupeSpannerDB(t *testing.T, idxType string) (*spanner.Client, func()) {
+	t.Helper()
+	srv, err := spannertest.NewServer("localhost:0")
+	if err != nil {
+		t.Fatalf("Failed to set up test spanner: %v", err)
+	}
+	os.Setenv("SPANNER_EMULATOR_HOST", srv.Addr)
+	dml, err := spansql.ParseDDL("", fmt.Sprintf("CREATE TABLE IDSeq (id INT64 NOT NULL, h BYTES(MAX) NOT NULL, idx %s NOT NULL,) PRIMARY KEY (id, h)", idxType))
+	if err != nil {
+		t.Fatalf("Invalid DDL: %v", err)
+	}
+	if err := srv.UpdateDDL(dml); err != nil {
+		t.Fatalf("Failed to create schema in test spanner: %v", err)
+	}
+	client, err := spanner.NewClient(context.Background(), "projects/p/instances/i/databases/d")
+	if err != nil {
+		t.Fatalf("Failed to connect to test spanner: %v", err)
+	}
+	return client, func() {
+		client.Close()
+		srv.Close()
+	}
+}
+
+func TestDedupe(t *testing.T) {
+	const (
+		storedIdx   = 42
+		assignedIdx = 7
+	)
+	for _, test := range []struct {
+		name       string
+		idxType    string
+		stored     any
+		opts       []func(*DedupeOptions)
+		wantIdx    uint64
+		wantErr    bool
+		wantAdd    bool
+		wantMetric string
+	}{
+		{
+			name:       "hit",
+			idxType:    "INT64",
+			stored:     int64(storedIdx),
+			wantIdx:    storedIdx,
+			wantMetric: "tessera.dedup.hits",
+		}, {
+			name:       "miss",
+			idxType:    "INT64",
+			wantIdx:    assignedIdx,
+			wantAdd:    true,
+			wantMetric: "tessera.dedup.misses",
+		}, {
+			// An idx which can't be read as an INT64 causes the lookup to fail.
+			name:       "error fails closed by default",
+			idxType:    "STRING(MAX)",
+			stored:     "corrupt",
+			wantErr:    true,
+			wantMetric: "tessera.dedup.errors",
+		}, {
+			name:       "error fails closed",
+			idxType:    "STRING(MAX)",
+			stored:     "corrupt",
+			opts:       []func(*DedupeOptions){WithDedupeFailurePolicy(DedupeFailClosed)},
+			wantErr:    true,
+			wantMetric: "tessera.dedup.errors",
+		}, {
+			name:       "error fails open",
+			idxType:    "STRING(MAX)",
+			stored:     "corrupt",
+			opts:       []func(*DedupeOptions){WithDedupeFailurePolicy(DedupeFailOpen)},
+			wantIdx:    assignedIdx,
+			wantAdd:    true,
+			wantMetric: "tessera.dedup.errors",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			client, close := newDedupeSpannerDB(t, test.idxType)
+			defer close()
+
+			e := tessera.NewEntry([]byte("entry"))
+			if test.stored != nil {
+				m := spanner.Insert("IDSeq", []string{"id", "h", "idx"}, []interface{}{0, e.Identity(), test.stored})
+				if _, err := client.Apply(ctx, []*spanner.Mutation{m}); err != nil {
+					t.Fatalf("Apply: %v", err)
+				}
+			}
+
+			reader := sdkmetric.NewManualReader()
+			m, err := newMetrics(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+			if err != nil {
+				t.Fatalf("newMetrics: %v", err)
+			}
+			added := false
+			delegate := func(_ context.Context, _ *tessera.Entry) tessera.IndexFuture {
+				added = true
+				return func() (uint64, error) { return assignedIdx, nil }
+			}
+			d := newDedupStorage(ctx, client, delegate, m, test.opts...)
+
+			idx, err := d.add(ctx, e)()
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Fatalf("add: got err %v, want err %t", err, test.wantErr)
+			}
+			if !test.wantErr && idx != test.wantIdx {
+				t.Errorf("add: got index %d, want %d", idx, test.wantIdx)
+			}
+			if added != test.wantAdd {
+				t.Errorf("delegate called: %t, want %t", added, test.wantAdd)
+			}
+
+			rm := metricdata.ResourceMetrics{}
+			if err := reader.Collect(ctx, &rm); err != nil {
+				t.Fatalf("Collect: %v", err)
+			}
+			got := map[string]int64{}
+			for _, sm := range rm.ScopeMetrics {
+				for _, m := range sm.Metrics {
+					if s, ok := m.Data.(metricdata.Sum[int64]); ok {
+						for _, dp := range s.DataPoints {
+							got[m.Name] += dp.Value
+						}
+					}
+				}
+			}
+			for _, name := range []string{"tessera.dedup.hits", "tessera.dedup.misses", "tessera.dedup.errors"} {
+				want := int64(0)
+				if name == test.wantMetric {
+					want = 1
+				}
+				if got[name] != want {
+					t.Errorf("%s: got %d, want %d", name, got[name], want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2024 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcp
+
+import (
+	"context"
+	"fmt"
+
+	"cloud.google.com/go/spanner"
+)
+
+// CoordinationState is a snapshot of the contents of the Spanner coordination tables.
+type CoordinationState struct {
+	// Next is the next sequence number to be assigned, from SeqCoord.
+	Next uint64
+	// Integrated is the size of the integrated tree, from IntCoord.
+	Integrated uint64
+	// RootHash is the root hash of the integrated tree, from IntCoord.
+	RootHash []byte
+	// PendingBatches is the number of batches in the Seq table awaiting integration.
+	PendingBatches uint64
+	// StaleBatches is the number of batches in the Seq table which precede the integrated tree size.
+	// These should have been removed during integration.
+	StaleBatches uint64
+}
+
+// InspectCoordination returns the current state of the coordination tables in the given Spanner database.
+//
+// The tables are read in a single read-only transaction, so no locks are taken and a live log is not affected.
+// This is intended to help diagnose integration backlogs and stuck sequencers.
+func InspectCoordination(ctx context.Context, spannerDB string) (CoordinationState, error) {
+	c, err := spanner.NewClient(ctx, spannerDB)
+	if err != nil {
+		return CoordinationState{}, fmt.Errorf("failed to connect to Spanner: %v", err)
+	}
+	defer c.Close()
+	return (&spannerSequencer{dbPool: c}).inspect(ctx)
+}
+
+// inspect reads the coordination tables without taking any locks.
+func (s *spannerSequencer) inspect(ctx context.Context) (CoordinationState, error) {
+	txn := s.dbPool.ReadOnlyTransaction()
+	defer txn.Close()
+
+	var r CoordinationState
+	row, err := txn.ReadRow(ctx, "SeqCoord", spanner.Key{0}, []string{"next"})
+	if err != nil {
+		return r, fmt.Errorf("failed to read SeqCoord: %v", err)
+	}
+	var next int64
+	if err := row.Columns(&next); err != nil {
+		return r, fmt.Errorf("failed to parse SeqCoord: %v", err)
+	}
+	row, err = txn.ReadRow(ctx, "IntCoord", spanner.Key{0}, []string{"seq", "rootHash"})
+	if err != nil {
+		return r, fmt.Errorf("failed to read IntCoord: %v", err)
+	}
+	var seq int64
+	if err := row.Columns(&seq, &r.RootHash); err != nil {
+		return r, fmt.Errorf("failed to parse IntCoord: %v", err)
+	}
+	r.Next, r.Integrated = uint64(next), uint64(seq)
+
+	if err := txn.Read(ctx, "Seq", spanner.Key{0}.AsPrefix(), []string{"seq"}).Do(func(row *spanner.Row) error {
+		var bs int64
+		if err := row.Columns(&bs); err != nil {
+			return err
+		}
+		if bs < seq {
+			r.StaleBatches++
+		} else {
+			r.PendingBatches++
+		}
+		return nil
+	}); err != nil {
+		return r, fmt.Errorf("failed to read Seq: %v", err)
+	}
+	return r, nil
+}
@@ -0,0 +1,195 @@
+// Copyright 2024 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcp
+
+import (
+	"fmt"
+
+	"github.com/transparency-dev/trillian-tessera/internal/options"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"k8s.io/klog/v2"
+)
+
+const name = "github.com/transparency-dev/trillian-tessera/storage/gcp"
+
+var tracer = otel.Tracer(name)
+
+var (
+	batchSizeKey = attribute.Key("tessera.batch_size")
+	fromSeqKey   = attribute.Key("tessera.from_seq")
+)
+
+// metrics holds the instruments used by a Storage instance.
+type metrics struct {
+	// idempotentWrites counts writes which were skipped because an identical object already existed.
+	//
+	// A high rate indicates that multiple frontends are redundantly writing the same tiles and bundles.
+	idempotentWrites metric.Int64Counter
+
+	// seqRows records the number of rows in the Seq table, i.e. sequenced batches awaiting integration.
+	//
+	// This is only recorded if Config.SeqCleanupInterval is set.
+	seqRows metric.Int64Gauge
+
+	// publishingPaused records whether checkpoint publishing has been paused via PausePublishing.
+	publishingPaused metric.Int64Gauge
+
+	// checkpointAge records the time since the published checkpoint was last updated.
+	//
+	// This grows without bound while publishing is paused.
+	checkpointAge metric.Float64Gauge
+
+	// entriesSequenced counts entries which have been assigned an index.
+	entriesSequenced metric.Int64Counter
+
+	// entriesIntegrated counts entries which have been integrated into the tree.
+	entriesIntegrated metric.Int64Counter
+
+	// integratedSize records the size of the tree into which entries have been integrated.
+	integratedSize metric.Int64Gauge
+
+	// pushbacks counts batches of entries which were refused with ErrPushback.
+	pushbacks metric.Int64Counter
+
+	// lastPublished records the UNIX timestamp of the last successfully published checkpoint.
+	//
+	// Unlike checkpointAge this is only updated by the instance which published the checkpoint, so it's
+	// suitable for alerting when a given instance has stopped publishing.
+	lastPublished metric.Int64Gauge
+
+	// dedupHits counts entries added via NewDedupe which had previously been assigned an index.
+	dedupHits metric.Int64Counter
+
+	// dedupMisses counts entries added via NewDedupe which had not previously been assigned an index.
+	dedupMisses metric.Int64Counter
+
+	// dedupErrors counts entries added via NewDedupe whose previous index couldn't be looked up.
+	//
+	// Whether these entries were rejected depends on the DedupeFailurePolicy.
+	dedupErrors metric.Int64Counter
+}
+
+// defaultMetrics are created with the global MeterProvider, and are used unless a Storage is configured
+// with tessera.WithMetricFactory.
+var defaultMetrics *metrics
+
+func init() {
+	var err error
+	defaultMetrics, err = newMetrics(otel.GetMeterProvider())
+	if err != nil {
+		klog.Exitf("Failed to create metrics: %v", err)
+	}
+}
+
+// metricsFor returns the metrics to be used by a Storage configured with opt.
+func metricsFor(opt *options.StorageOptions) (*metrics, error) {
+	if opt.MeterProvider == nil {
+		return defaultMetrics, nil
+	}
+	return newMetrics(opt.MeterProvider)
+}
+
+// newMetrics creates the instruments used by a Storage instance with the given MeterProvider.
+func newMetrics(mp metric.MeterProvider) (*metrics, error) {
+	meter := mp.Meter(name)
+	m := &metrics{}
+	var err error
+	m.entriesSequenced, err = meter.Int64Counter(
+		"tessera.storage.entries_sequenced",
+		metric.WithDescription("Number of entries assigned an index"),
+		metric.WithUnit("{entry}"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create entriesSequenced metric: %v", err)
+	}
+	m.entriesIntegrated, err = meter.Int64Counter(
+		"tessera.storage.entries_integrated",
+		metric.WithDescription("Number of entries integrated into the tree"),
+		metric.WithUnit("{entry}"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create entriesIntegrated metric: %v", err)
+	}
+	m.integratedSize, err = meter.Int64Gauge(
+		"tessera.storage.integrated_size",
+		metric.WithDescription("Size of the integrated tree"),
+		metric.WithUnit("{entry}"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create integratedSize metric: %v", err)
+	}
+	m.pushbacks, err = meter.Int64Counter(
+		"tessera.storage.pushbacks",
+		metric.WithDescription("Number of batches of entries refused because too many entries were awaiting integration"),
+		metric.WithUnit("{batch}"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create pushbacks metric: %v", err)
+	}
+	m.lastPublished, err = meter.Int64Gauge(
+		"tessera.storage.checkpoint_last_published",
+		metric.WithDescription("UNIX timestamp of the last checkpoint successfully published by this instance"),
+		metric.WithUnit("s"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create lastPublished metric: %v", err)
+	}
+	m.idempotentWrites, err = meter.Int64Counter(
+		"tessera.storage.idempotent_writes",
+		metric.WithDescription("Number of object writes which were no-ops because identical content was already stored"),
+		metric.WithUnit("{write}"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create idempotentWrites metric: %v", err)
+	}
+	m.publishingPaused, err = meter.Int64Gauge(
+		"tessera.storage.publishing_paused",
+		metric.WithDescription("Whether checkpoint publishing is paused (1) or not (0)"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create publishingPaused metric: %v", err)
+	}
+	m.checkpointAge, err = meter.Float64Gauge(
+		"tessera.storage.checkpoint_age",
+		metric.WithDescription("Time since the published checkpoint was last updated"),
+		metric.WithUnit("s"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create checkpointAge metric: %v", err)
+	}
+	m.seqRows, err = meter.Int64Gauge(
+		"tessera.storage.seq_rows",
+		metric.WithDescription("Number of sequenced batches awaiting integration"),
+		metric.WithUnit("{row}"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create seqRows metric: %v", err)
+	}
+	m.dedupHits, err = meter.Int64Counter(
+		"tessera.dedup.hits",
+		metric.WithDescription("Number of entries which had previously been assigned an index"),
+		metric.WithUnit("{entry}"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dedupHits metric: %v", err)
+	}
+	m.dedupMisses, err = meter.Int64Counter(
+		"tessera.dedup.misses",
+		metric.WithDescription("Number of entries which had not previously been assigned an index"),
+		metric.WithUnit("{entry}"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dedupMisses metric: %v", err)
+	}
+	m.dedupErrors, err = meter.Int64Counter(
+		"tessera.dedup.errors",
+		metric.WithDescription("Number of entries whose previous index could not be looked up"),
+		metric.WithUnit("{entry}"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dedupErrors metric: %v", err)
+	}
+	return m, nil
+}
@@ -0,0 +1,223 @@
+// Copyright 2024 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcp
+
+import (
+	"context"
+	"fmt"
+
+	"cloud.google.com/go/spanner"
+	gcs "cloud.google.com/go/storage"
+	"github.com/transparency-dev/formats/log"
+	"github.com/transparency-dev/merkle"
+	"github.com/transparency-dev/trillian-tessera/api"
+	"github.com/transparency-dev/trillian-tessera/api/layout"
+	"github.com/transparency-dev/trillian-tessera/internal/options"
+	storage "github.com/transparency-dev/trillian-tessera/storage/internal"
+	"golang.org/x/mod/sumdb/note"
+	"k8s.io/klog/v2"
+)
+
+// LeafHashesFunc parses a raw entry bundle, and returns the Merkle leaf hash of each entry it contains.
+type LeafHashesFunc func(bundle []byte) ([][]byte, error)
+
+// NewEntryLeafHashes returns a LeafHashesFunc which is only correct for logs whose entries were all created
+// with tessera.NewEntry, and are therefore stored in the default bundle format with their leaf hash being the
+// hash of the entry data.
+//
+// It MUST NOT be used for logs with other entry types, e.g. those written with
+// tessera.NewCertificateTransparencySequencedWriter.
+func NewEntryLeafHashes(h merkle.LogHasher) LeafHashesFunc {
+	return func(raw []byte) ([][]byte, error) {
+		bundle := &api.EntryBundle{}
+		if err := bundle.UnmarshalText(raw); err != nil {
+			return nil, err
+		}
+		r := make([][]byte, 0, len(bundle.Entries))
+		for _, e := range bundle.Entries {
+			r = append(r, h.HashLeaf(e))
+		}
+		return r, nil
+	}
+}
+
+// Recover rebuilds the Spanner coordination state for a log from the contents of its GCS bucket.
+//
+// This is intended for disaster recovery in the case where the Spanner database has been lost or
+// damaged, but the bucket is intact. The log must not be running while this is in progress.
+//
+// The checkpoint stored in the bucket is verified using origin and v, and the tiles it commits to are
+// checked against its root hash. If leafHashes is non-nil, any complete or partial entry bundles found
+// beyond the checkpoint are then integrated using the leaf hashes it returns, and the IntCoord and SeqCoord
+// tables are reset to match the resulting tree. Since the leaf hashes depend on how entries were created,
+// leafHashes must match the log's entry type; NewEntryLeafHashes can be used for logs which only contain
+// entries created with tessera.NewEntry.
+//
+// If leafHashes is nil, the log is only recovered if there are no entry bundles beyond the checkpoint,
+// and an error is returned otherwise.
+// Any sequenced but unintegrated entries in the Seq table are discarded.
+//
+// Returns the size and root hash of the recovered tree.
+func Recover(ctx context.Context, cfg Config, origin string, v note.Verifier, leafHashes LeafHashesFunc, opts ...func(*options.StorageOptions)) (uint64, []byte, error) {
+	opt, err := storage.ResolveStorageOptions(opts...)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	c, err := gcs.NewClient(ctx, gcs.WithJSONReads())
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to create GCS client: %v", err)
+	}
+	seq, err := newSpannerSequencer(ctx, cfg.Spanner, DefaultPushbackMaxOutstanding, opt.Hasher.EmptyRoot())
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to create Spanner sequencer: %v", err)
+	}
+
+	m, err := metricsFor(opt)
+	if err != nil {
+		return 0, nil, err
+	}
+	seq.metrics = m
+
+	s := &Storage{
+		metrics: m,
+		objStore: &gcsStorage{
+			metrics:   m,
+			gcsClient: c,
+			bucket:    cfg.Bucket,
+		},
+		sequencer:          seq,
+		entriesPath:        opt.EntriesPath,
+		hasher:             opt.Hasher,
+		integrationWorkers: opt.IntegrationWorkers,
+	}
+	size, root, err := s.recoverTree(ctx, origin, v, leafHashes)
+	if err != nil {
+		return 0, nil, err
+	}
+	if err := seq.resetCoordination(ctx, size, root); err != nil {
+		return 0, nil, fmt.Errorf("resetCoordination: %v", err)
+	}
+	return size, root, nil
+}
+
+// recoverTree verifies the tree committed to by the stored checkpoint, and then extends it with any
+// entry bundles present in the bucket beyond that tree size.
+//
+// Tiles for the extended tree are written to the bucket, and its size and root hash are returned.
+// If leafHashes is nil, an error is returned if there are any entry bundles beyond the checkpoint.
+func (s *Storage) recoverTree(ctx context.Context, origin string, v note.Verifier, leafHashes LeafHashesFunc) (uint64, []byte, error) {
+	cpRaw, err := s.get(ctx, layout.CheckpointPath)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to read checkpoint: %v", err)
+	}
+	cp, _, _, err := log.ParseCheckpoint(cpRaw, origin, v)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to parse checkpoint: %v", err)
+	}
+	if err := storage.VerifyRoot(ctx, s.getTiles, cp.Size, cp.Hash, s.hasher); err != nil {
+		return 0, nil, fmt.Errorf("checkpoint does not match stored tiles: %v", err)
+	}
+	klog.Infof("Verified checkpoint at size %d", cp.Size)
+
+	size, root := cp.Size, cp.Hash
+	for {
+		bundleIndex, offset := size/layout.EntryBundleWidth, size%layout.EntryBundleWidth
+		n, p, err := s.largestEntryBundle(ctx, bundleIndex)
+		if err != nil {
+			return 0, nil, err
+		}
+		if n <= offset {
+			// There are no entries beyond the current tree size.
+			break
+		}
+
+		if leafHashes == nil {
+			return 0, nil, fmt.Errorf("found entries beyond checkpoint size %d, but no LeafHashesFunc was provided to integrate them", cp.Size)
+		}
+
+		raw, err := s.getEntryBundle(ctx, bundleIndex, p)
+		if err != nil {
+			return 0, nil, fmt.Errorf("getEntryBundle(%d, %d): %v", bundleIndex, p, err)
+		}
+		hashes, err := leafHashes(raw)
+		if err != nil {
+			return 0, nil, fmt.Errorf("failed to parse entry bundle %d: %v", bundleIndex, err)
+		}
+		if got := uint64(len(hashes)); got != n {
+			return 0, nil, fmt.Errorf("entry bundle %d contains %d entries, expected %d", bundleIndex, got, n)
+		}
+		entries := make([]storage.SequencedEntry, 0, n-offset)
+		for _, h := range hashes[offset:] {
+			entries = append(entries, storage.SequencedEntry{LeafHash: h})
+		}
+
+		newSize, newRoot, tiles, err := storage.Integrate(ctx, s.getTiles, size, entries, s.hasher, s.integrationWorkers)
+		if err != nil {
+			return 0, nil, fmt.Errorf("Integrate: %v", err)
+		}
+		for k, t := range tiles {
+			if err := s.setTile(ctx, uint64(k.Level), k.Index, newSize, t); err != nil {
+				return 0, nil, fmt.Errorf("setTile: %v", err)
+			}
+		}
+		klog.Infof("Recovered entries [%d, %d)", size, newSize)
+		size, root = newSize, newRoot
+
+		if p != 0 {
+			// A partial bundle is necessarily the last one in the log.
+			break
+		}
+	}
+	return size, root, nil
+}
+
+// largestEntryBundle lists the entry bundles stored at the given index, and returns the number of
+// entries in the largest one along with its partial size (zero meaning a full bundle).
+//
+// Returns n == 0 if no bundle is stored at that index.
+func (s *Storage) largestEntryBundle(ctx context.Context, bundleIndex uint64) (n uint64, p uint8, err error) {
+	full := s.entriesPath(bundleIndex, 0)
+	names, err := s.objStore.listObjects(ctx, full)
+	if err != nil {
+		return 0, 0, fmt.Errorf("listObjects(%q): %v", full, err)
+	}
+	found := make(map[string]bool, len(names))
+	for _, n := range names {
+		found[n] = true
+	}
+	if found[full] {
+		return layout.EntryBundleWidth, 0, nil
+	}
+	for p := layout.EntryBundleWidth - 1; p > 0; p-- {
+		if found[s.entriesPath(bundleIndex, uint8(p))] {
+			return uint64(p), uint8(p), nil
+		}
+	}
+	return 0, 0, nil
+}
+
+// resetCoordination unconditionally sets the integrated tree state to the provided size and root hash,
+// sets the next available sequence number to follow on from it, and discards any sequenced entries.
+func (s *spannerSequencer) resetCoordination(ctx context.Context, size uint64, root []byte) error {
+	_, err := s.dbPool.ReadWriteTransaction(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		return txn.BufferWrite([]*spanner.Mutation{
+			spanner.Delete("Seq", spanner.AllKeys()),
+			spanner.Update("SeqCoord", []string{"id", "next"}, []interface{}{0, int64(size)}),
+			spanner.Update("IntCoord", []string{"id", "seq", "rootHash"}, []interface{}{0, int64(size), root}),
+		})
+	})
+	return err
+}
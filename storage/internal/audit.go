@@ -0,0 +1,58 @@
+// Copyright 2024 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"sort"
+	"strings"
+)
+
+// ObjectVersion describes a single version of an object held in a versioned object store.
+type ObjectVersion struct {
+	// Name is the name of the object.
+	Name string
+	// Checksum is a digest of the content of this version of the object.
+	Checksum string
+}
+
+// IsImmutable returns true if the object at the given path must never be rewritten once it has been
+// created, i.e. it is a full tile or a full entry bundle.
+func IsImmutable(path string) bool {
+	return strings.HasPrefix(path, "tile/") && !strings.Contains(path, ".p/")
+}
+
+// FindRewrites returns the sorted names of immutable objects for which versions with differing content exist.
+//
+// Versions of mutable objects, such as the checkpoint and partial tiles, are ignored.
+func FindRewrites(versions []ObjectVersion) []string {
+	checksums := make(map[string]string)
+	rewritten := make(map[string]bool)
+	for _, v := range versions {
+		if !IsImmutable(v.Name) {
+			continue
+		}
+		if c, ok := checksums[v.Name]; ok && c != v.Checksum {
+			rewritten[v.Name] = true
+			continue
+		}
+		checksums[v.Name] = v.Checksum
+	}
+	r := make([]string, 0, len(rewritten))
+	for n := range rewritten {
+		r = append(r, n)
+	}
+	sort.Strings(r)
+	return r
+}
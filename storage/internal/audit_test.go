@@ -0,0 +1,71 @@
+// Copyright 2024 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFindRewrites(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		versions []ObjectVersion
+		want     []string
+	}{
+		{
+			name: "no rewrites",
+			versions: []ObjectVersion{
+				{Name: "tile/0/000", Checksum: "a"},
+				{Name: "tile/entries/000", Checksum: "b"},
+			},
+			want: []string{},
+		}, {
+			name: "identical rewrites are fine",
+			versions: []ObjectVersion{
+				{Name: "tile/0/000", Checksum: "a"},
+				{Name: "tile/0/000", Checksum: "a"},
+			},
+			want: []string{},
+		}, {
+			name: "mutable objects are ignored",
+			versions: []ObjectVersion{
+				{Name: "checkpoint", Checksum: "a"},
+				{Name: "checkpoint", Checksum: "b"},
+				{Name: "tile/0/000.p/5", Checksum: "a"},
+				{Name: "tile/0/000.p/5", Checksum: "b"},
+			},
+			want: []string{},
+		}, {
+			name: "rewrites are found",
+			versions: []ObjectVersion{
+				{Name: "tile/entries/001", Checksum: "a"},
+				{Name: "tile/0/000", Checksum: "a"},
+				{Name: "tile/entries/001", Checksum: "b"},
+				{Name: "tile/0/000", Checksum: "a"},
+				{Name: "tile/0/000", Checksum: "c"},
+				{Name: "tile/0/000", Checksum: "d"},
+			},
+			want: []string{"tile/0/000", "tile/entries/001"},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if diff := cmp.Diff(test.want, FindRewrites(test.versions)); diff != "" {
+				t.Errorf("FindRewrites: diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright 2024 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// IdleBackoff tracks the interval at which a storage implementation should poll for work
+// (e.g. sequenced entries awaiting integration), lengthening it while there's nothing to do.
+//
+// Once Idle has been called idleThreshold times in a row, each further call doubles the interval,
+// up to maxInterval. Calls to Busy or Wake reset the interval to minInterval.
+type IdleBackoff struct {
+	minInterval   time.Duration
+	maxInterval   time.Duration
+	idleThreshold int
+
+	mu       sync.Mutex
+	idle     int
+	interval time.Duration
+
+	wake chan struct{}
+}
+
+// NewIdleBackoff creates a new IdleBackoff.
+//
+// If maxInterval is not larger than minInterval, the interval will never be lengthened.
+func NewIdleBackoff(minInterval, maxInterval time.Duration, idleThreshold int) *IdleBackoff {
+	return &IdleBackoff{
+		minInterval:   minInterval,
+		maxInterval:   max(minInterval, maxInterval),
+		idleThreshold: idleThreshold,
+		interval:      minInterval,
+		wake:          make(chan struct{}, 1),
+	}
+}
+
+// Interval returns the current polling interval.
+func (b *IdleBackoff) Interval() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.interval
+}
+
+// Idle records that a polling cycle found no work to do.
+func (b *IdleBackoff) Idle() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.idle++
+	if b.idle > b.idleThreshold {
+		b.interval = min(2*b.interval, b.maxInterval)
+	}
+}
+
+// Busy records that a polling cycle found work to do.
+func (b *IdleBackoff) Busy() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.idle = 0
+	b.interval = b.minInterval
+}
+
+// Wake resets the interval to minInterval, and signals via Woken that new work is expected soon.
+//
+// This is cheap and non-blocking, so it's suitable for calling on every Add.
+func (b *IdleBackoff) Wake() {
+	b.mu.Lock()
+	reset := b.interval != b.minInterval
+	b.idle = 0
+	b.interval = b.minInterval
+	b.mu.Unlock()
+
+	if reset {
+		select {
+		case b.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Woken returns a channel which receives a value when Wake has shortened the polling interval.
+//
+// Polling loops should select on this channel alongside their timer, and restart the wait using the
+// updated Interval when it fires.
+func (b *IdleBackoff) Woken() <-chan struct{} {
+	return b.wake
+}
+
+// WriteRetry is a policy for retrying writes to an object store which fail with transient errors.
+type WriteRetry struct {
+	// MaxAttempts is the maximum number of times a write will be attempted.
+	// Values less than 1 are treated as 1, i.e. no retries.
+	MaxAttempts int
+	// BaseDelay is the nominal delay before the first retry, which doubles for each subsequent retry.
+	// The actual delay is chosen at random from between half and all of the nominal delay.
+	BaseDelay time.Duration
+}
+
+// Do calls f until it succeeds, returns an error which isn't retryable, MaxAttempts calls have been
+// made, or ctx is done. The error returned by the final call to f is returned.
+func (r WriteRetry) Do(ctx context.Context, retryable func(error) bool, f func() error) error {
+	delay := r.BaseDelay
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil || attempt >= r.MaxAttempts || !retryable(err) {
+			return err
+		}
+		t := time.NewTimer(delay/2 + rand.N(delay/2+1))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+		delay *= 2
+	}
+}
@@ -0,0 +1,159 @@
+// Copyright 2024 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	storage "github.com/transparency-dev/trillian-tessera/storage/internal"
+)
+
+func TestIdleBackoff(t *testing.T) {
+	b := storage.NewIdleBackoff(time.Second, 5*time.Second, 2)
+
+	for i, want := range []time.Duration{time.Second, time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		b.Idle()
+		if got := b.Interval(); got != want {
+			t.Errorf("after %d Idle calls: Interval() = %v, want %v", i+1, got, want)
+		}
+	}
+
+	b.Busy()
+	if got, want := b.Interval(), time.Second; got != want {
+		t.Errorf("after Busy: Interval() = %v, want %v", got, want)
+	}
+	for i := 0; i < 3; i++ {
+		b.Idle()
+	}
+	if got, want := b.Interval(), 2*time.Second; got != want {
+		t.Errorf("Busy did not reset idle count: Interval() = %v, want %v", got, want)
+	}
+
+	b.Wake()
+	if got, want := b.Interval(), time.Second; got != want {
+		t.Errorf("after Wake: Interval() = %v, want %v", got, want)
+	}
+	select {
+	case <-b.Woken():
+	default:
+		t.Error("Wake after backing off did not signal Woken")
+	}
+
+	b.Wake()
+	select {
+	case <-b.Woken():
+		t.Error("Wake at minimum interval unexpectedly signalled Woken")
+	default:
+	}
+}
+
+func TestIdleBackoffDisabled(t *testing.T) {
+	b := storage.NewIdleBackoff(time.Second, 0, 0)
+	for i := 0; i < 10; i++ {
+		b.Idle()
+	}
+	if got, want := b.Interval(), time.Second; got != want {
+		t.Errorf("Interval() = %v, want %v", got, want)
+	}
+}
+
+func TestWriteRetry(t *testing.T) {
+	errTransient := errors.New("transient")
+	errPermanent := errors.New("permanent")
+	retryable := func(err error) bool { return errors.Is(err, errTransient) }
+
+	for _, test := range []struct {
+		name        string
+		maxAttempts int
+		errs        []error
+		wantCalls   int
+		wantErr     error
+	}{
+		{
+			name:        "success",
+			maxAttempts: 3,
+			wantCalls:   1,
+		}, {
+			name:        "success after transient errors",
+			maxAttempts: 3,
+			errs:        []error{errTransient, errTransient},
+			wantCalls:   3,
+		}, {
+			name:        "attempts exhausted",
+			maxAttempts: 3,
+			errs:        []error{errTransient, errTransient, errTransient, errTransient},
+			wantCalls:   3,
+			wantErr:     errTransient,
+		}, {
+			name:        "not retryable",
+			maxAttempts: 3,
+			errs:        []error{errTransient, errPermanent, errTransient},
+			wantCalls:   2,
+			wantErr:     errPermanent,
+		}, {
+			name:        "retries disabled",
+			maxAttempts: 1,
+			errs:        []error{errTransient},
+			wantCalls:   1,
+			wantErr:     errTransient,
+		}, {
+			name:        "zero attempts",
+			maxAttempts: 0,
+			errs:        []error{errTransient},
+			wantCalls:   1,
+			wantErr:     errTransient,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			r := storage.WriteRetry{MaxAttempts: test.maxAttempts, BaseDelay: time.Millisecond}
+			calls := 0
+			err := r.Do(context.Background(), retryable, func() error {
+				calls++
+				if calls <= len(test.errs) {
+					return test.errs[calls-1]
+				}
+				return nil
+			})
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("Do() = %v, want %v", err, test.wantErr)
+			}
+			if calls != test.wantCalls {
+				t.Errorf("got %d calls, want %d", calls, test.wantCalls)
+			}
+		})
+	}
+}
+
+func TestWriteRetryContextDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	errTransient := errors.New("transient")
+	r := storage.WriteRetry{MaxAttempts: 5, BaseDelay: time.Hour}
+	calls := 0
+	err := r.Do(ctx, func(error) bool { return true }, func() error {
+		calls++
+		return errTransient
+	})
+	if !errors.Is(err, errTransient) {
+		t.Errorf("Do() = %v, want %v", err, errTransient)
+	}
+	if calls != 1 {
+		t.Errorf("got %d calls, want 1", calls)
+	}
+}
@@ -15,16 +15,20 @@
 package storage
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"reflect"
 
+	f_log "github.com/transparency-dev/formats/log"
+	"github.com/transparency-dev/merkle"
 	"github.com/transparency-dev/merkle/compact"
-	"github.com/transparency-dev/merkle/rfc6962"
 	"github.com/transparency-dev/trillian-tessera/api"
 	"github.com/transparency-dev/trillian-tessera/api/layout"
 	"golang.org/x/exp/maps"
+	"golang.org/x/mod/sumdb/note"
+	"golang.org/x/sync/errgroup"
 	"k8s.io/klog/v2"
 )
 
@@ -36,9 +40,118 @@ type SequencedEntry struct {
 	LeafHash []byte
 }
 
-func Integrate(ctx context.Context, getTiles func(ctx context.Context, tileIDs []TileID, treeSize uint64) ([]*api.HashTile, error), fromSize uint64, entries []SequencedEntry) (newSize uint64, rootHash []byte, tiles map[TileID]*api.HashTile, err error) {
-	tb := newTreeBuilder(getTiles)
-	return tb.integrate(ctx, fromSize, entries)
+// Integrate adds the provided entries into the Merkle tree of size fromSize, using the provided hasher,
+// and returns the size and root hash of the new tree along with the set of tiles which were updated.
+//
+// Up to workers goroutines are used to hash the new entries into the tree; values less than 2 cause
+// the hashing to be done on the calling goroutine.
+func Integrate(ctx context.Context, getTiles func(ctx context.Context, tileIDs []TileID, treeSize uint64) ([]*api.HashTile, error), fromSize uint64, entries []SequencedEntry, h merkle.LogHasher, workers uint) (newSize uint64, rootHash []byte, tiles map[TileID]*api.HashTile, err error) {
+	ctx, span := tracer.Start(ctx, "tessera.storage.Integrate")
+	defer span.End()
+	span.SetAttributes(fromSeqKey.Int64(int64(fromSize)), batchSizeKey.Int(len(entries)))
+
+	tb := newTreeBuilder(getTiles, h)
+	newSize, rootHash, tiles, err = tb.integrate(ctx, fromSize, entries, workers)
+	span.SetAttributes(newSizeKey.Int64(int64(newSize)))
+	return newSize, rootHash, tiles, err
+}
+
+// VerifyRoot checks that the root hash of the tree of the given size, recalculated from the stored
+// tiles returned by getTiles, matches the provided root.
+//
+// Storage implementations can use this to detect a forked or corrupted log before appending to it.
+// Only the tiles containing the compact range nodes for the tree are read, so this check is cheap to
+// run but does not verify every node in the tree.
+func VerifyRoot(ctx context.Context, getTiles func(ctx context.Context, tileIDs []TileID, treeSize uint64) ([]*api.HashTile, error), size uint64, root []byte, h merkle.LogHasher) error {
+	want := h.EmptyRoot()
+	if size > 0 {
+		tb := newTreeBuilder(getTiles, h)
+		r, err := tb.newRange(ctx, size)
+		if err != nil {
+			return fmt.Errorf("failed to create range covering existing log: %w", err)
+		}
+		if want, err = r.GetRootHash(nil); err != nil {
+			return fmt.Errorf("unable to recalculate root: %w", err)
+		}
+	}
+	if !bytes.Equal(want, root) {
+		return fmt.Errorf("stored root %x for tree size %d does not match root %x calculated from tiles", root, size, want)
+	}
+	return nil
+}
+
+// VerifyCheckpoint checks that the tree committed to by the provided signed checkpoint is consistent with
+// the stored tiles returned by getTiles.
+//
+// The checkpoint's signatures are not checked, since it is expected to have been read from the log's own
+// storage. If full is false, only the tiles containing the compact range nodes are read, as for VerifyRoot.
+// Otherwise, the root is additionally recalculated from every leaf hash in the tree, which requires reading
+// all of the level 0 tiles.
+func VerifyCheckpoint(ctx context.Context, getTiles func(ctx context.Context, tileIDs []TileID, treeSize uint64) ([]*api.HashTile, error), cpRaw []byte, h merkle.LogHasher, full bool) error {
+	n, err := note.Open(cpRaw, note.VerifierList())
+	if err != nil {
+		var uErr *note.UnverifiedNoteError
+		if !errors.As(err, &uErr) {
+			return fmt.Errorf("failed to open checkpoint: %w", err)
+		}
+		n = uErr.Note
+	}
+	cp := &f_log.Checkpoint{}
+	if _, err := cp.Unmarshal([]byte(n.Text)); err != nil {
+		return fmt.Errorf("failed to parse checkpoint: %w", err)
+	}
+	if err := VerifyRoot(ctx, getTiles, cp.Size, cp.Hash, h); err != nil {
+		return err
+	}
+	if !full {
+		return nil
+	}
+	want, err := rootFromLeaves(ctx, getTiles, cp.Size, h)
+	if err != nil {
+		return fmt.Errorf("unable to recalculate root from leaves: %w", err)
+	}
+	if !bytes.Equal(want, cp.Hash) {
+		return fmt.Errorf("checkpoint root %x for tree size %d does not match root %x calculated from leaf hashes", cp.Hash, cp.Size, want)
+	}
+	return nil
+}
+
+// rootFromLeaves recalculates the root hash of the tree of the given size from all of the leaf hashes
+// stored in its level 0 tiles.
+func rootFromLeaves(ctx context.Context, getTiles func(ctx context.Context, tileIDs []TileID, treeSize uint64) ([]*api.HashTile, error), size uint64, h merkle.LogHasher) ([]byte, error) {
+	// Tiles are fetched in batches to bound memory use for large trees.
+	const batchSize = 64
+	if size == 0 {
+		return h.EmptyRoot(), nil
+	}
+	rf := &compact.RangeFactory{Hash: h.HashChildren}
+	r := rf.NewEmptyRange(0)
+	numTiles := (size + layout.TileWidth - 1) / layout.TileWidth
+	for first := uint64(0); first < numTiles; first += batchSize {
+		ids := make([]TileID, 0, batchSize)
+		for i := first; i < numTiles && i < first+batchSize; i++ {
+			ids = append(ids, TileID{Level: 0, Index: i})
+		}
+		tiles, err := getTiles(ctx, ids, size)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tiles: %w", err)
+		}
+		for i, id := range ids {
+			n := uint64(layout.PartialTileSize(0, id.Index, size))
+			if n == 0 {
+				n = layout.TileWidth
+			}
+			if i >= len(tiles) || tiles[i] == nil || uint64(len(tiles[i].Nodes)) < n {
+				return nil, fmt.Errorf("tile at level 0 index %d is missing or has fewer than %d hashes", id.Index, n)
+			}
+			for _, leafHash := range tiles[i].Nodes[:n] {
+				if err := r.Append(leafHash, nil); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return r.GetRootHash(nil)
 }
 
 // getPopulatedTileFunc is the signature of a function which can return a fully populated tile for the given tile coords.
@@ -54,17 +167,20 @@ type getPopulatedTileFunc func(ctx context.Context, tileID TileID, treeSize uint
 type treeBuilder struct {
 	readCache *tileReadCache
 	rf        *compact.RangeFactory
+	hasher    merkle.LogHasher
 }
 
 // newTreeBuilder creates a new instance of treeBuilder.
 //
 // The getTiles param must know how to fetch the specified tiles from storage. It must return tiles in the same order as the
 // provided tileIDs, substituing nil for any tiles which were not found.
-func newTreeBuilder(getTiles func(ctx context.Context, tileIDs []TileID, treeSize uint64) ([]*api.HashTile, error)) *treeBuilder {
-	readCache := newTileReadCache(getTiles)
+func newTreeBuilder(getTiles func(ctx context.Context, tileIDs []TileID, treeSize uint64) ([]*api.HashTile, error), h merkle.LogHasher) *treeBuilder {
+	rf := &compact.RangeFactory{Hash: h.HashChildren}
+	readCache := newTileReadCache(getTiles, rf)
 	r := &treeBuilder{
 		readCache: &readCache,
-		rf:        &compact.RangeFactory{Hash: rfc6962.DefaultHasher.HashChildren},
+		rf:        rf,
+		hasher:    h,
 	}
 
 	return r
@@ -98,7 +214,7 @@ func (t *treeBuilder) newRange(ctx context.Context, treeSize uint64) (*compact.R
 	return t.rf.NewRange(0, treeSize, hashes)
 }
 
-func (t *treeBuilder) integrate(ctx context.Context, fromSize uint64, entries []SequencedEntry) (newSize uint64, rootHash []byte, tiles map[TileID]*api.HashTile, err error) {
+func (t *treeBuilder) integrate(ctx context.Context, fromSize uint64, entries []SequencedEntry, workers uint) (newSize uint64, rootHash []byte, tiles map[TileID]*api.HashTile, err error) {
 	baseRange, err := t.newRange(ctx, fromSize)
 	if err != nil {
 		return 0, nil, nil, fmt.Errorf("failed to create range covering existing log: %w", err)
@@ -114,7 +230,7 @@ func (t *treeBuilder) integrate(ctx context.Context, fromSize uint64, entries []
 		// C2SP.org/log-tiles says all Merkle operations are those from RFC6962, we need to override
 		// the root of the empty tree to match (compact.Range will return an empty slice).
 		if fromSize == 0 {
-			r = rfc6962.DefaultHasher.EmptyRoot()
+			r = t.hasher.EmptyRoot()
 		}
 		// Nothing to do, nothing done.
 		return fromSize, r, nil, nil
@@ -122,15 +238,11 @@ func (t *treeBuilder) integrate(ctx context.Context, fromSize uint64, entries []
 
 	klog.V(1).Infof("Loaded state with roothash %x", r)
 	// Create a new compact range which represents the update to the tree
-	newRange := t.rf.NewEmptyRange(fromSize)
-	tc := newTileWriteCache(fromSize, t.readCache.Get)
+	tc := newTileWriteCache(fromSize, t.readCache.Get, t.rf)
 	visitor := tc.Visitor(ctx)
-	for _, e := range entries {
-		// Update range and set nodes
-		if err := newRange.Append(e.LeafHash, visitor); err != nil {
-			return 0, nil, nil, fmt.Errorf("newRange.Append(): %v", err)
-		}
-
+	newRange, err := t.appendEntries(fromSize, entries, workers, visitor)
+	if err != nil {
+		return 0, nil, nil, err
 	}
 	// Check whether the visitor had any problems building the update range
 	if err := tc.Err(); err != nil {
@@ -162,16 +274,99 @@ func (t *treeBuilder) integrate(ctx context.Context, fromSize uint64, entries []
 
 }
 
+// visitedNode is a node hash recorded by a compact.VisitFn.
+type visitedNode struct {
+	id   compact.NodeID
+	hash []byte
+}
+
+// appendEntries returns a compact range covering the provided entries, the first of which has index
+// fromSize, passing every node it creates to visitor in the same order as appending them one by one would.
+//
+// If workers is greater than 1, the entries are split into chunks aligned to tile boundaries, whose ranges are
+// hashed by up to that many goroutines concurrently and then merged in order. This doesn't change the
+// resulting nodes.
+func (t *treeBuilder) appendEntries(fromSize uint64, entries []SequencedEntry, workers uint, visitor compact.VisitFn) (*compact.Range, error) {
+	chunks := chunkBounds(fromSize, uint64(len(entries)), workers)
+	if len(chunks) <= 1 {
+		r := t.rf.NewEmptyRange(fromSize)
+		for _, e := range entries {
+			if err := r.Append(e.LeafHash, visitor); err != nil {
+				return nil, fmt.Errorf("newRange.Append(): %v", err)
+			}
+		}
+		return r, nil
+	}
+
+	type chunk struct {
+		r     *compact.Range
+		nodes []visitedNode
+	}
+	results := make([]chunk, len(chunks))
+	errG := errgroup.Group{}
+	errG.SetLimit(int(workers))
+	for i, b := range chunks {
+		errG.Go(func() error {
+			c := &results[i]
+			c.r = t.rf.NewEmptyRange(b[0])
+			for _, e := range entries[b[0]-fromSize : b[1]-fromSize] {
+				if err := c.r.Append(e.LeafHash, func(id compact.NodeID, hash []byte) {
+					c.nodes = append(c.nodes, visitedNode{id: id, hash: hash})
+				}); err != nil {
+					return fmt.Errorf("newRange.Append(): %v", err)
+				}
+			}
+			return nil
+		})
+	}
+	if err := errG.Wait(); err != nil {
+		return nil, err
+	}
+
+	r := t.rf.NewEmptyRange(fromSize)
+	for _, c := range results {
+		for _, n := range c.nodes {
+			visitor(n.id, n.hash)
+		}
+		if err := r.AppendRange(c.r, visitor); err != nil {
+			return nil, fmt.Errorf("failed to merge chunk ranges: %v", err)
+		}
+	}
+	return r, nil
+}
+
+// chunkBounds splits the n leaves starting at index from into contiguous [begin, end) chunks, sized so
+// that there are roughly as many as workers.
+//
+// Chunk boundaries fall on tile boundaries, so that each level-0 tile is built by a single chunk.
+func chunkBounds(from, n uint64, workers uint) [][2]uint64 {
+	if workers < 2 || n <= layout.TileWidth {
+		return [][2]uint64{{from, from + n}}
+	}
+	width := (n + uint64(workers) - 1) / uint64(workers)
+	width = (width + layout.TileWidth - 1) / layout.TileWidth * layout.TileWidth
+
+	var r [][2]uint64
+	for begin, end := from, from+n; begin < end; {
+		next := min((begin/width+1)*width, end)
+		r = append(r, [2]uint64{begin, next})
+		begin = next
+	}
+	return r
+}
+
 // tileReadCache is a structure which provides a very simple thread-safe read-through cache based on a map of tiles.
 type tileReadCache struct {
 	entries  map[string]*populatedTile
 	getTiles func(ctx context.Context, tileIDs []TileID, treeSize uint64) ([]*api.HashTile, error)
+	rf       *compact.RangeFactory
 }
 
-func newTileReadCache(getTiles func(ctx context.Context, tileIDs []TileID, treeSize uint64) ([]*api.HashTile, error)) tileReadCache {
+func newTileReadCache(getTiles func(ctx context.Context, tileIDs []TileID, treeSize uint64) ([]*api.HashTile, error), rf *compact.RangeFactory) tileReadCache {
 	return tileReadCache{
 		entries:  make(map[string]*populatedTile),
 		getTiles: getTiles,
+		rf:       rf,
 	}
 }
 
@@ -185,7 +380,7 @@ func (r *tileReadCache) Get(ctx context.Context, tileID TileID, treeSize uint64)
 		if err != nil {
 			return nil, err
 		}
-		e, err = newPopulatedTile(t[0])
+		e, err = newPopulatedTile(t[0], r.rf)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create fulltile: %v", err)
 		}
@@ -203,7 +398,7 @@ func (r *tileReadCache) Prewarm(ctx context.Context, tileIDs []TileID, treeSize
 		return err
 	}
 	for i, tile := range t {
-		e, err := newPopulatedTile(tile)
+		e, err := newPopulatedTile(tile, r.rf)
 		if err != nil {
 			return fmt.Errorf("failed to create fulltile: %v", err)
 		}
@@ -227,15 +422,17 @@ type tileWriteCache struct {
 
 	treeSize uint64
 	getTile  getPopulatedTileFunc
+	rf       *compact.RangeFactory
 }
 
 // newtileWriteCache creates a new cache for the given treeSize, and uses the provided
 // function to fetch existing tiles which are being updated by the Visitor func.
-func newTileWriteCache(treeSize uint64, getTile getPopulatedTileFunc) *tileWriteCache {
+func newTileWriteCache(treeSize uint64, getTile getPopulatedTileFunc, rf *compact.RangeFactory) *tileWriteCache {
 	return &tileWriteCache{
 		m:        make(map[TileID]*populatedTile),
 		treeSize: treeSize,
 		getTile:  getTile,
+		rf:       rf,
 	}
 }
 
@@ -278,7 +475,7 @@ func (tc *tileWriteCache) Visitor(ctx context.Context) compact.VisitFn {
 			}
 			if tile == nil {
 				// No tile found in storage: this is a brand new tile being created due to tree growth.
-				tile, err = newPopulatedTile(nil)
+				tile, err = newPopulatedTile(nil, tc.rf)
 				if err != nil {
 					tc.err = append(tc.err, err)
 					return
@@ -309,7 +506,8 @@ type populatedTile struct {
 }
 
 // newPopulatedTile creates and populates a fullTile struct based on the passed in HashTile data.
-func newPopulatedTile(h *api.HashTile) (*populatedTile, error) {
+// The provided RangeFactory is used to calculate the tile's internal nodes.
+func newPopulatedTile(h *api.HashTile, rf *compact.RangeFactory) (*populatedTile, error) {
 	ft := &populatedTile{
 		inner:  make(map[compact.NodeID][]byte),
 		leaves: make([][]byte, 0, layout.TileWidth),
@@ -317,7 +515,7 @@ func newPopulatedTile(h *api.HashTile) (*populatedTile, error) {
 
 	if h != nil {
 		// TODO: it might be better if we calculate (and cache) nodes in get, so we don't do more work that necessary.
-		r := (&compact.RangeFactory{Hash: rfc6962.DefaultHasher.HashChildren}).NewEmptyRange(0)
+		r := rf.NewEmptyRange(0)
 		for _, h := range h.Nodes {
 			if err := r.Append(h, ft.Set); err != nil {
 				return nil, fmt.Errorf("failed to append to range: %v", err)
@@ -16,24 +16,29 @@ package storage
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
 	"fmt"
 	"reflect"
 	"sync"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
+	f_log "github.com/transparency-dev/formats/log"
+	"github.com/transparency-dev/merkle"
 	"github.com/transparency-dev/merkle/compact"
 	"github.com/transparency-dev/merkle/rfc6962"
 	tessera "github.com/transparency-dev/trillian-tessera"
 	"github.com/transparency-dev/trillian-tessera/api"
 	"github.com/transparency-dev/trillian-tessera/api/layout"
+	"golang.org/x/mod/sumdb/note"
 	"k8s.io/klog/v2"
 )
 
 func TestNewRangeFetchesTiles(t *testing.T) {
 	ctx := context.Background()
 	m := newMemTileStore[api.HashTile]()
-	tb := newTreeBuilder(m.getTiles)
+	tb := newTreeBuilder(m.getTiles, rfc6962.DefaultHasher)
 
 	treeSize := uint64(0x102030)
 	wantIDs := []TileID{
@@ -89,7 +94,7 @@ func TestTileVisit(t *testing.T) {
 			},
 		},
 	} {
-		twc := newTileWriteCache(treeSize, m.getTile)
+		twc := newTileWriteCache(treeSize, m.getTile, &compact.RangeFactory{Hash: rfc6962.DefaultHasher.HashChildren})
 		v := twc.Visitor(ctx)
 		for id, k := range test.visits {
 			v(id, k)
@@ -120,51 +125,296 @@ func TestTileVisit(t *testing.T) {
 }
 
 func TestIntegrate(t *testing.T) {
-	ctx := context.Background()
-	m := newMemTileStore[api.HashTile]()
+	for _, test := range []struct {
+		name   string
+		hasher merkle.LogHasher
+	}{
+		{
+			name:   "rfc6962",
+			hasher: rfc6962.DefaultHasher,
+		}, {
+			name:   "custom hasher",
+			hasher: domainHasher{},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			m := newMemTileStore[api.HashTile]()
 
-	cr := (&compact.RangeFactory{Hash: rfc6962.DefaultHasher.HashChildren}).NewEmptyRange(0)
+			cr := (&compact.RangeFactory{Hash: test.hasher.HashChildren}).NewEmptyRange(0)
+
+			// An empty tree should have the hasher's empty root.
+			_, gotRoot, _, err := Integrate(ctx, m.getTiles, 0, nil, test.hasher, 1)
+			if err != nil {
+				t.Fatalf("Integrate(empty): %v", err)
+			}
+			if wantRoot := test.hasher.EmptyRoot(); !cmp.Equal(gotRoot, wantRoot) {
+				t.Errorf("Got empty root %x, want %x", gotRoot, wantRoot)
+			}
+
+			chunkSize := 200
+			numChunks := 1000
+			seq := uint64(0)
+			for chunk := 0; chunk < numChunks; chunk++ {
+				oldSeq := seq
+				c := make([]SequencedEntry, chunkSize)
+				for i := range c {
+					leaf := []byte{byte(seq)}
+					entry := tessera.NewEntryWithHasher(leaf, test.hasher)
+					c[i] = SequencedEntry{
+						BundleData: entry.MarshalBundleData(seq),
+						LeafHash:   entry.LeafHash(),
+					}
+					if err := cr.Append(test.hasher.HashLeaf(leaf), nil); err != nil {
+						t.Fatalf("compact Append: %v", err)
+					}
+					seq++
+				}
+				wantRoot, err := cr.GetRootHash(nil)
+				if err != nil {
+					t.Fatalf("[%d] compactRange: %v", chunk, err)
+				}
+				gotSize, gotRoot, gotTiles, err := Integrate(ctx, m.getTiles, oldSeq, c, test.hasher, 1)
+				if err != nil {
+					t.Fatalf("[%d] Integrate: %v", chunk, err)
+				}
+				if wantSize := seq; gotSize != wantSize {
+					t.Errorf("[%d] Got size %d, want %d", chunk, gotSize, wantSize)
+				}
+				if !cmp.Equal(gotRoot, wantRoot) {
+					t.Errorf("[%d] Got root %x, want %x", chunk, gotRoot, wantRoot)
+				}
+				for k, tile := range gotTiles {
+					if err := m.setTile(ctx, k, seq, tile); err != nil {
+						t.Fatalf("setTile: %v", err)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestIntegrateWorkers(t *testing.T) {
+	ctx := context.Background()
+	serial, parallel := newMemTileStore[api.HashTile](), newMemTileStore[api.HashTile]()
 
-	chunkSize := 200
-	numChunks := 1000
 	seq := uint64(0)
-	for chunk := 0; chunk < numChunks; chunk++ {
+	// Use batch sizes which leave the tree at a variety of offsets within a tile.
+	for i, batchSize := range []int{1000, 3000, 256, 20000, 1, 5000} {
 		oldSeq := seq
-		c := make([]SequencedEntry, chunkSize)
-		for i := range c {
-			leaf := []byte{byte(seq)}
-			entry := tessera.NewEntry(leaf)
-			c[i] = SequencedEntry{
+		c := make([]SequencedEntry, batchSize)
+		for j := range c {
+			entry := tessera.NewEntry([]byte(fmt.Sprintf("leaf %d", seq)))
+			c[j] = SequencedEntry{
 				BundleData: entry.MarshalBundleData(seq),
 				LeafHash:   entry.LeafHash(),
 			}
-			if err := cr.Append(rfc6962.DefaultHasher.HashLeaf(leaf), nil); err != nil {
-				t.Fatalf("compact Append: %v", err)
-			}
 			seq++
 		}
-		wantRoot, err := cr.GetRootHash(nil)
+		wantSize, wantRoot, wantTiles, err := Integrate(ctx, serial.getTiles, oldSeq, c, rfc6962.DefaultHasher, 1)
 		if err != nil {
-			t.Fatalf("[%d] compactRange: %v", chunk, err)
+			t.Fatalf("[%d] Integrate(workers=1): %v", i, err)
 		}
-		gotSize, gotRoot, gotTiles, err := Integrate(ctx, m.getTiles, oldSeq, c)
+		gotSize, gotRoot, gotTiles, err := Integrate(ctx, parallel.getTiles, oldSeq, c, rfc6962.DefaultHasher, 4)
 		if err != nil {
-			t.Fatalf("[%d] Integrate: %v", chunk, err)
+			t.Fatalf("[%d] Integrate(workers=4): %v", i, err)
 		}
-		if wantSize := seq; gotSize != wantSize {
-			t.Errorf("[%d] Got size %d, want %d", chunk, gotSize, wantSize)
+		if gotSize != wantSize {
+			t.Errorf("[%d] Got size %d, want %d", i, gotSize, wantSize)
 		}
 		if !cmp.Equal(gotRoot, wantRoot) {
-			t.Errorf("[%d] Got root %x, want %x", chunk, gotRoot, wantRoot)
+			t.Errorf("[%d] Got root %x, want %x", i, gotRoot, wantRoot)
+		}
+		if diff := cmp.Diff(wantTiles, gotTiles); diff != "" {
+			t.Errorf("[%d] Got tiles with diff (-want +got):\n%s", i, diff)
+		}
+		for k, tile := range wantTiles {
+			if err := serial.setTile(ctx, k, seq, tile); err != nil {
+				t.Fatalf("setTile: %v", err)
+			}
 		}
 		for k, tile := range gotTiles {
-			if err := m.setTile(ctx, k, seq, tile); err != nil {
+			if err := parallel.setTile(ctx, k, seq, tile); err != nil {
 				t.Fatalf("setTile: %v", err)
 			}
 		}
 	}
 }
 
+func TestChunkBounds(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		from, n uint64
+		workers uint
+		want    [][2]uint64
+	}{
+		{
+			name:    "single worker",
+			from:    10,
+			n:       1000,
+			workers: 1,
+			want:    [][2]uint64{{10, 1010}},
+		}, {
+			name:    "within a tile",
+			from:    10,
+			n:       200,
+			workers: 4,
+			want:    [][2]uint64{{10, 210}},
+		}, {
+			name:    "aligned",
+			from:    0,
+			n:       1024,
+			workers: 4,
+			want:    [][2]uint64{{0, 256}, {256, 512}, {512, 768}, {768, 1024}},
+		}, {
+			name:    "unaligned",
+			from:    100,
+			n:       1000,
+			workers: 2,
+			want:    [][2]uint64{{100, 512}, {512, 1024}, {1024, 1100}},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if got := chunkBounds(test.from, test.n, test.workers); !cmp.Equal(got, test.want) {
+				t.Errorf("chunkBounds(%d, %d, %d) = %v, want %v", test.from, test.n, test.workers, got, test.want)
+			}
+		})
+	}
+}
+
+func TestVerifyRoot(t *testing.T) {
+	ctx := context.Background()
+	m := newMemTileStore[api.HashTile]()
+
+	if err := VerifyRoot(ctx, m.getTiles, 0, rfc6962.DefaultHasher.EmptyRoot(), rfc6962.DefaultHasher); err != nil {
+		t.Errorf("VerifyRoot(empty): %v", err)
+	}
+
+	const size = 1000
+	c := make([]SequencedEntry, size)
+	for i := range c {
+		entry := tessera.NewEntry([]byte(fmt.Sprintf("leaf %d", i)))
+		c[i] = SequencedEntry{
+			BundleData: entry.MarshalBundleData(uint64(i)),
+			LeafHash:   entry.LeafHash(),
+		}
+	}
+	gotSize, root, tiles, err := Integrate(ctx, m.getTiles, 0, c, rfc6962.DefaultHasher, 1)
+	if err != nil {
+		t.Fatalf("Integrate: %v", err)
+	}
+	for k, tile := range tiles {
+		if err := m.setTile(ctx, k, gotSize, tile); err != nil {
+			t.Fatalf("setTile: %v", err)
+		}
+	}
+
+	if err := VerifyRoot(ctx, m.getTiles, gotSize, root, rfc6962.DefaultHasher); err != nil {
+		t.Errorf("VerifyRoot: %v", err)
+	}
+	badRoot := append([]byte{}, root...)
+	badRoot[0] ^= 0xff
+	if err := VerifyRoot(ctx, m.getTiles, gotSize, badRoot, rfc6962.DefaultHasher); err == nil {
+		t.Error("VerifyRoot succeeded with mismatched root, want error")
+	}
+}
+
+func TestVerifyCheckpoint(t *testing.T) {
+	ctx := context.Background()
+	m := newMemTileStore[api.HashTile]()
+
+	skey, _, err := note.GenerateKey(rand.Reader, "example.com/log")
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	signer, err := note.NewSigner(skey)
+	if err != nil {
+		t.Fatalf("NewSigner: %v", err)
+	}
+	newCP := func(size uint64, root []byte) []byte {
+		t.Helper()
+		cp := f_log.Checkpoint{Origin: signer.Name(), Size: size, Hash: root}.Marshal()
+		n, err := note.Sign(&note.Note{Text: string(cp)}, signer)
+		if err != nil {
+			t.Fatalf("Sign: %v", err)
+		}
+		return n
+	}
+
+	for _, full := range []bool{false, true} {
+		if err := VerifyCheckpoint(ctx, m.getTiles, newCP(0, rfc6962.DefaultHasher.EmptyRoot()), rfc6962.DefaultHasher, full); err != nil {
+			t.Errorf("VerifyCheckpoint(empty, full=%t): %v", full, err)
+		}
+	}
+
+	const size = 1000
+	c := make([]SequencedEntry, size)
+	for i := range c {
+		entry := tessera.NewEntry([]byte(fmt.Sprintf("leaf %d", i)))
+		c[i] = SequencedEntry{
+			BundleData: entry.MarshalBundleData(uint64(i)),
+			LeafHash:   entry.LeafHash(),
+		}
+	}
+	gotSize, root, tiles, err := Integrate(ctx, m.getTiles, 0, c, rfc6962.DefaultHasher, 1)
+	if err != nil {
+		t.Fatalf("Integrate: %v", err)
+	}
+	for k, tile := range tiles {
+		if err := m.setTile(ctx, k, gotSize, tile); err != nil {
+			t.Fatalf("setTile: %v", err)
+		}
+	}
+	cp := newCP(gotSize, root)
+	for _, full := range []bool{false, true} {
+		if err := VerifyCheckpoint(ctx, m.getTiles, cp, rfc6962.DefaultHasher, full); err != nil {
+			t.Errorf("VerifyCheckpoint(full=%t): %v", full, err)
+		}
+	}
+	badRoot := append([]byte{}, root...)
+	badRoot[0] ^= 0xff
+	if err := VerifyCheckpoint(ctx, m.getTiles, newCP(gotSize, badRoot), rfc6962.DefaultHasher, false); err == nil {
+		t.Error("VerifyCheckpoint succeeded with mismatched root, want error")
+	}
+	if err := VerifyCheckpoint(ctx, m.getTiles, []byte("not a checkpoint"), rfc6962.DefaultHasher, false); err == nil {
+		t.Error("VerifyCheckpoint succeeded with invalid checkpoint, want error")
+	}
+
+	// Corrupt a leaf hash which isn't needed to recalculate the root from the compact range, so that
+	// only the full check detects it.
+	leafTile := m.mem[layout.TilePath(0, 0, 0)]
+	leafTile.Nodes = append([][]byte{}, leafTile.Nodes...)
+	leafTile.Nodes[0] = rfc6962.DefaultHasher.HashLeaf([]byte("forked"))
+	if err := VerifyCheckpoint(ctx, m.getTiles, cp, rfc6962.DefaultHasher, false); err != nil {
+		t.Errorf("VerifyCheckpoint(full=false) with corrupted leaf: %v", err)
+	}
+	if err := VerifyCheckpoint(ctx, m.getTiles, cp, rfc6962.DefaultHasher, true); err == nil {
+		t.Error("VerifyCheckpoint(full=true) succeeded with corrupted leaf, want error")
+	}
+}
+
+// domainHasher is a non-RFC6962 hasher which uses distinct domain-separation prefixes.
+type domainHasher struct{}
+
+func (domainHasher) EmptyRoot() []byte {
+	h := sha256.Sum256([]byte("empty"))
+	return h[:]
+}
+
+func (domainHasher) HashLeaf(leaf []byte) []byte {
+	h := sha256.Sum256(append([]byte("leaf:"), leaf...))
+	return h[:]
+}
+
+func (domainHasher) HashChildren(l, r []byte) []byte {
+	h := sha256.Sum256(append(append([]byte("node:"), l...), r...))
+	return h[:]
+}
+
+func (domainHasher) Size() int {
+	return sha256.Size
+}
+
 func BenchmarkIntegrate(b *testing.B) {
 	ctx := context.Background()
 	m := newMemTileStore[api.HashTile]()
@@ -183,7 +433,7 @@ func BenchmarkIntegrate(b *testing.B) {
 			}
 			seq++
 		}
-		_, _, gotTiles, err := Integrate(ctx, m.getTiles, oldSeq, c)
+		_, _, gotTiles, err := Integrate(ctx, m.getTiles, oldSeq, c, rfc6962.DefaultHasher, 1)
 		if err != nil {
 			b.Fatalf("[%d] Integrate: %v", chunk, err)
 		}
@@ -195,6 +445,30 @@ func BenchmarkIntegrate(b *testing.B) {
 	}
 }
 
+func BenchmarkIntegrateWorkers(b *testing.B) {
+	ctx := context.Background()
+	const batchSize = 1 << 16
+	c := make([]SequencedEntry, batchSize)
+	for i := range c {
+		entry := tessera.NewEntry([]byte(fmt.Sprintf("leaf %d", i)))
+		c[i] = SequencedEntry{
+			BundleData: entry.MarshalBundleData(uint64(i)),
+			LeafHash:   entry.LeafHash(),
+		}
+	}
+
+	for _, workers := range []uint{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				m := newMemTileStore[api.HashTile]()
+				if _, _, _, err := Integrate(ctx, m.getTiles, 0, c, rfc6962.DefaultHasher, workers); err != nil {
+					b.Fatalf("Integrate: %v", err)
+				}
+			}
+		})
+	}
+}
+
 // zerotile creates a new api.HashTile of the provided size, whose leaves are all a single zero byte.
 func zeroTile(size uint64) *api.HashTile {
 	r := &api.HashTile{
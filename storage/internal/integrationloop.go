@@ -0,0 +1,95 @@
+// Copyright 2024 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// integrationPassTimeout is the time allowed for an integration pass of up to IntegrationLoop.SizeLimit entries.
+// Larger passes are allowed proportionally longer.
+const integrationPassTimeout = 10 * time.Second
+
+// IntegrationLoop periodically integrates sequenced entries for storage implementations which do so
+// asynchronously.
+type IntegrationLoop struct {
+	// SizeLimit is the maximum number of entries to integrate in a single pass.
+	SizeLimit uint64
+	// CatchUpSizeLimit, if non-zero, enables catch-up mode: whenever a pass integrates as many entries as it
+	// was allowed to, there's likely to be a backlog, so the next pass is started immediately and may integrate
+	// up to CatchUpSizeLimit entries. The usual cadence resumes once a pass is no longer saturated.
+	CatchUpSizeLimit uint64
+	// Backoff determines the interval between passes when not catching up.
+	Backoff *IdleBackoff
+	// Integrate should integrate up to limit sequenced entries, and return the number integrated.
+	Integrate func(ctx context.Context, limit uint64) (uint64, error)
+	// Integrated, if non-nil, is called after each successful pass.
+	Integrated func()
+}
+
+// Run integrates entries until ctx is done.
+func (l IntegrationLoop) Run(ctx context.Context) {
+	catchingUp := false
+	for {
+		if !catchingUp {
+			select {
+			case <-ctx.Done():
+				return
+			case <-l.Backoff.Woken():
+				// The interval has been shortened, so restart the wait.
+				continue
+			case <-time.After(l.Backoff.Interval()):
+			}
+		} else if ctx.Err() != nil {
+			return
+		}
+
+		limit := l.SizeLimit
+		if catchingUp {
+			limit = l.CatchUpSizeLimit
+		}
+		n, err := l.pass(ctx, limit)
+		catchingUp = false
+		if err != nil {
+			klog.Errorf("integrate: %v", err)
+			continue
+		}
+		// Checkpoint publication is throttled separately, so quickly looping here won't cause the
+		// checkpoint to be updated too frequently.
+		catchingUp = l.CatchUpSizeLimit > 0 && n >= limit
+		if n == 0 {
+			l.Backoff.Idle()
+		} else {
+			l.Backoff.Busy()
+		}
+		if l.Integrated != nil {
+			l.Integrated()
+		}
+	}
+}
+
+// pass runs a single integration pass of up to limit entries.
+func (l IntegrationLoop) pass(ctx context.Context, limit uint64) (uint64, error) {
+	timeout := integrationPassTimeout
+	if l.SizeLimit > 0 && limit > l.SizeLimit {
+		timeout = time.Duration((limit+l.SizeLimit-1)/l.SizeLimit) * integrationPassTimeout
+	}
+	cctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return l.Integrate(cctx, limit)
+}
@@ -0,0 +1,88 @@
+// Copyright 2024 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	storage "github.com/transparency-dev/trillian-tessera/storage/internal"
+)
+
+func TestIntegrationLoopCatchUp(t *testing.T) {
+	for _, test := range []struct {
+		name             string
+		sizeLimit        uint64
+		catchUpSizeLimit uint64
+		// backlog is the number of entries initially waiting to be integrated.
+		backlog uint64
+		// arrivals is the number of entries sequenced before each pass.
+		arrivals   uint64
+		wantLimits []uint64
+	}{
+		{
+			name:       "catch-up disabled",
+			sizeLimit:  100,
+			backlog:    2500,
+			wantLimits: []uint64{100, 100, 100, 100},
+		}, {
+			name:             "catch-up enabled, backlog",
+			sizeLimit:        100,
+			catchUpSizeLimit: 1000,
+			backlog:          2500,
+			wantLimits:       []uint64{100, 1000, 1000, 1000},
+		}, {
+			name:             "catch-up enabled, ends when pass isn't saturated",
+			sizeLimit:        100,
+			catchUpSizeLimit: 1000,
+			backlog:          1500,
+			wantLimits:       []uint64{100, 1000, 1000, 100},
+		}, {
+			name:             "catch-up enabled, steady traffic",
+			sizeLimit:        100,
+			catchUpSizeLimit: 1000,
+			arrivals:         50,
+			wantLimits:       []uint64{100, 100, 100, 100},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			backlog := test.backlog
+			var limits []uint64
+			l := storage.IntegrationLoop{
+				SizeLimit:        test.sizeLimit,
+				CatchUpSizeLimit: test.catchUpSizeLimit,
+				Backoff:          storage.NewIdleBackoff(time.Millisecond, 0, 0),
+				Integrate: func(_ context.Context, limit uint64) (uint64, error) {
+					limits = append(limits, limit)
+					if len(limits) == len(test.wantLimits) {
+						cancel()
+					}
+					backlog += test.arrivals
+					n := min(limit, backlog)
+					backlog -= n
+					return n, nil
+				},
+			}
+			l.Run(ctx)
+
+			if diff := cmp.Diff(test.wantLimits, limits); diff != "" {
+				t.Errorf("Integrate limits diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+// Copyright 2024 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// Jitter randomises a periodic interval, e.g. that at which checkpoints are published, so that
+// many instances configured with the same interval don't all do their work at the same moment.
+type Jitter struct {
+	// Interval is the nominal interval.
+	Interval time.Duration
+	// Fraction is the maximum proportion of Interval by which it may be randomly lengthened or shortened.
+	Fraction float64
+	// Floor is the shortest interval permitted, regardless of Fraction.
+	Floor time.Duration
+}
+
+// Min returns the shortest interval which Next may return.
+func (j Jitter) Min() time.Duration {
+	return max(time.Duration(float64(j.Interval)*(1-j.Fraction)), j.Floor)
+}
+
+// Next returns a random interval within ±Fraction of Interval, but no less than Floor.
+func (j Jitter) Next() time.Duration {
+	if j.Fraction <= 0 {
+		return max(j.Interval, j.Floor)
+	}
+	d := time.Duration(float64(j.Interval) * (1 + j.Fraction*(2*rand.Float64()-1)))
+	return max(d, j.Floor)
+}
@@ -0,0 +1,62 @@
+// Copyright 2024 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestJitter(t *testing.T) {
+	for _, test := range []struct {
+		name             string
+		j                Jitter
+		wantMin, wantMax time.Duration
+	}{
+		{
+			name:    "no jitter",
+			j:       Jitter{Interval: 10 * time.Second},
+			wantMin: 10 * time.Second,
+			wantMax: 10 * time.Second,
+		}, {
+			name:    "jitter",
+			j:       Jitter{Interval: 10 * time.Second, Fraction: 0.2},
+			wantMin: 8 * time.Second,
+			wantMax: 12 * time.Second,
+		}, {
+			name:    "floor",
+			j:       Jitter{Interval: 2 * time.Second, Fraction: 0.5, Floor: 1500 * time.Millisecond},
+			wantMin: 1500 * time.Millisecond,
+			wantMax: 3 * time.Second,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.j.Min(); got != test.wantMin {
+				t.Errorf("Min() = %v, want %v", got, test.wantMin)
+			}
+			varied := false
+			for i := 0; i < 1000; i++ {
+				got := test.j.Next()
+				if got < test.wantMin || got > test.wantMax {
+					t.Fatalf("Next() = %v, want in [%v, %v]", got, test.wantMin, test.wantMax)
+				}
+				varied = varied || got != test.j.Interval
+			}
+			if wantVaried := test.j.Fraction > 0; varied != wantVaried {
+				t.Errorf("Next() varied = %t, want %t", varied, wantVaried)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2024 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/transparency-dev/trillian-tessera/api"
+	"github.com/transparency-dev/trillian-tessera/api/layout"
+	"github.com/transparency-dev/trillian-tessera/internal/options"
+	"golang.org/x/mod/sumdb/note"
+)
+
+// MarshalLogMetadata returns the JSON encoded metadata which should be published for the log, as configured
+// by the WithLogMetadata option, or nil if the option wasn't provided.
+//
+// Storage implementations should call this before creating any resources, so that invalid keys are reported
+// as an error from their New.
+func MarshalLogMetadata(opt *options.StorageOptions) ([]byte, error) {
+	if opt.MetadataVerifierKeys == nil {
+		return nil, nil
+	}
+	if len(opt.MetadataVerifierKeys) == 0 {
+		return nil, errors.New("WithLogMetadata: at least one verifier key must be provided")
+	}
+	origin := ""
+	for i, k := range opt.MetadataVerifierKeys {
+		v, err := note.NewVerifier(k)
+		if err != nil {
+			return nil, fmt.Errorf("WithLogMetadata: invalid verifier key %q: %v", k, err)
+		}
+		if i == 0 {
+			origin = v.Name()
+		} else if v.Name() != origin {
+			return nil, fmt.Errorf("WithLogMetadata: verifier key name (%q) does not match first key name (%q)", v.Name(), origin)
+		}
+	}
+	return json.Marshal(api.LogMetadata{
+		Origin:           origin,
+		PublicKeys:       opt.MetadataVerifierKeys,
+		TileHeight:       layout.TileHeight,
+		EntryBundleWidth: layout.EntryBundleWidth,
+	})
+}
+
+// PublishLogMetadata writes the log metadata m, as returned by MarshalLogMetadata, to the metadata path of
+// the log using the provided write function. Nothing is written if m is empty.
+func PublishLogMetadata(ctx context.Context, m []byte, write func(ctx context.Context, path string, data []byte) error) error {
+	if len(m) == 0 {
+		return nil
+	}
+	// This is rewritten on every start so that it reflects the current configuration, e.g. rotated keys.
+	if err := write(ctx, layout.MetadataPath, m); err != nil {
+		return fmt.Errorf("failed to publish log metadata: %v", err)
+	}
+	return nil
+}
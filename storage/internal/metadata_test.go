@@ -0,0 +1,140 @@
+// Copyright 2024 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/transparency-dev/trillian-tessera/api"
+	"github.com/transparency-dev/trillian-tessera/api/layout"
+	"github.com/transparency-dev/trillian-tessera/internal/options"
+	"golang.org/x/mod/sumdb/note"
+)
+
+func mustVKey(t *testing.T, name string) string {
+	t.Helper()
+	_, vkey, err := note.GenerateKey(rand.Reader, name)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	return vkey
+}
+
+func TestMarshalLogMetadata(t *testing.T) {
+	k1, k2, other := mustVKey(t, "example.com/log"), mustVKey(t, "example.com/log"), mustVKey(t, "example.com/other")
+	for _, test := range []struct {
+		name    string
+		keys    []string
+		want    api.LogMetadata
+		wantErr bool
+	}{
+		{
+			name: "not configured",
+		}, {
+			name: "single key",
+			keys: []string{k1},
+			want: api.LogMetadata{Origin: "example.com/log", PublicKeys: []string{k1}, TileHeight: layout.TileHeight, EntryBundleWidth: layout.EntryBundleWidth},
+		}, {
+			name: "rotating keys",
+			keys: []string{k1, k2},
+			want: api.LogMetadata{Origin: "example.com/log", PublicKeys: []string{k1, k2}, TileHeight: layout.TileHeight, EntryBundleWidth: layout.EntryBundleWidth},
+		}, {
+			name:    "no keys",
+			keys:    []string{},
+			wantErr: true,
+		}, {
+			name:    "invalid key",
+			keys:    []string{"not a key"},
+			wantErr: true,
+		}, {
+			name:    "mismatched names",
+			keys:    []string{k1, other},
+			wantErr: true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			raw, err := MarshalLogMetadata(&options.StorageOptions{MetadataVerifierKeys: test.keys})
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Fatalf("MarshalLogMetadata: got err %v, want err %t", err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if test.keys == nil {
+				if raw != nil {
+					t.Errorf("MarshalLogMetadata without keys: got %q, want nil", raw)
+				}
+				return
+			}
+			var got api.LogMetadata
+			if err := json.Unmarshal(raw, &got); err != nil {
+				t.Fatalf("Unmarshal: %v", err)
+			}
+			if d := cmp.Diff(test.want, got); d != "" {
+				t.Errorf("got metadata with diff:\n%s", d)
+			}
+		})
+	}
+}
+
+func TestPublishLogMetadata(t *testing.T) {
+	ctx := context.Background()
+	for _, test := range []struct {
+		name      string
+		m         []byte
+		writeErr  error
+		wantWrite bool
+		wantErr   bool
+	}{
+		{
+			name: "no metadata",
+		}, {
+			name:      "ok",
+			m:         []byte("{}"),
+			wantWrite: true,
+		}, {
+			name:      "write error",
+			m:         []byte("{}"),
+			writeErr:  errors.New("bang"),
+			wantWrite: true,
+			wantErr:   true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			written := false
+			err := PublishLogMetadata(ctx, test.m, func(_ context.Context, path string, data []byte) error {
+				written = true
+				if path != layout.MetadataPath {
+					t.Errorf("got path %q, want %q", path, layout.MetadataPath)
+				}
+				if string(data) != string(test.m) {
+					t.Errorf("got data %q, want %q", data, test.m)
+				}
+				return test.writeErr
+			})
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Fatalf("PublishLogMetadata: %v, wantErr %t", err, test.wantErr)
+			}
+			if written != test.wantWrite {
+				t.Errorf("got written %t, want %t", written, test.wantWrite)
+			}
+		})
+	}
+}
@@ -0,0 +1,484 @@
+// Copyright 2024 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/gob"
+	"fmt"
+	"strings"
+	"time"
+
+	tessera "github.com/transparency-dev/trillian-tessera"
+	storage "github.com/transparency-dev/trillian-tessera/storage/internal"
+	"k8s.io/klog/v2"
+)
+
+// mySQLSequencer uses MySQL to provide
+// a durable and thread/multi-process safe sequencer.
+type mySQLSequencer struct {
+	metrics        *metrics
+	dbPool         *sql.DB
+	maxOutstanding uint64
+	// emptyRoot is the root hash of the empty tree, used when initialising the IntCoord table.
+	emptyRoot []byte
+}
+
+// newMySQLSequencer returns a new mysqlSequencer struct which uses the provided
+// DSN for its MySQL connection.
+func newMySQLSequencer(ctx context.Context, dsn string, maxOutstanding uint64, maxOpenConns, maxIdleConns int, emptyRoot []byte, skipSchemaInit, electPublisher bool) (*mySQLSequencer, error) {
+	dbPool, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to MySQL db: %v", err)
+	}
+
+	if maxOpenConns > 0 {
+		dbPool.SetMaxOpenConns(maxOpenConns)
+	}
+	if maxIdleConns >= 0 {
+		dbPool.SetMaxIdleConns(maxIdleConns)
+	}
+
+	if err := dbPool.Ping(); err != nil {
+		return nil, fmt.Errorf("failed to ping MySQL db: %v", err)
+	}
+
+	r := &mySQLSequencer{
+		dbPool:         dbPool,
+		metrics:        defaultMetrics,
+		maxOutstanding: maxOutstanding,
+		emptyRoot:      emptyRoot,
+	}
+
+	if err := r.initDB(ctx, skipSchemaInit, electPublisher); err != nil {
+		return nil, fmt.Errorf("failed to initDB: %v", err)
+	}
+	return r, nil
+}
+
+// initDB ensures that the coordination DB is initialised correctly.
+//
+// It creates tables if they don't exist already, and inserts zero values.
+// If skipSchemaInit is true, the tables are assumed to have been created externally and are only
+// checked for presence, so that no DDL privileges are required. The PubCoord table is only checked if
+// electPublisher is true.
+//
+// The database schema consists of 3 tables:
+//   - SeqCoord
+//     This table only ever contains a single row which tracks the next available
+//     sequence number.
+//   - Seq
+//     This table holds sequenced "batches" of entries. The batches are keyed
+//     by the sequence number assigned to the first entry in the batch, and
+//     each subsequent entry in the batch takes the numerically next sequence number.
+//   - IntCoord
+//     This table coordinates integration of the batches of entries stored in
+//     Seq into the committed tree state.
+//
+// An optional 4th table, PubCoord, holds the lease used when Config.ElectCheckpointPublisher is set.
+func (s *mySQLSequencer) initDB(ctx context.Context, skipSchemaInit, electPublisher bool) error {
+	if skipSchemaInit {
+		if err := s.checkSchema(ctx, electPublisher); err != nil {
+			return err
+		}
+	} else if err := s.createSchema(ctx); err != nil {
+		return err
+	}
+
+	// Set default values for a newly initialised schema - these rows being present are a precondition for
+	// sequencing and integration to occur.
+	// Note that this will only succeed if no row exists, so there's no danger
+	// of "resetting" an existing log.
+	if _, err := s.dbPool.ExecContext(ctx,
+		`INSERT IGNORE INTO SeqCoord (id, next) VALUES (0, 0)`); err != nil {
+		return err
+	}
+	if _, err := s.dbPool.ExecContext(ctx,
+		`INSERT IGNORE INTO IntCoord (id, seq, rootHash) VALUES (0, 0, ?)`, s.emptyRoot); err != nil {
+		return err
+	}
+	return nil
+}
+
+// checkSchema ensures that the tables and columns used by the sequencer are present.
+//
+// If electPublisher is true, the PubCoord table used for the publisher lease must also be present; otherwise
+// a missing table would only be noticed as a lease failure, causing every instance to publish checkpoints.
+func (s *mySQLSequencer) checkSchema(ctx context.Context, electPublisher bool) error {
+	qs := []string{
+		"SELECT id, next FROM SeqCoord LIMIT 0",
+		"SELECT id, seq, v FROM Seq LIMIT 0",
+		"SELECT id, seq, rootHash FROM IntCoord LIMIT 0",
+	}
+	if electPublisher {
+		qs = append(qs, "SELECT id, holder, expiresAt FROM PubCoord LIMIT 0")
+	}
+	for _, q := range qs {
+		rows, err := s.dbPool.QueryContext(ctx, q)
+		if err != nil {
+			return fmt.Errorf("schema check %q failed, has the schema been provisioned?: %v", q, err)
+		}
+		if err := rows.Close(); err != nil {
+			return fmt.Errorf("schema check %q: %v", q, err)
+		}
+	}
+	return nil
+}
+
+// createSchema creates the tables used by the sequencer if they don't already exist.
+func (s *mySQLSequencer) createSchema(ctx context.Context) error {
+	if _, err := s.dbPool.ExecContext(ctx,
+		`CREATE TABLE IF NOT EXISTS SeqCoord(
+			id INT UNSIGNED NOT NULL,
+			next BIGINT UNSIGNED NOT NULL,
+			PRIMARY KEY (id)
+		)`); err != nil {
+		return err
+	}
+	// TODO(phboneff): test this with very large leaves, consider downgrading to MEDIUMBLOB.
+	// Keep in mind that CT leaves can be large, as large as: https://crt.sh/?id=10751627.
+	if _, err := s.dbPool.ExecContext(ctx,
+		`CREATE TABLE IF NOT EXISTS Seq(
+			id INT UNSIGNED NOT NULL,
+			seq BIGINT UNSIGNED NOT NULL,
+			v LONGBLOB,
+			PRIMARY KEY (id, seq)
+		)`); err != nil {
+		return err
+	}
+	if _, err := s.dbPool.ExecContext(ctx,
+		`CREATE TABLE IF NOT EXISTS IntCoord(
+			id INT UNSIGNED NOT NULL,
+			seq BIGINT UNSIGNED NOT NULL,
+			rootHash TINYBLOB NOT NULL,
+			PRIMARY KEY (id)
+		)`); err != nil {
+		return err
+	}
+	// expiresAt is a millisecond UNIX timestamp.
+	if _, err := s.dbPool.ExecContext(ctx,
+		`CREATE TABLE IF NOT EXISTS PubCoord(
+			id INT UNSIGNED NOT NULL,
+			holder VARCHAR(64) NOT NULL,
+			expiresAt BIGINT NOT NULL,
+			PRIMARY KEY (id)
+		)`); err != nil {
+		return err
+	}
+	return nil
+}
+
+// assignEntries durably assigns each of the passed-in entries an index in the log.
+//
+// Entries are allocated contiguous indices, in the order in which they appear in the entries parameter.
+// This is achieved by storing the passed-in entries in the Seq table in MySQL, keyed by the
+// index assigned to the first entry in the batch.
+func (s *mySQLSequencer) assignEntries(ctx context.Context, entries []*tessera.Entry) error {
+	ctx, span := tracer.Start(ctx, "tessera.storage.objectstore.assignEntries")
+	defer span.End()
+	span.SetAttributes(batchSizeKey.Int(len(entries)))
+
+	// First grab the treeSize in a non-locking read-only fashion (we don't want to block/collide with integration).
+	// We'll use this value to determine whether we need to apply back-pressure.
+	var treeSize uint64
+	row := s.dbPool.QueryRowContext(ctx, "SELECT seq FROM IntCoord WHERE id = ?", 0)
+	if err := row.Scan(&treeSize); err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read integration coordination info: %v", err)
+	}
+
+	// Now move on with sequencing in a single transaction
+	tx, err := s.dbPool.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin Tx: %v", err)
+	}
+	defer func() {
+		if tx != nil {
+			if err := tx.Rollback(); err != nil {
+				klog.Errorf("failed to rollback Tx: %v", err)
+			}
+		}
+	}()
+
+	// First we need to grab the next available sequence number from the SeqCoord table.
+	var next, id uint64
+	r := tx.QueryRowContext(ctx, "SELECT id, next FROM SeqCoord WHERE id = ? FOR UPDATE", 0)
+	if err := r.Scan(&id, &next); err != nil {
+		return fmt.Errorf("failed to read seqcoord: %v", err)
+	}
+
+	// Check whether there are too many outstanding entries and we should apply
+	// back-pressure.
+	if outstanding := next - treeSize; outstanding > s.maxOutstanding {
+		s.metrics.pushbacks.Add(ctx, 1)
+		return tessera.ErrPushback
+	}
+
+	sequencedEntries := make([]storage.SequencedEntry, len(entries))
+	// Assign provisional sequence numbers to entries.
+	// We need to do this here in order to support serialisations which include the log position.
+	for i, e := range entries {
+		sequencedEntries[i] = storage.SequencedEntry{
+			BundleData: e.MarshalBundleData(next + uint64(i)),
+			LeafHash:   e.LeafHash(),
+		}
+	}
+
+	// Flatten the entries into a single slice of bytes which we can store in the Seq.v column.
+	b := &bytes.Buffer{}
+	e := gob.NewEncoder(b)
+	if err := e.Encode(sequencedEntries); err != nil {
+		return fmt.Errorf("failed to serialise batch: %v", err)
+	}
+	data := b.Bytes()
+	num := uint64(len(entries))
+
+	// Insert our newly sequenced batch of entries into Seq,
+	if _, err := tx.ExecContext(ctx, "INSERT INTO Seq(id, seq, v) VALUES(?, ?, ?)", 0, next, data); err != nil {
+		return fmt.Errorf("insert into seq: %v", err)
+	}
+	// and update the next-available sequence number row in SeqCoord.
+	if _, err := tx.ExecContext(ctx, "UPDATE SeqCoord SET next = ? WHERE ID = ?", next+num, 0); err != nil {
+		return fmt.Errorf("update seqcoord: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit Tx: %v", err)
+	}
+	tx = nil
+	s.metrics.entriesSequenced.Add(ctx, int64(num))
+
+	return nil
+}
+
+// consumeEntries calls f with previously sequenced entries.
+//
+// Once f returns without error, the entries it was called with are considered to have been consumed and are
+// removed from the Seq table.
+//
+// Returns true if some entries were consumed as a weak signal that there may be further entries waiting to be consumed.
+func (s *mySQLSequencer) consumeEntries(ctx context.Context, limit uint64, f consumeFunc, forceUpdate bool) (bool, error) {
+	ctx, span := tracer.Start(ctx, "tessera.storage.objectstore.consumeEntries")
+	defer span.End()
+
+	tx, err := s.dbPool.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin Tx: %v", err)
+	}
+	defer func() {
+		if tx != nil {
+			if err := tx.Rollback(); err != nil {
+				klog.Errorf("failed to rollback Tx: %v", err)
+			}
+		}
+	}()
+
+	// Figure out which is the starting index of sequenced entries to start consuming from.
+	row := tx.QueryRowContext(ctx, "SELECT seq, rootHash FROM IntCoord WHERE id = ? FOR UPDATE", 0)
+	var fromSeq uint64
+	var rootHash []byte
+	if err := row.Scan(&fromSeq, &rootHash); err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to read IntCoord: %v", err)
+	}
+	klog.V(1).Infof("Consuming from %d", fromSeq)
+
+	// Now read the sequenced starting at the index we got above.
+	rows, err := tx.QueryContext(ctx, "SELECT seq, v FROM Seq WHERE id = ? AND seq >= ? ORDER BY seq LIMIT ? FOR UPDATE", 0, fromSeq, limit)
+	if err != nil {
+		return false, fmt.Errorf("failed to read Seq: %v", err)
+	}
+	defer rows.Close()
+
+	// This needs to be of type `any`, to be passed to ExecContext. Only uint64s will be stored.
+	seqsConsumed := []any{}
+	entries := make([]storage.SequencedEntry, 0, limit)
+	orderCheck := fromSeq
+	for rows.Next() {
+
+		var vGob []byte
+		var seq uint64
+		if err := rows.Scan(&seq, &vGob); err != nil {
+			return false, fmt.Errorf("failed to scan Seq row: %v", err)
+		}
+
+		if orderCheck != seq {
+			return false, fmt.Errorf("integrity fail - expected seq %d, but found %d", orderCheck, seq)
+		}
+
+		g := gob.NewDecoder(bytes.NewReader(vGob))
+		b := []storage.SequencedEntry{}
+		if err := g.Decode(&b); err != nil {
+			return false, fmt.Errorf("failed to deserialise v from Seq: %v", err)
+		}
+		entries = append(entries, b...)
+		seqsConsumed = append(seqsConsumed, seq)
+		orderCheck += uint64(len(b))
+	}
+	if len(seqsConsumed) == 0 && !forceUpdate {
+		klog.V(1).Info("Found no rows to sequence")
+		return false, nil
+	}
+
+	// Call consumeFunc with the entries we've found
+	newRoot, err := f(ctx, uint64(fromSeq), entries)
+	if err != nil {
+		return false, err
+	}
+
+	// consumeFunc was successful, so we can update our coordination row, and delete the row(s) for
+	// the then consumed entries.
+	if _, err := tx.ExecContext(ctx, "UPDATE IntCoord SET seq=?, rootHash=? WHERE id=?", orderCheck, newRoot, 0); err != nil {
+		return false, fmt.Errorf("update intcoord: %v", err)
+	}
+
+	if len(seqsConsumed) > 0 {
+		// TODO(phboneff): evaluate if seq BETWEEN ? AND ? is more efficient
+		q := "DELETE FROM Seq WHERE id=? AND seq IN ( " + placeholder(len(seqsConsumed)) + " )"
+		if _, err := tx.ExecContext(ctx, q, append([]any{0}, seqsConsumed...)...); err != nil {
+			return false, fmt.Errorf("update intcoord: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit Tx: %v", err)
+	}
+	tx = nil
+
+	return true, nil
+}
+
+// currentTree returns the size and root hash of the currently integrated tree.
+func (s *mySQLSequencer) currentTree(ctx context.Context) (uint64, []byte, error) {
+	row := s.dbPool.QueryRowContext(ctx, "SELECT seq, rootHash FROM IntCoord WHERE id = ?", 0)
+	var fromSeq uint64
+	var rootHash []byte
+	if err := row.Scan(&fromSeq, &rootHash); err != nil {
+		return 0, nil, fmt.Errorf("failed to read IntCoord: %v", err)
+	}
+
+	return fromSeq, rootHash, nil
+}
+
+// publisherLease acquires or renews the publisher lease for holder if it's unheld, expired, or already
+// held by holder.
+func (s *mySQLSequencer) publisherLease(ctx context.Context, holder string, ttl time.Duration) (bool, error) {
+	tx, err := s.dbPool.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin Tx: %v", err)
+	}
+	defer func() {
+		if tx != nil {
+			if err := tx.Rollback(); err != nil {
+				klog.Errorf("failed to rollback Tx: %v", err)
+			}
+		}
+	}()
+
+	// Ensure the lease row exists so that it can be locked below.
+	if _, err := tx.ExecContext(ctx, "INSERT IGNORE INTO PubCoord (id, holder, expiresAt) VALUES (0, '', 0)"); err != nil {
+		return false, fmt.Errorf("failed to initialise PubCoord: %v", err)
+	}
+	var curHolder string
+	var expiresAt int64
+	if err := tx.QueryRowContext(ctx, "SELECT holder, expiresAt FROM PubCoord WHERE id = ? FOR UPDATE", 0).Scan(&curHolder, &expiresAt); err != nil {
+		return false, fmt.Errorf("failed to read PubCoord: %v", err)
+	}
+	now := time.Now()
+	if curHolder != holder && now.Before(time.UnixMilli(expiresAt)) {
+		return false, nil
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE PubCoord SET holder = ?, expiresAt = ? WHERE id = ?", holder, now.Add(ttl).UnixMilli(), 0); err != nil {
+		return false, fmt.Errorf("failed to update PubCoord: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit Tx: %v", err)
+	}
+	tx = nil
+	return true, nil
+}
+
+func placeholder(n int) string {
+	places := make([]string, n)
+	for i := 0; i < n; i++ {
+		places[i] = "?"
+	}
+	return strings.Join(places, ",")
+}
+
+// CoordinationState is a snapshot of the contents of the MySQL coordination tables.
+type CoordinationState struct {
+	// Next is the next sequence number to be assigned, from SeqCoord.
+	Next uint64
+	// Integrated is the size of the integrated tree, from IntCoord.
+	Integrated uint64
+	// RootHash is the root hash of the integrated tree, from IntCoord.
+	RootHash []byte
+	// PendingBatches is the number of batches in the Seq table awaiting integration.
+	PendingBatches uint64
+	// StaleBatches is the number of batches in the Seq table which precede the integrated tree size.
+	// These should have been removed during integration.
+	StaleBatches uint64
+}
+
+// InspectCoordination returns the current state of the coordination tables in the MySQL database at dsn.
+//
+// The tables are read in a single read-only transaction without locking reads, so a live log is not
+// affected. This is intended to help diagnose integration backlogs and stuck sequencers.
+func InspectCoordination(ctx context.Context, dsn string) (CoordinationState, error) {
+	dbPool, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return CoordinationState{}, fmt.Errorf("failed to connect to MySQL db: %v", err)
+	}
+	defer func() {
+		if err := dbPool.Close(); err != nil {
+			klog.Warningf("Failed to close db: %v", err)
+		}
+	}()
+	return (&mySQLSequencer{dbPool: dbPool}).inspect(ctx)
+}
+
+// inspect reads the coordination tables without taking any locks.
+func (s *mySQLSequencer) inspect(ctx context.Context) (CoordinationState, error) {
+	var r CoordinationState
+	tx, err := s.dbPool.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return r, fmt.Errorf("failed to begin Tx: %v", err)
+	}
+	// Nothing is written, so the transaction is always rolled back.
+	defer func() {
+		if err := tx.Rollback(); err != nil {
+			klog.Errorf("failed to rollback Tx: %v", err)
+		}
+	}()
+
+	if err := tx.QueryRowContext(ctx, "SELECT next FROM SeqCoord WHERE id = ?", 0).Scan(&r.Next); err != nil {
+		return r, fmt.Errorf("failed to read SeqCoord: %v", err)
+	}
+	if err := tx.QueryRowContext(ctx, "SELECT seq, rootHash FROM IntCoord WHERE id = ?", 0).Scan(&r.Integrated, &r.RootHash); err != nil {
+		return r, fmt.Errorf("failed to read IntCoord: %v", err)
+	}
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM Seq WHERE id = ? AND seq >= ?", 0, r.Integrated).Scan(&r.PendingBatches); err != nil {
+		return r, fmt.Errorf("failed to count pending Seq rows: %v", err)
+	}
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM Seq WHERE id = ? AND seq < ?", 0, r.Integrated).Scan(&r.StaleBatches); err != nil {
+		return r, fmt.Errorf("failed to count stale Seq rows: %v", err)
+	}
+	return r, nil
+}
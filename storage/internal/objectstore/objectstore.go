@@ -0,0 +1,773 @@
+// Copyright 2024 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package objectstore contains the parts of a Tessera storage implementation which are common to the
+// object store based implementations which use MySQL for coordination, i.e. AWS and Azure.
+//
+// Entry bundles and log tiles are stored in, and served from, an object store provided by the
+// implementation. The object names are selected so as to conform to the expected layout of a
+// tile-based log.
+//
+// A MySQL database provides a transactional mechanism to allow multiple frontends to safely update
+// the contents of the log.
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/transparency-dev/merkle"
+	tessera "github.com/transparency-dev/trillian-tessera"
+	"github.com/transparency-dev/trillian-tessera/api"
+	"github.com/transparency-dev/trillian-tessera/api/layout"
+	"github.com/transparency-dev/trillian-tessera/internal/options"
+	storage "github.com/transparency-dev/trillian-tessera/storage/internal"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/errgroup"
+	"k8s.io/klog/v2"
+
+	_ "github.com/go-sql-driver/mysql"
+)
+
+const (
+	logContType           = "application/octet-stream"
+	ckptContType          = "text/plain; charset=utf-8"
+	metaContType          = "application/json"
+	minCheckpointInterval = time.Second
+
+	DefaultPushbackMaxOutstanding = tessera.DefaultPushbackMaxOutstanding
+	DefaultIntegrationSizeLimit   = 5 * 4096
+
+	// integrationInterval is how frequently we poll for sequenced entries to integrate while the log is busy.
+	integrationInterval = time.Second
+	// integrationIdleThreshold is the number of consecutive idle polls after which we start backing off.
+	integrationIdleThreshold = 3
+	// publisherLeaseIntervals is the number of checkpoint intervals for which the publisher lease is held
+	// without being renewed, i.e. how long it takes for another instance to take over publishing checkpoints
+	// if the current publisher stops.
+	publisherLeaseIntervals = 3
+)
+
+// ErrObjectExists is returned, wrapped, by ObjStore.SetObjectIfNoneMatch when the object already exists.
+var ErrObjectExists = errors.New("object already exists")
+
+// Storage is a storage implementation for Tessera which keeps the log in an ObjStore, and
+// coordinates sequencing and integration via MySQL.
+type Storage struct {
+	// metrics holds the instruments used to record this instance's activity.
+	metrics     *metrics
+	newCP       options.NewCPFunc
+	entriesPath options.EntriesPathFunc
+	hasher      merkle.LogHasher
+	// integrationWorkers is the number of goroutines used to hash entries during integration.
+	integrationWorkers uint
+	// newResourceSig, if non-nil, is used to sign tiles and entry bundles as they're written.
+	newResourceSig options.NewResourceSigFunc
+
+	sequencer sequencer
+	objStore  ObjStore
+	// readStore, if set, is used instead of objStore to serve ReadCheckpoint, ReadTile, and ReadEntryBundle.
+	readStore ObjStore
+	// readCache, if non-nil, caches the immutable resources returned by ReadTile and ReadEntryBundle.
+	readCache *storage.ReadCache
+
+	// publishPolicy decides whether a checkpoint should be published for the current tree.
+	publishPolicy storage.PublishPolicy
+	// publishingPaused, if set, prevents new checkpoints from being published.
+	publishingPaused atomic.Bool
+	// electPublisher, if set, causes checkpoints to be published only while this instance holds the
+	// publisher lease, identified by publisherID.
+	electPublisher bool
+	publisherID    string
+	// publisherLease is how long the publisher lease is held for without being renewed.
+	publisherLease time.Duration
+
+	queue *storage.Queue
+	// integrationBackoff controls how frequently we poll for sequenced entries to integrate.
+	integrationBackoff *storage.IdleBackoff
+	// integrationSizeLimit is the maximum number of entries integrated in a single pass.
+	integrationSizeLimit uint64
+	// catchUpSizeLimit, if non-zero, is the integration size limit used while catching up on a backlog.
+	catchUpSizeLimit uint64
+
+	treeUpdated chan struct{}
+}
+
+// ObjStore describes a type which can store and retrieve objects.
+type ObjStore interface {
+	// GetObject returns the content of the named object.
+	// Returns an error wrapping os.ErrNotExist if the object does not exist.
+	GetObject(ctx context.Context, obj string) ([]byte, error)
+	// SetObject stores data in the named object, overwriting any existing content.
+	SetObject(ctx context.Context, obj string, data []byte, contType string) error
+	// SetObjectIfNoneMatch stores data in the named object only if it doesn't already exist.
+	// Returns an error wrapping ErrObjectExists if it does.
+	SetObjectIfNoneMatch(ctx context.Context, obj string, data []byte, contType string) error
+	// LastModified returns the time at which the named object was last written.
+	// Returns an error wrapping os.ErrNotExist if the object does not exist.
+	LastModified(ctx context.Context, obj string) (time.Time, error)
+	// Exists returns true if the named object exists, without fetching its content.
+	Exists(ctx context.Context, obj string) (bool, error)
+}
+
+// sequencer describes a type which knows how to sequence entries.
+type sequencer interface {
+	// assignEntries should durably allocate contiguous index numbers to the provided entries.
+	assignEntries(ctx context.Context, entries []*tessera.Entry) error
+	// consumeEntries should call the provided function with up to limit previously sequenced entries.
+	// If the call to consumeFunc returns no error, the entries should be considered to have been consumed.
+	// If any entries were successfully consumed, the implementation should also return true; this
+	// serves as a weak hint that there may be more entries to be consumed.
+	// If forceUpdate is true, then the consumeFunc should be called, with an empty slice of entries if
+	// necessary. This allows the log self-initialise in a transactionally safe manner.
+	consumeEntries(ctx context.Context, limit uint64, f consumeFunc, forceUpdate bool) (bool, error)
+
+	// currentTree returns the sequencer's view of the current tree state.
+	currentTree(ctx context.Context) (uint64, []byte, error)
+	// publisherLease attempts to acquire or renew, for holder, the lease which elects a single instance
+	// to publish checkpoints. Returns true if holder holds the lease for the next ttl.
+	publisherLease(ctx context.Context, holder string, ttl time.Duration) (bool, error)
+}
+
+// consumeFunc is the signature of a function which can consume entries from the sequencer.
+// Returns the updated root hash of the tree with the consumed entries integrated.
+type consumeFunc func(ctx context.Context, from uint64, entries []storage.SequencedEntry) ([]byte, error)
+
+// Config holds the configuration for a Storage instance.
+type Config struct {
+	// ObjStore is the object store in which the log is kept.
+	ObjStore ObjStore
+	// ReadStore, if non-nil, is the object store from which ReadCheckpoint, ReadTile and ReadEntryBundle
+	// serve the log, e.g. a replica of ObjStore which is isolated from the write path.
+	//
+	// Integration always reads from and writes to ObjStore, so this does not affect the log itself. However,
+	// reads from ReadStore will lag behind writes to ObjStore by the replication delay, and since checkpoints,
+	// tiles, and entry bundles may be replicated independently, a checkpoint read from it may refer to tiles
+	// which have not yet been replicated.
+	ReadStore ObjStore
+	// DSN is the DSN of the MySQL instance to use.
+	DSN string
+	// Maximum connections to the MySQL database
+	MaxOpenConns int
+	// Maximum idle database connections in the connection pool
+	MaxIdleConns int
+	// ElectCheckpointPublisher causes the frontends sharing the log to elect, via a lease in the PubCoord
+	// table in MySQL, a single instance to publish checkpoints, rather than each of them attempting to
+	// update the checkpoint object every interval.
+	//
+	// If the lease can't be read or written, e.g. because the lease row can't be locked, the instance
+	// falls back to publishing checkpoints itself.
+	ElectCheckpointPublisher bool
+}
+
+// New creates a new Storage instance using the provided, already resolved, storage options.
+//
+// Storage instances created via this c'tor will participate in integrating newly sequenced entries into the log
+// and periodically publishing a new checkpoint which commits to the state of the tree.
+func New(ctx context.Context, cfg Config, opt *options.StorageOptions) (*Storage, error) {
+	if opt.PushbackMaxOutstanding == 0 {
+		opt.PushbackMaxOutstanding = DefaultPushbackMaxOutstanding
+	}
+	minInterval := storage.MinCheckpointInterval(opt, minCheckpointInterval)
+	if opt.CheckpointInterval < minInterval {
+		return nil, fmt.Errorf("requested CheckpointInterval (%v) is less than minimum permitted %v", opt.CheckpointInterval, minInterval)
+	}
+	metadata, err := storage.MarshalLogMetadata(opt)
+	if err != nil {
+		return nil, err
+	}
+
+	seq, err := newMySQLSequencer(ctx, cfg.DSN, uint64(opt.PushbackMaxOutstanding), cfg.MaxOpenConns, cfg.MaxIdleConns, opt.Hasher.EmptyRoot(), opt.SkipSchemaInit, cfg.ElectCheckpointPublisher)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create MySQL sequencer: %v", err)
+	}
+	m, err := metricsFor(opt)
+	if err != nil {
+		return nil, err
+	}
+	seq.metrics = m
+
+	r := &Storage{
+		metrics:            m,
+		objStore:           cfg.ObjStore,
+		readStore:          cfg.ReadStore,
+		sequencer:          seq,
+		newCP:              opt.NewCP,
+		newResourceSig:     storage.ResourceSigner(opt),
+		entriesPath:        opt.EntriesPath,
+		hasher:             opt.Hasher,
+		integrationWorkers: opt.IntegrationWorkers,
+		treeUpdated:        make(chan struct{}),
+		publishPolicy:      storage.PublishPolicy{OnlyOnChange: opt.PublishOnlyOnChange, RepublishInterval: opt.CheckpointRepublishInterval},
+		readCache:          storage.NewReadCache(opt.ReadCacheMaxBytes, opt.ReadCacheTTL),
+		electPublisher:     cfg.ElectCheckpointPublisher,
+		publisherID:        newPublisherID(),
+		publisherLease:     publisherLeaseIntervals * opt.CheckpointInterval,
+	}
+	r.queue = storage.NewQueue(ctx, opt.BatchMaxAge, opt.BatchMaxSize, opt.MaxConcurrentAdds, opt.EntryBundleCodec, opt.Hasher, r.sequencer.assignEntries)
+	r.integrationBackoff = storage.NewIdleBackoff(integrationInterval, opt.IntegrationMaxIdleInterval, integrationIdleThreshold)
+	r.integrationSizeLimit = DefaultIntegrationSizeLimit
+	if opt.IntegrationSizeLimit != nil {
+		r.integrationSizeLimit = *opt.IntegrationSizeLimit
+	}
+	r.catchUpSizeLimit = uint64(opt.IntegrationCatchUpSizeLimit)
+
+	if err := r.init(ctx); err != nil {
+		return nil, fmt.Errorf("failed to initialise log storage: %v", err)
+	}
+	if err := storage.PublishLogMetadata(ctx, metadata, func(ctx context.Context, path string, data []byte) error {
+		return r.objStore.SetObject(ctx, path, data, metaContType)
+	}); err != nil {
+		return nil, err
+	}
+	if opt.VerifyRootOnInit {
+		if err := r.verifyRoot(ctx, opt.VerifyRootFull); err != nil {
+			return nil, fmt.Errorf("failed to verify log integrity: %v", err)
+		}
+	}
+
+	// Kick off go-routine which handles the integration of entries.
+	go r.consumeEntriesTask(ctx)
+
+	// Kick off go-routine which handles the publication of checkpoints.
+	go r.publishCheckpointTask(ctx, storage.Jitter{Interval: opt.CheckpointInterval, Fraction: opt.CheckpointIntervalJitter, Floor: minInterval})
+
+	return r, nil
+}
+
+// consumeEntriesTask periodically integrates newly sequenced entries.
+//
+// This function does not return until the passed context is done.
+func (s *Storage) consumeEntriesTask(ctx context.Context) {
+	storage.IntegrationLoop{
+		SizeLimit:        s.integrationSizeLimit,
+		CatchUpSizeLimit: s.catchUpSizeLimit,
+		Backoff:          s.integrationBackoff,
+		Integrate:        s.integrateSequenced,
+		Integrated: func() {
+			select {
+			case s.treeUpdated <- struct{}{}:
+			default:
+			}
+		},
+	}.Run(ctx)
+}
+
+// publishCheckpointTask periodically attempts to publish a new checkpoint representing the current state
+// of the tree, once per (jittered) interval.
+//
+// This function does not return until the passed in context is done.
+func (s *Storage) publishCheckpointTask(ctx context.Context, j storage.Jitter) {
+	t := time.NewTimer(j.Next())
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.treeUpdated:
+		case <-t.C:
+			t.Reset(j.Next())
+		}
+		if err := s.publishCheckpoint(ctx, j.Min()); err != nil {
+			klog.Warningf("publishCheckpoint: %v", err)
+		}
+	}
+}
+
+// Add is the entrypoint for adding entries to a sequencing log.
+func (s *Storage) Add(ctx context.Context, e *tessera.Entry) tessera.IndexFuture {
+	ctx, span := tracer.Start(ctx, "tessera.storage.objectstore.Add")
+	defer span.End()
+
+	if s.integrationBackoff != nil {
+		s.integrationBackoff.Wake()
+	}
+	return s.queue.Add(ctx, e)
+}
+
+// AddBatch adds all of the provided entries to the log, returning one future per entry in the same order.
+//
+// The entries are guaranteed to be assigned a contiguous range of indices, in the order provided.
+// Batches with more entries than can be queued together are rejected with tessera.ErrBatchTooLarge.
+func (s *Storage) AddBatch(ctx context.Context, entries []*tessera.Entry) []tessera.IndexFuture {
+	ctx, span := tracer.Start(ctx, "tessera.storage.objectstore.AddBatch")
+	defer span.End()
+
+	if s.integrationBackoff != nil {
+		s.integrationBackoff.Wake()
+	}
+	return s.queue.AddBatch(ctx, entries)
+}
+
+// ReadCheckpoint returns the latest published checkpoint.
+//
+// This, along with ReadTile and ReadEntryBundle, reads directly from the object store without consulting MySQL,
+// so reads remain available even if MySQL is not.
+func (s *Storage) ReadCheckpoint(ctx context.Context) ([]byte, error) {
+	return s.read(ctx, layout.CheckpointPath)
+}
+
+// ReadMetadata returns the log metadata published when the storage was created with the WithLogMetadata option.
+func (s *Storage) ReadMetadata(ctx context.Context) ([]byte, error) {
+	return s.read(ctx, layout.MetadataPath)
+}
+
+// ReadTile returns the requested tile.
+func (s *Storage) ReadTile(ctx context.Context, l, i uint64, p uint8) ([]byte, error) {
+	return s.readCache.Get(ctx, layout.TilePath(l, i, p), s.read)
+}
+
+// ReadEntryBundle returns the requested entry bundle.
+func (s *Storage) ReadEntryBundle(ctx context.Context, i uint64, p uint8) ([]byte, error) {
+	return s.readCache.Get(ctx, s.entriesPath(i, p), s.read)
+}
+
+// TileExists returns true if the requested tile is present, without fetching its content.
+func (s *Storage) TileExists(ctx context.Context, l, i uint64, p uint8) (bool, error) {
+	return s.exists(ctx, layout.TilePath(l, i, p))
+}
+
+// EntryBundleExists returns true if the requested entry bundle is present, without fetching its content.
+func (s *Storage) EntryBundleExists(ctx context.Context, i uint64, p uint8) (bool, error) {
+	return s.exists(ctx, s.entriesPath(i, p))
+}
+
+// IntegratedSize returns the size of the tree into which the sequencer has integrated entries.
+//
+// This may be larger than the size of the most recently published checkpoint.
+func (s *Storage) IntegratedSize(ctx context.Context) (uint64, error) {
+	size, _, err := s.sequencer.currentTree(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("currentTree: %v", err)
+	}
+	return size, nil
+}
+
+// read returns the requested object from readStore if set, or objStore otherwise.
+//
+// This is indended to be used to proxy read requests through the personality for debug/testing purposes.
+func (s *Storage) read(ctx context.Context, path string) ([]byte, error) {
+	if s.readStore == nil {
+		return s.get(ctx, path)
+	}
+	return s.readStore.GetObject(ctx, path)
+}
+
+// exists checks for the presence of the requested object in readStore if set, or objStore otherwise.
+func (s *Storage) exists(ctx context.Context, path string) (bool, error) {
+	if s.readStore == nil {
+		return s.objStore.Exists(ctx, path)
+	}
+	return s.readStore.Exists(ctx, path)
+}
+
+// get returns the requested object from objStore.
+func (s *Storage) get(ctx context.Context, path string) ([]byte, error) {
+	d, err := s.objStore.GetObject(ctx, path)
+	return d, err
+}
+
+// init ensures that the storage represents a log in a valid state.
+func (s *Storage) init(ctx context.Context) error {
+	_, err := s.get(ctx, layout.CheckpointPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			// No checkpoint exists, do a forced (possibly empty) integration to create one in a safe
+			// way (calling updateCP directly here would not be safe as it's outside the transactional
+			// framework which prevents the tree from rolling backwards or otherwise forking).
+			cctx, c := context.WithTimeout(ctx, 10*time.Second)
+			defer c()
+			if _, err := s.sequencer.consumeEntries(cctx, s.integrationSizeLimit, s.integrate, true); err != nil {
+				return fmt.Errorf("forced integrate: %v", err)
+			}
+			// Publish the checkpoint for the new tree now, rather than waiting for the first asynchronous
+			// publish, so that readers see a valid checkpoint as soon as the storage is available.
+			if err := s.publishCheckpoint(ctx, 0); err != nil {
+				return fmt.Errorf("failed to publish initial checkpoint: %v", err)
+			}
+			return nil
+		}
+		return fmt.Errorf("failed to read checkpoint: %v", err)
+	}
+
+	return nil
+}
+
+// verifyRoot checks that the root hash committed to by the published checkpoint is consistent with the
+// stored tiles.
+func (s *Storage) verifyRoot(ctx context.Context, full bool) error {
+	cpRaw, err := s.get(ctx, layout.CheckpointPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			// Nothing has been published yet, so there's nothing to verify.
+			return nil
+		}
+		return fmt.Errorf("failed to read checkpoint: %v", err)
+	}
+	return storage.VerifyCheckpoint(ctx, s.getTiles, cpRaw, s.hasher, full)
+}
+
+// holdsPublisherLease returns true if this instance should publish checkpoints, i.e. if publisher election
+// is disabled, this instance holds the publisher lease, or the lease is unavailable.
+func (s *Storage) holdsPublisherLease(ctx context.Context) bool {
+	if !s.electPublisher {
+		return true
+	}
+	held, err := s.sequencer.publisherLease(ctx, s.publisherID, s.publisherLease)
+	if err != nil {
+		klog.Warningf("publisherLease: %v; publishing checkpoints from this instance", err)
+		return true
+	}
+	return held
+}
+
+// newPublisherID returns a random identifier for this instance for use with the publisher lease.
+func newPublisherID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Errorf("rand.Read: %v", err))
+	}
+	return hex.EncodeToString(b)
+}
+
+// PausePublishing stops new checkpoints from being published, while entries continue to be sequenced
+// and integrated as normal.
+//
+// This is intended for use during an outage of the log's witnesses, to avoid publishing checkpoints which
+// can't be cosigned. Publishing is paused only for this instance, so it should be called on all instances
+// serving the log.
+func (s *Storage) PausePublishing() {
+	s.publishingPaused.Store(true)
+	s.metrics.publishingPaused.Record(context.Background(), 1)
+	klog.Info("Checkpoint publishing paused")
+}
+
+// ResumePublishing undoes a previous call to PausePublishing.
+//
+// A checkpoint covering all entries integrated in the meantime will be published at the next checkpoint interval.
+func (s *Storage) ResumePublishing() {
+	s.publishingPaused.Store(false)
+	s.metrics.publishingPaused.Record(context.Background(), 0)
+	klog.Info("Checkpoint publishing resumed")
+}
+
+func (s *Storage) publishCheckpoint(ctx context.Context, minStaleness time.Duration) error {
+	ctx, span := tracer.Start(ctx, "tessera.storage.objectstore.publishCheckpoint")
+	defer span.End()
+
+	m, err := s.objStore.LastModified(ctx, layout.CheckpointPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("lastModified(%q): %v", layout.CheckpointPath, err)
+	}
+	if !m.IsZero() {
+		s.metrics.checkpointAge.Record(ctx, time.Since(m).Seconds())
+	}
+	if s.publishingPaused.Load() {
+		klog.V(1).Info("publishCheckpoint: skipping publish because publishing is paused")
+		return nil
+	}
+	if time.Since(m) < minStaleness {
+		return nil
+	}
+	// The lease is only taken or renewed once the checkpoint is due to be replaced, so that instances don't
+	// contend for it every time they integrate entries.
+	if !s.holdsPublisherLease(ctx) {
+		return nil
+	}
+
+	size, root, err := s.sequencer.currentTree(ctx)
+	if err != nil {
+		return fmt.Errorf("currentTree: %v", err)
+	}
+	if !s.publishPolicy.ShouldPublish(size, time.Since(m)) {
+		return nil
+	}
+	cpRaw, err := s.newCP(size, root)
+	if err != nil {
+		return fmt.Errorf("newCP: %v", err)
+	}
+
+	if err := s.objStore.SetObject(ctx, layout.CheckpointPath, cpRaw, ckptContType); err != nil {
+		return fmt.Errorf("writeCheckpoint: %v", err)
+	}
+	s.publishPolicy.Published(size)
+	s.metrics.lastPublished.Record(ctx, time.Now().Unix())
+	return nil
+
+}
+
+// setTile idempotently stores the provided tile at the location implied by the given level, index, and treeSize.
+//
+// The location to which the tile is written is defined by the tile layout spec.
+func (s *Storage) setTile(ctx context.Context, level, index, logSize uint64, tile *api.HashTile) error {
+	data, err := tile.MarshalText()
+	if err != nil {
+		return err
+	}
+	tPath := layout.TilePath(level, index, layout.PartialTileSize(level, index, logSize))
+	klog.V(2).Infof("StoreTile: %s (%d entries)", tPath, len(tile.Nodes))
+
+	if err := s.setObjectIfNoneMatch(ctx, tPath, data, logContType); err != nil {
+		return err
+	}
+	return s.setResourceSignature(ctx, tPath, data)
+}
+
+// setResourceSignature stores a signature over the tile or entry bundle data written to objName at
+// the corresponding signature path, if resource signing is enabled.
+func (s *Storage) setResourceSignature(ctx context.Context, objName string, data []byte) error {
+	if s.newResourceSig == nil {
+		return nil
+	}
+	sig, err := s.newResourceSig(objName, data)
+	if err != nil {
+		return fmt.Errorf("failed to sign %q: %v", objName, err)
+	}
+	// Signatures need not be deterministic, so this write isn't conditional: any signature which
+	// may already be present covers the same content.
+	sigPath := layout.SignaturePath(objName)
+	if err := s.objStore.SetObject(ctx, sigPath, sig, ckptContType); err != nil {
+		return fmt.Errorf("failed to write signature %q: %v", sigPath, err)
+	}
+	return nil
+}
+
+// setObjectIfNoneMatch stores data in the named object, unless it already exists.
+//
+// If an object already exists under the same name, an error will be returned *unless* the currently stored
+// data is bit-for-bit identical to the data to-be-written. This is intended to provide idempotency for writes.
+func (s *Storage) setObjectIfNoneMatch(ctx context.Context, objName string, data []byte, contType string) error {
+	err := s.objStore.SetObjectIfNoneMatch(ctx, objName, data, contType)
+	if !errors.Is(err, ErrObjectExists) {
+		return err
+	}
+	existing, err := s.objStore.GetObject(ctx, objName)
+	if err != nil {
+		return fmt.Errorf("failed to fetch existing content for %q: %v", objName, err)
+	}
+	if !bytes.Equal(existing, data) {
+		klog.Errorf("Resource %q non-idempotent write:\n%s", objName, cmp.Diff(existing, data))
+		return fmt.Errorf("precondition failed: resource content for %q differs from data to-be-written", objName)
+	}
+	s.metrics.idempotentWrites.Add(ctx, 1)
+	klog.V(2).Infof("setObjectIfNoneMatch: identical resource already exists for %q, continuing", objName)
+	return nil
+}
+
+// getTiles returns the tiles with the given tile-coords for the specified log size.
+//
+// Tiles are returned in the same order as they're requested, nils represent tiles which were not found.
+func (s *Storage) getTiles(ctx context.Context, tileIDs []storage.TileID, logSize uint64) ([]*api.HashTile, error) {
+	r := make([]*api.HashTile, len(tileIDs))
+	errG := errgroup.Group{}
+	for i, id := range tileIDs {
+		i := i
+		id := id
+		errG.Go(func() error {
+			objName := layout.TilePath(id.Level, id.Index, layout.PartialTileSize(id.Level, id.Index, logSize))
+			data, err := s.objStore.GetObject(ctx, objName)
+			if err != nil {
+				if errors.Is(err, os.ErrNotExist) {
+					// Depending on context, this may be ok.
+					// We'll signal to higher levels that it wasn't found by retuning a nil for this tile.
+					return nil
+				}
+				return err
+			}
+			t := &api.HashTile{}
+			if err := t.UnmarshalText(data); err != nil {
+				return fmt.Errorf("unmarshal(%q): %v", objName, err)
+			}
+			r[i] = t
+			return nil
+		})
+	}
+	if err := errG.Wait(); err != nil {
+		return nil, err
+	}
+	return r, nil
+
+}
+
+// getEntryBundle returns the serialised entry bundle at the location implied by the given index and treeSize.
+//
+// Returns a wrapped os.ErrNotExist if the bundle does not exist.
+func (s *Storage) getEntryBundle(ctx context.Context, bundleIndex uint64, p uint8) ([]byte, error) {
+	objName := s.entriesPath(bundleIndex, p)
+	data, err := s.objStore.GetObject(ctx, objName)
+	if err != nil {
+		// GetObject wraps os.ErrNotExist if the bundle doesn't exist, allowing higher levels to
+		// differentiate between this and other errors.
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// setEntryBundle idempotently stores the serialised entry bundle at the location implied by the bundleIndex and treeSize.
+//
+// Each size of a partial bundle is stored at its own path, so growing a partial bundle writes a new object
+// rather than overwriting the smaller one.
+func (s *Storage) setEntryBundle(ctx context.Context, bundleIndex uint64, p uint8, bundleRaw []byte) error {
+	objName := s.entriesPath(bundleIndex, p)
+	// Note that setObjectIfNoneMatch does an idempotent interpretation of IfNoneMatch - it only
+	// returns an error if the named object exists _and_ contains different data to what's
+	// passed in here.
+	if err := s.setObjectIfNoneMatch(ctx, objName, bundleRaw, logContType); err != nil {
+		return fmt.Errorf("setObjectIfNoneMatch(%q): %v", objName, err)
+
+	}
+	return s.setResourceSignature(ctx, objName, bundleRaw)
+}
+
+// integrateSequenced integrates up to limit previously sequenced entries, and returns the number integrated.
+func (s *Storage) integrateSequenced(ctx context.Context, limit uint64) (uint64, error) {
+	var n uint64
+	_, err := s.sequencer.consumeEntries(ctx, limit, func(ctx context.Context, fromSeq uint64, entries []storage.SequencedEntry) ([]byte, error) {
+		n = uint64(len(entries))
+		return s.integrate(ctx, fromSeq, entries)
+	}, false)
+	return n, err
+}
+
+// integrate incorporates the provided entries into the log starting at fromSeq.
+//
+// Returns the new root hash of the log with the entries added.
+func (s *Storage) integrate(ctx context.Context, fromSeq uint64, entries []storage.SequencedEntry) ([]byte, error) {
+	var opts []trace.SpanStartOption
+	if s.queue != nil {
+		// Link to the spans in which these entries were sequenced, if that happened in this instance.
+		opts = append(opts, trace.WithLinks(s.queue.SequencedLinks(fromSeq, fromSeq+uint64(len(entries)))...))
+	}
+	ctx, span := tracer.Start(ctx, "tessera.storage.objectstore.integrate", opts...)
+	defer span.End()
+	span.SetAttributes(fromSeqKey.Int64(int64(fromSeq)), batchSizeKey.Int(len(entries)))
+
+	var newRoot []byte
+
+	getTiles := func(ctx context.Context, tileIDs []storage.TileID, treeSize uint64) ([]*api.HashTile, error) {
+		n, err := s.getTiles(ctx, tileIDs, treeSize)
+		if err != nil {
+			return nil, fmt.Errorf("getTiles: %w", err)
+		}
+		return n, nil
+	}
+
+	errG := errgroup.Group{}
+
+	errG.Go(func() error {
+		if err := s.updateEntryBundles(ctx, fromSeq, entries); err != nil {
+			return fmt.Errorf("updateEntryBundles: %v", err)
+		}
+		return nil
+	})
+
+	errG.Go(func() error {
+		newSize, root, tiles, err := storage.Integrate(ctx, getTiles, fromSeq, entries, s.hasher, s.integrationWorkers)
+		if err != nil {
+			return fmt.Errorf("Integrate: %v", err)
+		}
+		newRoot = root
+		for k, v := range tiles {
+			func(ctx context.Context, k storage.TileID, v *api.HashTile) {
+				errG.Go(func() error {
+					return s.setTile(ctx, uint64(k.Level), k.Index, newSize, v)
+				})
+			}(ctx, k, v)
+		}
+		klog.Infof("New tree: %d, %x", newSize, newRoot)
+
+		return nil
+	})
+
+	if err := errG.Wait(); err != nil {
+		return nil, err
+	}
+	s.metrics.entriesIntegrated.Add(ctx, int64(len(entries)))
+	s.metrics.integratedSize.Record(ctx, int64(fromSeq)+int64(len(entries)))
+	return newRoot, nil
+}
+
+// updateEntryBundles adds the entries being integrated into the entry bundles.
+//
+// The right-most bundle will be grown, if it's partial, and/or new bundles will be created as required.
+func (s *Storage) updateEntryBundles(ctx context.Context, fromSeq uint64, entries []storage.SequencedEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	numAdded := uint64(0)
+	bundleIndex, entriesInBundle := fromSeq/layout.EntryBundleWidth, fromSeq%layout.EntryBundleWidth
+	bundleWriter := &bytes.Buffer{}
+	if entriesInBundle > 0 {
+		// If the latest bundle is partial, we need to read the data it contains in for our newer, larger, bundle.
+		part, err := s.getEntryBundle(ctx, uint64(bundleIndex), uint8(entriesInBundle))
+		if err != nil {
+			return err
+		}
+
+		if _, err := bundleWriter.Write(part); err != nil {
+			return fmt.Errorf("bundleWriter: %v", err)
+		}
+	}
+
+	seqErr := errgroup.Group{}
+
+	// goSetEntryBundle is a function which uses seqErr to spin off a go-routine to write out an entry bundle.
+	// It's used in the for loop below.
+	goSetEntryBundle := func(ctx context.Context, bundleIndex uint64, p uint8, bundleRaw []byte) {
+		seqErr.Go(func() error {
+			if err := s.setEntryBundle(ctx, bundleIndex, p, bundleRaw); err != nil {
+				return err
+			}
+			return nil
+		})
+	}
+
+	// Add new entries to the bundle
+	for _, e := range entries {
+		if _, err := bundleWriter.Write(e.BundleData); err != nil {
+			return fmt.Errorf("Write: %v", err)
+		}
+		entriesInBundle++
+		fromSeq++
+		numAdded++
+		if entriesInBundle == layout.EntryBundleWidth {
+			//  This bundle is full, so we need to write it out...
+			klog.V(1).Infof("In-memory bundle idx %d is full, attempting write to object storage", bundleIndex)
+			goSetEntryBundle(ctx, bundleIndex, 0, bundleWriter.Bytes())
+			// ... and prepare the next entry bundle for any remaining entries in the batch
+			bundleIndex++
+			entriesInBundle = 0
+			// Don't use Reset/Truncate here - the backing []bytes is still being used by goSetEntryBundle above.
+			bundleWriter = &bytes.Buffer{}
+			klog.V(1).Infof("Starting to fill in-memory bundle idx %d", bundleIndex)
+		}
+	}
+	// If we have a partial bundle remaining once we've added all the entries from the batch,
+	// this needs writing out too.
+	if entriesInBundle > 0 {
+		klog.V(1).Infof("Attempting to write in-memory partial bundle idx %d.%d to object storage", bundleIndex, entriesInBundle)
+		goSetEntryBundle(ctx, bundleIndex, uint8(entriesInBundle), bundleWriter.Bytes())
+	}
+	return seqErr.Wait()
+}
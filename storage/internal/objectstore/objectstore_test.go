@@ -0,0 +1,790 @@
+// Copyright 2024 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This the tests for the object store and MySQL based Tessera implementation shared by AWS and Azure.  It requires a
+// MySQL database to successfully run the MySQL tests, otherwise they are
+// skipped.  Run tests with `-parallel=1` to avoid concurent tests on the same
+// database, and specifically runs of `mustDropTables`.
+//
+// Sample command to start a local MySQL database using Docker:
+// $ docker run --name test-mysql -p 3306:3306 -e MYSQL_ROOT_PASSWORD=root -e MYSQL_DATABASE=test_tessera -d mysql
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/transparency-dev/merkle/rfc6962"
+	tessera "github.com/transparency-dev/trillian-tessera"
+	"github.com/transparency-dev/trillian-tessera/api"
+	"github.com/transparency-dev/trillian-tessera/api/layout"
+	storage "github.com/transparency-dev/trillian-tessera/storage/internal"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"k8s.io/klog/v2"
+)
+
+var (
+	mySQLURI            = flag.String("mysql_uri", "root:root@tcp(localhost:3306)/test_tessera", "Connection string for a MySQL database")
+	isMySQLTestOptional = flag.Bool("is_mysql_test_optional", true, "Boolean value to control whether the MySQL test is optional")
+)
+
+// TestMain inits flags and runs tests.
+func TestMain(m *testing.M) {
+	klog.InitFlags(nil)
+	// m.Run() will parse flags
+	os.Exit(m.Run())
+}
+
+// canSkipMySQLTest checks if the test MySQL db is available and, if not, if the test can be skipped.
+//
+// Use this method before every MySQL test, and if it returns true, skip the test.
+//
+// If is_mysql_test_optional is set to true and MySQL database cannot be opened or pinged,
+// the test will fail immediately. Otherwise, the test will be skipped if the test is optional
+// and the database is not available.
+func canSkipMySQLTest(t *testing.T, ctx context.Context) bool {
+	t.Helper()
+
+	db, err := sql.Open("mysql", *mySQLURI)
+	if err != nil {
+		if *isMySQLTestOptional {
+			return true
+		}
+		t.Fatalf("failed to open MySQL test db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Fatalf("failed to close MySQL database: %v", err)
+		}
+	}()
+	if err := db.PingContext(ctx); err != nil {
+		if *isMySQLTestOptional {
+			return true
+		}
+		t.Fatalf("failed to ping MySQL test db: %v", err)
+	}
+	return false
+}
+
+// mustDropTables drops the `Seq`, `SeqCoord` and `IntCoord` tables.
+// Call this function before every MySQL test.
+func mustDropTables(t *testing.T, ctx context.Context) {
+	t.Helper()
+
+	db, err := sql.Open("mysql", *mySQLURI)
+	if err != nil {
+		t.Fatalf("failed to connect to db: %v", *mySQLURI)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Fatalf("failed to close db: %v", err)
+		}
+	}()
+
+	if _, err := db.ExecContext(ctx, "DROP TABLE IF EXISTS `Seq`, `SeqCoord`, `IntCoord`, `PubCoord`"); err != nil {
+		t.Fatalf("failed to drop all tables: %v", err)
+	}
+}
+
+func TestMySQLSequencerAssignEntries(t *testing.T) {
+	ctx := context.Background()
+	if canSkipMySQLTest(t, ctx) {
+		klog.Warningf("MySQL not available, skipping %s", t.Name())
+		t.Skip("MySQL not available, skipping test")
+	}
+	// Clean tables in case there's already something in there.
+	mustDropTables(t, ctx)
+
+	seq, err := newMySQLSequencer(ctx, *mySQLURI, 1000, 0, 0, rfc6962.DefaultHasher.EmptyRoot(), false, false)
+	if err != nil {
+		t.Fatalf("newMySQLSequencer: %v", err)
+	}
+
+	want := uint64(0)
+	for chunks := 0; chunks < 10; chunks++ {
+		entries := []*tessera.Entry{}
+		for i := 0; i < 10+chunks; i++ {
+			entries = append(entries, tessera.NewEntry([]byte(fmt.Sprintf("item %d/%d", chunks, i))))
+		}
+		if err := seq.assignEntries(ctx, entries); err != nil {
+			t.Fatalf("assignEntries: %v", err)
+		}
+		for i, e := range entries {
+			if got := *e.Index(); got != want {
+				t.Errorf("Chunk %d entry %d got seq %d, want %d", chunks, i, got, want)
+			}
+			want++
+		}
+	}
+}
+
+func TestMySQLSequencerSkipSchemaInit(t *testing.T) {
+	ctx := context.Background()
+	if canSkipMySQLTest(t, ctx) {
+		klog.Warningf("MySQL not available, skipping %s", t.Name())
+		t.Skip("MySQL not available, skipping test")
+	}
+	mustDropTables(t, ctx)
+
+	// With no tables present, the schema check should fail rather than creating them.
+	if _, err := newMySQLSequencer(ctx, *mySQLURI, 1000, 0, 0, rfc6962.DefaultHasher.EmptyRoot(), true, false); err == nil {
+		t.Fatal("newMySQLSequencer with skipSchemaInit succeeded without a schema, want error")
+	}
+	if _, err := newMySQLSequencer(ctx, *mySQLURI, 1000, 0, 0, rfc6962.DefaultHasher.EmptyRoot(), false, false); err != nil {
+		t.Fatalf("newMySQLSequencer: %v", err)
+	}
+	// Now that the schema has been provisioned, the check should pass.
+	if _, err := newMySQLSequencer(ctx, *mySQLURI, 1000, 0, 0, rfc6962.DefaultHasher.EmptyRoot(), true, false); err != nil {
+		t.Fatalf("newMySQLSequencer with skipSchemaInit: %v", err)
+	}
+	// Without PubCoord, the check should only fail if publisher election is enabled.
+	db, err := sql.Open("mysql", *mySQLURI)
+	if err != nil {
+		t.Fatalf("failed to connect to db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Fatalf("failed to close db: %v", err)
+		}
+	}()
+	if _, err := db.ExecContext(ctx, "DROP TABLE `PubCoord`"); err != nil {
+		t.Fatalf("failed to drop PubCoord: %v", err)
+	}
+	if _, err := newMySQLSequencer(ctx, *mySQLURI, 1000, 0, 0, rfc6962.DefaultHasher.EmptyRoot(), true, false); err != nil {
+		t.Fatalf("newMySQLSequencer with skipSchemaInit and no PubCoord: %v", err)
+	}
+	if _, err := newMySQLSequencer(ctx, *mySQLURI, 1000, 0, 0, rfc6962.DefaultHasher.EmptyRoot(), true, true); err == nil {
+		t.Fatal("newMySQLSequencer with skipSchemaInit and electPublisher succeeded without PubCoord, want error")
+	}
+}
+
+func TestMySQLSequencerPushback(t *testing.T) {
+	ctx := context.Background()
+	if canSkipMySQLTest(t, ctx) {
+		klog.Warningf("MySQL not available, skipping %s", t.Name())
+		t.Skip("MySQL not available, skipping test")
+	}
+	// Clean tables in case there's already something in there.
+	mustDropTables(t, ctx)
+
+	for _, test := range []struct {
+		name           string
+		threshold      uint64
+		initialEntries int
+		wantPushback   bool
+	}{
+		{
+			name:           "no pushback: num < threshold",
+			threshold:      10,
+			initialEntries: 5,
+		},
+		{
+			name:           "no pushback: num = threshold",
+			threshold:      10,
+			initialEntries: 10,
+		},
+		{
+			name:           "pushback: initial > threshold",
+			threshold:      10,
+			initialEntries: 15,
+			wantPushback:   true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			mustDropTables(t, ctx)
+
+			seq, err := newMySQLSequencer(ctx, *mySQLURI, test.threshold, 0, 0, rfc6962.DefaultHasher.EmptyRoot(), false, false)
+			if err != nil {
+				t.Fatalf("newMySQLSequencer: %v", err)
+			}
+			// Set up the test scenario with the configured number of initial outstanding entries
+			entries := []*tessera.Entry{}
+			for i := 0; i < test.initialEntries; i++ {
+				entries = append(entries, tessera.NewEntry([]byte(fmt.Sprintf("initial item %d", i))))
+			}
+			if err := seq.assignEntries(ctx, entries); err != nil {
+				t.Fatalf("initial assignEntries: %v", err)
+			}
+
+			// Now perform the test with a single additional entry to check for pushback
+			entries = []*tessera.Entry{tessera.NewEntry([]byte("additional"))}
+			err = seq.assignEntries(ctx, entries)
+			if gotPushback := errors.Is(err, tessera.ErrPushback); gotPushback != test.wantPushback {
+				t.Fatalf("assignEntries: got pushback %t (%v), want pushback: %t", gotPushback, err, test.wantPushback)
+			} else if !gotPushback && err != nil {
+				t.Fatalf("assignEntries: %v", err)
+			}
+		})
+	}
+}
+
+func TestMySQLSequencerPublisherLease(t *testing.T) {
+	ctx := context.Background()
+	if canSkipMySQLTest(t, ctx) {
+		klog.Warningf("MySQL not available, skipping %s", t.Name())
+		t.Skip("MySQL not available, skipping test")
+	}
+	// Clean tables in case there's already something in there.
+	mustDropTables(t, ctx)
+
+	seq, err := newMySQLSequencer(ctx, *mySQLURI, 1000, 0, 0, rfc6962.DefaultHasher.EmptyRoot(), false, false)
+	if err != nil {
+		t.Fatalf("newMySQLSequencer: %v", err)
+	}
+
+	const ttl = 500 * time.Millisecond
+	for _, step := range []struct {
+		holder string
+		sleep  time.Duration
+		want   bool
+	}{
+		{holder: "a", want: true},
+		{holder: "b", want: false},
+		// The holder can renew its lease.
+		{holder: "a", want: true},
+		{holder: "b", want: false},
+		// Once the lease has expired, another instance can take over.
+		{holder: "b", sleep: ttl, want: true},
+		{holder: "a", want: false},
+	} {
+		time.Sleep(step.sleep)
+		got, err := seq.publisherLease(ctx, step.holder, ttl)
+		if err != nil {
+			t.Fatalf("publisherLease(%q): %v", step.holder, err)
+		}
+		if got != step.want {
+			t.Errorf("publisherLease(%q) = %t, want %t", step.holder, got, step.want)
+		}
+	}
+}
+
+func TestMySQLSequencerRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	if canSkipMySQLTest(t, ctx) {
+		klog.Warningf("MySQL not available, skipping %s", t.Name())
+		t.Skip("MySQL not available, skipping test")
+	}
+	// Clean tables in case there's already something in there.
+	mustDropTables(t, ctx)
+
+	s, err := newMySQLSequencer(ctx, *mySQLURI, 1000, 0, 0, rfc6962.DefaultHasher.EmptyRoot(), false, false)
+	if err != nil {
+		t.Fatalf("newMySQLSequencer: %v", err)
+	}
+
+	seq := 0
+	wantEntries := []storage.SequencedEntry{}
+	for chunks := 0; chunks < 10; chunks++ {
+		entries := []*tessera.Entry{}
+		for i := 0; i < 10+chunks; i++ {
+			e := tessera.NewEntry([]byte(fmt.Sprintf("item %d", seq)))
+			entries = append(entries, e)
+			wantEntries = append(wantEntries, storage.SequencedEntry{
+				BundleData: e.MarshalBundleData(uint64(seq)),
+				LeafHash:   e.LeafHash(),
+			})
+			seq++
+		}
+		if err := s.assignEntries(ctx, entries); err != nil {
+			t.Fatalf("assignEntries: %v", err)
+		}
+	}
+
+	seenIdx := uint64(0)
+	f := func(_ context.Context, fromSeq uint64, entries []storage.SequencedEntry) ([]byte, error) {
+		if fromSeq != seenIdx {
+			return nil, fmt.Errorf("f called with fromSeq %d, want %d", fromSeq, seenIdx)
+		}
+		for i, e := range entries {
+
+			if got, want := e, wantEntries[i]; !reflect.DeepEqual(got, want) {
+				return nil, fmt.Errorf("entry %d+%d != %d", fromSeq, i, seenIdx)
+			}
+			seenIdx++
+		}
+		return []byte("newroot"), nil
+	}
+
+	more, err := s.consumeEntries(ctx, 7, f, false)
+	if err != nil {
+		t.Errorf("consumeEntries: %v", err)
+	}
+	if !more {
+		t.Errorf("more: false, expected true")
+	}
+}
+
+func TestInspectCoordination(t *testing.T) {
+	ctx := context.Background()
+	if canSkipMySQLTest(t, ctx) {
+		klog.Warningf("MySQL not available, skipping %s", t.Name())
+		t.Skip("MySQL not available, skipping test")
+	}
+	// Clean tables in case there's already something in there.
+	mustDropTables(t, ctx)
+
+	s, err := newMySQLSequencer(ctx, *mySQLURI, 1000, 0, 0, rfc6962.DefaultHasher.EmptyRoot(), false, false)
+	if err != nil {
+		t.Fatalf("newMySQLSequencer: %v", err)
+	}
+	// Sequence 3 batches of 10 entries, and integrate only the first.
+	for b := 0; b < 3; b++ {
+		entries := []*tessera.Entry{}
+		for i := 0; i < 10; i++ {
+			entries = append(entries, tessera.NewEntry([]byte(fmt.Sprintf("item %d/%d", b, i))))
+		}
+		if err := s.assignEntries(ctx, entries); err != nil {
+			t.Fatalf("assignEntries: %v", err)
+		}
+	}
+	f := func(_ context.Context, _ uint64, _ []storage.SequencedEntry) ([]byte, error) {
+		return []byte("newroot"), nil
+	}
+	if _, err := s.consumeEntries(ctx, 1, f, false); err != nil {
+		t.Fatalf("consumeEntries: %v", err)
+	}
+
+	got, err := InspectCoordination(ctx, *mySQLURI)
+	if err != nil {
+		t.Fatalf("InspectCoordination: %v", err)
+	}
+	want := CoordinationState{
+		Next:           30,
+		Integrated:     10,
+		RootHash:       []byte("newroot"),
+		PendingBatches: 2,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("InspectCoordination diff (-want +got):\n%s", diff)
+	}
+}
+
+func makeTile(t *testing.T, size uint64) *api.HashTile {
+	t.Helper()
+	r := &api.HashTile{Nodes: make([][]byte, size)}
+	for i := uint64(0); i < size; i++ {
+		h := sha256.Sum256([]byte(fmt.Sprintf("%d", i)))
+		r.Nodes[i] = h[:]
+	}
+	return r
+}
+
+func TestTileRoundtrip(t *testing.T) {
+	ctx := context.Background()
+	m := newMemObjStore()
+	s := &Storage{
+		metrics:  defaultMetrics,
+		objStore: m,
+	}
+
+	for _, test := range []struct {
+		name     string
+		level    uint64
+		index    uint64
+		logSize  uint64
+		tileSize uint64
+	}{
+		{
+			name:     "ok",
+			level:    0,
+			index:    3 * layout.TileWidth,
+			logSize:  3*layout.TileWidth + 20,
+			tileSize: 20,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			wantTile := makeTile(t, test.tileSize)
+			if err := s.setTile(ctx, test.level, test.index, test.logSize, wantTile); err != nil {
+				t.Fatalf("setTile: %v", err)
+			}
+
+			expPath := layout.TilePath(test.level, test.index, layout.PartialTileSize(test.level, test.index, test.logSize))
+			_, ok := m.mem[expPath]
+			if !ok {
+				t.Fatalf("want tile at %v but found none", expPath)
+			}
+
+			got, err := s.getTiles(ctx, []storage.TileID{{Level: test.level, Index: test.index}}, test.logSize)
+			if err != nil {
+				t.Fatalf("getTile: %v", err)
+			}
+			if !cmp.Equal(got[0], wantTile) {
+				t.Fatal("roundtrip returned different data")
+			}
+		})
+	}
+}
+
+func TestResourceExists(t *testing.T) {
+	ctx := context.Background()
+	m := newMemObjStore()
+	var s tessera.ResourceExistenceChecker = &Storage{
+		metrics:     defaultMetrics,
+		objStore:    m,
+		entriesPath: layout.EntriesPath,
+	}
+	m.mem[layout.TilePath(1, 2, 0)] = []byte("tile")
+	m.mem[layout.EntriesPath(3, 4)] = []byte("bundle")
+
+	for _, test := range []struct {
+		name   string
+		exists func() (bool, error)
+		want   bool
+	}{
+		{
+			name:   "tile present",
+			exists: func() (bool, error) { return s.TileExists(ctx, 1, 2, 0) },
+			want:   true,
+		}, {
+			name:   "tile missing",
+			exists: func() (bool, error) { return s.TileExists(ctx, 1, 2, 5) },
+		}, {
+			name:   "bundle present",
+			exists: func() (bool, error) { return s.EntryBundleExists(ctx, 3, 4) },
+			want:   true,
+		}, {
+			name:   "bundle missing",
+			exists: func() (bool, error) { return s.EntryBundleExists(ctx, 3, 0) },
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := test.exists()
+			if err != nil {
+				t.Fatalf("exists: %v", err)
+			}
+			if got != test.want {
+				t.Errorf("exists = %t, want %t", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSetObjectIfNoneMatch(t *testing.T) {
+	ctx := context.Background()
+	m := newMemObjStore()
+	s := &Storage{
+		metrics:  defaultMetrics,
+		objStore: m,
+	}
+
+	if err := s.setObjectIfNoneMatch(ctx, "obj", []byte("data"), logContType); err != nil {
+		t.Fatalf("setObjectIfNoneMatch: %v", err)
+	}
+	if err := s.setObjectIfNoneMatch(ctx, "obj", []byte("data"), logContType); err != nil {
+		t.Errorf("setObjectIfNoneMatch with identical data: %v", err)
+	}
+	if err := s.setObjectIfNoneMatch(ctx, "obj", []byte("different"), logContType); err == nil {
+		t.Error("setObjectIfNoneMatch with different data succeeded, want error")
+	}
+	if got := string(m.mem["obj"]); got != "data" {
+		t.Errorf("stored data = %q, want %q", got, "data")
+	}
+}
+
+func makeBundle(t *testing.T, size uint64) []byte {
+	t.Helper()
+	r := &bytes.Buffer{}
+	for i := uint64(0); i < size; i++ {
+		e := tessera.NewEntry([]byte(fmt.Sprintf("%d", i)))
+		if _, err := r.Write(e.MarshalBundleData(i)); err != nil {
+			t.Fatalf("MarshalBundleEntry: %v", err)
+		}
+	}
+	return r.Bytes()
+}
+
+func TestBundleRoundtrip(t *testing.T) {
+	ctx := context.Background()
+	m := newMemObjStore()
+	s := &Storage{
+		metrics:     defaultMetrics,
+		objStore:    m,
+		entriesPath: layout.EntriesPath,
+	}
+
+	for _, test := range []struct {
+		name       string
+		index      uint64
+		p          uint8
+		bundleSize uint64
+	}{
+		{
+			name:       "ok",
+			index:      3 * layout.EntryBundleWidth,
+			p:          20,
+			bundleSize: 20,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			wantBundle := makeBundle(t, test.bundleSize)
+			if err := s.setEntryBundle(ctx, test.index, test.p, wantBundle); err != nil {
+				t.Fatalf("setEntryBundle: %v", err)
+			}
+
+			expPath := layout.EntriesPath(test.index, test.p)
+			_, ok := m.mem[expPath]
+			if !ok {
+				t.Fatalf("want bundle at %v but found none", expPath)
+			}
+
+			got, err := s.getEntryBundle(ctx, test.index, test.p)
+			if err != nil {
+				t.Fatalf("getEntryBundle: %v", err)
+			}
+			if !cmp.Equal(got, wantBundle) {
+				t.Fatal("roundtrip returned different data")
+			}
+		})
+	}
+}
+
+// unavailableSequencer is a sequencer whose database is unreachable.
+//
+// Only publisherLease is implemented, so any other use of the sequencer panics; this lets tests assert
+// that code paths such as reads never touch the coordination database.
+type unavailableSequencer struct{ sequencer }
+
+func (unavailableSequencer) publisherLease(_ context.Context, _ string, _ time.Duration) (bool, error) {
+	return false, errors.New("MySQL unavailable")
+}
+
+func TestWithMetricFactory(t *testing.T) {
+	ctx := context.Background()
+	reader := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+
+	opt, err := storage.ResolveStorageOptions(tessera.WithMetricFactory(mp))
+	if err != nil {
+		t.Fatalf("ResolveStorageOptions: %v", err)
+	}
+	m, err := metricsFor(opt)
+	if err != nil {
+		t.Fatalf("metricsFor: %v", err)
+	}
+	s := &Storage{metrics: m}
+	s.PausePublishing()
+
+	rm := metricdata.ResourceMetrics{}
+	if err := reader.Collect(ctx, &rm); err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name == "tessera.storage.publishing_paused" {
+				return
+			}
+		}
+	}
+	t.Error("tessera.storage.publishing_paused not recorded with the provided MeterProvider")
+}
+
+func TestHoldsPublisherLeaseFallback(t *testing.T) {
+	s := &Storage{
+		metrics:        defaultMetrics,
+		sequencer:      unavailableSequencer{},
+		electPublisher: true,
+		publisherID:    newPublisherID(),
+	}
+	if !s.holdsPublisherLease(context.Background()) {
+		t.Error("holdsPublisherLease: got false with unavailable lease, want true")
+	}
+}
+
+func TestReadsWithoutSequencer(t *testing.T) {
+	ctx := context.Background()
+	m := newMemObjStore()
+	s := &Storage{
+		metrics:     defaultMetrics,
+		objStore:    m,
+		sequencer:   unavailableSequencer{},
+		entriesPath: layout.EntriesPath,
+	}
+	for path, data := range map[string][]byte{
+		layout.CheckpointPath:    []byte("checkpoint"),
+		layout.TilePath(0, 0, 0): []byte("tile"),
+		layout.EntriesPath(0, 0): []byte("bundle"),
+	} {
+		if err := m.SetObject(ctx, path, data, ""); err != nil {
+			t.Fatalf("SetObject(%q): %v", path, err)
+		}
+	}
+
+	if got, err := s.ReadCheckpoint(ctx); err != nil || string(got) != "checkpoint" {
+		t.Errorf("ReadCheckpoint: got (%q, %v), want %q", got, err, "checkpoint")
+	}
+	if got, err := s.ReadTile(ctx, 0, 0, 0); err != nil || string(got) != "tile" {
+		t.Errorf("ReadTile: got (%q, %v), want %q", got, err, "tile")
+	}
+	if got, err := s.ReadEntryBundle(ctx, 0, 0); err != nil || string(got) != "bundle" {
+		t.Errorf("ReadEntryBundle: got (%q, %v), want %q", got, err, "bundle")
+	}
+}
+
+func TestPublishCheckpoint(t *testing.T) {
+	ctx := context.Background()
+	if canSkipMySQLTest(t, ctx) {
+		klog.Warningf("MySQL not available, skipping %s", t.Name())
+		t.Skip("MySQL not available, skipping test")
+	}
+	// Clean tables in case there's already something in there.
+	mustDropTables(t, ctx)
+
+	s, err := newMySQLSequencer(ctx, *mySQLURI, 1000, 0, 0, rfc6962.DefaultHasher.EmptyRoot(), false, false)
+	if err != nil {
+		t.Fatalf("newMySQLSequencer: %v", err)
+	}
+
+	for _, test := range []struct {
+		name             string
+		cpModifiedAt     time.Time
+		publishInterval  time.Duration
+		pausePublishing  bool
+		resumePublishing bool
+		wantUpdate       bool
+	}{
+		{
+			name:            "works ok",
+			cpModifiedAt:    time.Now().Add(-15 * time.Second),
+			publishInterval: 10 * time.Second,
+			wantUpdate:      true,
+		}, {
+			name:            "too soon, skip update",
+			cpModifiedAt:    time.Now().Add(-5 * time.Second),
+			publishInterval: 10 * time.Second,
+			wantUpdate:      false,
+		}, {
+			name:            "publishing paused, skip update",
+			cpModifiedAt:    time.Now().Add(-15 * time.Second),
+			publishInterval: 10 * time.Second,
+			pausePublishing: true,
+			wantUpdate:      false,
+		}, {
+			name:             "publishing resumed",
+			cpModifiedAt:     time.Now().Add(-15 * time.Second),
+			publishInterval:  10 * time.Second,
+			pausePublishing:  true,
+			resumePublishing: true,
+			wantUpdate:       true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			m := newMemObjStore()
+			storage := &Storage{
+				metrics:     defaultMetrics,
+				objStore:    m,
+				sequencer:   s,
+				entriesPath: layout.EntriesPath,
+				hasher:      rfc6962.DefaultHasher,
+				newCP:       func(size uint64, hash []byte) ([]byte, error) { return []byte(fmt.Sprintf("%d/%x,", size, hash)), nil },
+			}
+			// Call init so we've got a zero-sized checkpoint to work with.
+			if err := storage.init(ctx); err != nil {
+				t.Fatalf("storage.init: %v", err)
+			}
+			cpOld := []byte("bananas")
+			if err := m.SetObject(ctx, layout.CheckpointPath, cpOld, ""); err != nil {
+				t.Fatalf("SetObject(bananas): %v", err)
+			}
+			m.lMod = test.cpModifiedAt
+			if test.pausePublishing {
+				storage.PausePublishing()
+			}
+			if test.resumePublishing {
+				storage.ResumePublishing()
+			}
+			if err := storage.publishCheckpoint(ctx, test.publishInterval); err != nil {
+				t.Fatalf("publishCheckpoint: %v", err)
+			}
+			cpNew, err := m.GetObject(ctx, layout.CheckpointPath)
+			cpUpdated := !bytes.Equal(cpOld, cpNew)
+			if err != nil {
+				if !errors.Is(err, os.ErrNotExist) {
+					t.Fatalf("GetObject: %v", err)
+				}
+				cpUpdated = false
+			}
+			if test.wantUpdate != cpUpdated {
+				t.Fatalf("got cpUpdated=%t, want %t", cpUpdated, test.wantUpdate)
+			}
+		})
+	}
+
+}
+
+type memObjStore struct {
+	sync.RWMutex
+	mem  map[string][]byte
+	lMod time.Time
+}
+
+func newMemObjStore() *memObjStore {
+	return &memObjStore{
+		mem: make(map[string][]byte),
+	}
+}
+
+func (m *memObjStore) GetObject(_ context.Context, obj string) ([]byte, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	d, ok := m.mem[obj]
+	if !ok {
+		return nil, fmt.Errorf("obj %q not found: %w", obj, os.ErrNotExist)
+	}
+	return d, nil
+}
+
+// TODO(phboneff): add content type tests
+func (m *memObjStore) SetObject(_ context.Context, obj string, data []byte, _ string) error {
+	m.Lock()
+	defer m.Unlock()
+	m.mem[obj] = data
+	return nil
+}
+
+// TODO(phboneff): add content type tests
+func (m *memObjStore) SetObjectIfNoneMatch(_ context.Context, obj string, data []byte, _ string) error {
+	m.Lock()
+	defer m.Unlock()
+
+	if _, ok := m.mem[obj]; ok {
+		return fmt.Errorf("obj %q: %w", obj, ErrObjectExists)
+	}
+	m.mem[obj] = data
+	return nil
+}
+
+func (m *memObjStore) LastModified(_ context.Context, obj string) (time.Time, error) {
+	return m.lMod, nil
+}
+
+func (m *memObjStore) Exists(_ context.Context, obj string) (bool, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	_, ok := m.mem[obj]
+	return ok, nil
+}
@@ -15,21 +15,82 @@
 package storage
 
 import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/transparency-dev/merkle/rfc6962"
 	tessera "github.com/transparency-dev/trillian-tessera"
+	"github.com/transparency-dev/trillian-tessera/api"
 	"github.com/transparency-dev/trillian-tessera/api/layout"
 	"github.com/transparency-dev/trillian-tessera/internal/options"
 )
 
 // ResolveStorageOptions turns a variadic array of storage options into a StorageOptions instance.
-func ResolveStorageOptions(opts ...func(*options.StorageOptions)) *options.StorageOptions {
+//
+// Returns an error if any of the options were provided with invalid values.
+func ResolveStorageOptions(opts ...func(*options.StorageOptions)) (*options.StorageOptions, error) {
 	defaults := &options.StorageOptions{
-		BatchMaxSize:       tessera.DefaultBatchMaxSize,
-		BatchMaxAge:        tessera.DefaultBatchMaxAge,
-		EntriesPath:        layout.EntriesPath,
-		CheckpointInterval: tessera.DefaultCheckpointInterval,
+		BatchMaxSize:               tessera.DefaultBatchMaxSize,
+		BatchMaxAge:                tessera.DefaultBatchMaxAge,
+		EntriesPath:                layout.EntriesPath,
+		EntryBundleCodec:           api.LengthPrefixedCodec{},
+		CheckpointInterval:         tessera.DefaultCheckpointInterval,
+		Hasher:                     rfc6962.DefaultHasher,
+		IntegrationMaxIdleInterval: tessera.DefaultIntegrationMaxIdleInterval,
+		ObjectWriteRetryAttempts:   tessera.DefaultObjectWriteRetryAttempts,
+		ObjectWriteRetryBaseDelay:  tessera.DefaultObjectWriteRetryBaseDelay,
 	}
 	for _, opt := range opts {
 		opt(defaults)
 	}
-	return defaults
+	if err := validateStorageOptions(defaults); err != nil {
+		return nil, err
+	}
+	return defaults, nil
+}
+
+// validateStorageOptions returns an error if any of the values recorded by the options are invalid.
+func validateStorageOptions(o *options.StorageOptions) error {
+	if o.IntegrationSizeLimit != nil && *o.IntegrationSizeLimit == 0 {
+		return errors.New("WithIntegrationSizeLimit: limit must be non-zero")
+	}
+	if o.UnsafeCheckpointInterval {
+		if os.Getenv(tessera.UnsafeCheckpointIntervalEnv) != "true" {
+			return fmt.Errorf("WithUnsafeCheckpointInterval: refusing to use unsafe checkpoint interval without %s=true", tessera.UnsafeCheckpointIntervalEnv)
+		}
+		if o.CheckpointInterval <= 0 {
+			return fmt.Errorf("WithUnsafeCheckpointInterval: interval must be positive, got %v", o.CheckpointInterval)
+		}
+	}
+	if o.CheckpointIntervalJitter < 0 || o.CheckpointIntervalJitter >= 1 {
+		return fmt.Errorf("WithCheckpointIntervalJitter: fraction must be in [0, 1), got %v", o.CheckpointIntervalJitter)
+	}
+	if o.ObjectWriteRetryAttempts < 1 {
+		return fmt.Errorf("WithObjectWriteRetry: maxAttempts must be at least 1, got %d", o.ObjectWriteRetryAttempts)
+	}
+	if o.ObjectWriteRetryBaseDelay < 0 {
+		return fmt.Errorf("WithObjectWriteRetry: baseDelay must not be negative, got %v", o.ObjectWriteRetryBaseDelay)
+	}
+	return nil
+}
+
+// MinCheckpointInterval returns the shortest checkpoint interval permitted by the given options, where min is
+// the storage implementation's own minimum.
+// This is min unless the caller has explicitly opted in to an unsafe (test-only) interval, in which case it's zero.
+func MinCheckpointInterval(opt *options.StorageOptions, min time.Duration) time.Duration {
+	if opt.UnsafeCheckpointInterval {
+		return 0
+	}
+	return min
+}
+
+// ResourceSigner returns the function which should be used to sign tiles and entry bundles as they're
+// written, or nil if resource signatures haven't been requested.
+func ResourceSigner(opt *options.StorageOptions) options.NewResourceSigFunc {
+	if !opt.SignResources {
+		return nil
+	}
+	return opt.NewResourceSig
 }
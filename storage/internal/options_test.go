@@ -0,0 +1,110 @@
+// Copyright 2024 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	tessera "github.com/transparency-dev/trillian-tessera"
+	"github.com/transparency-dev/trillian-tessera/internal/options"
+)
+
+func TestMinCheckpointInterval(t *testing.T) {
+	const min = time.Second
+
+	opt, err := ResolveStorageOptions(tessera.WithCheckpointInterval(2 * time.Second))
+	if err != nil {
+		t.Fatalf("ResolveStorageOptions: %v", err)
+	}
+	if got := MinCheckpointInterval(opt, min); got != min {
+		t.Errorf("MinCheckpointInterval() = %v, want %v", got, min)
+	}
+
+	t.Setenv(tessera.UnsafeCheckpointIntervalEnv, "true")
+	opt, err = ResolveStorageOptions(tessera.WithUnsafeCheckpointInterval(10 * time.Millisecond))
+	if err != nil {
+		t.Fatalf("ResolveStorageOptions: %v", err)
+	}
+	if got, want := opt.CheckpointInterval, 10*time.Millisecond; got != want {
+		t.Errorf("CheckpointInterval = %v, want %v", got, want)
+	}
+	if got := MinCheckpointInterval(opt, min); got != 0 {
+		t.Errorf("MinCheckpointInterval() with unsafe interval = %v, want 0", got)
+	}
+}
+
+func TestResolveStorageOptionsInvalid(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		opts      []func(*options.StorageOptions)
+		unsafeEnv bool
+		wantErr   bool
+	}{
+		{
+			name: "defaults",
+		}, {
+			name: "integration size limit",
+			opts: []func(*options.StorageOptions){tessera.WithIntegrationSizeLimit(10)},
+		}, {
+			name:    "zero integration size limit",
+			opts:    []func(*options.StorageOptions){tessera.WithIntegrationSizeLimit(0)},
+			wantErr: true,
+		}, {
+			name: "object write retry",
+			opts: []func(*options.StorageOptions){tessera.WithObjectWriteRetry(3, 0)},
+		}, {
+			name:    "zero object write attempts",
+			opts:    []func(*options.StorageOptions){tessera.WithObjectWriteRetry(0, time.Second)},
+			wantErr: true,
+		}, {
+			name:    "negative object write retry delay",
+			opts:    []func(*options.StorageOptions){tessera.WithObjectWriteRetry(3, -time.Second)},
+			wantErr: true,
+		}, {
+			name:      "unsafe checkpoint interval",
+			opts:      []func(*options.StorageOptions){tessera.WithUnsafeCheckpointInterval(time.Millisecond)},
+			unsafeEnv: true,
+		}, {
+			name:    "unsafe checkpoint interval without env",
+			opts:    []func(*options.StorageOptions){tessera.WithUnsafeCheckpointInterval(time.Millisecond)},
+			wantErr: true,
+		}, {
+			name:      "zero unsafe checkpoint interval",
+			opts:      []func(*options.StorageOptions){tessera.WithUnsafeCheckpointInterval(0)},
+			unsafeEnv: true,
+			wantErr:   true,
+		}, {
+			name: "checkpoint interval jitter",
+			opts: []func(*options.StorageOptions){tessera.WithCheckpointIntervalJitter(0.5)},
+		}, {
+			name:    "negative checkpoint interval jitter",
+			opts:    []func(*options.StorageOptions){tessera.WithCheckpointIntervalJitter(-0.1)},
+			wantErr: true,
+		}, {
+			name:    "checkpoint interval jitter of one",
+			opts:    []func(*options.StorageOptions){tessera.WithCheckpointIntervalJitter(1)},
+			wantErr: true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv(tessera.UnsafeCheckpointIntervalEnv, strconv.FormatBool(test.unsafeEnv))
+			if _, err := ResolveStorageOptions(test.opts...); (err != nil) != test.wantErr {
+				t.Errorf("ResolveStorageOptions: got err %v, want err %t", err, test.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2024 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"k8s.io/klog/v2"
+)
+
+const name = "github.com/transparency-dev/trillian-tessera/storage/internal"
+
+var tracer = otel.Tracer(name)
+var meter = otel.Meter(name)
+
+var (
+	batchSizeKey = attribute.Key("tessera.batch_size")
+	fromSeqKey   = attribute.Key("tessera.from_seq")
+	newSizeKey   = attribute.Key("tessera.new_size")
+
+	evictionReasonKey = attribute.Key("tessera.eviction_reason")
+)
+
+// readCacheHits and readCacheMisses count reads of immutable resources served from, and not found in,
+// a ReadCache respectively.
+var (
+	readCacheHits   metric.Int64Counter
+	readCacheMisses metric.Int64Counter
+	// readCacheEvictions counts objects removed from a ReadCache, either to make space for others or because
+	// they had expired.
+	readCacheEvictions metric.Int64Counter
+	// readCacheBytes tracks the total size of the data held in ReadCaches.
+	readCacheBytes metric.Int64UpDownCounter
+)
+
+func init() {
+	var err error
+	readCacheHits, err = meter.Int64Counter(
+		"tessera.storage.read_cache_hits",
+		metric.WithDescription("Number of tile and entry bundle reads served from the in-memory read cache"),
+		metric.WithUnit("{read}"))
+	if err != nil {
+		klog.Exitf("Failed to create readCacheHits metric: %v", err)
+	}
+	readCacheMisses, err = meter.Int64Counter(
+		"tessera.storage.read_cache_misses",
+		metric.WithDescription("Number of tile and entry bundle reads which were not present in the in-memory read cache"),
+		metric.WithUnit("{read}"))
+	if err != nil {
+		klog.Exitf("Failed to create readCacheMisses metric: %v", err)
+	}
+	readCacheEvictions, err = meter.Int64Counter(
+		"tessera.storage.read_cache_evictions",
+		metric.WithDescription("Number of tiles and entry bundles evicted from the in-memory read cache"),
+		metric.WithUnit("{object}"))
+	if err != nil {
+		klog.Exitf("Failed to create readCacheEvictions metric: %v", err)
+	}
+	readCacheBytes, err = meter.Int64UpDownCounter(
+		"tessera.storage.read_cache_size",
+		metric.WithDescription("Total size of the tiles and entry bundles held in the in-memory read cache"),
+		metric.WithUnit("By"))
+	if err != nil {
+		klog.Exitf("Failed to create readCacheBytes metric: %v", err)
+	}
+}
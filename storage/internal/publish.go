@@ -0,0 +1,54 @@
+// Copyright 2024 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package storage
+
+import (
+	"sync/atomic"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// PublishPolicy decides whether a storage implementation should publish a new checkpoint, as configured
+// by the WithPublishOnlyOnChange and WithCheckpointRepublishInterval options.
+//
+// The zero value always publishes.
+type PublishPolicy struct {
+	// OnlyOnChange, if set, prevents republishing a checkpoint for an unchanged tree size.
+	OnlyOnChange bool
+	// RepublishInterval, if non-zero, is the age at which a checkpoint is republished even if
+	// OnlyOnChange is set and the tree size is unchanged.
+	RepublishInterval time.Duration
+
+	// publishedSize is the tree size of the last checkpoint published by this instance.
+	publishedSize atomic.Pointer[uint64]
+}
+
+// ShouldPublish returns true if a checkpoint should be published for a tree of the given size, when the
+// currently published checkpoint is cpAge old.
+func (p *PublishPolicy) ShouldPublish(size uint64, cpAge time.Duration) bool {
+	if !p.OnlyOnChange {
+		return true
+	}
+	if last := p.publishedSize.Load(); last != nil && *last == size && (p.RepublishInterval == 0 || cpAge < p.RepublishInterval) {
+		klog.V(1).Infof("publishCheckpoint: skipping publish because tree size %d is unchanged", size)
+		return false
+	}
+	return true
+}
+
+// Published records that a checkpoint has been published for a tree of the given size.
+func (p *PublishPolicy) Published(size uint64) {
+	p.publishedSize.Store(&size)
+}
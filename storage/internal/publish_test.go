@@ -0,0 +1,79 @@
+// Copyright 2024 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPublishPolicy(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		p         *PublishPolicy
+		published bool
+		size      uint64
+		cpAge     time.Duration
+		want      bool
+	}{
+		{
+			name:      "always publish",
+			p:         &PublishPolicy{},
+			published: true,
+			size:      10,
+			want:      true,
+		}, {
+			name: "only on change, nothing published yet",
+			p:    &PublishPolicy{OnlyOnChange: true},
+			size: 10,
+			want: true,
+		}, {
+			name:      "only on change, size changed",
+			p:         &PublishPolicy{OnlyOnChange: true},
+			published: true,
+			size:      11,
+			want:      true,
+		}, {
+			name:      "only on change, size unchanged",
+			p:         &PublishPolicy{OnlyOnChange: true},
+			published: true,
+			size:      10,
+			cpAge:     time.Hour,
+			want:      false,
+		}, {
+			name:      "size unchanged, before republish interval",
+			p:         &PublishPolicy{OnlyOnChange: true, RepublishInterval: time.Minute},
+			published: true,
+			size:      10,
+			cpAge:     30 * time.Second,
+			want:      false,
+		}, {
+			name:      "size unchanged, after republish interval",
+			p:         &PublishPolicy{OnlyOnChange: true, RepublishInterval: time.Minute},
+			published: true,
+			size:      10,
+			cpAge:     time.Minute,
+			want:      true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if test.published {
+				test.p.Published(10)
+			}
+			if got := test.p.ShouldPublish(test.size, test.cpAge); got != test.want {
+				t.Errorf("ShouldPublish(%d, %v) = %t, want %t", test.size, test.cpAge, got, test.want)
+			}
+		})
+	}
+}
@@ -18,11 +18,16 @@ package storage
 import (
 	"context"
 	"errors"
+	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/globocom/go-buffer"
+	"github.com/transparency-dev/merkle"
 	tessera "github.com/transparency-dev/trillian-tessera"
+	"github.com/transparency-dev/trillian-tessera/api"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Queue knows how to queue up a number of entries in order, taking care of deduplication as they're added.
@@ -36,9 +41,31 @@ import (
 // duplicate add calls.
 // Note that this deduplication only applies to "in-flight" entries currently in the queue; entries added
 // after a flush will not be deduped against those added before the flush.
+//
+// Each flush is traced in a span which links to the spans in which its entries were added, and which is the
+// parent of any spans started by the FlushFunc.
 type Queue struct {
 	buf   *buffer.Buffer
 	flush FlushFunc
+	// maxSize is the maximum number of entries which will be passed to a single call to flush.
+	maxSize uint
+	// pushMu serialises pushes to buf, so that the number of entries pending in it is known when pushing.
+	pushMu sync.Mutex
+	// pending is the number of entries which have been pushed to buf but not yet flushed from it.
+	pending atomic.Int64
+	// codec, if non-nil, is the codec which entries must have been created with to be added.
+	codec api.EntryBundleCodec
+	// hasher, if non-nil, is the hasher which entries' leaf hashes must have been calculated with to be added.
+	hasher merkle.LogHasher
+	// done is closed when the context passed to NewQueue is done, after which no further flushes will happen.
+	done <-chan struct{}
+
+	// inFlight, if non-nil, is used as a semaphore to limit the number of concurrent Add calls
+	// which are waiting in the queue for an index to be assigned.
+	inFlight chan struct{}
+
+	// sequenced remembers the flush spans in which ranges of entries were assigned indices.
+	sequenced SpanLinks
 }
 
 // FlushFunc is the signature of a function which will receive the slice of queued entries.
@@ -53,9 +80,22 @@ type FlushFunc func(ctx context.Context, entries []*tessera.Entry) error
 // The provided FlushFunc will be called with a slice containing the contents of the queue, in
 // the same order as they were added, when either the oldest entry in the queue has been there
 // for maxAge, or the size of the queue reaches maxSize.
-func NewQueue(ctx context.Context, maxAge time.Duration, maxSize uint, f FlushFunc) *Queue {
+//
+// If maxInFlight is non-zero, at most this many Add calls may be awaiting index assignment at
+// any one time; further calls will fail with tessera.ErrPushback until earlier entries are flushed.
+//
+// Entries which fail validation against codec and hasher, e.g. because they're too large to be marshalled into
+// a bundle, or their leaf hash was calculated with a different hasher, are rejected when they're added.
+func NewQueue(ctx context.Context, maxAge time.Duration, maxSize uint, maxInFlight uint, codec api.EntryBundleCodec, hasher merkle.LogHasher, f FlushFunc) *Queue {
 	q := &Queue{
-		flush: f,
+		flush:   f,
+		maxSize: maxSize,
+		codec:   codec,
+		hasher:  hasher,
+		done:    ctx.Done(),
+	}
+	if maxInFlight > 0 {
+		q.inFlight = make(chan struct{}, maxInFlight)
 	}
 
 	// The underlying queue implementation blocks additions during a flush.
@@ -65,12 +105,18 @@ func NewQueue(ctx context.Context, maxAge time.Duration, maxSize uint, f FlushFu
 	// This same worker thread will also handle the callbacks to f.
 	work := make(chan []*queueItem, 1)
 	toWork := func(items []interface{}) {
-		entries := make([]*queueItem, len(items))
-		for i, t := range items {
-			entries[i] = t.(*queueItem)
+		entries := make([]*queueItem, 0, len(items))
+		for _, t := range items {
+			switch t := t.(type) {
+			case *queueItem:
+				entries = append(entries, t)
+			case []*queueItem:
+				// Items added with AddBatch are kept together so that they're passed to the same flush.
+				entries = append(entries, t...)
+			}
 		}
+		q.pending.Add(-int64(len(entries)))
 		work <- entries
-
 	}
 
 	q.buf = buffer.New(
@@ -94,58 +140,232 @@ func NewQueue(ctx context.Context, maxAge time.Duration, maxSize uint, f FlushFu
 }
 
 // Add places e into the queue, and returns a func which may be called to retrieve the assigned index.
+//
+// Once queued, the entry will be sequenced regardless of whether ctx is subsequently cancelled, and
+// the returned future does not depend on ctx: it waits for the queue to be flushed, and then returns the
+// index assigned to the entry. This allows the future to be safely shared between callers, e.g. by
+// InMemoryDedupe. Callers which need to stop waiting early should do so without calling the future again.
+// The span in ctx, if any, is linked from the span in which the entry is flushed.
+//
+// The future will return an error without waiting if the context passed to NewQueue is done before the
+// entry is flushed.
 func (q *Queue) Add(ctx context.Context, e *tessera.Entry) tessera.IndexFuture {
-	qi := newEntry(e)
+	if err := e.Validate(q.codec, q.hasher); err != nil {
+		return func() (uint64, error) { return 0, fmt.Errorf("invalid entry: %w", err) }
+	}
+	if !q.acquire() {
+		return func() (uint64, error) {
+			return 0, fmt.Errorf("%w: too many concurrent adds", tessera.ErrPushback)
+		}
+	}
+	qi := q.newEntry(e, trace.SpanContextFromContext(ctx))
 
-	if err := q.buf.Push(qi); err != nil {
+	if err := q.push(qi, 1); err != nil {
 		qi.notify(err)
+		q.release()
 	}
 	return qi.f
 }
 
+// AddBatch places all of the provided entries into the queue, and returns one future per entry, in the
+// same order, which may be called to retrieve the index assigned to that entry.
+//
+// The entries are queued atomically, and are passed to the same call to the FlushFunc in the order provided,
+// so they will be assigned a contiguous range of indices. Any entries already queued are flushed first if
+// the batch wouldn't otherwise fit alongside them, so no flush contains more than maxSize entries.
+//
+// A batch may contain at most maxSize entries, and at most maxInFlight entries if that's non-zero. Larger
+// batches can never be queued, so they're rejected with tessera.ErrBatchTooLarge rather than
+// tessera.ErrPushback, and must be split by the caller.
+//
+// As with Add, the returned futures do not depend on ctx.
+func (q *Queue) AddBatch(ctx context.Context, entries []*tessera.Entry) []tessera.IndexFuture {
+	fs := make([]tessera.IndexFuture, len(entries))
+	if len(entries) == 0 {
+		return fs
+	}
+	if max := q.maxBatchSize(); uint(len(entries)) > max {
+		err := fmt.Errorf("%w: %d entries, but at most %d may be added at once", tessera.ErrBatchTooLarge, len(entries), max)
+		for j := range fs {
+			fs[j] = func() (uint64, error) { return 0, err }
+		}
+		return fs
+	}
+	// The batch is added atomically, so a single invalid entry causes the whole batch to be rejected.
+	for i, e := range entries {
+		if err := e.Validate(q.codec, q.hasher); err != nil {
+			for j := range fs {
+				fs[j] = func() (uint64, error) { return 0, fmt.Errorf("invalid entry %d in batch: %w", i, err) }
+			}
+			return fs
+		}
+	}
+	for i := range entries {
+		if !q.acquire() {
+			for range i {
+				q.release()
+			}
+			for j := range fs {
+				fs[j] = func() (uint64, error) {
+					return 0, fmt.Errorf("%w: too many concurrent adds", tessera.ErrPushback)
+				}
+			}
+			return fs
+		}
+	}
+	sc := trace.SpanContextFromContext(ctx)
+	qis := make([]*queueItem, len(entries))
+	for i, e := range entries {
+		qis[i] = q.newEntry(e, sc)
+		fs[i] = qis[i].f
+	}
+
+	if err := q.push(qis, len(qis)); err != nil {
+		for _, qi := range qis {
+			qi.notify(err)
+			q.release()
+		}
+	}
+	return fs
+}
+
+// maxBatchSize returns the largest number of entries which can be passed to AddBatch.
+func (q *Queue) maxBatchSize() uint {
+	if q.inFlight != nil && uint(cap(q.inFlight)) < q.maxSize {
+		return uint(cap(q.inFlight))
+	}
+	return q.maxSize
+}
+
+// push adds item, which holds n entries, to the buffer.
+//
+// The buffer counts each push as a single item, so to keep flushes within maxSize entries, any entries already
+// pending are flushed first if item wouldn't fit alongside them, and the buffer is flushed once it holds
+// maxSize entries.
+func (q *Queue) push(item interface{}, n int) error {
+	q.pushMu.Lock()
+	defer q.pushMu.Unlock()
+
+	if p := q.pending.Load(); p > 0 && uint(p)+uint(n) > q.maxSize {
+		if err := q.buf.Flush(); err != nil {
+			return fmt.Errorf("failed to flush queue: %v", err)
+		}
+	}
+	pending := q.pending.Add(int64(n))
+	if err := q.buf.Push(item); err != nil {
+		q.pending.Add(-int64(n))
+		return err
+	}
+	if uint(pending) >= q.maxSize {
+		// The buffer won't flush itself until it holds maxSize items, which may be many more entries than this.
+		// If the flush can't be requested, the entries will still be flushed once they reach maxAge.
+		_ = q.buf.Flush()
+	}
+	return nil
+}
+
+// acquire attempts to reserve an in-flight slot for a new entry, returning false if none are available.
+func (q *Queue) acquire() bool {
+	if q.inFlight == nil {
+		return true
+	}
+	select {
+	case q.inFlight <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// release frees an in-flight slot previously reserved with acquire.
+func (q *Queue) release() {
+	if q.inFlight != nil {
+		<-q.inFlight
+	}
+}
+
+// SequencedLinks returns links to the flush spans in which any of the entries in [from, to) were assigned
+// their indices by this queue.
+//
+// Storage implementations which integrate asynchronously should call this when integrating entries, so
+// that the integration span can be linked to the entries' lifecycle.
+func (q *Queue) SequencedLinks(from, to uint64) []trace.Link {
+	return q.sequenced.Take(from, to)
+}
+
 // doFlush handles the queue flush, and sending notifications of assigned log indices.
 func (q *Queue) doFlush(ctx context.Context, entries []*queueItem) {
 	entriesData := make([]*tessera.Entry, 0, len(entries))
+	links := make([]trace.Link, 0, len(entries))
 	for _, e := range entries {
 		entriesData = append(entriesData, e.entry)
+		if e.span.IsValid() {
+			links = append(links, trace.Link{SpanContext: e.span})
+		}
 	}
 
+	ctx, span := tracer.Start(ctx, "tessera.storage.flush", trace.WithLinks(links...))
+	span.SetAttributes(batchSizeKey.Int(len(entries)))
 	err := q.flush(ctx, entriesData)
+	if err == nil && len(entriesData) > 0 {
+		first, last := entriesData[0].Index(), entriesData[len(entriesData)-1].Index()
+		if first != nil && last != nil {
+			q.sequenced.Add(*first, *last+1, span.SpanContext())
+		}
+	}
+	span.End()
 
 	// Send assigned indices to all the waiting Add() requests
 	for _, e := range entries {
 		e.notify(err)
+		q.release()
 	}
 }
 
 // queueItem represents an in-flight queueItem in the queue.
 //
 // The f field acts as a future for the queueItem's assigned index/error, and will
-// hang until assign is called.
+// hang until notify is called or the queue's context is done.
 type queueItem struct {
 	entry *tessera.Entry
-	c     chan tessera.IndexFuture
-	f     tessera.IndexFuture
+	// span identifies the span in which the entry was added, if any.
+	span trace.SpanContext
+	// done is closed by notify once res has been set.
+	done chan struct{}
+	res  tessera.IndexFuture
+	f    tessera.IndexFuture
 }
 
-// newEntry creates a new entry for the provided data.
-func newEntry(data *tessera.Entry) *queueItem {
+// newEntry creates a new entry for the provided data, added in the span identified by sc.
+func (q *Queue) newEntry(data *tessera.Entry, sc trace.SpanContext) *queueItem {
 	e := &queueItem{
 		entry: data,
-		c:     make(chan tessera.IndexFuture, 1),
+		span:  sc,
+		done:  make(chan struct{}),
+	}
+	e.f = func() (uint64, error) {
+		// Prefer the outcome of the flush if it's already known.
+		select {
+		case <-e.done:
+			return e.res()
+		default:
+		}
+		select {
+		case <-e.done:
+			return e.res()
+		case <-q.done:
+			return 0, errors.New("queue closed before entry was sequenced")
+		}
 	}
-	e.f = sync.OnceValues(func() (uint64, error) {
-		return (<-e.c)()
-	})
 	return e
 }
 
-// assign sets the assigned log index (or an error) to the entry.
+// notify sets the assigned log index (or an error) to the entry.
 //
-// This func must only be called once, and will cause any current or future callers of index()
+// This func must only be called once, and will cause any current or future callers of f
 // to be given the values provided here.
 func (e *queueItem) notify(err error) {
-	e.c <- func() (uint64, error) {
+	e.res = func() (uint64, error) {
 		if err != nil {
 			return 0, err
 		}
@@ -154,5 +374,5 @@ func (e *queueItem) notify(err error) {
 		}
 		return *e.entry.Index(), nil
 	}
-	close(e.c)
+	close(e.done)
 }
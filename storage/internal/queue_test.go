@@ -16,14 +16,23 @@ package storage_test
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math"
 	"reflect"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/transparency-dev/merkle"
+	"github.com/transparency-dev/merkle/rfc6962"
 	tessera "github.com/transparency-dev/trillian-tessera"
+	"github.com/transparency-dev/trillian-tessera/api"
 	"github.com/transparency-dev/trillian-tessera/storage/internal"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func TestQueue(t *testing.T) {
@@ -71,7 +80,7 @@ func TestQueue(t *testing.T) {
 			}
 
 			// Create the Queue
-			q := storage.NewQueue(ctx, test.maxWait, uint(test.maxEntries), flushFunc)
+			q := storage.NewQueue(ctx, test.maxWait, uint(test.maxEntries), 0, nil, nil, flushFunc)
 
 			// Now submit a bunch of entries
 			adds := make([]tessera.IndexFuture, test.numItems)
@@ -95,3 +104,355 @@ func TestQueue(t *testing.T) {
 		})
 	}
 }
+
+func TestQueueMaxInFlight(t *testing.T) {
+	ctx := context.Background()
+	const maxInFlight = 10
+
+	release := make(chan struct{})
+	idx := uint64(0)
+	flushFunc := func(_ context.Context, entries []*tessera.Entry) error {
+		<-release
+		for _, e := range entries {
+			_ = e.MarshalBundleData(idx)
+			idx++
+		}
+		return nil
+	}
+
+	// The flushFunc blocks until release is closed, so entries remain in-flight until then.
+	q := storage.NewQueue(ctx, 10*time.Millisecond, 2*maxInFlight, maxInFlight, nil, nil, flushFunc)
+
+	adds := make([]tessera.IndexFuture, 0, maxInFlight)
+	for i := 0; i < maxInFlight; i++ {
+		adds = append(adds, q.Add(ctx, tessera.NewEntry([]byte(fmt.Sprintf("item %d", i)))))
+	}
+
+	if _, err := q.Add(ctx, tessera.NewEntry([]byte("one too many")))(); !errors.Is(err, tessera.ErrPushback) {
+		t.Fatalf("Add over limit: got err %v, want %v", err, tessera.ErrPushback)
+	}
+
+	// Allow the queued entries to be flushed and check they all succeeded.
+	close(release)
+	for i, f := range adds {
+		if _, err := f(); err != nil {
+			t.Fatalf("Add %d: %v", i, err)
+		}
+	}
+}
+
+func TestQueueAddCancelled(t *testing.T) {
+	ctx := context.Background()
+
+	flushed := make(chan []*tessera.Entry, 10)
+	release := make(chan struct{})
+	idx := uint64(0)
+	flushFunc := func(_ context.Context, entries []*tessera.Entry) error {
+		<-release
+		for _, e := range entries {
+			_ = e.MarshalBundleData(idx)
+			idx++
+		}
+		flushed <- entries
+		return nil
+	}
+	q := storage.NewQueue(ctx, 10*time.Millisecond, 100, 0, nil, nil, flushFunc)
+
+	// Cancelling the Add's context once the entry is queued must not prevent it from being sequenced,
+	// nor cause the future to give up.
+	cctx, cancel := context.WithCancel(ctx)
+	f := q.Add(cctx, tessera.NewEntry([]byte("cancelled")))
+	cancel()
+	close(release)
+	if got := <-flushed; len(got) != 1 || string(got[0].Data()) != "cancelled" {
+		t.Errorf("flushed %v, want the cancelled entry", got)
+	}
+	if got, err := f(); err != nil || got != 0 {
+		t.Errorf("cancelled Add: got (%d, %v), want index 0", got, err)
+	}
+}
+
+func TestQueueClosed(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	flushFunc := func(_ context.Context, entries []*tessera.Entry) error {
+		t.Error("unexpected flush")
+		return nil
+	}
+	q := storage.NewQueue(ctx, time.Hour, 100, 0, nil, nil, flushFunc)
+
+	f := q.Add(context.Background(), tessera.NewEntry([]byte("never flushed")))
+	cancel()
+	if _, err := f(); err == nil {
+		t.Error("Add to closed queue: got no error, want error")
+	}
+}
+
+// prefixHasher is a LogHasher whose leaf hashes are domain separated from RFC6962 ones by an extra prefix.
+type prefixHasher struct {
+	merkle.LogHasher
+}
+
+func (h prefixHasher) HashLeaf(leaf []byte) []byte {
+	return h.LogHasher.HashLeaf(append([]byte("prefix"), leaf...))
+}
+
+func TestQueueInvalidEntry(t *testing.T) {
+	ctx := context.Background()
+	flushFunc := func(_ context.Context, entries []*tessera.Entry) error {
+		t.Errorf("unexpected flush of %d entries", len(entries))
+		return nil
+	}
+	q := storage.NewQueue(ctx, 10*time.Millisecond, 100, 0, api.LengthPrefixedCodec{}, rfc6962.DefaultHasher, flushFunc)
+
+	tooBig := tessera.NewEntry(make([]byte, math.MaxUint16+1))
+	if _, err := q.Add(ctx, tooBig)(); err == nil {
+		t.Error("Add of oversize entry succeeded, want error")
+	}
+	for i, f := range q.AddBatch(ctx, []*tessera.Entry{tessera.NewEntry([]byte("ok")), tooBig}) {
+		if _, err := f(); err == nil {
+			t.Errorf("AddBatch with oversize entry: entry %d succeeded, want error", i)
+		}
+	}
+	hashEntry, err := tessera.NewEntryWithCodec(make([]byte, 32), api.HashCodec{Size: 32})
+	if err != nil {
+		t.Fatalf("NewEntryWithCodec: %v", err)
+	}
+	if _, err := q.Add(ctx, hashEntry)(); err == nil {
+		t.Error("Add of entry with different codec succeeded, want error")
+	}
+	if _, err := q.Add(ctx, tessera.NewEntryWithHasher([]byte("ok"), prefixHasher{rfc6962.DefaultHasher}))(); err == nil {
+		t.Error("Add of entry with different hasher succeeded, want error")
+	}
+}
+
+func TestQueueSpanLinks(t *testing.T) {
+	ctx := context.Background()
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(tp)
+
+	var flushSpan trace.SpanContext
+	flushFunc := func(ctx context.Context, entries []*tessera.Entry) error {
+		flushSpan = trace.SpanContextFromContext(ctx)
+		for i, e := range entries {
+			_ = e.MarshalBundleData(uint64(i))
+		}
+		return nil
+	}
+	q := storage.NewQueue(ctx, 10*time.Millisecond, 100, 0, nil, nil, flushFunc)
+
+	addCtx, addSpan := tp.Tracer("test").Start(ctx, "add")
+	if _, err := q.Add(addCtx, tessera.NewEntry([]byte("traced")))(); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	addSpan.End()
+
+	// The flush span should link to the span in which the entry was added, and be the parent of any spans
+	// started by the FlushFunc.
+	var flushLinks []sdktrace.Link
+	for _, s := range recorder.Ended() {
+		if s.Name() == "tessera.storage.flush" {
+			if !s.SpanContext().Equal(flushSpan) {
+				t.Errorf("FlushFunc called with span %v, want flush span %v", flushSpan, s.SpanContext())
+			}
+			flushLinks = s.Links()
+		}
+	}
+	if len(flushLinks) != 1 || !flushLinks[0].SpanContext.Equal(addSpan.SpanContext()) {
+		t.Errorf("flush span links = %v, want link to add span %v", flushLinks, addSpan.SpanContext())
+	}
+
+	// Integration of the entry should be able to link back to the flush span, but only once.
+	if got := q.SequencedLinks(0, 1); len(got) != 1 || !got[0].SpanContext.Equal(flushSpan) {
+		t.Errorf("SequencedLinks = %v, want link to flush span %v", got, flushSpan)
+	}
+	if got := q.SequencedLinks(0, 1); len(got) != 0 {
+		t.Errorf("second SequencedLinks = %v, want none", got)
+	}
+}
+
+func TestQueueAddCancelledDedupe(t *testing.T) {
+	ctx := context.Background()
+
+	release := make(chan struct{})
+	var mu sync.Mutex
+	numFlushed := 0
+	flushFunc := func(_ context.Context, entries []*tessera.Entry) error {
+		<-release
+		mu.Lock()
+		defer mu.Unlock()
+		for _, e := range entries {
+			_ = e.MarshalBundleData(uint64(numFlushed))
+			numFlushed++
+		}
+		return nil
+	}
+	q := storage.NewQueue(ctx, 10*time.Millisecond, 100, 0, nil, nil, flushFunc)
+	add := tessera.InMemoryDedupe(q.Add, 10)
+
+	// The first caller's context is cancelled, but the dedupe layer has cached its future.
+	cctx, cancel := context.WithCancel(ctx)
+	_ = add(cctx, tessera.NewEntry([]byte("dupe")))
+	cancel()
+	close(release)
+
+	// A later caller with a live context must get the index assigned to the entry.
+	if got, err := add(ctx, tessera.NewEntry([]byte("dupe")))(); err != nil || got != 0 {
+		t.Errorf("retried Add: got (%d, %v), want index 0", got, err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if numFlushed != 1 {
+		t.Errorf("sequenced %d entries, want 1", numFlushed)
+	}
+}
+
+func TestQueueAddBatch(t *testing.T) {
+	ctx := context.Background()
+	const numSingles, numBatches, batchSize, maxSize = 50, 10, 25, 32
+
+	var mu sync.Mutex
+	idx := uint64(0)
+	flushFunc := func(_ context.Context, entries []*tessera.Entry) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if len(entries) > maxSize {
+			t.Errorf("flushed %d entries, want at most %d", len(entries), maxSize)
+		}
+		for _, e := range entries {
+			_ = e.MarshalBundleData(idx)
+			idx++
+		}
+		return nil
+	}
+	// Use a small queue to ensure that batches are added concurrently with flushes.
+	q := storage.NewQueue(ctx, time.Millisecond, maxSize, 0, nil, nil, flushFunc)
+
+	var wg sync.WaitGroup
+	for i := 0; i < numSingles; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := q.Add(ctx, tessera.NewEntry([]byte(fmt.Sprintf("single %d", i))))(); err != nil {
+				t.Errorf("Add: %v", err)
+			}
+		}(i)
+	}
+	for b := 0; b < numBatches; b++ {
+		wg.Add(1)
+		go func(b int) {
+			defer wg.Done()
+			entries := make([]*tessera.Entry, batchSize)
+			for i := range entries {
+				entries[i] = tessera.NewEntry([]byte(fmt.Sprintf("batch %d entry %d", b, i)))
+			}
+			fs := q.AddBatch(ctx, entries)
+			if len(fs) != len(entries) {
+				t.Errorf("AddBatch returned %d futures, want %d", len(fs), len(entries))
+				return
+			}
+			first, err := fs[0]()
+			if err != nil {
+				t.Errorf("AddBatch: %v", err)
+				return
+			}
+			// The entries in the batch must have been assigned a contiguous range of indices, in order.
+			for i, f := range fs {
+				got, err := f()
+				if err != nil {
+					t.Errorf("AddBatch entry %d: %v", i, err)
+					return
+				}
+				if want := first + uint64(i); got != want {
+					t.Errorf("batch %d entry %d: got index %d, want %d", b, i, got, want)
+				}
+			}
+		}(b)
+	}
+	wg.Wait()
+
+	if got, want := idx, uint64(numSingles+numBatches*batchSize); got != want {
+		t.Errorf("got %d entries flushed, want %d", got, want)
+	}
+}
+
+func TestQueueAddBatchMaxInFlight(t *testing.T) {
+	ctx := context.Background()
+	q := storage.NewQueue(ctx, time.Hour, 100, 3, nil, nil, func(context.Context, []*tessera.Entry) error { return nil })
+
+	// A batch which could never fit is rejected outright, rather than with pushback.
+	fs := q.AddBatch(ctx, []*tessera.Entry{tessera.NewEntry([]byte("a")), tessera.NewEntry([]byte("b")), tessera.NewEntry([]byte("c")), tessera.NewEntry([]byte("d"))})
+	for i, f := range fs {
+		if _, err := f(); !errors.Is(err, tessera.ErrBatchTooLarge) || errors.Is(err, tessera.ErrPushback) {
+			t.Errorf("AddBatch entry %d larger than limit: got err %v, want %v", i, err, tessera.ErrBatchTooLarge)
+		}
+	}
+
+	// A batch which doesn't fit alongside the entries already in flight is pushed back.
+	_ = q.Add(ctx, tessera.NewEntry([]byte("in flight")))
+	fs = q.AddBatch(ctx, []*tessera.Entry{tessera.NewEntry([]byte("a")), tessera.NewEntry([]byte("b")), tessera.NewEntry([]byte("c"))})
+	for i, f := range fs {
+		if _, err := f(); !errors.Is(err, tessera.ErrPushback) {
+			t.Errorf("AddBatch entry %d over limit: got err %v, want %v", i, err, tessera.ErrPushback)
+		}
+	}
+	// The slots acquired for the rejected batch must have been released, so a batch which fits is accepted
+	// and occupies all of the available slots.
+	fs = q.AddBatch(ctx, []*tessera.Entry{tessera.NewEntry([]byte("a")), tessera.NewEntry([]byte("b"))})
+	if got := len(fs); got != 2 {
+		t.Fatalf("AddBatch returned %d futures, want 2", got)
+	}
+	if _, err := q.Add(ctx, tessera.NewEntry([]byte("one too many")))(); !errors.Is(err, tessera.ErrPushback) {
+		t.Errorf("Add over limit: got err %v, want %v", err, tessera.ErrPushback)
+	}
+}
+
+func TestQueueAddBatchLargerThanMaxSize(t *testing.T) {
+	ctx := context.Background()
+	q := storage.NewQueue(ctx, time.Hour, 2, 0, nil, nil, func(context.Context, []*tessera.Entry) error {
+		t.Error("unexpected flush")
+		return nil
+	})
+
+	fs := q.AddBatch(ctx, []*tessera.Entry{tessera.NewEntry([]byte("a")), tessera.NewEntry([]byte("b")), tessera.NewEntry([]byte("c"))})
+	for i, f := range fs {
+		if _, err := f(); !errors.Is(err, tessera.ErrBatchTooLarge) {
+			t.Errorf("AddBatch entry %d larger than maxSize: got err %v, want %v", i, err, tessera.ErrBatchTooLarge)
+		}
+	}
+}
+
+func TestQueueAddBatchFlushesPending(t *testing.T) {
+	ctx := context.Background()
+	const maxSize = 4
+
+	flushed := make(chan int, 10)
+	idx := uint64(0)
+	flushFunc := func(_ context.Context, entries []*tessera.Entry) error {
+		for _, e := range entries {
+			_ = e.MarshalBundleData(idx)
+			idx++
+		}
+		flushed <- len(entries)
+		return nil
+	}
+	// The queue is never flushed by age, so flushes only happen once it holds maxSize entries, or to make room
+	// for a batch.
+	q := storage.NewQueue(ctx, time.Hour, maxSize, 0, nil, nil, flushFunc)
+
+	single := q.Add(ctx, tessera.NewEntry([]byte("single")))
+	batch := q.AddBatch(ctx, []*tessera.Entry{tessera.NewEntry([]byte("a")), tessera.NewEntry([]byte("b")), tessera.NewEntry([]byte("c")), tessera.NewEntry([]byte("d"))})
+	for i, want := range []int{1, maxSize} {
+		if got := <-flushed; got != want {
+			t.Errorf("flush %d: got %d entries, want %d", i, got, want)
+		}
+	}
+	if got, err := single(); err != nil || got != 0 {
+		t.Errorf("Add: got (%d, %v), want index 0", got, err)
+	}
+	for i, f := range batch {
+		if got, err := f(); err != nil || got != uint64(i+1) {
+			t.Errorf("AddBatch entry %d: got (%d, %v), want index %d", i, got, err, i+1)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright 2024 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/golang-lru/v2/simplelru"
+	"go.opentelemetry.io/otel/metric"
+	"k8s.io/klog/v2"
+)
+
+var (
+	evictedForSize = metric.WithAttributes(evictionReasonKey.String("size"))
+	evictedForTTL  = metric.WithAttributes(evictionReasonKey.String("ttl"))
+)
+
+// ReadCache is an in-memory LRU cache of immutable log resources, i.e. tiles and entry bundles,
+// bounded by the total size of the cached data.
+//
+// Mutable resources, such as the checkpoint, must not be read via the cache.
+//
+// A nil *ReadCache is valid, and passes all reads through uncached.
+type ReadCache struct {
+	mu       sync.Mutex
+	lru      *simplelru.LRU[string, cachedObject]
+	maxBytes int
+	ttl      time.Duration
+	// size is the total size of the data currently held in lru.
+	size int
+}
+
+type cachedObject struct {
+	data  []byte
+	added time.Time
+}
+
+// NewReadCache returns a ReadCache which holds up to maxBytes of data.
+//
+// If ttl is non-zero, objects are evicted once they have been cached for longer than this, otherwise they
+// are held until they're evicted to make space for others.
+//
+// Returns nil, i.e. no caching, if maxBytes is zero.
+func NewReadCache(maxBytes int, ttl time.Duration) *ReadCache {
+	if maxBytes <= 0 {
+		klog.Info("Read cache disabled")
+		return nil
+	}
+	klog.Infof("Read cache enabled: holding up to %d bytes, ttl %v", maxBytes, ttl)
+	// A soft memory limit of MaxInt64 means that none has been set.
+	if limit := debug.SetMemoryLimit(-1); limit != math.MaxInt64 && int64(maxBytes) >= limit {
+		klog.Warningf("Read cache may hold up to %d bytes, which is not less than the Go runtime's memory limit of %d bytes", maxBytes, limit)
+	}
+	c := &ReadCache{
+		maxBytes: maxBytes,
+		ttl:      ttl,
+	}
+	// The number of objects is bounded by maxBytes, so the LRU itself is effectively unbounded.
+	l, err := simplelru.NewLRU(math.MaxInt, func(_ string, o cachedObject) {
+		c.size -= len(o.data)
+		readCacheBytes.Add(context.Background(), -int64(len(o.data)))
+	})
+	if err != nil {
+		panic(fmt.Errorf("simplelru.NewLRU: %v", err))
+	}
+	c.lru = l
+	return c
+}
+
+// Get returns the object at path from the cache if present, or reads it using f and caches it otherwise.
+func (c *ReadCache) Get(ctx context.Context, path string, f func(ctx context.Context, path string) ([]byte, error)) ([]byte, error) {
+	if c == nil {
+		return f(ctx, path)
+	}
+	if d, ok := c.lookup(ctx, path); ok {
+		readCacheHits.Add(ctx, 1)
+		return d, nil
+	}
+	readCacheMisses.Add(ctx, 1)
+
+	d, err := f(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	c.add(ctx, path, d)
+	return d, nil
+}
+
+// lookup returns the cached data for path, if present and not expired.
+func (c *ReadCache) lookup(ctx context.Context, path string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	o, ok := c.lru.Get(path)
+	if !ok {
+		return nil, false
+	}
+	if c.ttl > 0 && time.Since(o.added) > c.ttl {
+		c.lru.Remove(path)
+		readCacheEvictions.Add(ctx, 1, evictedForTTL)
+		return nil, false
+	}
+	return o.data, true
+}
+
+// add caches d for path, evicting the least recently used objects as necessary to keep within maxBytes.
+func (c *ReadCache) add(ctx context.Context, path string, d []byte) {
+	if len(d) > c.maxBytes {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Remove any existing copy first so that the eviction callback accounts for its size.
+	c.lru.Remove(path)
+	c.lru.Add(path, cachedObject{data: d, added: time.Now()})
+	c.size += len(d)
+	readCacheBytes.Add(ctx, int64(len(d)))
+	for c.size > c.maxBytes {
+		c.lru.RemoveOldest()
+		readCacheEvictions.Add(ctx, 1, evictedForSize)
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2024 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// countingReader returns len(path) bytes for each path, and counts the reads of each path.
+type countingReader map[string]int
+
+func (r countingReader) read(_ context.Context, path string) ([]byte, error) {
+	r[path]++
+	return make([]byte, len(path)), nil
+}
+
+func TestReadCache(t *testing.T) {
+	ctx := context.Background()
+	for _, test := range []struct {
+		name      string
+		maxBytes  int
+		ttl       time.Duration
+		reads     []string
+		wantReads map[string]int
+	}{
+		{
+			name:      "disabled",
+			maxBytes:  0,
+			reads:     []string{"a", "a", "a"},
+			wantReads: map[string]int{"a": 3},
+		}, {
+			name:      "cached",
+			maxBytes:  10,
+			reads:     []string{"a", "bb", "a", "bb"},
+			wantReads: map[string]int{"a": 1, "bb": 1},
+		}, {
+			name:     "evicts least recently used",
+			maxBytes: 4,
+			// "a" is used more recently than "bb" when "ccc" is added, so only "bb" is evicted.
+			reads:     []string{"a", "bb", "a", "ccc", "a", "bb"},
+			wantReads: map[string]int{"a": 1, "bb": 2, "ccc": 1},
+		}, {
+			name:      "too large to cache",
+			maxBytes:  2,
+			reads:     []string{"ccc", "ccc"},
+			wantReads: map[string]int{"ccc": 2},
+		}, {
+			name:      "expired",
+			maxBytes:  10,
+			ttl:       time.Nanosecond,
+			reads:     []string{"a", "a"},
+			wantReads: map[string]int{"a": 2},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			c := NewReadCache(test.maxBytes, test.ttl)
+			r := countingReader{}
+			for _, p := range test.reads {
+				if test.ttl > 0 {
+					time.Sleep(test.ttl)
+				}
+				d, err := c.Get(ctx, p, r.read)
+				if err != nil {
+					t.Fatalf("Get(%q): %v", p, err)
+				}
+				if len(d) != len(p) {
+					t.Fatalf("Get(%q): got %d bytes, want %d", p, len(d), len(p))
+				}
+			}
+			for p, want := range test.wantReads {
+				if got := r[p]; got != want {
+					t.Errorf("got %d reads of %q, want %d", got, p, want)
+				}
+			}
+			if c != nil && c.size > test.maxBytes {
+				t.Errorf("cache holds %d bytes, more than max %d", c.size, test.maxBytes)
+			}
+		})
+	}
+}
+
+func TestReadCacheErrorNotCached(t *testing.T) {
+	ctx := context.Background()
+	c := NewReadCache(10, 0)
+	wantErr := errors.New("boom")
+	if _, err := c.Get(ctx, "a", func(context.Context, string) ([]byte, error) { return nil, wantErr }); !errors.Is(err, wantErr) {
+		t.Fatalf("Get: got err %v, want %v", err, wantErr)
+	}
+	if d, err := c.Get(ctx, "a", func(context.Context, string) ([]byte, error) { return []byte("a"), nil }); err != nil || string(d) != "a" {
+		t.Errorf("Get: got (%q, %v), want %q", d, err, "a")
+	}
+}
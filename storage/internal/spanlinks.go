@@ -0,0 +1,76 @@
+// Copyright 2024 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"sync"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maxSpanLinks is the maximum number of sequenced ranges remembered by SpanLinks.
+//
+// Ranges sequenced by this instance may be integrated by another, in which case they're never taken, so
+// the oldest are forgotten once this limit is reached.
+const maxSpanLinks = 1024
+
+// SpanLinks remembers the spans in which ranges of entries were sequenced, so that the spans in which
+// they're later integrated can be linked to them.
+//
+// This allows the lifecycle of an entry to be traced in storage implementations which integrate
+// asynchronously, and so can't simply propagate the context.
+type SpanLinks struct {
+	mu sync.Mutex
+	rs []spanRange
+}
+
+// spanRange records the span in which the entries in [from, to) were sequenced.
+type spanRange struct {
+	from, to uint64
+	sc       trace.SpanContext
+}
+
+// Add records that the entries in [from, to) were sequenced in the span identified by sc.
+func (l *SpanLinks) Add(from, to uint64, sc trace.SpanContext) {
+	if !sc.IsValid() || from >= to {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.rs) >= maxSpanLinks {
+		l.rs = l.rs[1:]
+	}
+	l.rs = append(l.rs, spanRange{from: from, to: to, sc: sc})
+}
+
+// Take returns links to the spans in which any of the entries in [from, to) were sequenced.
+//
+// Ranges which end at or before to are forgotten, since those entries won't be integrated again.
+func (l *SpanLinks) Take(from, to uint64) []trace.Link {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var links []trace.Link
+	keep := l.rs[:0]
+	for _, r := range l.rs {
+		if r.from < to && r.to > from {
+			links = append(links, trace.Link{SpanContext: r.sc})
+		}
+		if r.to > to {
+			keep = append(keep, r)
+		}
+	}
+	l.rs = keep
+	return links
+}
@@ -0,0 +1,92 @@
+// Copyright 2024 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"testing"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+func spanContext(i byte) trace.SpanContext {
+	return trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: trace.TraceID{i},
+		SpanID:  trace.SpanID{i},
+	})
+}
+
+func TestSpanLinks(t *testing.T) {
+	var l SpanLinks
+	l.Add(0, 10, spanContext(1))
+	l.Add(10, 20, spanContext(2))
+	l.Add(20, 30, spanContext(3))
+	// Spans which weren't recorded can't be linked to.
+	l.Add(30, 40, trace.SpanContext{})
+
+	for _, test := range []struct {
+		name     string
+		from, to uint64
+		want     []trace.SpanContext
+	}{
+		{
+			name: "part of first range",
+			from: 0,
+			to:   5,
+			want: []trace.SpanContext{spanContext(1)},
+		}, {
+			name: "spanning ranges",
+			from: 5,
+			to:   15,
+			want: []trace.SpanContext{spanContext(1), spanContext(2)},
+		}, {
+			name: "first range forgotten",
+			from: 0,
+			to:   5,
+		}, {
+			name: "unrecorded range",
+			from: 30,
+			to:   40,
+		}, {
+			name: "all forgotten",
+			from: 0,
+			to:   40,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got := l.Take(test.from, test.to)
+			if len(got) != len(test.want) {
+				t.Fatalf("Take(%d, %d) = %v, want %v", test.from, test.to, got, test.want)
+			}
+			for i := range got {
+				if !got[i].SpanContext.Equal(test.want[i]) {
+					t.Errorf("Take(%d, %d)[%d] = %v, want %v", test.from, test.to, i, got[i].SpanContext, test.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSpanLinksBounded(t *testing.T) {
+	var l SpanLinks
+	for i := uint64(0); i <= maxSpanLinks; i++ {
+		l.Add(i, i+1, spanContext(1))
+	}
+	if got := l.Take(0, 1); len(got) != 0 {
+		t.Errorf("Take of oldest range = %v, want it to have been forgotten", got)
+	}
+	if got := l.Take(maxSpanLinks, maxSpanLinks+1); len(got) != 1 {
+		t.Errorf("Take of newest range = %v, want one link", got)
+	}
+}
@@ -0,0 +1,46 @@
+// Copyright 2024 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlstore
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"k8s.io/klog/v2"
+)
+
+const name = "github.com/transparency-dev/trillian-tessera/storage/internal/sqlstore"
+
+var tracer = otel.Tracer(name)
+var meter = otel.Meter(name)
+
+var (
+	batchSizeKey = attribute.Key("tessera.batch_size")
+	fromSeqKey   = attribute.Key("tessera.from_seq")
+)
+
+// checkpointAge records the time since the published checkpoint was last updated.
+var checkpointAge metric.Float64Gauge
+
+func init() {
+	var err error
+	checkpointAge, err = meter.Float64Gauge(
+		"tessera.storage.checkpoint_age",
+		metric.WithDescription("Time since the published checkpoint was last updated"),
+		metric.WithUnit("s"))
+	if err != nil {
+		klog.Exitf("Failed to create checkpointAge metric: %v", err)
+	}
+}
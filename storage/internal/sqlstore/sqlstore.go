@@ -0,0 +1,756 @@
+// Copyright 2024 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sqlstore contains the parts of a Tessera storage implementation which are common to the
+// SQL database based implementations, i.e. MySQL and PostgreSQL.
+//
+// The log's tiles, entry bundles, tree state and checkpoint are all stored in tables in the database,
+// and updated transactionally. The differences between the databases are described by a Dialect.
+package sqlstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/transparency-dev/merkle"
+	tessera "github.com/transparency-dev/trillian-tessera"
+	"github.com/transparency-dev/trillian-tessera/api"
+	"github.com/transparency-dev/trillian-tessera/api/layout"
+	"github.com/transparency-dev/trillian-tessera/internal/options"
+	storage "github.com/transparency-dev/trillian-tessera/storage/internal"
+	"k8s.io/klog/v2"
+)
+
+const (
+	checkpointID = 0
+	treeStateID  = 0
+
+	minCheckpointInterval = time.Second
+)
+
+// Statements holds the SQL statements used to access the tables described by a database's schema.sql.
+//
+// Statements which write a row must replace any existing row with the same key, unless noted otherwise.
+type Statements struct {
+	// SelectCheckpoint selects the note and published_at columns of the Checkpoint row with the given id.
+	SelectCheckpoint string
+	// SelectCheckpointForUpdate is SelectCheckpoint, but also locks the row for the rest of the transaction.
+	SelectCheckpointForUpdate string
+	// UpsertCheckpoint writes the id, note and published_at columns of a Checkpoint row.
+	UpsertCheckpoint string
+
+	// SelectCheckpointAtSize selects the note of the CheckpointHistory row with the smallest size which is at
+	// least the given size.
+	SelectCheckpointAtSize string
+	// SelectCheckpointAt selects the note of the CheckpointHistory row with the given size.
+	SelectCheckpointAt string
+	// InsertCheckpointHistory writes the size, note and published_at columns of a CheckpointHistory row, but
+	// must leave any existing row for the same size unchanged.
+	InsertCheckpointHistory string
+	// PruneCheckpointHistory deletes CheckpointHistory rows published before the given time, and with a size
+	// less than the given size.
+	PruneCheckpointHistory string
+	// CheckCheckpointHistory is any query which succeeds if the CheckpointHistory table is usable.
+	CheckCheckpointHistory string
+
+	// SelectTreeStateForUpdate selects the size and root of the TreeState row with the given id, and locks it
+	// for the rest of the transaction.
+	SelectTreeStateForUpdate string
+	// UpsertTreeState writes the id, size and root columns of a TreeState row.
+	UpsertTreeState string
+
+	// SelectSubtree selects the nodes of the Subtree row with the given level and index.
+	SelectSubtree string
+	// SelectSubtreesPrefix is the start of a query which selects the level, index and nodes of Subtree rows,
+	// which is completed with a parenthesised list of (level, index) pairs.
+	SelectSubtreesPrefix string
+	// UpsertSubtree writes the level, index and nodes columns of a Subtree row.
+	UpsertSubtree string
+
+	// SelectTiledLeaves selects the size and data of the TiledLeaves row with the given tile_index.
+	SelectTiledLeaves string
+	// UpsertTiledLeaves writes the tile_index, size and data columns of a TiledLeaves row.
+	UpsertTiledLeaves string
+}
+
+// Dialect describes how to use a particular SQL database to store a log.
+type Dialect interface {
+	// Statements returns the SQL statements used to access the database.
+	Statements() *Statements
+	// Placeholder returns the placeholder for the i'th (zero-based) argument in a statement.
+	Placeholder(i int) string
+	// CheckEntrySize returns an error if an entry with n bytes of bundle data cannot safely be added to the log.
+	CheckEntrySize(n int) error
+	// CheckBundleSize returns an error if an entry bundle of n bytes is too large to be written to the database.
+	CheckBundleSize(n int) error
+}
+
+// Storage is a SQL database based storage implementation for Tessera.
+type Storage struct {
+	db    *sql.DB
+	d     Dialect
+	stmts *Statements
+	queue *storage.Queue
+
+	newCheckpoint options.NewCPFunc
+	hasher        merkle.LogHasher
+	// integrationWorkers is the number of goroutines used to hash entries during integration.
+	integrationWorkers uint
+
+	cpUpdated chan struct{}
+
+	// publishPolicy decides whether a checkpoint should be published for the current tree.
+	publishPolicy storage.PublishPolicy
+
+	// checkpointHistory, if set, causes published checkpoints to be retained in the CheckpointHistory table
+	// for historyRetention, or indefinitely if that's zero.
+	checkpointHistory bool
+	historyRetention  time.Duration
+}
+
+// New creates a new instance of the SQL database based Storage, using the tables in db which are accessed
+// as described by d.
+func New(ctx context.Context, db *sql.DB, d Dialect, opt *options.StorageOptions) (*Storage, error) {
+	minInterval := storage.MinCheckpointInterval(opt, minCheckpointInterval)
+	if opt.CheckpointInterval < minInterval {
+		return nil, fmt.Errorf("requested CheckpointInterval too low - %v < %v", opt.CheckpointInterval, minInterval)
+	}
+	if opt.MetadataVerifierKeys != nil {
+		return nil, errors.New("tessera.WithLogMetadata is not supported by this storage implementation")
+	}
+
+	s := &Storage{
+		db:                 db,
+		d:                  d,
+		stmts:              d.Statements(),
+		newCheckpoint:      opt.NewCP,
+		hasher:             opt.Hasher,
+		integrationWorkers: opt.IntegrationWorkers,
+		cpUpdated:          make(chan struct{}, 1),
+
+		publishPolicy: storage.PublishPolicy{OnlyOnChange: opt.PublishOnlyOnChange, RepublishInterval: opt.CheckpointRepublishInterval},
+
+		checkpointHistory: opt.CheckpointHistory,
+		historyRetention:  opt.CheckpointHistoryRetention,
+	}
+	if err := s.db.Ping(); err != nil {
+		klog.Errorf("Failed to ping database: %v", err)
+		return nil, err
+	}
+	if s.newCheckpoint == nil {
+		return nil, errors.New("tessera.WithCheckpointSigner must be provided in New()")
+	}
+
+	if s.checkpointHistory {
+		// Check this up-front, as otherwise every attempt to publish a checkpoint would fail.
+		if err := s.db.QueryRowContext(ctx, s.stmts.CheckCheckpointHistory).Scan(new(int)); err != nil && err != sql.ErrNoRows {
+			return nil, fmt.Errorf("checkpoint history enabled, but the CheckpointHistory table is unusable (see schema.sql): %v", err)
+		}
+	}
+
+	s.queue = storage.NewQueue(ctx, opt.BatchMaxAge, opt.BatchMaxSize, opt.MaxConcurrentAdds, opt.EntryBundleCodec, opt.Hasher, s.sequenceBatch)
+
+	if err := s.maybeInitTree(ctx); err != nil {
+		return nil, fmt.Errorf("maybeInitTree: %v", err)
+	}
+	if opt.VerifyRootOnInit {
+		if err := s.verifyRoot(ctx, opt.VerifyRootFull); err != nil {
+			return nil, fmt.Errorf("failed to verify log integrity: %v", err)
+		}
+	}
+	// Publish a checkpoint now if there isn't one, rather than waiting for the first asynchronous
+	// publish, so that readers see a valid checkpoint as soon as the storage is available.
+	if _, err := s.ReadCheckpoint(ctx); errors.Is(err, os.ErrNotExist) {
+		if err := s.publishCheckpoint(ctx, 0); err != nil {
+			return nil, fmt.Errorf("failed to publish initial checkpoint: %v", err)
+		}
+	}
+
+	go func(ctx context.Context, j storage.Jitter) {
+		t := time.NewTimer(j.Next())
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-s.cpUpdated:
+			case <-t.C:
+				t.Reset(j.Next())
+			}
+			if err := s.publishCheckpoint(ctx, j.Min()); err != nil {
+				klog.Warningf("publishCheckpoint: %v", err)
+			}
+		}
+	}(ctx, storage.Jitter{Interval: opt.CheckpointInterval, Fraction: opt.CheckpointIntervalJitter, Floor: minInterval})
+	return s, nil
+}
+
+// maybeInitTree will insert an initial "empty tree" row into the
+// TreeState table iff no row already exists.
+//
+// This method doesn't also publish this new empty tree as a Checkpoint,
+// rather, New will publish one synchronously if none exists.
+func (s *Storage) maybeInitTree(ctx context.Context) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("being tx init tree state: %v", err)
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			klog.Errorf("Failed to rollback in write initial tree state: %v", err)
+		}
+	}()
+
+	treeState, err := s.readTreeState(ctx, tx)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		klog.Errorf("Failed to read tree state: %v", err)
+		return err
+	}
+	if treeState == nil {
+		klog.Infof("Initializing tree state")
+		if err := s.writeTreeState(ctx, tx, 0, s.hasher.EmptyRoot()); err != nil {
+			klog.Errorf("Failed to write initial tree state: %v", err)
+			return err
+		}
+		// Only need to commit if we've actually initialised the tree state, otherwise we'll
+		// rely on the defer'd rollback to tidy up.
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("commit init tree state: %v", err)
+		}
+	}
+	return nil
+}
+
+// verifyRoot checks that the root hash committed to by the published checkpoint is consistent with the
+// stored tiles.
+//
+// The TreeState row is locked for the duration, so that integration can't modify the tiles being read.
+func (s *Storage) verifyRoot(ctx context.Context, full bool) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			klog.Errorf("Failed to rollback in verifyRoot: %v", err)
+		}
+	}()
+
+	if _, err := s.readTreeState(ctx, tx); err != nil {
+		return fmt.Errorf("readTreeState: %v", err)
+	}
+	var cpRaw []byte
+	var at int64
+	if err := tx.QueryRowContext(ctx, s.stmts.SelectCheckpoint, checkpointID).Scan(&cpRaw, &at); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			// Nothing has been published yet, so there's nothing to verify.
+			return nil
+		}
+		return fmt.Errorf("scan checkpoint: %v", err)
+	}
+	return storage.VerifyCheckpoint(ctx, s.getTiles(tx), cpRaw, s.hasher, full)
+}
+
+// ReadCheckpoint returns the latest stored checkpoint.
+// If the checkpoint is not found, it returns os.ErrNotExist.
+func (s *Storage) ReadCheckpoint(ctx context.Context) ([]byte, error) {
+	row := s.db.QueryRowContext(ctx, s.stmts.SelectCheckpoint, checkpointID)
+	if err := row.Err(); err != nil {
+		return nil, err
+	}
+
+	var checkpoint []byte
+	var at int64
+	if err := row.Scan(&checkpoint, &at); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, os.ErrNotExist
+		}
+		return nil, fmt.Errorf("scan checkpoint: %v", err)
+	}
+	return checkpoint, nil
+}
+
+// ReadCheckpointAtSize returns the earliest retained checkpoint which commits to a tree of at least the
+// given size.
+// Since the oldest checkpoints are pruned first, if the earliest such checkpoint has been pruned, a later one
+// is returned. If no checkpoint committing to at least this size has been published yet, it returns os.ErrNotExist.
+// Checkpoint history must have been enabled with tessera.WithCheckpointHistory.
+func (s *Storage) ReadCheckpointAtSize(ctx context.Context, size uint64) ([]byte, error) {
+	return s.readCheckpointHistory(ctx, s.stmts.SelectCheckpointAtSize, size)
+}
+
+// ReadCheckpointAt returns the checkpoint which was published for a tree of exactly the given size.
+// If the tree size is republished, this is the first checkpoint published for it.
+// If no checkpoint has been published at this size, or it has been pruned, it returns os.ErrNotExist.
+// Checkpoint history must have been enabled with tessera.WithCheckpointHistory.
+func (s *Storage) ReadCheckpointAt(ctx context.Context, size uint64) ([]byte, error) {
+	return s.readCheckpointHistory(ctx, s.stmts.SelectCheckpointAt, size)
+}
+
+// readCheckpointHistory returns the checkpoint selected from the CheckpointHistory table by the given query.
+func (s *Storage) readCheckpointHistory(ctx context.Context, query string, size uint64) ([]byte, error) {
+	if !s.checkpointHistory {
+		return nil, fmt.Errorf("%w: checkpoint history is not enabled", errors.ErrUnsupported)
+	}
+	var checkpoint []byte
+	if err := s.db.QueryRowContext(ctx, query, size).Scan(&checkpoint); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, os.ErrNotExist
+		}
+		return nil, fmt.Errorf("scan checkpoint history: %v", err)
+	}
+	return checkpoint, nil
+}
+
+// publishCheckpoint creates a new checkpoint for the given size and root hash, and stores it in the
+// Checkpoint table.
+func (s *Storage) publishCheckpoint(ctx context.Context, interval time.Duration) error {
+	ctx, span := tracer.Start(ctx, "tessera.storage.sqlstore.publishCheckpoint")
+	defer span.End()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %v", err)
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			klog.Warningf("publishCheckpoint rollback failed: %v", err)
+		}
+	}()
+
+	var note []byte
+	var at int64
+	if err := tx.QueryRowContext(ctx, s.stmts.SelectCheckpointForUpdate, checkpointID).Scan(&note, &at); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("scan checkpoint: %v", err)
+	}
+	if at > 0 {
+		checkpointAge.Record(ctx, time.Since(time.UnixMilli(at)).Seconds())
+	}
+	if time.Since(time.UnixMilli(at)) < interval {
+		// Too soon, try again later.
+		klog.V(1).Info("skipping publish - too soon")
+		return nil
+	}
+
+	treeState, err := s.readTreeState(ctx, tx)
+	if err != nil {
+		return fmt.Errorf("readTreeState: %v", err)
+	}
+	size := treeState.size
+	if !s.publishPolicy.ShouldPublish(size, time.Since(time.UnixMilli(at))) {
+		return nil
+	}
+
+	rawCheckpoint, err := s.newCheckpoint(treeState.size, treeState.root)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UnixMilli()
+	if _, err := tx.ExecContext(ctx, s.stmts.UpsertCheckpoint, checkpointID, rawCheckpoint, now); err != nil {
+		return err
+	}
+	if s.checkpointHistory {
+		if _, err := tx.ExecContext(ctx, s.stmts.InsertCheckpointHistory, size, rawCheckpoint, now); err != nil {
+			return fmt.Errorf("insert checkpoint history: %v", err)
+		}
+		if s.historyRetention > 0 {
+			// The checkpoint for the current size is always kept, even if it was first published long ago.
+			if _, err := tx.ExecContext(ctx, s.stmts.PruneCheckpointHistory, now-s.historyRetention.Milliseconds(), size); err != nil {
+				return fmt.Errorf("prune checkpoint history: %v", err)
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.publishPolicy.Published(size)
+	return nil
+}
+
+type treeState struct {
+	size uint64
+	root []byte
+}
+
+// readTreeState returns the currently stored tree state information, and locks the TreeState row for the
+// rest of the transaction.
+// If there is no stored tree state, it returns os.ErrNotExist.
+func (s *Storage) readTreeState(ctx context.Context, tx *sql.Tx) (*treeState, error) {
+	row := tx.QueryRowContext(ctx, s.stmts.SelectTreeStateForUpdate, treeStateID)
+	if err := row.Err(); err != nil {
+		return nil, err
+	}
+
+	r := &treeState{}
+	if err := row.Scan(&r.size, &r.root); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, os.ErrNotExist
+		}
+		return nil, fmt.Errorf("scan tree state: %v", err)
+	}
+	return r, nil
+}
+
+// writeTreeState updates the TreeState table with the new tree state information.
+func (s *Storage) writeTreeState(ctx context.Context, tx *sql.Tx, size uint64, rootHash []byte) error {
+	if _, err := tx.ExecContext(ctx, s.stmts.UpsertTreeState, treeStateID, size, rootHash); err != nil {
+		klog.Errorf("Failed to execute UpsertTreeState: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// ReadTile returns a full tile or a partial tile at the given level, index and treeSize.
+// If the tile is not found, it returns os.ErrNotExist.
+//
+// Note that if a partial tile is requested, but a larger tile is available, this
+// will return the largest tile available. This could be trimmed to return only the
+// number of entries specifically requested if this behaviour becomes problematic.
+func (s *Storage) ReadTile(ctx context.Context, level, index uint64, p uint8) ([]byte, error) {
+	row := s.db.QueryRowContext(ctx, s.stmts.SelectSubtree, level, index)
+	if err := row.Err(); err != nil {
+		return nil, err
+	}
+
+	var tile []byte
+	if err := row.Scan(&tile); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, os.ErrNotExist
+		}
+
+		return nil, fmt.Errorf("scan tile: %v", err)
+	}
+
+	numEntries := uint64(len(tile) / sha256.Size)
+	requestedEntries := uint64(p)
+	if requestedEntries == 0 {
+		requestedEntries = layout.TileWidth
+	}
+	if requestedEntries > numEntries {
+		// If the user has requested a size larger than we have, they can't have it
+		return nil, os.ErrNotExist
+	}
+
+	return tile, nil
+}
+
+// getTiles returns a function which reads the requested tiles within the provided transaction.
+//
+// The returned tiles are in the same order as the requested tileIDs, with nil for any which were not found.
+func (s *Storage) getTiles(tx *sql.Tx) func(ctx context.Context, tileIDs []storage.TileID, treeSize uint64) ([]*api.HashTile, error) {
+	return func(ctx context.Context, tileIDs []storage.TileID, treeSize uint64) ([]*api.HashTile, error) {
+		hashTiles := make([]*api.HashTile, len(tileIDs))
+		if len(tileIDs) == 0 {
+			return hashTiles, nil
+		}
+
+		// Build the SQL and args to fetch the hash tiles.
+		var sql strings.Builder
+		args := make([]any, 0, len(tileIDs)*2)
+		sql.WriteString(s.stmts.SelectSubtreesPrefix)
+		sql.WriteString("(")
+		for i, id := range tileIDs {
+			if i != 0 {
+				sql.WriteString(", ")
+			}
+			fmt.Fprintf(&sql, "(%s, %s)", s.d.Placeholder(2*i), s.d.Placeholder(2*i+1))
+			args = append(args, id.Level, id.Index)
+		}
+		sql.WriteString(")")
+
+		rows, err := tx.QueryContext(ctx, sql.String(), args...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query the hash tiles with SQL (%s): %w", sql.String(), err)
+		}
+		defer func() {
+			if err := rows.Close(); err != nil {
+				klog.Warningf("Failed to close the rows: %v", err)
+			}
+		}()
+
+		// Rows are not returned in any particular order, so match them up with the requested IDs.
+		found := make(map[storage.TileID][]byte, len(tileIDs))
+		for rows.Next() {
+			var id storage.TileID
+			var tile []byte
+			if err := rows.Scan(&id.Level, &id.Index, &tile); err != nil {
+				return nil, fmt.Errorf("scan subtree tile: %w", err)
+			}
+			found[id] = tile
+		}
+		if err = rows.Err(); err != nil {
+			return nil, fmt.Errorf("rows error while fetching subtrees: %w", err)
+		}
+
+		for i, id := range tileIDs {
+			tile, ok := found[id]
+			if !ok {
+				continue
+			}
+			t := &api.HashTile{}
+			if err := t.UnmarshalText(tile); err != nil {
+				return nil, fmt.Errorf("unmarshal tile: %w", err)
+			}
+			hashTiles[i] = t
+		}
+
+		return hashTiles, nil
+	}
+}
+
+// writeTile replaces the tile nodes at the given level and index.
+func (s *Storage) writeTile(ctx context.Context, tx *sql.Tx, level, index uint64, nodes []byte) error {
+	if _, err := tx.ExecContext(ctx, s.stmts.UpsertSubtree, level, index, nodes); err != nil {
+		klog.Errorf("Failed to execute UpsertSubtree: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// ReadEntryBundle returns the log entries at the given index.
+// If the entry bundle is not found, it returns os.ErrNotExist.
+//
+// Note that if a partial tile is requested, but a larger tile is available, this
+// will return the largest tile available. This could be trimmed to return only the
+// number of entries specifically requested if this behaviour becomes problematic.
+func (s *Storage) ReadEntryBundle(ctx context.Context, index uint64, p uint8) ([]byte, error) {
+	row := s.db.QueryRowContext(ctx, s.stmts.SelectTiledLeaves, index)
+	if err := row.Err(); err != nil {
+		return nil, err
+	}
+
+	var size uint32
+	var entryBundle []byte
+	if err := row.Scan(&size, &entryBundle); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, os.ErrNotExist
+		}
+		return nil, fmt.Errorf("scan entry bundle: %v", err)
+	}
+
+	requestedSize := uint32(p)
+	if requestedSize == 0 {
+		requestedSize = layout.EntryBundleWidth
+	}
+
+	if requestedSize > size {
+		return nil, fmt.Errorf("bundle with %d entries requested, but only %d available: %w", requestedSize, size, os.ErrNotExist)
+	}
+
+	return entryBundle, nil
+}
+
+func (s *Storage) writeEntryBundle(ctx context.Context, tx *sql.Tx, index uint64, size uint32, entryBundle []byte) error {
+	if err := s.d.CheckBundleSize(len(entryBundle)); err != nil {
+		return fmt.Errorf("entry bundle %d: %w", index, err)
+	}
+	if _, err := tx.ExecContext(ctx, s.stmts.UpsertTiledLeaves, index, size, entryBundle); err != nil {
+		klog.Errorf("Failed to execute UpsertTiledLeaves: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// Add is the entrypoint for adding entries to a sequencing log.
+func (s *Storage) Add(ctx context.Context, entry *tessera.Entry) tessera.IndexFuture {
+	ctx, span := tracer.Start(ctx, "tessera.storage.sqlstore.Add")
+	defer span.End()
+
+	// Reject entries which could never be written, rather than having them fail the whole batch later on.
+	// Note that the sequence number doesn't affect the size of the bundle data with the default serialisation.
+	if err := s.d.CheckEntrySize(len(entry.MarshalBundleData(0))); err != nil {
+		return func() (uint64, error) { return 0, fmt.Errorf("entry too large: %w", err) }
+	}
+	return s.queue.Add(ctx, entry)
+}
+
+// AddBatch adds all of the provided entries to the log, returning one future per entry in the same order.
+//
+// The entries are guaranteed to be assigned a contiguous range of indices, in the order provided.
+// Batches with more entries than can be queued together are rejected with tessera.ErrBatchTooLarge.
+// If any of the entries is too large to be written, none of them are added.
+func (s *Storage) AddBatch(ctx context.Context, entries []*tessera.Entry) []tessera.IndexFuture {
+	ctx, span := tracer.Start(ctx, "tessera.storage.sqlstore.AddBatch")
+	defer span.End()
+
+	for i, e := range entries {
+		if err := s.d.CheckEntrySize(len(e.MarshalBundleData(0))); err != nil {
+			err := fmt.Errorf("entry %d too large: %w", i, err)
+			fs := make([]tessera.IndexFuture, len(entries))
+			for j := range fs {
+				fs[j] = func() (uint64, error) { return 0, err }
+			}
+			return fs
+		}
+	}
+	return s.queue.AddBatch(ctx, entries)
+}
+
+// sequenceBatch writes the entries from the provided batch into the entry bundle files of the log.
+//
+// This func starts filling entries bundles at the next available slot in the log, ensuring that the
+// sequenced entries are contiguous from the zeroth entry (i.e left-hand dense).
+// We try to minimise the number of partially complete entry bundles by writing entries in chunks rather
+// than one-by-one.
+//
+// TODO(#21): Separate sequencing and integration for better performance.
+func (s *Storage) sequenceBatch(ctx context.Context, entries []*tessera.Entry) error {
+	ctx, span := tracer.Start(ctx, "tessera.storage.sqlstore.sequenceBatch")
+	defer span.End()
+	span.SetAttributes(batchSizeKey.Int(len(entries)))
+
+	// Return when there is no entry to sequence.
+	if len(entries) == 0 {
+		return nil
+	}
+
+	// Get a Tx for making transaction requests.
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %v", err)
+	}
+	// Defer a rollback in case anything fails.
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			klog.Errorf("Failed to rollback in sequenceBatch: %v", err)
+		}
+	}()
+
+	// Get tree size. Note that "SELECT ... FOR UPDATE" is used for row-level locking.
+	// A shared lock is not sufficient here: concurrent frontends would each take one and then deadlock
+	// when attempting to update the row, causing the whole batch to fail.
+	state, err := s.readTreeState(ctx, tx)
+	if err != nil {
+		return fmt.Errorf("failed to read tree state: %w", err)
+	}
+
+	// Integrate the new entries into the entry bundle (TiledLeaves table) and tile (Subtree table).
+	if err := s.integrate(ctx, tx, state.size, entries); err != nil {
+		return fmt.Errorf("failed to integrate: %w", err)
+	}
+
+	// Commit the transaction.
+	err = tx.Commit()
+
+	select {
+	case s.cpUpdated <- struct{}{}:
+	default:
+	}
+
+	return err
+}
+
+// integrate incorporates the provided entries into the log starting at fromSeq.
+func (s *Storage) integrate(ctx context.Context, tx *sql.Tx, fromSeq uint64, entries []*tessera.Entry) error {
+	ctx, span := tracer.Start(ctx, "tessera.storage.sqlstore.integrate")
+	defer span.End()
+	span.SetAttributes(fromSeqKey.Int64(int64(fromSeq)), batchSizeKey.Int(len(entries)))
+
+	sequencedEntries := make([]storage.SequencedEntry, len(entries))
+	// Assign provisional sequence numbers to entries.
+	// We need to do this here in order to support serialisations which include the log position.
+	for i, e := range entries {
+		sequencedEntries[i] = storage.SequencedEntry{
+			BundleData: e.MarshalBundleData(fromSeq + uint64(i)),
+			LeafHash:   e.LeafHash(),
+		}
+	}
+
+	// Add sequenced entries to entry bundles.
+	bundleIndex, entriesInBundle := fromSeq/layout.EntryBundleWidth, fromSeq%layout.EntryBundleWidth
+	bundleWriter := &bytes.Buffer{}
+
+	// If the latest bundle is partial, we need to read the data it contains in for our newer, larger, bundle.
+	if entriesInBundle > 0 {
+		row := tx.QueryRowContext(ctx, s.stmts.SelectTiledLeaves, bundleIndex)
+		if err := row.Err(); err != nil {
+			return fmt.Errorf("query tiled leaves: %v", err)
+		}
+
+		var size uint32
+		var partialEntryBundle []byte
+		if err := row.Scan(&size, &partialEntryBundle); err != nil {
+			return fmt.Errorf("scan partial entry bundle: %w", err)
+		}
+		if size != uint32(entriesInBundle) {
+			return fmt.Errorf("expected %d entries in storage but found %d", entriesInBundle, size)
+		}
+
+		if _, err := bundleWriter.Write(partialEntryBundle); err != nil {
+			return fmt.Errorf("write partial entry bundle: %w", err)
+		}
+	}
+
+	// Add new entries to the bundle.
+	for _, e := range sequencedEntries {
+		if _, err := bundleWriter.Write(e.BundleData); err != nil {
+			return fmt.Errorf("write bundle data: %w", err)
+		}
+		entriesInBundle++
+
+		// This bundle is full, so we need to write it out.
+		if entriesInBundle == layout.EntryBundleWidth {
+			if err := s.writeEntryBundle(ctx, tx, bundleIndex, uint32(entriesInBundle), bundleWriter.Bytes()); err != nil {
+				return fmt.Errorf("writeEntryBundle: %w", err)
+			}
+
+			// Prepare the next entry bundle for any remaining entries in the batch.
+			bundleIndex++
+			entriesInBundle = 0
+			bundleWriter = &bytes.Buffer{}
+		}
+	}
+
+	// If we have a partial bundle remaining once we've added all the entries from the batch,
+	// this needs writing out too.
+	if entriesInBundle > 0 {
+		if err := s.writeEntryBundle(ctx, tx, bundleIndex, uint32(entriesInBundle), bundleWriter.Bytes()); err != nil {
+			return fmt.Errorf("writeEntryBundle: %w", err)
+		}
+	}
+
+	newSize, newRoot, tiles, err := storage.Integrate(ctx, s.getTiles(tx), fromSeq, sequencedEntries, s.hasher, s.integrationWorkers)
+	if err != nil {
+		return fmt.Errorf("tb.Integrate: %v", err)
+	}
+	for k, v := range tiles {
+		nodes, err := v.MarshalText()
+		if err != nil {
+			return err
+		}
+
+		if err := s.writeTile(ctx, tx, uint64(k.Level), k.Index, nodes); err != nil {
+			return fmt.Errorf("failed to set tile(%v): %w", k, err)
+		}
+	}
+
+	// Write new tree state.
+	if err := s.writeTreeState(ctx, tx, newSize, newRoot); err != nil {
+		return fmt.Errorf("writeCheckpoint: %w", err)
+	}
+	return nil
+}
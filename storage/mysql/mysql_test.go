@@ -29,9 +29,12 @@ import (
 	"fmt"
 	"io/fs"
 	"os"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/transparency-dev/formats/log"
+	"github.com/transparency-dev/merkle/compact"
 	"github.com/transparency-dev/merkle/rfc6962"
 	tessera "github.com/transparency-dev/trillian-tessera"
 	"github.com/transparency-dev/trillian-tessera/api"
@@ -103,7 +106,7 @@ func TestMain(m *testing.M) {
 // `multiStatements=true` in the data source name allows multiple statements in one query.
 // This is not being used in the actual MySQL storage implementation.
 func initDatabaseSchema(ctx context.Context) {
-	dropTablesSQL := "DROP TABLE IF EXISTS `Checkpoint`, `Subtree`, `TiledLeaves`, `TreeState`"
+	dropTablesSQL := "DROP TABLE IF EXISTS `Checkpoint`, `CheckpointHistory`, `Subtree`, `TiledLeaves`, `TreeState`"
 
 	rawSchema, err := os.ReadFile("schema.sql")
 	if err != nil {
@@ -156,6 +159,14 @@ func TestNew(t *testing.T) {
 				tessera.WithPushback(10),
 			},
 		},
+		{
+			name: "unsupported tessera.WithLogMetadata",
+			opts: []func(*options.StorageOptions){
+				tessera.WithCheckpointSigner(noteSigner),
+				tessera.WithLogMetadata(testPublicKey),
+			},
+			wantErr: true,
+		},
 	} {
 		t.Run(test.name, func(t *testing.T) {
 			_, err := mysql.New(ctx, testDB, test.opts...)
@@ -167,6 +178,115 @@ func TestNew(t *testing.T) {
 	}
 }
 
+func TestReadCheckpointAfterNew(t *testing.T) {
+	ctx := context.Background()
+	s := newTestMySQLStorage(t, ctx)
+
+	// The checkpoint for the empty tree should be available immediately.
+	if _, err := s.ReadCheckpoint(ctx); err != nil {
+		t.Errorf("ReadCheckpoint: %v", err)
+	}
+}
+
+func TestReadCheckpointAtSize(t *testing.T) {
+	ctx := context.Background()
+	s := newTestMySQLStorage(t, ctx, tessera.WithCheckpointHistory(0))
+
+	// Ensure that the storage can be used to serve historical checkpoints.
+	var _ tessera.CheckpointHistoryReader = s
+
+	cp0, err := s.ReadCheckpointAtSize(ctx, 0)
+	if err != nil {
+		t.Fatalf("ReadCheckpointAtSize(0): %v", err)
+	}
+	if _, err := s.ReadCheckpointAtSize(ctx, 1); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("ReadCheckpointAtSize(1) before integration: got %v, want os.ErrNotExist", err)
+	}
+
+	awaiter := tessera.NewIntegrationAwaiter(ctx, s.ReadCheckpoint, 10*time.Millisecond)
+	if _, _, err := awaiter.Await(ctx, s.Add(ctx, tessera.NewEntry([]byte("TestReadCheckpointAtSize")))); err != nil {
+		t.Fatalf("Await: %v", err)
+	}
+
+	cp1, err := s.ReadCheckpointAtSize(ctx, 1)
+	if err != nil {
+		t.Fatalf("ReadCheckpointAtSize(1): %v", err)
+	}
+	if latest, err := s.ReadCheckpoint(ctx); err != nil {
+		t.Fatalf("ReadCheckpoint: %v", err)
+	} else if !bytes.Equal(cp1, latest) {
+		t.Errorf("ReadCheckpointAtSize(1) = %q, want latest checkpoint %q", cp1, latest)
+	}
+	if got, err := s.ReadCheckpointAtSize(ctx, 0); err != nil {
+		t.Fatalf("ReadCheckpointAtSize(0): %v", err)
+	} else if !bytes.Equal(got, cp0) {
+		t.Errorf("ReadCheckpointAtSize(0) = %q, want %q", got, cp0)
+	}
+}
+
+func TestReadCheckpointAt(t *testing.T) {
+	ctx := context.Background()
+	s := newTestMySQLStorage(t, ctx, tessera.WithCheckpointHistory(0))
+
+	cp0, err := s.ReadCheckpointAt(ctx, 0)
+	if err != nil {
+		t.Fatalf("ReadCheckpointAt(0): %v", err)
+	}
+
+	awaiter := tessera.NewIntegrationAwaiter(ctx, s.ReadCheckpoint, 10*time.Millisecond)
+	if _, _, err := awaiter.Await(ctx, s.Add(ctx, tessera.NewEntry([]byte("TestReadCheckpointAt")))); err != nil {
+		t.Fatalf("Await: %v", err)
+	}
+
+	if got, err := s.ReadCheckpointAt(ctx, 0); err != nil {
+		t.Fatalf("ReadCheckpointAt(0): %v", err)
+	} else if !bytes.Equal(got, cp0) {
+		t.Errorf("ReadCheckpointAt(0) = %q, want %q", got, cp0)
+	}
+	if got, err := s.ReadCheckpointAt(ctx, 1); err != nil {
+		t.Fatalf("ReadCheckpointAt(1): %v", err)
+	} else if latest, err := s.ReadCheckpoint(ctx); err != nil {
+		t.Fatalf("ReadCheckpoint: %v", err)
+	} else if !bytes.Equal(got, latest) {
+		t.Errorf("ReadCheckpointAt(1) = %q, want latest checkpoint %q", got, latest)
+	}
+	if _, err := s.ReadCheckpointAt(ctx, 2); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("ReadCheckpointAt(2): got %v, want os.ErrNotExist", err)
+	}
+}
+
+func TestCheckpointHistoryDisabled(t *testing.T) {
+	ctx := context.Background()
+	s := newTestMySQLStorage(t, ctx)
+
+	if _, err := s.ReadCheckpointAt(ctx, 0); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("ReadCheckpointAt(0): got %v, want errors.ErrUnsupported", err)
+	}
+	if _, err := s.ReadCheckpointAtSize(ctx, 0); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("ReadCheckpointAtSize(0): got %v, want errors.ErrUnsupported", err)
+	}
+}
+
+func TestCheckpointHistoryRetention(t *testing.T) {
+	ctx := context.Background()
+	s := newTestMySQLStorage(t, ctx, tessera.WithCheckpointHistory(time.Millisecond))
+
+	if _, err := s.ReadCheckpointAt(ctx, 0); err != nil {
+		t.Fatalf("ReadCheckpointAt(0): %v", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	awaiter := tessera.NewIntegrationAwaiter(ctx, s.ReadCheckpoint, 10*time.Millisecond)
+	if _, _, err := awaiter.Await(ctx, s.Add(ctx, tessera.NewEntry([]byte("TestCheckpointHistoryRetention")))); err != nil {
+		t.Fatalf("Await: %v", err)
+	}
+
+	// Publishing the checkpoint at size 1 should have pruned the expired one at size 0.
+	if _, err := s.ReadCheckpointAt(ctx, 0); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("ReadCheckpointAt(0): got %v, want os.ErrNotExist", err)
+	}
+}
+
 func TestGetTile(t *testing.T) {
 	ctx := context.Background()
 	s := newTestMySQLStorage(t, ctx)
@@ -361,6 +481,154 @@ func TestParallelAdd(t *testing.T) {
 	}
 }
 
+// TestConcurrentFrontends runs several Storage instances, standing in for frontends, against the same
+// database, and checks that the entries they add concurrently are sequenced and integrated into a single
+// coherent log, with a single consistent progression of published checkpoints.
+func TestConcurrentFrontends(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const (
+		numFrontends = 3
+		numEntries   = 200
+		total        = numFrontends * numEntries
+	)
+	frontends := []*mysql.Storage{newTestMySQLStorage(t, ctx)}
+	for len(frontends) < numFrontends {
+		s, err := mysql.New(ctx, testDB,
+			tessera.WithCheckpointSigner(noteSigner),
+			tessera.WithCheckpointInterval(time.Second),
+			tessera.WithBatching(128, 100*time.Millisecond))
+		if err != nil {
+			t.Fatalf("Failed to create mysql.Storage: %v", err)
+		}
+		frontends = append(frontends, s)
+	}
+	verifier, err := note.NewVerifier(testPublicKey)
+	if err != nil {
+		t.Fatalf("NewVerifier: %v", err)
+	}
+
+	// Record each distinct checkpoint published.
+	var (
+		cpMu sync.Mutex
+		cps  []log.Checkpoint
+	)
+	watchDone := make(chan struct{})
+	go func() {
+		defer close(watchDone)
+		var last []byte
+		for ctx.Err() == nil {
+			raw, err := frontends[0].ReadCheckpoint(ctx)
+			if err == nil && !bytes.Equal(raw, last) {
+				cp, _, _, err := log.ParseCheckpoint(raw, verifier.Name(), verifier)
+				if err != nil {
+					t.Errorf("ParseCheckpoint: %v", err)
+					return
+				}
+				cpMu.Lock()
+				cps = append(cps, *cp)
+				cpMu.Unlock()
+				last = raw
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+
+	// Add entries concurrently via all of the frontends.
+	var (
+		addMu   sync.Mutex
+		byIndex = make(map[uint64][]byte)
+	)
+	eG := errgroup.Group{}
+	for f, s := range frontends {
+		for i := 0; i < numEntries; i++ {
+			eG.Go(func() error {
+				data := []byte(fmt.Sprintf("frontend %d entry %d", f, i))
+				idx, err := s.Add(ctx, tessera.NewEntry(data))()
+				if err != nil {
+					return err
+				}
+				addMu.Lock()
+				defer addMu.Unlock()
+				if _, ok := byIndex[idx]; ok {
+					return fmt.Errorf("index %d assigned more than once", idx)
+				}
+				byIndex[idx] = data
+				return nil
+			})
+		}
+	}
+	if err := eG.Wait(); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	// Wait for a checkpoint covering all of the entries to be published.
+	for deadline := time.Now().Add(30 * time.Second); ; {
+		cpMu.Lock()
+		done := len(cps) > 0 && cps[len(cps)-1].Size == total
+		cpMu.Unlock()
+		if done {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for checkpoint of size %d", total)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-watchDone
+
+	// There must be no gaps in the assigned indices, and each entry must be stored at its index.
+	leafHashes := make([][]byte, total)
+	for i := uint64(0); i < total; i++ {
+		data, ok := byIndex[i]
+		if !ok {
+			t.Fatalf("index %d was not assigned", i)
+		}
+		leafHashes[i] = rfc6962.DefaultHasher.HashLeaf(data)
+		if i%layout.EntryBundleWidth == 0 {
+			raw, err := frontends[0].ReadEntryBundle(context.Background(), i/layout.EntryBundleWidth, layout.PartialTileSize(0, i/layout.EntryBundleWidth, total))
+			if err != nil {
+				t.Fatalf("ReadEntryBundle(%d): %v", i/layout.EntryBundleWidth, err)
+			}
+			eb := api.EntryBundle{}
+			if err := eb.UnmarshalText(raw); err != nil {
+				t.Fatalf("UnmarshalText: %v", err)
+			}
+			for j, got := range eb.Entries {
+				if want := byIndex[i+uint64(j)]; !bytes.Equal(got, want) {
+					t.Errorf("entry %d = %q, want %q", i+uint64(j), got, want)
+				}
+			}
+		}
+	}
+
+	// The published checkpoints must never go backwards, and each must commit to the expected tree.
+	rf := &compact.RangeFactory{Hash: rfc6962.DefaultHasher.HashChildren}
+	cr := rf.NewEmptyRange(0)
+	for i, cp := range cps {
+		if i > 0 && cp.Size < cps[i-1].Size {
+			t.Errorf("checkpoint size went backwards from %d to %d", cps[i-1].Size, cp.Size)
+			continue
+		}
+		for cr.End() < cp.Size {
+			if err := cr.Append(leafHashes[cr.End()], nil); err != nil {
+				t.Fatalf("Append: %v", err)
+			}
+		}
+		want := rfc6962.DefaultHasher.EmptyRoot()
+		if cp.Size > 0 {
+			if want, err = cr.GetRootHash(nil); err != nil {
+				t.Fatalf("GetRootHash: %v", err)
+			}
+		}
+		if !bytes.Equal(cp.Hash, want) {
+			t.Errorf("checkpoint for size %d has root %x, want %x", cp.Size, cp.Hash, want)
+		}
+	}
+}
+
 func TestTileRoundTrip(t *testing.T) {
 	ctx := context.Background()
 	s := newTestMySQLStorage(t, ctx)
@@ -459,14 +727,95 @@ func TestEntryBundleRoundTrip(t *testing.T) {
 	}
 }
 
-func newTestMySQLStorage(t *testing.T, ctx context.Context) *mysql.Storage {
+// TestEntrySizeLimit checks that entries are limited to their share of max_allowed_packet, so that a full
+// entry bundle can always be written.
+func TestEntrySizeLimit(t *testing.T) {
+	ctx := context.Background()
+
+	// A max_allowed_packet of 1MiB limits entries to (1<<20 - 1024) / 256 = 4092 bytes of bundle data, which
+	// is 4090 bytes of entry data once the length prefix is added.
+	const maxAllowedPacket, maxEntryData = 1 << 20, 4090
+	var orig uint64
+	if err := testDB.QueryRowContext(ctx, "SELECT @@GLOBAL.max_allowed_packet").Scan(&orig); err != nil {
+		t.Fatalf("Failed to read max_allowed_packet: %v", err)
+	}
+	if _, err := testDB.ExecContext(ctx, fmt.Sprintf("SET GLOBAL max_allowed_packet = %d", maxAllowedPacket)); err != nil {
+		t.Fatalf("Failed to set max_allowed_packet: %v", err)
+	}
+	t.Cleanup(func() {
+		if _, err := testDB.ExecContext(context.Background(), fmt.Sprintf("SET GLOBAL max_allowed_packet = %d", orig)); err != nil {
+			t.Errorf("Failed to restore max_allowed_packet: %v", err)
+		}
+	})
+	// The global setting only applies to new sessions, so use a new connection pool.
+	db, err := sql.Open("mysql", *mysqlURI)
+	if err != nil {
+		t.Fatalf("Failed to open MySQL test db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("Failed to close MySQL test db: %v", err)
+		}
+	}()
+	initDatabaseSchema(ctx)
+	s, err := mysql.New(ctx, db,
+		tessera.WithCheckpointSigner(noteSigner),
+		tessera.WithCheckpointInterval(time.Second),
+		tessera.WithBatching(128, 100*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Failed to create mysql.Storage: %v", err)
+	}
+
+	tooLarge := tessera.NewEntry(make([]byte, maxEntryData+1))
+	if _, err := s.Add(ctx, tooLarge)(); err == nil {
+		t.Error("Add succeeded with oversize entry, want error")
+	}
+	for i, f := range s.AddBatch(ctx, []*tessera.Entry{tessera.NewEntry([]byte("small")), tooLarge}) {
+		if _, err := f(); err == nil {
+			t.Errorf("AddBatch future %d succeeded for batch containing oversize entry, want error", i)
+		}
+	}
+
+	// Fill the first entry bundle with entries of the largest permitted size. These are added in two
+	// batches, so the second batch must grow the partial bundle to its full size.
+	const half = layout.EntryBundleWidth / 2
+	for start := 0; start < layout.EntryBundleWidth; start += half {
+		entries := make([]*tessera.Entry, half)
+		for i := range entries {
+			entries[i] = tessera.NewEntry(bytes.Repeat([]byte{byte(start + i)}, maxEntryData))
+		}
+		for i, f := range s.AddBatch(ctx, entries) {
+			idx, err := f()
+			if err != nil {
+				t.Fatalf("AddBatch future %d: %v", start+i, err)
+			}
+			if want := uint64(start + i); idx != want {
+				t.Errorf("AddBatch future %d got index %d, want %d", start+i, idx, want)
+			}
+		}
+	}
+	raw, err := s.ReadEntryBundle(ctx, 0, 0)
+	if err != nil {
+		t.Fatalf("ReadEntryBundle: %v", err)
+	}
+	bundle := api.EntryBundle{}
+	if err := bundle.UnmarshalText(raw); err != nil {
+		t.Fatalf("Failed to parse entry bundle: %v", err)
+	}
+	if got, want := len(bundle.Entries), layout.EntryBundleWidth; got != want {
+		t.Errorf("Got %d entries in bundle, want %d", got, want)
+	}
+}
+
+func newTestMySQLStorage(t *testing.T, ctx context.Context, opts ...func(*options.StorageOptions)) *mysql.Storage {
 	t.Helper()
 	initDatabaseSchema(ctx)
 
-	s, err := mysql.New(ctx, testDB,
+	s, err := mysql.New(ctx, testDB, append([]func(*options.StorageOptions){
 		tessera.WithCheckpointSigner(noteSigner),
 		tessera.WithCheckpointInterval(time.Second),
-		tessera.WithBatching(128, 100*time.Millisecond))
+		tessera.WithBatching(128, 100*time.Millisecond),
+	}, opts...)...)
 	if err != nil {
 		t.Fatalf("Failed to create mysql.Storage: %v", err)
 	}
@@ -27,6 +27,21 @@ CREATE TABLE IF NOT EXISTS `Checkpoint` (
   PRIMARY KEY(`id`)
 );
 
+-- "CheckpointHistory" table stores checkpoints published by the log, keyed by tree size.
+-- It's only used if the storage is created with the tessera.WithCheckpointHistory option.
+-- Only the first checkpoint published for each tree size is retained, and rows older than the configured
+-- retention period are deleted as new checkpoints are published.
+CREATE TABLE IF NOT EXISTS `CheckpointHistory` (
+  -- size is the size of the tree committed to by the checkpoint.
+  `size`  BIGINT UNSIGNED NOT NULL,
+  -- note is the text signed by one or more keys in the checkpoint format. See https://c2sp.org/tlog-checkpoint and https://c2sp.org/signed-note.
+  `note`  MEDIUMBLOB NOT NULL,
+  -- published_at is the millisecond UNIX timestamp of when this row was written.
+  `published_at` BIGINT NOT NULL,
+  PRIMARY KEY(`size`),
+  INDEX(`published_at`)
+);
+
 -- "TreeState" table stores the current state of the integrated tree.
 -- This is not the same thing as a Checkpoint, which is a signed commitment to such a state.
 CREATE TABLE IF NOT EXISTS `TreeState` (
@@ -26,7 +26,7 @@ import (
 	"syscall"
 	"time"
 
-	"github.com/transparency-dev/merkle/rfc6962"
+	"github.com/transparency-dev/merkle"
 	tessera "github.com/transparency-dev/trillian-tessera"
 	"github.com/transparency-dev/trillian-tessera/api"
 	"github.com/transparency-dev/trillian-tessera/api/layout"
@@ -36,9 +36,14 @@ import (
 )
 
 const (
-	dirPerm  = 0o755
-	filePerm = 0o644
-	stateDir = ".state"
+	defaultDirPerm  = 0o755
+	defaultFilePerm = 0o644
+	stateDir        = ".state"
+
+	// treeStateLock is the name of the lock file which serialises updates to the tree state.
+	treeStateLock = "treeState.lock"
+	// publishLock is the name of the lock file which serialises publication of checkpoints.
+	publishLock = "publish.lock"
 
 	minCheckpointInterval = time.Second
 )
@@ -56,33 +61,128 @@ type Storage struct {
 	cpUpdated chan struct{}
 
 	entriesPath options.EntriesPathFunc
+	hasher      merkle.LogHasher
+	// dirPerm and filePerm are the modes with which directories and files are created.
+	dirPerm  os.FileMode
+	filePerm os.FileMode
+	// fsync, if set, causes written files and the directories containing them to be synced to disk.
+	fsync bool
+	// integrationWorkers is the number of goroutines used to hash entries during integration.
+	integrationWorkers uint
+
+	// publishPolicy decides whether a checkpoint should be published for the current tree.
+	publishPolicy storage.PublishPolicy
 }
 
 // NewTreeFunc is the signature of a function which receives information about newly integrated trees.
 type NewTreeFunc func(size uint64, root []byte) error
 
+// WithPermissions configures the modes with which the POSIX storage creates directories and files,
+// including the lock files in the .state directory. This is useful when the log is served by a process
+// running as a different user to the one which appends to it.
+//
+// As with os.MkdirAll and os.WriteFile, the modes are subject to the process's umask, so e.g. a
+// group-writable fileMode will only take effect if the umask permits it.
+//
+// The modes must contain only permission bits, and must allow the owner to read and write files, and
+// to read, write, and search directories, otherwise New returns an error.
+// If this option isn't provided, directories are created with 0o755 and files with 0o644.
+func WithPermissions(dirMode, fileMode os.FileMode) func(*options.StorageOptions) {
+	return func(o *options.StorageOptions) {
+		o.Permissions = true
+		o.DirPerm = dirMode
+		o.FilePerm = fileMode
+	}
+}
+
+// validatePermissions returns an error if dirMode and fileMode aren't suitable for use with WithPermissions.
+func validatePermissions(dirMode, fileMode os.FileMode) error {
+	if dirMode&^os.ModePerm != 0 || dirMode&0o700 != 0o700 {
+		return fmt.Errorf("invalid dirMode %#o, must be permission bits including 0o700", dirMode)
+	}
+	if fileMode&^os.ModePerm != 0 || fileMode&0o600 != 0o600 {
+		return fmt.Errorf("invalid fileMode %#o, must be permission bits including 0o600", fileMode)
+	}
+	return nil
+}
+
+// WithFsync configures whether the POSIX storage fsyncs each file it writes, along with the directory
+// it's written into, before the write is considered complete. This includes syncing the directory after
+// the checkpoint is atomically renamed into place, so that a published checkpoint never refers to tiles or
+// entry bundles which could be lost on power failure.
+//
+// This is off by default, in which case durability depends on the filesystem and OS flushing data in
+// the background. Enabling it substantially reduces write throughput, since every entry bundle, tile, and
+// state file written requires at least two synchronous round trips to the underlying device; the impact is
+// largest on spinning disks and network filesystems, and can be mitigated by using larger batches.
+func WithFsync(enabled bool) func(*options.StorageOptions) {
+	return func(o *options.StorageOptions) {
+		o.Fsync = enabled
+	}
+}
+
 // New creates a new POSIX storage.
 // - path is a directory in which the log should be stored
 // - create must only be set when first creating the log, and will create the directory structure and an empty checkpoint
 func New(ctx context.Context, path string, create bool, opts ...func(*options.StorageOptions)) (*Storage, error) {
-	opt := storage.ResolveStorageOptions(opts...)
-	if opt.CheckpointInterval < minCheckpointInterval {
-		return nil, fmt.Errorf("requested CheckpointInterval (%v) is less than minimum permitted %v", opt.CheckpointInterval, minCheckpointInterval)
+	opt, err := storage.ResolveStorageOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	minInterval := storage.MinCheckpointInterval(opt, minCheckpointInterval)
+	if opt.CheckpointInterval < minInterval {
+		return nil, fmt.Errorf("requested CheckpointInterval (%v) is less than minimum permitted %v", opt.CheckpointInterval, minInterval)
+	}
+	if opt.Permissions {
+		if err := validatePermissions(opt.DirPerm, opt.FilePerm); err != nil {
+			return nil, fmt.Errorf("WithPermissions: %v", err)
+		}
+	}
+	metadata, err := storage.MarshalLogMetadata(opt)
+	if err != nil {
+		return nil, err
 	}
 
 	r := &Storage{
-		path:        path,
-		newCP:       opt.NewCP,
-		entriesPath: opt.EntriesPath,
-		cpUpdated:   make(chan struct{}),
+		path:               path,
+		newCP:              opt.NewCP,
+		entriesPath:        opt.EntriesPath,
+		hasher:             opt.Hasher,
+		dirPerm:            defaultDirPerm,
+		filePerm:           defaultFilePerm,
+		fsync:              opt.Fsync,
+		integrationWorkers: opt.IntegrationWorkers,
+		cpUpdated:          make(chan struct{}),
+
+		publishPolicy: storage.PublishPolicy{OnlyOnChange: opt.PublishOnlyOnChange, RepublishInterval: opt.CheckpointRepublishInterval},
+	}
+	if opt.Permissions {
+		r.dirPerm = opt.DirPerm
+		r.filePerm = opt.FilePerm
 	}
 	if err := r.initialise(create); err != nil {
 		return nil, err
 	}
-	r.queue = storage.NewQueue(ctx, opt.BatchMaxAge, opt.BatchMaxSize, r.sequenceBatch)
+	if err := storage.PublishLogMetadata(ctx, metadata, func(_ context.Context, p string, data []byte) error {
+		return r.createExclusive(filepath.Join(path, p), data)
+	}); err != nil {
+		return nil, err
+	}
+	if opt.VerifyRootOnInit {
+		cpRaw, err := r.ReadCheckpoint(ctx)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to read checkpoint: %v", err)
+		}
+		if err == nil {
+			if err := storage.VerifyCheckpoint(ctx, r.readTiles, cpRaw, r.hasher, opt.VerifyRootFull); err != nil {
+				return nil, fmt.Errorf("failed to verify log integrity: %v", err)
+			}
+		}
+	}
+	r.queue = storage.NewQueue(ctx, opt.BatchMaxAge, opt.BatchMaxSize, opt.MaxConcurrentAdds, opt.EntryBundleCodec, opt.Hasher, r.sequenceBatch)
 
-	go func(ctx context.Context, i time.Duration) {
-		t := time.NewTicker(i)
+	go func(ctx context.Context, j storage.Jitter) {
+		t := time.NewTimer(j.Next())
 		defer t.Stop()
 		for {
 			select {
@@ -90,12 +190,13 @@ func New(ctx context.Context, path string, create bool, opts ...func(*options.St
 				return
 			case <-r.cpUpdated:
 			case <-t.C:
+				t.Reset(j.Next())
 			}
-			if err := r.publishCheckpoint(i); err != nil {
+			if err := r.publishCheckpoint(j.Min()); err != nil {
 				klog.Warningf("publishCheckpoint: %v", err)
 			}
 		}
-	}(ctx, opt.CheckpointInterval)
+	}(ctx, storage.Jitter{Interval: opt.CheckpointInterval, Fraction: opt.CheckpointIntervalJitter, Floor: minInterval})
 
 	return r, nil
 }
@@ -108,8 +209,12 @@ func New(ctx context.Context, path string, create bool, opts ...func(*options.St
 // (e.g. <something>.lock>) to avoid inherent brittleness of the `fcntrl` API
 // (*any* `Close` operation on this file (even if it's a different FD) from
 // this PID, or overwriting of the file by *any* process breaks the lock.)
-func lockFile(p string) (func() error, error) {
-	f, err := os.OpenFile(p, syscall.O_CREAT|syscall.O_RDWR|syscall.O_CLOEXEC, filePerm)
+//
+// The lock is released by the OS when the holding process exits, so a lock file
+// left behind by a killed process does not need to be cleaned up. Use InspectLocks
+// to find out whether, and by which process, a lock is currently held.
+func (s *Storage) lockFile(p string) (func() error, error) {
+	f, err := os.OpenFile(p, syscall.O_CREAT|syscall.O_RDWR|syscall.O_CLOEXEC, s.filePerm)
 	if err != nil {
 		return nil, err
 	}
@@ -145,13 +250,33 @@ func lockFile(p string) (func() error, error) {
 // mean that some of the entries added are not committed to by a checkpoint, and thus are
 // not considered to be in the log.
 func (s *Storage) Add(ctx context.Context, e *tessera.Entry) tessera.IndexFuture {
+	ctx, span := tracer.Start(ctx, "tessera.storage.posix.Add")
+	defer span.End()
+
 	return s.queue.Add(ctx, e)
 }
 
+// AddBatch adds all of the provided entries to the log, returning one future per entry in the same order.
+//
+// The entries are guaranteed to be assigned a contiguous range of indices, in the order provided.
+// Batches with more entries than can be queued together are rejected with tessera.ErrBatchTooLarge.
+// As with Add, each future resolves only once its entry has been integrated.
+func (s *Storage) AddBatch(ctx context.Context, entries []*tessera.Entry) []tessera.IndexFuture {
+	ctx, span := tracer.Start(ctx, "tessera.storage.posix.AddBatch")
+	defer span.End()
+
+	return s.queue.AddBatch(ctx, entries)
+}
+
 func (s *Storage) ReadCheckpoint(_ context.Context) ([]byte, error) {
 	return os.ReadFile(filepath.Join(s.path, layout.CheckpointPath))
 }
 
+// ReadMetadata returns the log metadata published when the storage was created with the WithLogMetadata option.
+func (s *Storage) ReadMetadata(_ context.Context) ([]byte, error) {
+	return os.ReadFile(filepath.Join(s.path, layout.MetadataPath))
+}
+
 // ReadEntryBundle retrieves the Nth entries bundle for a log of the given size.
 func (s *Storage) ReadEntryBundle(_ context.Context, index uint64, p uint8) ([]byte, error) {
 	return os.ReadFile(filepath.Join(s.path, s.entriesPath(index, p)))
@@ -161,6 +286,31 @@ func (s *Storage) ReadTile(_ context.Context, level, index uint64, p uint8) ([]b
 	return os.ReadFile(filepath.Join(s.path, layout.TilePath(level, index, p)))
 }
 
+// WriteTile stores the serialised tile at the given coordinates, e.g. when this storage is used as the
+// local store of a tessera.ReadThroughCache.
+//
+// The tile is written verbatim, so this should not be used on a log which is also having entries added to it.
+func (s *Storage) WriteTile(_ context.Context, level, index uint64, p uint8, data []byte) error {
+	return s.writeResource(layout.TilePath(level, index, p), data)
+}
+
+// WriteEntryBundle stores the serialised entry bundle at the given coordinates, e.g. when this storage is
+// used as the local store of a tessera.ReadThroughCache.
+//
+// The bundle is written verbatim, so this should not be used on a log which is also having entries added to it.
+func (s *Storage) WriteEntryBundle(_ context.Context, index uint64, p uint8, data []byte) error {
+	return s.writeResource(s.entriesPath(index, p), data)
+}
+
+// writeResource atomically writes data to the file at path, relative to the root of the log.
+func (s *Storage) writeResource(path string, data []byte) error {
+	f := filepath.Join(s.path, path)
+	if err := s.mkdirAll(filepath.Dir(f)); err != nil {
+		return fmt.Errorf("failed to create directory %q: %w", filepath.Dir(f), err)
+	}
+	return s.createExclusive(f, data)
+}
+
 // sequenceBatch writes the entries from the provided batch into the entry bundle files of the log.
 //
 // This func starts filling entries bundles at the next available slot in the log, ensuring that the
@@ -168,11 +318,15 @@ func (s *Storage) ReadTile(_ context.Context, level, index uint64, p uint8) ([]b
 // We try to minimise the number of partially complete entry bundles by writing entries in chunks rather
 // than one-by-one.
 func (s *Storage) sequenceBatch(ctx context.Context, entries []*tessera.Entry) error {
+	ctx, span := tracer.Start(ctx, "tessera.storage.posix.sequenceBatch")
+	defer span.End()
+	span.SetAttributes(batchSizeKey.Int(len(entries)))
+
 	// Double locking:
 	// - The mutex `Lock()` ensures that multiple concurrent calls to this function within a task are serialised.
 	// - The POSIX `lockForTreeUpdate()` ensures that distinct tasks are serialised.
 	s.mu.Lock()
-	unlock, err := lockFile(filepath.Join(s.path, stateDir, "treeState.lock"))
+	unlock, err := s.lockFile(filepath.Join(s.path, stateDir, treeStateLock))
 	if err != nil {
 		panic(err)
 	}
@@ -208,10 +362,10 @@ func (s *Storage) sequenceBatch(ctx context.Context, entries []*tessera.Entry) e
 	}
 	writeBundle := func(bundleIndex uint64, partialSize uint8) error {
 		bf := filepath.Join(s.path, s.entriesPath(bundleIndex, partialSize))
-		if err := os.MkdirAll(filepath.Dir(bf), dirPerm); err != nil {
+		if err := s.mkdirAll(filepath.Dir(bf)); err != nil {
 			return fmt.Errorf("failed to make entries directory structure: %w", err)
 		}
-		if err := createExclusive(bf, currTile.Bytes()); err != nil {
+		if err := s.createExclusive(bf, currTile.Bytes()); err != nil {
 			if !errors.Is(err, os.ErrExist) {
 				return err
 			}
@@ -267,6 +421,10 @@ func (s *Storage) sequenceBatch(ctx context.Context, entries []*tessera.Entry) e
 
 // doIntegrate handles integrating new entries into the log, and updating the tree state.
 func (s *Storage) doIntegrate(ctx context.Context, fromSeq uint64, entries []storage.SequencedEntry) error {
+	ctx, span := tracer.Start(ctx, "tessera.storage.posix.integrate")
+	defer span.End()
+	span.SetAttributes(fromSeqKey.Int64(int64(fromSeq)), batchSizeKey.Int(len(entries)))
+
 	getTiles := func(ctx context.Context, tileIDs []storage.TileID, treeSize uint64) ([]*api.HashTile, error) {
 		n, err := s.readTiles(ctx, tileIDs, treeSize)
 		if err != nil {
@@ -275,7 +433,7 @@ func (s *Storage) doIntegrate(ctx context.Context, fromSeq uint64, entries []sto
 		return n, nil
 	}
 
-	newSize, newRoot, tiles, err := storage.Integrate(ctx, getTiles, fromSeq, entries)
+	newSize, newRoot, tiles, err := storage.Integrate(ctx, getTiles, fromSeq, entries, s.hasher, s.integrationWorkers)
 	if err != nil {
 		klog.Errorf("Integrate: %v", err)
 		return fmt.Errorf("Integrate: %v", err)
@@ -343,11 +501,11 @@ func (s *Storage) storeTile(_ context.Context, level, index, logSize uint64, til
 
 	tPath := filepath.Join(s.path, layout.TilePath(level, index, layout.PartialTileSize(level, index, logSize)))
 	tDir := filepath.Dir(tPath)
-	if err := os.MkdirAll(tDir, dirPerm); err != nil {
+	if err := s.mkdirAll(tDir); err != nil {
 		return fmt.Errorf("failed to create directory %q: %w", tDir, err)
 	}
 
-	if err := createExclusive(tPath, t); err != nil {
+	if err := s.createExclusive(tPath, t); err != nil {
 		return err
 	}
 
@@ -383,10 +541,10 @@ func (s *Storage) initialise(create bool) error {
 	if create {
 		// Create the directory structure and write out an empty checkpoint
 		klog.Infof("Initializing directory for POSIX log at %q (this should only happen ONCE per log!)", s.path)
-		if err := os.MkdirAll(filepath.Join(s.path, stateDir), dirPerm); err != nil {
+		if err := s.mkdirAll(filepath.Join(s.path, stateDir)); err != nil {
 			return fmt.Errorf("failed to create log directory: %q", err)
 		}
-		if err := s.writeTreeState(0, rfc6962.DefaultHasher.EmptyRoot()); err != nil {
+		if err := s.writeTreeState(0, s.hasher.EmptyRoot()); err != nil {
 			return fmt.Errorf("failed to write tree-state checkpoint: %v", err)
 		}
 		if err := s.publishCheckpoint(0); err != nil {
@@ -414,7 +572,7 @@ func (s *Storage) writeTreeState(size uint64, root []byte) error {
 		return fmt.Errorf("Marshal: %v", err)
 	}
 
-	if err := createExclusive(filepath.Join(s.path, stateDir, "treeState"), raw); err != nil {
+	if err := s.createExclusive(filepath.Join(s.path, stateDir, "treeState"), raw); err != nil {
 		return fmt.Errorf("failed to create private tree state file: %w", err)
 	}
 	// Notify that we know for sure there's a new checkpoint, but don't block if there's already
@@ -445,8 +603,8 @@ func (s *Storage) readTreeState() (uint64, []byte, error) {
 // stored tree state.
 func (s *Storage) publishCheckpoint(minStaleness time.Duration) error {
 	// Lock the destination "published" checkpoint location:
-	lockPath := filepath.Join(s.path, stateDir, "publish.lock")
-	unlock, err := lockFile(lockPath)
+	lockPath := filepath.Join(s.path, stateDir, publishLock)
+	unlock, err := s.lockFile(lockPath)
 	if err != nil {
 		return fmt.Errorf("lockFile(%s): %v", lockPath, err)
 	}
@@ -456,14 +614,17 @@ func (s *Storage) publishCheckpoint(minStaleness time.Duration) error {
 		}
 	}()
 
+	var cpAge time.Duration
 	info, err := os.Stat(filepath.Join(s.path, layout.CheckpointPath))
 	if errors.Is(err, os.ErrNotExist) {
 		klog.V(1).Infof("No checkpoint exists, publishing")
 	} else if err != nil {
 		return fmt.Errorf("stat(%s): %v", layout.CheckpointPath, err)
 	} else {
-		if d := time.Since(info.ModTime()); d < minStaleness {
-			klog.V(1).Infof("publishCheckpoint: skipping publish because previous checkpoint published %v ago, less than %v", d, minStaleness)
+		cpAge = time.Since(info.ModTime())
+		checkpointAge.Record(context.Background(), cpAge.Seconds())
+		if cpAge < minStaleness {
+			klog.V(1).Infof("publishCheckpoint: skipping publish because previous checkpoint published %v ago, less than %v", cpAge, minStaleness)
 			return nil
 		}
 	}
@@ -471,14 +632,18 @@ func (s *Storage) publishCheckpoint(minStaleness time.Duration) error {
 	if err != nil {
 		return fmt.Errorf("readTreeState: %v", err)
 	}
+	if !s.publishPolicy.ShouldPublish(size, cpAge) {
+		return nil
+	}
 	cpRaw, err := s.newCP(size, root)
 	if err != nil {
 		return fmt.Errorf("newCP: %v", err)
 	}
 
-	if err := createExclusive(filepath.Join(s.path, layout.CheckpointPath), cpRaw); err != nil {
+	if err := s.createExclusive(filepath.Join(s.path, layout.CheckpointPath), cpRaw); err != nil {
 		return fmt.Errorf("createExclusive(%s): %v", layout.CheckpointPath, err)
 	}
+	s.publishPolicy.Published(size)
 	klog.Infof("Published latest checkpoint")
 
 	return nil
@@ -487,13 +652,87 @@ func (s *Storage) publishCheckpoint(minStaleness time.Duration) error {
 // createExclusive creates a file at the given path and name before writing the data in d to it.
 // It will error if the file already exists, or it's unable to fully write the
 // data & close the file.
-func createExclusive(f string, d []byte) error {
+//
+// If fsync is enabled, both the file and the directory it's renamed into are synced before returning.
+func (s *Storage) createExclusive(f string, d []byte) error {
 	tmpName := f + ".temp"
-	if err := os.WriteFile(tmpName, d, filePerm); err != nil {
+	if err := s.writeFile(tmpName, d); err != nil {
 		return fmt.Errorf("unable to write data to temporary file: %w", err)
 	}
 	if err := os.Rename(tmpName, f); err != nil {
 		return err
 	}
+	if s.fsync {
+		if err := syncDir(filepath.Dir(f)); err != nil {
+			return fmt.Errorf("failed to sync directory: %w", err)
+		}
+	}
 	return nil
 }
+
+// writeFile writes d to the file f, syncing it to disk before closing it if fsync is enabled.
+func (s *Storage) writeFile(f string, d []byte) error {
+	if !s.fsync {
+		return os.WriteFile(f, d, s.filePerm)
+	}
+	fd, err := os.OpenFile(f, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, s.filePerm)
+	if err != nil {
+		return err
+	}
+	if _, err := fd.Write(d); err != nil {
+		_ = fd.Close()
+		return err
+	}
+	if err := fd.Sync(); err != nil {
+		_ = fd.Close()
+		return err
+	}
+	return fd.Close()
+}
+
+// mkdirAll creates the directory dir along with any necessary parents.
+// If fsync is enabled, the parent of each newly created directory is synced so that the new directories
+// survive a crash.
+func (s *Storage) mkdirAll(dir string) error {
+	if !s.fsync {
+		return os.MkdirAll(dir, s.dirPerm)
+	}
+	// Find the deepest ancestor which already exists, so we know which directories we're about to create.
+	existing := dir
+	for {
+		if _, err := os.Stat(existing); err == nil {
+			break
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			break
+		}
+		existing = parent
+	}
+	if err := os.MkdirAll(dir, s.dirPerm); err != nil {
+		return err
+	}
+	for d := dir; d != existing; d = filepath.Dir(d) {
+		if err := syncDir(filepath.Dir(d)); err != nil {
+			return fmt.Errorf("failed to sync directory: %w", err)
+		}
+	}
+	return nil
+}
+
+// syncDir is called to sync directories, and is a variable so that tests can observe which directories
+// are synced.
+var syncDir = fsyncDir
+
+// fsyncDir fsyncs the directory at path dir, ensuring that changes to its entries are durable.
+func fsyncDir(dir string) error {
+	fd, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	if err := fd.Sync(); err != nil {
+		_ = fd.Close()
+		return err
+	}
+	return fd.Close()
+}
@@ -0,0 +1,282 @@
+// Copyright 2024 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package posix
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/transparency-dev/merkle/rfc6962"
+	tessera "github.com/transparency-dev/trillian-tessera"
+	"github.com/transparency-dev/trillian-tessera/api"
+	"github.com/transparency-dev/trillian-tessera/api/layout"
+	"github.com/transparency-dev/trillian-tessera/internal/options"
+	"golang.org/x/mod/sumdb/note"
+)
+
+const testPrivateKey = "PRIVATE+KEY+example.com/log/testdata+33d7b496+AeymY/SZAX0jZcJ8enZ5FY1Dz+wTML2yWSkK+9DSF3eg"
+
+// newTestStorage creates a new log in a temporary directory, and adds the given number of entries to it.
+func newTestStorage(t *testing.T, ctx context.Context, entries int, opts ...func(*options.StorageOptions)) (*Storage, string) {
+	t.Helper()
+	signer, err := note.NewSigner(testPrivateKey)
+	if err != nil {
+		t.Fatalf("NewSigner: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "log")
+	s, err := New(ctx, path, true, append([]func(*options.StorageOptions){tessera.WithCheckpointSigner(signer)}, opts...)...)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	for i := 0; i < entries; i++ {
+		if _, err := s.Add(ctx, tessera.NewEntry([]byte(fmt.Sprintf("entry %d", i))))(); err != nil {
+			t.Fatalf("Add(%d): %v", i, err)
+		}
+	}
+	return s, path
+}
+
+func TestValidatePermissions(t *testing.T) {
+	for _, test := range []struct {
+		name              string
+		dirMode, fileMode os.FileMode
+		wantErr           bool
+	}{
+		{name: "defaults", dirMode: defaultDirPerm, fileMode: defaultFilePerm},
+		{name: "group writable", dirMode: 0o775, fileMode: 0o664},
+		{name: "owner only", dirMode: 0o700, fileMode: 0o600},
+		{name: "dir not searchable by owner", dirMode: 0o655, fileMode: 0o644, wantErr: true},
+		{name: "dir not writable by owner", dirMode: 0o555, fileMode: 0o644, wantErr: true},
+		{name: "file not writable by owner", dirMode: 0o755, fileMode: 0o444, wantErr: true},
+		{name: "dir with setgid bit", dirMode: os.ModeSetgid | 0o755, fileMode: 0o644, wantErr: true},
+		{name: "file with type bits", dirMode: 0o755, fileMode: os.ModeDir | 0o644, wantErr: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			err := validatePermissions(test.dirMode, test.fileMode)
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Errorf("validatePermissions(%#o, %#o): %v, wantErr %t", test.dirMode, test.fileMode, err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestWithPermissions(t *testing.T) {
+	// Clear the umask so that the requested modes are applied unmodified.
+	oldMask := syscall.Umask(0)
+	t.Cleanup(func() { syscall.Umask(oldMask) })
+
+	for _, test := range []struct {
+		name              string
+		opts              []func(*options.StorageOptions)
+		dirMode, fileMode os.FileMode
+	}{
+		{
+			name:     "defaults",
+			dirMode:  defaultDirPerm,
+			fileMode: defaultFilePerm,
+		}, {
+			name:     "group writable",
+			opts:     []func(*options.StorageOptions){WithPermissions(0o770, 0o660)},
+			dirMode:  0o770,
+			fileMode: 0o660,
+		}, {
+			name:     "owner only",
+			opts:     []func(*options.StorageOptions){WithPermissions(0o700, 0o600)},
+			dirMode:  0o700,
+			fileMode: 0o600,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			_, path := newTestStorage(t, ctx, 3, test.opts...)
+
+			if err := filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				info, err := d.Info()
+				if err != nil {
+					return err
+				}
+				want := test.fileMode
+				if d.IsDir() {
+					want = test.dirMode
+				}
+				if got := info.Mode().Perm(); got != want {
+					t.Errorf("%s: got mode %#o, want %#o", p, got, want)
+				}
+				return nil
+			}); err != nil {
+				t.Fatalf("WalkDir: %v", err)
+			}
+		})
+	}
+}
+
+func TestWithPermissionsInvalid(t *testing.T) {
+	signer, err := note.NewSigner(testPrivateKey)
+	if err != nil {
+		t.Fatalf("NewSigner: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "log")
+	if _, err := New(context.Background(), path, true, tessera.WithCheckpointSigner(signer), WithPermissions(0o755, 0o400)); err == nil {
+		t.Error("New succeeded with unwritable fileMode, want error")
+	}
+}
+
+func TestLogMetadata(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, vkey, err := note.GenerateKey(rand.Reader, "example.com/log/testdata")
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+
+	s, _ := newTestStorage(t, ctx, 0, tessera.WithLogMetadata(vkey))
+	raw, err := s.ReadMetadata(ctx)
+	if err != nil {
+		t.Fatalf("ReadMetadata: %v", err)
+	}
+	var m api.LogMetadata
+	if err := json.Unmarshal(raw, &m); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if got, want := m.Origin, "example.com/log/testdata"; got != want {
+		t.Errorf("got origin %q, want %q", got, want)
+	}
+
+	// Invalid keys are reported by New, before anything is created.
+	path := filepath.Join(t.TempDir(), "log")
+	if _, err := New(ctx, path, true, tessera.WithLogMetadata("not a key")); err == nil {
+		t.Error("New with invalid metadata key succeeded, want error")
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Stat(%q) after failed New: got %v, want %v", path, err, os.ErrNotExist)
+	}
+}
+
+func TestFsyncRoundTrip(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var (
+		mu     sync.Mutex
+		synced = make(map[string]bool)
+	)
+	defer func(old func(string) error) { syncDir = old }(syncDir)
+	syncDir = func(dir string) error {
+		mu.Lock()
+		synced[dir] = true
+		mu.Unlock()
+		return fsyncDir(dir)
+	}
+
+	const numEntries = 10
+	s, path := newTestStorage(t, ctx, numEntries, WithFsync(true))
+
+	bundleRaw, err := s.ReadEntryBundle(ctx, 0, numEntries)
+	if err != nil {
+		t.Fatalf("ReadEntryBundle: %v", err)
+	}
+	bundle := api.EntryBundle{}
+	if err := bundle.UnmarshalText(bundleRaw); err != nil {
+		t.Fatalf("UnmarshalText(bundle): %v", err)
+	}
+	tileRaw, err := s.ReadTile(ctx, 0, 0, numEntries)
+	if err != nil {
+		t.Fatalf("ReadTile: %v", err)
+	}
+	tile := api.HashTile{}
+	if err := tile.UnmarshalText(tileRaw); err != nil {
+		t.Fatalf("UnmarshalText(tile): %v", err)
+	}
+	if got := len(bundle.Entries); got != numEntries {
+		t.Fatalf("got %d entries in bundle, want %d", got, numEntries)
+	}
+	if got := len(tile.Nodes); got != numEntries {
+		t.Fatalf("got %d nodes in tile, want %d", got, numEntries)
+	}
+	for i := 0; i < numEntries; i++ {
+		want := []byte(fmt.Sprintf("entry %d", i))
+		if !bytes.Equal(bundle.Entries[i], want) {
+			t.Errorf("entry %d: got %q, want %q", i, bundle.Entries[i], want)
+		}
+		if wantHash := rfc6962.DefaultHasher.HashLeaf(want); !bytes.Equal(tile.Nodes[i], wantHash) {
+			t.Errorf("tile node %d: got %x, want %x", i, tile.Nodes[i], wantHash)
+		}
+	}
+
+	// The directories holding the entry bundle and tile must have been synced after the files were
+	// renamed into them.
+	for _, p := range []string{
+		filepath.Join(path, layout.EntriesPath(0, numEntries)),
+		filepath.Join(path, layout.TilePath(0, 0, numEntries)),
+	} {
+		mu.Lock()
+		ok := synced[filepath.Dir(p)]
+		mu.Unlock()
+		if !ok {
+			t.Errorf("directory of %s was not synced", p)
+		}
+	}
+}
+
+func TestMkdirAllSyncsParents(t *testing.T) {
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "a"), 0o755); err != nil {
+		t.Fatalf("Mkdir: %v", err)
+	}
+
+	var synced []string
+	defer func(old func(string) error) { syncDir = old }(syncDir)
+	syncDir = func(dir string) error {
+		if strings.HasPrefix(dir, root) {
+			synced = append(synced, dir)
+		}
+		return fsyncDir(dir)
+	}
+
+	s := &Storage{dirPerm: defaultDirPerm, fsync: true}
+	if err := s.mkdirAll(filepath.Join(root, "a", "b", "c")); err != nil {
+		t.Fatalf("mkdirAll: %v", err)
+	}
+	// Only the parents of the newly created b and c directories need syncing.
+	want := []string{filepath.Join(root, "a", "b"), filepath.Join(root, "a")}
+	if !slices.Equal(synced, want) {
+		t.Errorf("got synced directories %q, want %q", synced, want)
+	}
+
+	// Creating a directory which already exists syncs nothing.
+	synced = nil
+	if err := s.mkdirAll(filepath.Join(root, "a", "b")); err != nil {
+		t.Fatalf("mkdirAll: %v", err)
+	}
+	if len(synced) != 0 {
+		t.Errorf("got synced directories %q for existing directory, want none", synced)
+	}
+}
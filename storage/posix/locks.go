@@ -0,0 +1,89 @@
+// Copyright 2024 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package posix
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// LockInfo describes the state of one of the advisory locks used by a POSIX log.
+type LockInfo struct {
+	// Path is the location of the lock file.
+	Path string
+	// Exists is true if the lock file is present on disk.
+	Exists bool
+	// Held is true if another process currently holds the lock.
+	Held bool
+	// PID is the ID of the process holding the lock, if Held is true.
+	PID int
+}
+
+// InspectLocks reports on the state of the advisory locks used by the log stored under path.
+//
+// This is intended to help diagnose a wedged log, e.g. a writer which is blocked waiting on a lock held
+// by another process. Note that, due to the semantics of fcntl locks, locks held by the calling process
+// itself are not reported as held, so this should be called from a separate process (see
+// cmd/experimental/posix-locks).
+//
+// There is no corresponding way to forcibly release a lock: fcntl locks are released by the OS when the
+// holding process exits, so a held lock always belongs to a live process, and that process is the one
+// which must be stopped.
+func InspectLocks(path string) ([]LockInfo, error) {
+	r := []LockInfo{}
+	for _, n := range []string{treeStateLock, publishLock} {
+		li, err := inspectLock(filepath.Join(path, stateDir, n))
+		if err != nil {
+			return nil, err
+		}
+		r = append(r, li)
+	}
+	return r, nil
+}
+
+func inspectLock(p string) (LockInfo, error) {
+	r := LockInfo{Path: p}
+	// Open read-only so that we neither create the lock file nor disturb an existing lock by closing the file.
+	f, err := os.OpenFile(p, syscall.O_RDONLY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return r, nil
+		}
+		return r, fmt.Errorf("failed to open lock file %q: %v", p, err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	r.Exists = true
+
+	flockT := syscall.Flock_t{
+		Type:   syscall.F_WRLCK,
+		Whence: io.SeekStart,
+		Start:  0,
+		Len:    0,
+	}
+	if err := syscall.FcntlFlock(f.Fd(), syscall.F_GETLK, &flockT); err != nil {
+		return r, fmt.Errorf("failed to query lock %q: %v", p, err)
+	}
+	if flockT.Type != syscall.F_UNLCK {
+		r.Held = true
+		r.PID = int(flockT.Pid)
+	}
+	return r, nil
+}
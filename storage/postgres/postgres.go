@@ -0,0 +1,91 @@
+// Copyright 2024 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package postgres contains a PostgreSQL-based storage implementation for Tessera.
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	options "github.com/transparency-dev/trillian-tessera/internal/options"
+	storage "github.com/transparency-dev/trillian-tessera/storage/internal"
+	"github.com/transparency-dev/trillian-tessera/storage/internal/sqlstore"
+)
+
+var statements = &sqlstore.Statements{
+	SelectCheckpoint:          `SELECT "note", "published_at" FROM "Checkpoint" WHERE "id" = $1`,
+	SelectCheckpointForUpdate: `SELECT "note", "published_at" FROM "Checkpoint" WHERE "id" = $1 FOR UPDATE`,
+	UpsertCheckpoint:          `INSERT INTO "Checkpoint" ("id", "note", "published_at") VALUES ($1, $2, $3) ON CONFLICT ("id") DO UPDATE SET "note" = EXCLUDED."note", "published_at" = EXCLUDED."published_at"`,
+	SelectCheckpointAtSize:    `SELECT "note" FROM "CheckpointHistory" WHERE "size" >= $1 ORDER BY "size" LIMIT 1`,
+	SelectCheckpointAt:        `SELECT "note" FROM "CheckpointHistory" WHERE "size" = $1`,
+	InsertCheckpointHistory:   `INSERT INTO "CheckpointHistory" ("size", "note", "published_at") VALUES ($1, $2, $3) ON CONFLICT ("size") DO NOTHING`,
+	PruneCheckpointHistory:    `DELETE FROM "CheckpointHistory" WHERE "published_at" < $1 AND "size" < $2`,
+	CheckCheckpointHistory:    `SELECT 1 FROM "CheckpointHistory" LIMIT 1`,
+	SelectTreeStateForUpdate:  `SELECT "size", "root" FROM "TreeState" WHERE "id" = $1 FOR UPDATE`,
+	UpsertTreeState:           `INSERT INTO "TreeState" ("id", "size", "root") VALUES ($1, $2, $3) ON CONFLICT ("id") DO UPDATE SET "size" = EXCLUDED."size", "root" = EXCLUDED."root"`,
+	SelectSubtree:             `SELECT "nodes" FROM "Subtree" WHERE "level" = $1 AND "index" = $2`,
+	SelectSubtreesPrefix:      `SELECT "level", "index", "nodes" FROM "Subtree" WHERE ("level", "index") IN `,
+	UpsertSubtree:             `INSERT INTO "Subtree" ("level", "index", "nodes") VALUES ($1, $2, $3) ON CONFLICT ("level", "index") DO UPDATE SET "nodes" = EXCLUDED."nodes"`,
+	SelectTiledLeaves:         `SELECT "size", "data" FROM "TiledLeaves" WHERE "tile_index" = $1`,
+	UpsertTiledLeaves:         `INSERT INTO "TiledLeaves" ("tile_index", "size", "data") VALUES ($1, $2, $3) ON CONFLICT ("tile_index") DO UPDATE SET "size" = EXCLUDED."size", "data" = EXCLUDED."data"`,
+}
+
+// Storage is a PostgreSQL-based storage implementation for Tessera.
+type Storage struct {
+	*sqlstore.Storage
+}
+
+// New creates a new instance of the PostgreSQL-based Storage.
+//
+// The tables in schema.sql must already have been created in the database.
+// Note that `tessera.WithCheckpointSigner()` is mandatory in the `opts` argument.
+func New(ctx context.Context, db *sql.DB, opts ...func(*options.StorageOptions)) (*Storage, error) {
+	opt, err := storage.ResolveStorageOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	s, err := sqlstore.New(ctx, db, dialect{}, opt)
+	if err != nil {
+		return nil, err
+	}
+	return &Storage{Storage: s}, nil
+}
+
+// dialect implements sqlstore.Dialect for PostgreSQL.
+type dialect struct{}
+
+// Statements implements sqlstore.Dialect.
+func (dialect) Statements() *sqlstore.Statements {
+	return statements
+}
+
+// Placeholder implements sqlstore.Dialect.
+func (dialect) Placeholder(i int) string {
+	return fmt.Sprintf("$%d", i+1)
+}
+
+// CheckEntrySize implements sqlstore.Dialect.
+//
+// PostgreSQL doesn't impose a limit on the size of entries which is lower than the limits enforced by
+// the entry bundle codec.
+func (dialect) CheckEntrySize(int) error {
+	return nil
+}
+
+// CheckBundleSize implements sqlstore.Dialect.
+func (dialect) CheckBundleSize(int) error {
+	return nil
+}
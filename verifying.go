@@ -0,0 +1,126 @@
+// Copyright 2024 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tessera
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/transparency-dev/merkle"
+	"github.com/transparency-dev/trillian-tessera/api"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// ErrEntryBundleMismatch is returned by a VerifyingReader when the entries in an entry bundle do not
+// hash to the leaf hashes stored in the corresponding level-0 tile.
+var ErrEntryBundleMismatch = errors.New("entry bundle does not match tile")
+
+// VerifyingReader is a LogReader which checks that each entry bundle it serves is consistent with the
+// level-0 tile covering the same range of the log, by recomputing the leaf hashes of the bundle's entries.
+//
+// This provides defence-in-depth when serving a log from storage which is not fully trusted, at the cost
+// of an additional tile read and hashing work for every entry bundle read.
+// Tiles and checkpoints are passed straight through to the delegate.
+type VerifyingReader struct {
+	delegate LogReader
+	codec    api.EntryBundleCodec
+	hasher   merkle.LogHasher
+}
+
+// NewVerifyingReader returns a VerifyingReader which reads from delegate.
+//
+// The codec and hasher MUST be the same as those used by the log when the entries were added.
+func NewVerifyingReader(delegate LogReader, codec api.EntryBundleCodec, hasher merkle.LogHasher) *VerifyingReader {
+	return &VerifyingReader{
+		delegate: delegate,
+		codec:    codec,
+		hasher:   hasher,
+	}
+}
+
+// ReadCheckpoint returns the latest checkpoint from the delegate.
+func (r *VerifyingReader) ReadCheckpoint(ctx context.Context) ([]byte, error) {
+	return r.delegate.ReadCheckpoint(ctx)
+}
+
+// ReadTile returns the requested tile from the delegate.
+func (r *VerifyingReader) ReadTile(ctx context.Context, level, index uint64, p uint8) ([]byte, error) {
+	return r.delegate.ReadTile(ctx, level, index, p)
+}
+
+// ReadEntryBundle returns the requested entry bundle from the delegate, having checked that its entries
+// hash to the leaf hashes in the corresponding level-0 tile.
+//
+// The bundle and tile are read separately, so for partial resources (p != 0) the delegate may return a
+// larger bundle or tile than requested if the log grows between the two reads. In this case only the
+// entries present in both are compared, though each must contain at least p entries.
+//
+// An error wrapping ErrEntryBundleMismatch is returned if they do not match.
+func (r *VerifyingReader) ReadEntryBundle(ctx context.Context, index uint64, p uint8) ([]byte, error) {
+	raw, err := r.delegate.ReadEntryBundle(ctx, index, p)
+	if err != nil {
+		return nil, err
+	}
+	rawTile, err := r.delegate.ReadTile(ctx, 0, index, p)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tile for entry bundle %d (p=%d): %v", index, p, err)
+	}
+	if err := r.verify(raw, rawTile, p); err != nil {
+		bundleVerificationFailures.Add(ctx, 1)
+		return nil, fmt.Errorf("entry bundle %d (p=%d): %w", index, p, err)
+	}
+	return raw, nil
+}
+
+// verify checks that the entries in the raw entry bundle hash to the nodes in the raw level-0 tile.
+//
+// If p is non-zero, both must contain at least p entries, and only those present in both are compared.
+func (r *VerifyingReader) verify(raw, rawTile []byte, p uint8) error {
+	bundle := api.EntryBundle{}
+	if err := bundle.UnmarshalWithCodec(raw, r.codec); err != nil {
+		return fmt.Errorf("failed to parse entry bundle: %v", err)
+	}
+	tile := api.HashTile{}
+	if err := tile.UnmarshalText(rawTile); err != nil {
+		return fmt.Errorf("failed to parse tile: %v", err)
+	}
+	n := len(bundle.Entries)
+	if p == 0 {
+		if got, want := len(bundle.Entries), len(tile.Nodes); got != want {
+			return fmt.Errorf("%w: bundle has %d entries, tile has %d hashes", ErrEntryBundleMismatch, got, want)
+		}
+	} else {
+		if len(bundle.Entries) < int(p) || len(tile.Nodes) < int(p) {
+			return fmt.Errorf("%w: bundle has %d entries, tile has %d hashes, want at least %d", ErrEntryBundleMismatch, len(bundle.Entries), len(tile.Nodes), p)
+		}
+		n = min(n, len(tile.Nodes))
+	}
+	for i, e := range bundle.Entries[:n] {
+		if h := r.hasher.HashLeaf(e); !bytes.Equal(h, tile.Nodes[i]) {
+			return fmt.Errorf("%w: entry %d has leaf hash %x, tile has %x", ErrEntryBundleMismatch, i, h, tile.Nodes[i])
+		}
+	}
+	return nil
+}
+
+var bundleVerificationFailures metric.Int64Counter
+
+func init() {
+	mustCreateCounters(
+		counter{&bundleVerificationFailures, "tessera.verifying_reader.failures", "Number of entry bundles which a VerifyingReader refused to serve because they did not match their tile"},
+	)
+}
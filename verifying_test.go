@@ -0,0 +1,119 @@
+// Copyright 2024 The Tessera authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tessera_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/transparency-dev/merkle/rfc6962"
+	tessera "github.com/transparency-dev/trillian-tessera"
+	"github.com/transparency-dev/trillian-tessera/api"
+	"github.com/transparency-dev/trillian-tessera/api/layout"
+)
+
+// addBundle stores an entry bundle containing the provided entries, along with its level-0 tile.
+func addBundle(t *testing.T, l *memLog, index uint64, p uint8, entries ...[]byte) {
+	t.Helper()
+	bundle := []byte{}
+	tile := api.HashTile{}
+	for _, e := range entries {
+		d, err := api.LengthPrefixedCodec{}.MarshalEntry(e)
+		if err != nil {
+			t.Fatalf("MarshalEntry: %v", err)
+		}
+		bundle = append(bundle, d...)
+		tile.Nodes = append(tile.Nodes, rfc6962.DefaultHasher.HashLeaf(e))
+	}
+	rawTile, err := tile.MarshalText()
+	if err != nil {
+		t.Fatalf("MarshalText: %v", err)
+	}
+	l.m[layout.EntriesPath(index, p)] = bundle
+	l.m[layout.TilePath(0, index, p)] = rawTile
+}
+
+func TestVerifyingReader(t *testing.T) {
+	ctx := context.Background()
+	l := newMemLog()
+	addBundle(t, l, 0, 0, []byte("one"), []byte("two"), []byte("three"))
+	addBundle(t, l, 1, 2, []byte("four"), []byte("five"))
+	// Bundle 2 has been tampered with.
+	addBundle(t, l, 2, 2, []byte("six"), []byte("seven"))
+	good := l.m[layout.EntriesPath(2, 2)]
+	addBundle(t, l, 2, 2, []byte("six"), []byte("eight"))
+	l.m[layout.EntriesPath(2, 2)] = good
+	// Bundle 3 is missing an entry.
+	addBundle(t, l, 3, 2, []byte("nine"))
+	addBundle(t, l, 3, 1, []byte("nine"), []byte("ten"))
+	l.m[layout.TilePath(0, 3, 2)] = l.m[layout.TilePath(0, 3, 1)]
+	delete(l.m, layout.TilePath(0, 3, 1))
+	// Bundle 4 has no corresponding tile.
+	addBundle(t, l, 4, 1, []byte("ten"))
+	delete(l.m, layout.TilePath(0, 4, 1))
+	// Bundles 5 and 6 grew between the bundle and tile reads, so one is larger than requested.
+	addBundle(t, l, 5, 1, []byte("eleven"))
+	addBundle(t, l, 5, 2, []byte("eleven"), []byte("twelve"))
+	l.m[layout.EntriesPath(5, 1)] = l.m[layout.EntriesPath(5, 2)]
+	addBundle(t, l, 6, 1, []byte("thirteen"))
+	addBundle(t, l, 6, 2, []byte("thirteen"), []byte("fourteen"))
+	l.m[layout.TilePath(0, 6, 1)] = l.m[layout.TilePath(0, 6, 2)]
+	// Bundle 7 is a full bundle with an extra entry.
+	addBundle(t, l, 7, 0, []byte("fifteen"))
+	l.m[layout.EntriesPath(7, 0)] = append(l.m[layout.EntriesPath(7, 0)], 0, 1, 'x')
+
+	r := tessera.NewVerifyingReader(l, api.LengthPrefixedCodec{}, rfc6962.DefaultHasher)
+
+	for _, test := range []struct {
+		index        uint64
+		p            uint8
+		wantErr      bool
+		wantMismatch bool
+	}{
+		{index: 0, p: 0},
+		{index: 1, p: 2},
+		{index: 2, p: 2, wantErr: true, wantMismatch: true},
+		{index: 3, p: 2, wantErr: true, wantMismatch: true},
+		{index: 4, p: 1, wantErr: true},
+		{index: 5, p: 1},
+		{index: 6, p: 1},
+		{index: 7, p: 0, wantErr: true, wantMismatch: true},
+		{index: 8, p: 0, wantErr: true},
+	} {
+		t.Run(fmt.Sprintf("%d.p%d", test.index, test.p), func(t *testing.T) {
+			got, err := r.ReadEntryBundle(ctx, test.index, test.p)
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Fatalf("ReadEntryBundle: %v, wantErr %t", err, test.wantErr)
+			}
+			if gotMismatch := errors.Is(err, tessera.ErrEntryBundleMismatch); gotMismatch != test.wantMismatch {
+				t.Errorf("ReadEntryBundle: %v, wantMismatch %t", err, test.wantMismatch)
+			}
+			if err != nil {
+				return
+			}
+			if want := l.m[layout.EntriesPath(test.index, test.p)]; !bytes.Equal(got, want) {
+				t.Errorf("ReadEntryBundle: got %q, want %q", got, want)
+			}
+		})
+	}
+
+	if _, err := r.ReadEntryBundle(ctx, 8, 0); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("ReadEntryBundle of missing bundle: got %v, want os.ErrNotExist", err)
+	}
+}